@@ -12,6 +12,7 @@ import (
 
 	"auto-upnp/config"
 	"auto-upnp/internal/admin"
+	"auto-upnp/internal/logging"
 	"auto-upnp/internal/service"
 
 	"github.com/sirupsen/logrus"
@@ -42,12 +43,27 @@ var (
 
 var (
 	configFile  = flag.String("config", "config.yaml", "配置文件路径")
+	configDir   = flag.String("config-dir", "", "配置目录，目录中的*.yaml文件按文件名顺序合并并覆盖主配置文件")
 	logLevel    = flag.String("log-level", "info", "日志级别 (debug, info, warn, error)")
 	showHelp    = flag.Bool("help", false, "显示帮助信息")
 	showVersion = flag.Bool("version", false, "显示版本信息")
+	force       = flag.Bool("force", false, "监控端口数量超过上限时仍然启动")
+	adminPort   = flag.Int("admin-port", -1, "管理服务端口，0表示由系统分配空闲端口，默认使用配置文件中的admin.port")
+	portRange   = flag.String("port-range", "", "监控的端口范围，如 18000-19000，默认使用配置文件中的port_range")
+	dataDir     = flag.String("data-dir", "", "数据目录，默认使用配置文件中的admin.data_dir")
+	dryRun      = flag.Bool("dry-run", false, "模拟模式，只记录将要添加的映射，不修改路由器")
 )
 
 func main() {
+	// 子命令
+	if len(os.Args) > 1 && os.Args[1] == "top" {
+		if err := runTop(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	flag.Parse()
 
 	if *showHelp {
@@ -92,11 +108,25 @@ func main() {
 	logger.AddHook(&PerformanceHook{})
 
 	// 加载配置文件
-	cfg, err := config.LoadConfig(*configFile)
+	cfg, err := config.LoadConfigWithDir(*configFile, *configDir)
 	if err != nil {
 		logger.WithError(err).Fatal("加载配置文件失败")
 	}
 
+	// 命令行参数优先于环境变量和配置文件
+	overrides := config.Overrides{PortRange: *portRange, DataDir: *dataDir, DryRun: *dryRun}
+	if *adminPort >= 0 {
+		overrides.AdminPort = adminPort
+	}
+	if err := cfg.ApplyOverrides(overrides); err != nil {
+		logger.WithError(err).Fatal("应用命令行参数失败")
+	}
+
+	// 校验数据目录，不可用时直接退出，避免持久化在运行时静默失败
+	if err := cfg.PrepareDataDir(); err != nil {
+		logger.WithError(err).Fatal("数据目录不可用")
+	}
+
 	// 配置日志文件输出
 	if cfg.Log.File != "" {
 		// 创建日志文件
@@ -110,16 +140,36 @@ func main() {
 		logger.SetOutput(mw)
 	}
 
+	// 配置日志采样，合并高频重复日志
+	var samplingHook *logging.SamplingHook
+	if cfg.Log.SampleInterval > 0 {
+		samplingHook = logging.NewSamplingHook(logger, logger.Out, cfg.Log.SampleInterval)
+		samplingHook.Install()
+	}
+
+	// 检查端口范围，扫描过多端口会占用大量系统资源
+	if err := cfg.CheckPortRange(); err != nil {
+		if !*force {
+			logger.WithError(err).Fatal("监控端口数量过多，如确需监控请使用 -force 启动")
+		}
+		logger.WithError(err).Warn("监控端口数量过多，已通过 -force 强制启动")
+	}
+
 	// 创建自动UPnP服务
 	autoService := service.NewAutoUPnPService(cfg, logger)
 
+	// 先监听管理端口，使其在自动端口监控第一次扫描前就被排除
+	adminServer := admin.NewAdminServer(cfg, logger, autoService)
+	if err := adminServer.Listen(); err != nil {
+		logger.WithError(err).Fatal("监听HTTP管理端口失败")
+	}
+
 	// 启动服务
 	if err := autoService.Start(); err != nil {
 		logger.WithError(err).Fatal("启动自动UPnP服务失败")
 	}
 
-	// 创建并启动HTTP管理服务
-	adminServer := admin.NewAdminServer(cfg, logger, autoService)
+	// 启动HTTP管理服务
 	if err := adminServer.Start(); err != nil {
 		logger.WithError(err).Fatal("启动HTTP管理服务失败")
 	}
@@ -129,9 +179,19 @@ func main() {
 		"config_file": *configFile,
 		"log_level":   *logLevel,
 		"port_range":  fmt.Sprintf("%d-%d", cfg.PortRange.Start, cfg.PortRange.End),
+		"port_count":  cfg.PortCount(),
 		"admin_port":  adminServer.GetPort(),
+		"dry_run":     cfg.UPnP.DryRun,
 	}).Info("自动UPnP服务已启动")
 
+	if cfg.UPnP.DryRun {
+		logger.Warn("模拟模式已开启，端口映射只记录在本地，不会写入路由器")
+	}
+
+	if cfg.Admin.Enabled {
+		fmt.Printf("管理界面: %s\n", adminServer.URL())
+	}
+
 	// 等待中断信号
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -145,6 +205,10 @@ func main() {
 	adminServer.Stop()
 
 	logger.Info("自动UPnP服务已停止")
+
+	if samplingHook != nil {
+		samplingHook.Stop()
+	}
 }
 
 func showUsage() {
@@ -152,6 +216,7 @@ func showUsage() {
 	fmt.Println()
 	fmt.Println("用法:")
 	fmt.Printf("  %s [选项]\n", os.Args[0])
+	fmt.Printf("  %s top [-config 配置文件] [-addr host:port] [-once]   在终端中查看运行中服务的状态\n", os.Args[0])
 	fmt.Println()
 	fmt.Println("选项:")
 	flag.PrintDefaults()
@@ -159,6 +224,14 @@ func showUsage() {
 	fmt.Println("示例:")
 	fmt.Printf("  %s -config config.yaml -log-level debug\n", os.Args[0])
 	fmt.Printf("  %s -config /path/to/config.yaml\n", os.Args[0])
+	fmt.Printf("  %s -config config.yaml -config-dir conf.d  # 合并conf.d中的配置文件\n", os.Args[0])
+	fmt.Printf("  %s -admin-port 0                     # 管理服务使用系统分配的空闲端口\n", os.Args[0])
+	fmt.Printf("  %s -port-range 18000-18100           # 临时监控其他端口范围，不修改配置文件\n", os.Args[0])
+	fmt.Printf("  %s -dry-run -log-level debug         # 查看将要添加的映射，不修改路由器\n", os.Args[0])
+	fmt.Printf("  %s top -once | less\n", os.Args[0])
+	fmt.Println()
+	fmt.Println("配置优先级: 命令行参数 > 环境变量 > 配置文件 > 默认值")
+	fmt.Printf("  环境变量名为 %s_ 加上大写的配置键，点号替换为下划线，如 %s_PORT_RANGE_START=18000\n", config.EnvPrefix, config.EnvPrefix)
 	fmt.Println()
 	fmt.Println("功能:")
 	fmt.Println("  1. 自动监控指定端口范围的上下线状态")
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"auto-upnp/config"
+	"auto-upnp/internal/admin"
+	"auto-upnp/internal/service"
+	"auto-upnp/internal/upnp"
+)
+
+// topStatus top命令使用的状态数据，对应/api/status的响应
+type topStatus struct {
+	PortRange struct {
+		Start     int `json:"start"`
+		End       int `json:"end"`
+		PortCount int `json:"port_count"`
+	} `json:"port_range"`
+	PortStatus struct {
+		TotalPorts      int   `json:"total_ports"`
+		ActivePortsList []int `json:"active_ports_list"`
+	} `json:"port_status"`
+	UPnPMappings struct {
+		Mappings map[string]*upnp.PortMapping `json:"mappings"`
+	} `json:"upnp_mappings"`
+	ManualMappings struct {
+		Mappings []*service.ManualMapping `json:"mappings"`
+	} `json:"manual_mappings"`
+	UPnPStatus struct {
+		ClientCount int  `json:"client_count"`
+		Available   bool `json:"available"`
+	} `json:"upnp_status"`
+	IPv6Pinholes struct {
+		Enabled       bool `json:"enabled"`
+		Available     bool `json:"available"`
+		TotalPinholes int  `json:"total_pinholes"`
+	} `json:"ipv6_pinholes"`
+}
+
+// topClient 管理API客户端
+type topClient struct {
+	baseURL  string
+	username string
+	password string
+	client   *http.Client
+}
+
+// runTop 运行top子命令，在终端中实时显示服务状态
+func runTop(args []string) error {
+	fs := flag.NewFlagSet("top", flag.ExitOnError)
+	configPath := fs.String("config", "config.yaml", "配置文件路径")
+	configDir := fs.String("config-dir", "", "配置目录")
+	addr := fs.String("addr", "", "管理服务地址 (host:port)，默认从数据目录读取")
+	once := fs.Bool("once", false, "只输出一次状态快照")
+	interval := fs.Duration("interval", time.Second, "刷新间隔")
+	fs.Parse(args)
+
+	cfg, err := config.LoadConfigWithDir(*configPath, *configDir)
+	if err != nil {
+		return fmt.Errorf("加载配置文件失败: %w", err)
+	}
+
+	if *addr == "" {
+		*addr, err = admin.ReadAddrFile(cfg.Admin.DataDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	client := &topClient{
+		baseURL:  "http://" + dialableAddr(*addr),
+		username: cfg.Admin.Username,
+		password: cfg.Admin.Password,
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}
+
+	if *once {
+		status, err := client.fetchStatus()
+		if err != nil {
+			return err
+		}
+		renderTop(os.Stdout, status)
+		return nil
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		// 清屏并将光标移到左上角
+		fmt.Print("\033[H\033[2J")
+		fmt.Printf("auto-upnp top - %s (%s)  按 Ctrl+C 退出\n\n", client.baseURL, time.Now().Format("15:04:05"))
+
+		status, err := client.fetchStatus()
+		if err != nil {
+			fmt.Printf("获取状态失败: %v\n", err)
+		} else {
+			renderTop(os.Stdout, status)
+		}
+
+		select {
+		case <-sigChan:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// fetchStatus 获取服务状态
+func (c *topClient) fetchStatus() (*topStatus, error) {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+"/api/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.username, c.password)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("连接管理服务失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("管理服务返回错误: %s", resp.Status)
+	}
+
+	var status topStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("解析状态失败: %w", err)
+	}
+	return &status, nil
+}
+
+// renderTop 以表格形式输出状态
+func renderTop(out io.Writer, status *topStatus) {
+	upnpText := "不可用"
+	if status.UPnPStatus.Available {
+		upnpText = fmt.Sprintf("可用 (%d个客户端)", status.UPnPStatus.ClientCount)
+	}
+	pinholeText := "未启用"
+	if status.IPv6Pinholes.Enabled {
+		pinholeText = "不可用"
+		if status.IPv6Pinholes.Available {
+			pinholeText = fmt.Sprintf("可用 (%d个针孔)", status.IPv6Pinholes.TotalPinholes)
+		}
+	}
+
+	fmt.Fprintf(out, "UPnP: %s    IPv6针孔: %s\n", upnpText, pinholeText)
+	fmt.Fprintf(out, "监控端口: %d-%d (%d个)    活跃端口: %d\n\n",
+		status.PortRange.Start, status.PortRange.End, status.PortRange.PortCount, len(status.PortStatus.ActivePortsList))
+
+	// 手动映射
+	manual := status.ManualMappings.Mappings
+	sort.Slice(manual, func(i, j int) bool { return manual[i].InternalPort < manual[j].InternalPort })
+
+	fmt.Fprintf(out, "手动映射 (%d)\n", len(manual))
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "内部端口\t外部端口\t协议\t状态\t描述")
+	for _, mapping := range manual {
+		state := "非活跃"
+		if mapping.Active {
+			state = "活跃"
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\n", mapping.InternalPort, mapping.CurrentExternalPort(), mapping.Protocol, state, mapping.Description)
+	}
+	tw.Flush()
+
+	// UPnP映射
+	upnpMappings := make([]*upnp.PortMapping, 0, len(status.UPnPMappings.Mappings))
+	for _, mapping := range status.UPnPMappings.Mappings {
+		upnpMappings = append(upnpMappings, mapping)
+	}
+	sort.Slice(upnpMappings, func(i, j int) bool { return upnpMappings[i].ExternalPort < upnpMappings[j].ExternalPort })
+
+	fmt.Fprintf(out, "\n路由器映射 (%d)\n", len(upnpMappings))
+	tw = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "外部端口\t内部地址\t协议\t存在时间\t描述")
+	for _, mapping := range upnpMappings {
+		fmt.Fprintf(tw, "%d\t%s:%d\t%s\t%s\t%s\n",
+			mapping.ExternalPort, mapping.InternalClient, mapping.InternalPort, mapping.Protocol,
+			time.Since(mapping.CreatedAt).Truncate(time.Second), mapping.Description)
+	}
+	tw.Flush()
+
+	// 活跃端口
+	active := status.PortStatus.ActivePortsList
+	sort.Ints(active)
+	portTexts := make([]string, len(active))
+	for i, port := range active {
+		portTexts[i] = fmt.Sprintf("%d", port)
+	}
+	fmt.Fprintf(out, "\n活跃端口: %s\n", strings.Join(portTexts, " "))
+}
+
+// dialableAddr 将监听在所有地址上的管理服务地址转换为本机可连接的地址
+func dialableAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
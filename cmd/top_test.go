@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// topStatusJSON 模拟/api/status的响应
+const topStatusJSON = `{
+	"port_range": {"start": 8000, "end": 8100, "port_count": 101},
+	"port_status": {"total_ports": 101, "active_ports_list": [8081, 8080]},
+	"upnp_mappings": {"mappings": {"8080:8080:TCP": {"internal_port": 8080, "external_port": 8080, "protocol": "TCP", "internal_client": "192.168.1.10", "description": "AutoUPnP-8080", "created_at": "2026-01-01T00:00:00Z"}}},
+	"manual_mappings": {"mappings": [{"internal_port": 22, "external_port": 2222, "protocol": "TCP", "description": "ssh", "active": true}]},
+	"upnp_status": {"client_count": 1, "available": true},
+	"ipv6_pinholes": {"enabled": false}
+}`
+
+func TestTopClient_FetchesAndRendersStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "admin" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/api/status" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(topStatusJSON))
+	}))
+	defer server.Close()
+
+	client := &topClient{baseURL: server.URL, username: "admin", password: "secret", client: &http.Client{Timeout: time.Second}}
+	status, err := client.fetchStatus()
+	if err != nil {
+		t.Fatalf("获取状态失败: %v", err)
+	}
+
+	var out bytes.Buffer
+	renderTop(&out, status)
+	for _, want := range []string{
+		"UPnP: 可用 (1个客户端)    IPv6针孔: 未启用",
+		"监控端口: 8000-8100 (101个)    活跃端口: 2",
+		"手动映射 (1)",
+		"ssh",
+		"路由器映射 (1)",
+		"192.168.1.10:8080",
+		"活跃端口: 8080 8081",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("输出缺少 %q:\n%s", want, out.String())
+		}
+	}
+
+	// 凭据错误时返回错误而不是空状态
+	client.password = "wrong"
+	if _, err := client.fetchStatus(); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("认证失败时应返回错误, 实际为 %v", err)
+	}
+}
+
+func TestDialableAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"0.0.0.0:8080", "127.0.0.1:8080"},
+		{"[::]:8080", "127.0.0.1:8080"},
+		{":8080", "127.0.0.1:8080"},
+		{"192.168.1.10:8080", "192.168.1.10:8080"},
+		{"[fe80::1]:8080", "[fe80::1]:8080"},
+		{"无效地址", "无效地址"},
+	}
+	for _, tt := range tests {
+		if got := dialableAddr(tt.addr); got != tt.want {
+			t.Errorf("dialableAddr(%q) = %q, 期望 %q", tt.addr, got, tt.want)
+		}
+	}
+}
@@ -1,6 +1,12 @@
 package config
 
 import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/spf13/viper"
@@ -8,142 +14,587 @@ import (
 
 // Config 配置结构体
 type Config struct {
-	PortRange PortRangeConfig `mapstructure:"port_range"`
-	UPnP      UPnPConfig      `mapstructure:"upnp"`
-	Network   NetworkConfig   `mapstructure:"network"`
-	Log       LogConfig       `mapstructure:"log"`
-	Monitor   MonitorConfig   `mapstructure:"monitor"`
-	Admin     AdminConfig     `mapstructure:"admin"`
+	PortRange     PortRangeConfig     `mapstructure:"port_range"`
+	UPnP          UPnPConfig          `mapstructure:"upnp"`
+	Network       NetworkConfig       `mapstructure:"network"`
+	Log           LogConfig           `mapstructure:"log"`
+	Monitor       MonitorConfig       `mapstructure:"monitor"`
+	Admin         AdminConfig         `mapstructure:"admin"`
+	ExternalIP    ExternalIPConfig    `mapstructure:"external_ip"`
+	DDNS          DDNSConfig          `mapstructure:"ddns"`
+	MDNS          MDNSConfig          `mapstructure:"mdns"`
+	Exporters     ExportersConfig     `mapstructure:"exporters"`
+	Notifications NotificationsConfig `mapstructure:"notifications"`
 }
 
 // PortRangeConfig 端口范围配置
 type PortRangeConfig struct {
-	Start int `mapstructure:"start"`
-	End   int `mapstructure:"end"`
-	Step  int `mapstructure:"step"`
+	Start    int `mapstructure:"start"`
+	End      int `mapstructure:"end"`
+	Step     int `mapstructure:"step"`
+	MaxPorts int `mapstructure:"max_ports"` // 监控端口数量上限，0表示不限制
 }
 
 // UPnPConfig UPnP配置
 type UPnPConfig struct {
-	DiscoveryTimeout    time.Duration `mapstructure:"discovery_timeout"`
-	MappingDuration     time.Duration `mapstructure:"mapping_duration"`
-	RetryAttempts       int           `mapstructure:"retry_attempts"`
-	RetryDelay          time.Duration `mapstructure:"retry_delay"`
-	HealthCheckInterval time.Duration `mapstructure:"health_check_interval"`
-	MaxFailCount        int           `mapstructure:"max_fail_count"`
-	KeepAliveInterval   time.Duration `mapstructure:"keep_alive_interval"`
-	MaxCacheSize        int           `mapstructure:"max_cache_size"`
-	CacheTTL            time.Duration `mapstructure:"cache_ttl"`
-	EnableRetry         bool          `mapstructure:"enable_retry"`
-	RetryMaxAttempts    int           `mapstructure:"retry_max_attempts"`
-	RetryBackoffFactor  float64       `mapstructure:"retry_backoff_factor"`
+	DiscoveryTimeout       time.Duration   `mapstructure:"discovery_timeout"`
+	MappingDuration        time.Duration   `mapstructure:"mapping_duration"`
+	RetryAttempts          int             `mapstructure:"retry_attempts"`
+	RetryDelay             time.Duration   `mapstructure:"retry_delay"`
+	HealthCheckInterval    time.Duration   `mapstructure:"health_check_interval"`
+	MaxFailCount           int             `mapstructure:"max_fail_count"`
+	KeepAliveInterval      time.Duration   `mapstructure:"keep_alive_interval"`
+	MaxCacheSize           int             `mapstructure:"max_cache_size"`
+	CacheTTL               time.Duration   `mapstructure:"cache_ttl"`
+	EnableRetry            bool            `mapstructure:"enable_retry"`
+	RetryMaxAttempts       int             `mapstructure:"retry_max_attempts"`
+	RetryBackoffFactor     float64         `mapstructure:"retry_backoff_factor"`
+	EnableIPv6Pinhole      bool            `mapstructure:"enable_ipv6_pinhole"`
+	UserAgent              string          `mapstructure:"user_agent"`
+	RemoveOnShutdown       bool            `mapstructure:"remove_on_shutdown"`
+	RemoveManualOnShutdown bool            `mapstructure:"remove_manual_on_shutdown"` // remove_on_shutdown为true时是否同时删除手动映射
+	InstanceID             string          `mapstructure:"instance_id"`               // 为空时使用主机名
+	RestoreConcurrency     int             `mapstructure:"restore_concurrency"`       // 启动时并发恢复手动映射的数量
+	ControlURL             string          `mapstructure:"control_url"`               // 路由器的设备描述或控制地址，不为空时跳过SSDP发现
+	GatewayURLs            []string        `mapstructure:"gateway_urls"`              // 网关的设备描述或控制地址列表，不为空时只使用这些网关，不进行SSDP发现
+	RestoreTimeout         time.Duration   `mapstructure:"restore_timeout"`           // 恢复单个手动映射的超时，0表示不限制
+	DiscoveryRetryMin      time.Duration   `mapstructure:"discovery_retry_min"`       // UPnP不可用时重新发现的初始间隔，失败后按指数退避
+	DiscoveryRetryMax      time.Duration   `mapstructure:"discovery_retry_max"`       // 重新发现的最大间隔，UPnP可用时也按该间隔重试待处理的映射
+	DriftCheckInterval     time.Duration   `mapstructure:"drift_check_interval"`      // 比较本地记录与路由器映射表的间隔，0表示不定期校验
+	ReassertOnWANChange    bool            `mapstructure:"reassert_on_wan_change"`    // 健康检查发现WAN地址变化时重新写入所有映射
+	GatewayPolicy          string          `mapstructure:"gateway_policy"`            // 多个健康网关时的选择策略: first_healthy、round_robin、weighted
+	GatewayWeights         []GatewayWeight `mapstructure:"gateway_weights"`           // weighted策略下各网关的权重
+	DryRun                 bool            `mapstructure:"dry_run"`                   // 模拟模式，只记录将要添加的映射，不修改路由器
+}
+
+// 网关选择策略的可选值，与upnp包中的策略一一对应
+const (
+	GatewayPolicyFirstHealthy = "first_healthy" // 总是使用第一个健康的网关
+	GatewayPolicyRoundRobin   = "round_robin"   // 依次轮流使用各网关
+	GatewayPolicyWeighted     = "weighted"      // 按权重分配
+)
+
+// GatewayWeight 网关的权重，Gateway可以是网关的URL、主机名（IP）或设备名，未列出的网关权重为1
+type GatewayWeight struct {
+	Gateway string `mapstructure:"gateway"`
+	Weight  int    `mapstructure:"weight"`
+}
+
+// GatewayWeightMap 按网关索引的权重
+func (u *UPnPConfig) GatewayWeightMap() map[string]int {
+	weights := make(map[string]int, len(u.GatewayWeights))
+	for _, gw := range u.GatewayWeights {
+		weights[gw.Gateway] = gw.Weight
+	}
+	return weights
 }
 
 // NetworkConfig 网络配置
 type NetworkConfig struct {
 	PreferredInterfaces []string `mapstructure:"preferred_interfaces"`
 	ExcludeInterfaces   []string `mapstructure:"exclude_interfaces"`
+	BindAddress         string   `mapstructure:"bind_address"` // 出站流量使用的本地地址，为空时由系统选择
+}
+
+// BindIP 获取出站流量使用的本地地址，未配置时返回nil
+func (n NetworkConfig) BindIP() net.IP {
+	if n.BindAddress == "" {
+		return nil
+	}
+	return net.ParseIP(n.BindAddress)
 }
 
 // LogConfig 日志配置
 type LogConfig struct {
-	Level       string `mapstructure:"level"`
-	Format      string `mapstructure:"format"`
-	File        string `mapstructure:"file"`
-	MaxSize     int64  `mapstructure:"max_size"`
-	BackupCount int    `mapstructure:"backup_count"`
+	Level          string        `mapstructure:"level"`
+	Format         string        `mapstructure:"format"`
+	File           string        `mapstructure:"file"`
+	MaxSize        int64         `mapstructure:"max_size"`
+	BackupCount    int           `mapstructure:"backup_count"`
+	SampleInterval time.Duration `mapstructure:"sample_interval"`
 }
 
 // MonitorConfig 监控配置
 type MonitorConfig struct {
-	CheckInterval   time.Duration `mapstructure:"check_interval"`
-	CleanupInterval time.Duration `mapstructure:"cleanup_interval"`
-	MaxMappings     int           `mapstructure:"max_mappings"`
+	CheckInterval        time.Duration `mapstructure:"check_interval"`
+	CleanupInterval      time.Duration `mapstructure:"cleanup_interval"`
+	MaxMappings          int           `mapstructure:"max_mappings"`
+	FastScan             bool          `mapstructure:"fast_scan"`
+	RemoveGracePeriod    time.Duration `mapstructure:"remove_grace_period"`
+	FullScanInterval     time.Duration `mapstructure:"full_scan_interval"`      // 逐端口检测时两次全量扫描的间隔，0表示每轮都全量扫描
+	AllowExecHealthCheck bool          `mapstructure:"allow_exec_health_check"` // 允许映射使用exec类型的健康检查在本机执行命令
 }
 
 // AdminConfig 管理服务配置
 type AdminConfig struct {
-	Enabled  bool   `mapstructure:"enabled"`
-	Host     string `mapstructure:"host"`
-	Username string `mapstructure:"username"`
-	Password string `mapstructure:"password"`
-	DataDir  string `mapstructure:"data_dir"`
+	Enabled       bool   `mapstructure:"enabled"`
+	Host          string `mapstructure:"host"`
+	Port          int    `mapstructure:"port"` // 0表示由系统分配，小于0表示在监控端口范围内查找
+	Username      string `mapstructure:"username"`
+	Password      string `mapstructure:"password"`
+	DataDir       string `mapstructure:"data_dir"`
+	APIToken      string `mapstructure:"api_token"`      // 脚本使用的Bearer令牌，为空时只支持Basic认证
+	IdempotentAdd bool   `mapstructure:"idempotent_add"` // 重复添加相同的映射时返回已有映射，而不是报错
+	MetricsPublic bool   `mapstructure:"metrics_public"` // /metrics不需要认证，便于Prometheus直接抓取
+}
+
+// ExternalIPConfig 公网IP获取配置
+type ExternalIPConfig struct {
+	Sources     []string      `mapstructure:"sources"` // 按优先级排列: router, stun, http
+	CacheTTL    time.Duration `mapstructure:"cache_ttl"`
+	Timeout     time.Duration `mapstructure:"timeout"`
+	HTTPURLs    []string      `mapstructure:"http_urls"`
+	STUNServers []string      `mapstructure:"stun_servers"`
+	// PublicIPOverride 固定的公网IP，设置后不再自动获取；路由器位于已知的1:1 NAT之后时使用
+	PublicIPOverride string `mapstructure:"public_ip_override"`
+}
+
+// DDNSConfig 动态域名配置，公网IP变化时自动更新解析
+type DDNSConfig struct {
+	Provider    string           `mapstructure:"provider"` // cloudflare, duckdns 或 webhook，为空时不启用
+	MinInterval time.Duration    `mapstructure:"min_interval"`
+	Timeout     time.Duration    `mapstructure:"timeout"`
+	Cloudflare  CloudflareConfig `mapstructure:"cloudflare"`
+	DuckDNS     DuckDNSConfig    `mapstructure:"duckdns"`
+	Webhook     WebhookConfig    `mapstructure:"webhook"`
 }
 
-// LoadConfig 加载配置文件
+// CloudflareConfig Cloudflare DNS配置
+type CloudflareConfig struct {
+	APIToken   string `mapstructure:"api_token"`
+	ZoneID     string `mapstructure:"zone_id"`
+	RecordName string `mapstructure:"record_name"`
+}
+
+// DuckDNSConfig DuckDNS配置
+type DuckDNSConfig struct {
+	Token   string   `mapstructure:"token"`
+	Domains []string `mapstructure:"domains"`
+}
+
+// WebhookConfig 通用回调配置，URL中的{ip}会被替换为新的公网IP
+type WebhookConfig struct {
+	URL string `mapstructure:"url"`
+}
+
+// MDNSConfig 局域网mDNS/DNS-SD服务广播配置
+type MDNSConfig struct {
+	Enabled   bool                `mapstructure:"enabled"`
+	HostName  string              `mapstructure:"host_name"` // 为空时使用主机名
+	Interface string              `mapstructure:"interface"` // 加入组播组的网卡，为空时由系统选择
+	Services  []MDNSServiceConfig `mapstructure:"services"`  // 需要广播的自动映射端口
+}
+
+// MDNSServiceConfig 自动映射端口的DNS-SD服务类型和名称
+type MDNSServiceConfig struct {
+	Port int    `mapstructure:"port"`
+	Type string `mapstructure:"type"` // 例如 _http._tcp
+	Name string `mapstructure:"name"` // 为空时使用 主机名-端口
+}
+
+// ExportersConfig 指标推送配置
+type ExportersConfig struct {
+	InfluxDB InfluxDBConfig `mapstructure:"influxdb"`
+}
+
+// NotificationsConfig 映射添加、删除和失败时的Webhook通知配置
+type NotificationsConfig struct {
+	WebhookURL  string        `mapstructure:"webhook_url"`  // 以JSON格式POST映射事件的地址，为空时不启用
+	Timeout     time.Duration `mapstructure:"timeout"`      // 单次请求超时
+	QueueSize   int           `mapstructure:"queue_size"`   // 等待推送的事件数量上限，队列满时丢弃新事件
+	MaxAttempts int           `mapstructure:"max_attempts"` // 每个事件最多尝试的次数
+	RetryDelay  time.Duration `mapstructure:"retry_delay"`  // 第一次重试前的等待时间，之后每次翻倍
+}
+
+// InfluxDBConfig InfluxDB/Telegraf行协议推送配置
+type InfluxDBConfig struct {
+	Enabled    bool              `mapstructure:"enabled"`
+	URL        string            `mapstructure:"url"`   // 行协议写入地址
+	Token      string            `mapstructure:"token"` // InfluxDB v2令牌，Telegraf一般不需要
+	Interval   time.Duration     `mapstructure:"interval"`
+	Timeout    time.Duration     `mapstructure:"timeout"`
+	MaxPending int               `mapstructure:"max_pending"` // 端点不可用时最多保留的批次数
+	Tags       map[string]string `mapstructure:"tags"`        // 附加到每个指标的标签
+}
+
+// LoadConfig 加载配置文件，configPath为目录时合并目录下的所有配置文件
 func LoadConfig(configPath string) (*Config, error) {
-	viper.SetConfigFile(configPath)
-	viper.SetConfigType("yaml")
+	return LoadConfigWithDir(configPath, "")
+}
+
+// LoadConfigWithDir 加载主配置文件并合并配置目录中的配置文件
+// 合并顺序：主配置文件最先加载，配置目录中的文件按文件名排序依次覆盖，
+// 映射类配置按键深度合并，列表类配置整体替换
+func LoadConfigWithDir(configPath, configDir string) (*Config, error) {
+	files, err := ConfigFiles(configPath, configDir)
+	if err != nil {
+		return nil, err
+	}
+
+	v := viper.New()
+	v.SetConfigType("yaml")
 
 	// 设置默认值
-	setDefaults()
+	setDefaults(v)
 
-	if err := viper.ReadInConfig(); err != nil {
-		return nil, err
+	// 环境变量覆盖配置文件，只对有默认值或在配置文件中出现的键生效
+	v.SetEnvPrefix(EnvPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+
+	for i, file := range files {
+		v.SetConfigFile(file)
+		if i == 0 {
+			err = v.ReadInConfig()
+		} else {
+			err = v.MergeInConfig()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("读取配置文件 %s 失败: %w", file, err)
+		}
 	}
 
 	var config Config
-	if err := viper.Unmarshal(&config); err != nil {
+	if err := v.Unmarshal(&config); err != nil {
 		return nil, err
 	}
 
+	dataDir, err := ExpandPath(config.Admin.DataDir)
+	if err != nil {
+		return nil, fmt.Errorf("解析数据目录失败: %w", err)
+	}
+	config.Admin.DataDir = dataDir
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("配置校验失败: %w", err)
+	}
+
 	return &config, nil
 }
 
+// ConfigFiles 按合并顺序列出需要加载的配置文件
+func ConfigFiles(configPath, configDir string) ([]string, error) {
+	var files []string
+
+	if configPath != "" {
+		info, err := os.Stat(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("访问配置文件 %s 失败: %w", configPath, err)
+		}
+		if info.IsDir() {
+			// 直接指定目录时等同于只使用配置目录
+			if configDir != "" {
+				return nil, fmt.Errorf("配置文件 %s 是目录，不能与配置目录同时指定", configPath)
+			}
+			configDir = configPath
+		} else {
+			files = append(files, configPath)
+		}
+	}
+
+	if configDir != "" {
+		entries, err := os.ReadDir(configDir)
+		if err != nil {
+			return nil, fmt.Errorf("读取配置目录 %s 失败: %w", configDir, err)
+		}
+		// os.ReadDir 已按文件名排序
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || strings.HasPrefix(name, ".") {
+				continue
+			}
+			if ext := filepath.Ext(name); ext != ".yaml" && ext != ".yml" {
+				continue
+			}
+			files = append(files, filepath.Join(configDir, name))
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("没有找到配置文件")
+	}
+
+	return files, nil
+}
+
+// Validate 校验合并后的完整配置
+func (c *Config) Validate() error {
+	if !validPort(c.PortRange.Start) || !validPort(c.PortRange.End) {
+		return fmt.Errorf("端口范围 %d-%d 超出 1-65535", c.PortRange.Start, c.PortRange.End)
+	}
+	if c.PortRange.Start > c.PortRange.End {
+		return fmt.Errorf("端口范围起始端口 %d 大于结束端口 %d", c.PortRange.Start, c.PortRange.End)
+	}
+	if c.Network.BindAddress != "" && c.Network.BindIP() == nil {
+		return fmt.Errorf("绑定地址 %q 不是合法的IP地址", c.Network.BindAddress)
+	}
+	if c.UPnP.ControlURL != "" {
+		if u, err := url.Parse(c.UPnP.ControlURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("UPnP控制URL %q 不是合法的HTTP地址", c.UPnP.ControlURL)
+		}
+	}
+	for _, gatewayURL := range c.UPnP.GatewayURLs {
+		if u, err := url.Parse(gatewayURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("upnp.gateway_urls 中的 %q 不是合法的HTTP地址", gatewayURL)
+		}
+	}
+	if c.UPnP.ControlURL != "" && len(c.UPnP.GatewayURLs) > 0 {
+		return fmt.Errorf("upnp.control_url 和 upnp.gateway_urls 不能同时配置，可以把control_url加入gateway_urls")
+	}
+	if c.UPnP.DiscoveryRetryMin <= 0 || c.UPnP.DiscoveryRetryMax < c.UPnP.DiscoveryRetryMin {
+		return fmt.Errorf("UPnP重新发现间隔配置错误: 最小 %s, 最大 %s", c.UPnP.DiscoveryRetryMin, c.UPnP.DiscoveryRetryMax)
+	}
+	if !validGatewayPolicy(c.UPnP.GatewayPolicy) {
+		return fmt.Errorf("upnp.gateway_policy %q 无效，可选 first_healthy、round_robin、weighted", c.UPnP.GatewayPolicy)
+	}
+	for _, gw := range c.UPnP.GatewayWeights {
+		if gw.Gateway == "" || gw.Weight < 0 {
+			return fmt.Errorf("upnp.gateway_weights 配置错误: 网关 %q, 权重 %d", gw.Gateway, gw.Weight)
+		}
+	}
+	if override := c.ExternalIP.PublicIPOverride; override != "" && !isPublicIP(net.ParseIP(override)) {
+		return fmt.Errorf("external_ip.public_ip_override %q 不是合法的公网IP地址", override)
+	}
+	if c.Admin.Port > 65535 {
+		return fmt.Errorf("管理服务端口 %d 超出 0-65535", c.Admin.Port)
+	}
+	if influx := c.Exporters.InfluxDB; influx.Enabled && (influx.URL == "" || influx.Interval <= 0) {
+		return fmt.Errorf("启用InfluxDB推送时必须配置exporters.influxdb.url和大于0的interval")
+	}
+	if n := c.Notifications; n.WebhookURL != "" {
+		if u, err := url.Parse(n.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("notifications.webhook_url %q 不是合法的HTTP地址", n.WebhookURL)
+		}
+		if n.QueueSize <= 0 || n.MaxAttempts <= 0 {
+			return fmt.Errorf("notifications.queue_size 和 notifications.max_attempts 必须大于0")
+		}
+	}
+	for _, svc := range c.MDNS.Services {
+		if !validPort(svc.Port) || svc.Type == "" {
+			return fmt.Errorf("mDNS服务配置错误: 端口 %d, 类型 %q", svc.Port, svc.Type)
+		}
+	}
+	return nil
+}
+
+// validPort 判断端口号是否合法
+func validPort(port int) bool {
+	return port >= 1 && port <= 65535
+}
+
+// validGatewayPolicy 判断网关选择策略是否有效，空字符串等同于first_healthy
+func validGatewayPolicy(policy string) bool {
+	switch policy {
+	case "", GatewayPolicyFirstHealthy, GatewayPolicyRoundRobin, GatewayPolicyWeighted:
+		return true
+	}
+	return false
+}
+
+// isPublicIP 检查IP是否为公网地址，排除私有、回环、链路本地、组播和运营商级NAT地址
+func isPublicIP(ip net.IP) bool {
+	if ip == nil || ip.IsUnspecified() || ip.IsLoopback() || ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+		return false
+	}
+	// 100.64.0.0/10 运营商级NAT地址
+	if ip4 := ip.To4(); ip4 != nil && ip4[0] == 100 && ip4[1]&0xc0 == 64 {
+		return false
+	}
+	return true
+}
+
+// ExpandPath 展开路径中的~和环境变量
+func ExpandPath(path string) (string, error) {
+	path = os.ExpandEnv(path)
+
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("无法获取用户主目录: %w", err)
+		}
+		path = filepath.Join(home, strings.TrimPrefix(path, "~"))
+	}
+
+	return filepath.Clean(path), nil
+}
+
+// EnsureDataDir 确保数据目录存在且可写，目录不存在时以0700权限创建
+func EnsureDataDir(dir string) error {
+	if dir == "" {
+		return fmt.Errorf("数据目录未配置")
+	}
+
+	info, err := os.Stat(dir)
+	switch {
+	case os.IsNotExist(err):
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("创建数据目录 %s 失败: %w", dir, err)
+		}
+	case err != nil:
+		return fmt.Errorf("访问数据目录 %s 失败: %w", dir, err)
+	case !info.IsDir():
+		return fmt.Errorf("数据目录 %s 不是目录", dir)
+	}
+
+	// 写入探测文件检查写权限
+	probeFile := filepath.Join(dir, ".write_probe")
+	if err := os.WriteFile(probeFile, []byte("probe"), 0600); err != nil {
+		return fmt.Errorf("数据目录 %s 不可写: %w", dir, err)
+	}
+	os.Remove(probeFile)
+
+	return nil
+}
+
+// PrepareDataDir 校验管理服务的数据目录
+func (c *Config) PrepareDataDir() error {
+	return EnsureDataDir(c.Admin.DataDir)
+}
+
 // setDefaults 设置默认配置值
-func setDefaults() {
+func setDefaults(v *viper.Viper) {
 	// 端口范围默认值
-	viper.SetDefault("port_range.start", 8000)
-	viper.SetDefault("port_range.end", 9000)
-	viper.SetDefault("port_range.step", 1)
+	v.SetDefault("port_range.start", 8000)
+	v.SetDefault("port_range.end", 9000)
+	v.SetDefault("port_range.step", 1)
+	v.SetDefault("port_range.max_ports", 4096)
 
 	// UPnP默认值
-	viper.SetDefault("upnp.discovery_timeout", 10)
-	viper.SetDefault("upnp.mapping_duration", "1h")
-	viper.SetDefault("upnp.retry_attempts", 3)
-	viper.SetDefault("upnp.retry_delay", "5s")
-	viper.SetDefault("upnp.health_check_interval", "1m")
-	viper.SetDefault("upnp.max_fail_count", 3)
-	viper.SetDefault("upnp.keep_alive_interval", "2m")
-	viper.SetDefault("upnp.max_cache_size", 1000)
-	viper.SetDefault("upnp.cache_ttl", "1h")
-	viper.SetDefault("upnp.enable_retry", true)
-	viper.SetDefault("upnp.retry_max_attempts", 5)
-	viper.SetDefault("upnp.retry_backoff_factor", 2.0)
+	v.SetDefault("upnp.discovery_timeout", 10)
+	v.SetDefault("upnp.mapping_duration", "1h")
+	v.SetDefault("upnp.retry_attempts", 3)
+	v.SetDefault("upnp.retry_delay", "5s")
+	v.SetDefault("upnp.health_check_interval", "1m")
+	v.SetDefault("upnp.max_fail_count", 3)
+	v.SetDefault("upnp.keep_alive_interval", "2m")
+	v.SetDefault("upnp.max_cache_size", 1000)
+	v.SetDefault("upnp.cache_ttl", "1h")
+	v.SetDefault("upnp.enable_retry", true)
+	v.SetDefault("upnp.retry_max_attempts", 5)
+	v.SetDefault("upnp.retry_backoff_factor", 2.0)
+	v.SetDefault("upnp.enable_ipv6_pinhole", true)
+	v.SetDefault("upnp.user_agent", "")
+	v.SetDefault("upnp.remove_on_shutdown", true)
+	v.SetDefault("upnp.remove_manual_on_shutdown", false)
+	v.SetDefault("upnp.instance_id", "")
+	v.SetDefault("upnp.restore_concurrency", 4)
+	v.SetDefault("upnp.restore_timeout", "30s")
+	v.SetDefault("upnp.control_url", "")
+	v.SetDefault("upnp.gateway_urls", []string{})
+	v.SetDefault("upnp.discovery_retry_min", "5s")
+	v.SetDefault("upnp.discovery_retry_max", "5m")
+	v.SetDefault("upnp.drift_check_interval", "15m")
+	v.SetDefault("upnp.reassert_on_wan_change", true)
+	v.SetDefault("upnp.gateway_policy", GatewayPolicyFirstHealthy)
+	v.SetDefault("upnp.dry_run", false)
 
 	// 网络默认值
-	viper.SetDefault("network.preferred_interfaces", []string{"eth0", "wlan0"})
-	viper.SetDefault("network.exclude_interfaces", []string{"lo", "docker"})
+	v.SetDefault("network.preferred_interfaces", []string{"eth0", "wlan0"})
+	v.SetDefault("network.exclude_interfaces", []string{"lo", "docker"})
+	v.SetDefault("network.bind_address", "")
 
 	// 日志默认值
-	viper.SetDefault("log.level", "info")
-	viper.SetDefault("log.format", "json")
-	viper.SetDefault("log.file", "auto_upnp.log")
-	viper.SetDefault("log.max_size", 10*1024*1024) // 10MB
-	viper.SetDefault("log.backup_count", 5)
+	v.SetDefault("log.level", "info")
+	v.SetDefault("log.format", "json")
+	v.SetDefault("log.file", "auto_upnp.log")
+	v.SetDefault("log.max_size", 10*1024*1024) // 10MB
+	v.SetDefault("log.backup_count", 5)
+	v.SetDefault("log.sample_interval", 0)
 
 	// 监控默认值
-	viper.SetDefault("monitor.check_interval", "30s")
-	viper.SetDefault("monitor.cleanup_interval", "5m")
-	viper.SetDefault("monitor.max_mappings", 100)
+	v.SetDefault("monitor.check_interval", "30s")
+	v.SetDefault("monitor.cleanup_interval", "5m")
+	v.SetDefault("monitor.max_mappings", 100)
+	v.SetDefault("monitor.fast_scan", false)
+	v.SetDefault("monitor.remove_grace_period", 0)
+	v.SetDefault("monitor.full_scan_interval", 0)
+	v.SetDefault("monitor.allow_exec_health_check", false)
 
 	// 管理服务默认值
-	viper.SetDefault("admin.enabled", true)
-	viper.SetDefault("admin.host", "0.0.0.0")
-	viper.SetDefault("admin.username", "admin")
-	viper.SetDefault("admin.password", "admin")
-	viper.SetDefault("admin.data_dir", "data")
+	v.SetDefault("admin.enabled", true)
+	v.SetDefault("admin.host", "0.0.0.0")
+	v.SetDefault("admin.port", -1)
+	v.SetDefault("admin.username", "admin")
+	v.SetDefault("admin.password", "admin")
+	v.SetDefault("admin.data_dir", "data")
+	v.SetDefault("admin.api_token", "")
+	v.SetDefault("admin.idempotent_add", false)
+	v.SetDefault("admin.metrics_public", false)
+
+	// 公网IP默认值
+	v.SetDefault("external_ip.sources", []string{"router", "stun", "http"})
+	v.SetDefault("external_ip.cache_ttl", "5m")
+	v.SetDefault("external_ip.timeout", "5s")
+	v.SetDefault("external_ip.http_urls", []string{"https://api.ipify.org", "https://ifconfig.me/ip"})
+	v.SetDefault("external_ip.stun_servers", []string{}) // 为空时使用externalip.DefaultSTUNServers
+	v.SetDefault("external_ip.public_ip_override", "")
+
+	// DDNS默认值
+	v.SetDefault("ddns.provider", "")
+	v.SetDefault("ddns.min_interval", "5m")
+	v.SetDefault("ddns.timeout", "10s")
+
+	// mDNS默认值
+	v.SetDefault("mdns.enabled", false)
+	v.SetDefault("mdns.host_name", "")
+	v.SetDefault("mdns.interface", "")
+
+	// 指标推送默认值
+	v.SetDefault("exporters.influxdb.enabled", false)
+	v.SetDefault("exporters.influxdb.interval", "30s")
+	v.SetDefault("exporters.influxdb.timeout", "5s")
+	v.SetDefault("exporters.influxdb.max_pending", 10)
+
+	// 映射事件通知默认值
+	v.SetDefault("notifications.webhook_url", "")
+	v.SetDefault("notifications.timeout", "10s")
+	v.SetDefault("notifications.queue_size", 100)
+	v.SetDefault("notifications.max_attempts", 3)
+	v.SetDefault("notifications.retry_delay", "2s")
 }
 
 // GetPortRange 获取端口范围列表
 func (c *Config) GetPortRange() []int {
 	var ports []int
-	for i := c.PortRange.Start; i <= c.PortRange.End; i += c.PortRange.Step {
+	step := c.portStep()
+	for i := c.PortRange.Start; i <= c.PortRange.End; i += step {
 		ports = append(ports, i)
 	}
 	return ports
 }
 
+// InPortRange 端口是否落在自动监控的端口范围内（按起止端口判断，不考虑端口间隔）
+func (c *Config) InPortRange(port int) bool {
+	return port >= c.PortRange.Start && port <= c.PortRange.End
+}
+
+// PortCount 计算端口范围内需要监控的端口数量
+func (c *Config) PortCount() int {
+	if c.PortRange.End < c.PortRange.Start {
+		return 0
+	}
+	return (c.PortRange.End-c.PortRange.Start)/c.portStep() + 1
+}
+
+// CheckPortRange 检查监控的端口数量是否超过上限
+func (c *Config) CheckPortRange() error {
+	count := c.PortCount()
+	if c.PortRange.MaxPorts > 0 && count > c.PortRange.MaxPorts {
+		return fmt.Errorf("端口范围 %d-%d 包含 %d 个端口，超过上限 %d，建议缩小端口范围或增大端口间隔",
+			c.PortRange.Start, c.PortRange.End, count, c.PortRange.MaxPorts)
+	}
+	return nil
+}
+
+// portStep 获取端口间隔，未配置或非法时按1处理
+func (c *Config) portStep() int {
+	if c.PortRange.Step <= 0 {
+		return 1
+	}
+	return c.PortRange.Step
+}
+
 // GetPortPairs 获取端口对列表 (内部端口, 外部端口)
 func (c *Config) GetPortPairs() [][2]int {
 	ports := c.GetPortRange()
@@ -0,0 +1,235 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnsureDataDir_CreatesMissing(t *testing.T) {
+	dataDir := filepath.Join(t.TempDir(), "nested", "data")
+
+	if err := EnsureDataDir(dataDir); err != nil {
+		t.Fatalf("创建数据目录失败: %v", err)
+	}
+
+	info, err := os.Stat(dataDir)
+	if err != nil {
+		t.Fatalf("数据目录未创建: %v", err)
+	}
+	if !info.IsDir() {
+		t.Fatal("数据目录不是目录")
+	}
+	if perm := info.Mode().Perm(); perm != 0700 {
+		t.Errorf("数据目录权限错误: %o", perm)
+	}
+
+	// 探测文件应当被清理
+	if _, err := os.Stat(filepath.Join(dataDir, ".write_probe")); !os.IsNotExist(err) {
+		t.Error("探测文件未被清理")
+	}
+}
+
+func TestEnsureDataDir_PermissionError(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root用户不受目录权限限制")
+	}
+
+	dataDir := t.TempDir()
+	if err := os.Chmod(dataDir, 0500); err != nil {
+		t.Fatalf("修改目录权限失败: %v", err)
+	}
+	defer os.Chmod(dataDir, 0700)
+
+	if err := EnsureDataDir(dataDir); err == nil {
+		t.Fatal("只读目录应当返回错误")
+	}
+}
+
+func TestEnsureDataDir_NotDirectory(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(filePath, []byte("x"), 0600); err != nil {
+		t.Fatalf("创建文件失败: %v", err)
+	}
+
+	if err := EnsureDataDir(filePath); err == nil {
+		t.Fatal("普通文件应当返回错误")
+	}
+}
+
+func TestExpandPath(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip("无法获取用户主目录")
+	}
+	t.Setenv("AUTO_UPNP_TEST_DIR", "/srv/auto-upnp")
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"~/data", filepath.Join(home, "data")},
+		{"$AUTO_UPNP_TEST_DIR/data", "/srv/auto-upnp/data"},
+		{"data/", "data"},
+	}
+
+	for _, tt := range tests {
+		got, err := ExpandPath(tt.input)
+		if err != nil {
+			t.Errorf("ExpandPath(%q) 返回错误: %v", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ExpandPath(%q) = %q, 期望 %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestPortCount(t *testing.T) {
+	tests := []struct {
+		start, end, step int
+		want             int
+	}{
+		{8000, 9000, 1, 1001},
+		{8000, 9000, 10, 101},
+		{8000, 8000, 1, 1},
+		{9000, 8000, 1, 0},
+		{8000, 8009, 0, 10}, // 非法间隔按1处理
+	}
+
+	for _, tt := range tests {
+		cfg := &Config{PortRange: PortRangeConfig{Start: tt.start, End: tt.end, Step: tt.step}}
+		if got := cfg.PortCount(); got != tt.want {
+			t.Errorf("PortCount(%d-%d/%d) = %d, 期望 %d", tt.start, tt.end, tt.step, got, tt.want)
+		}
+		if got := len(cfg.GetPortRange()); got != tt.want {
+			t.Errorf("GetPortRange(%d-%d/%d) 返回 %d 个端口, 期望 %d", tt.start, tt.end, tt.step, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPortRange(t *testing.T) {
+	cfg := &Config{PortRange: PortRangeConfig{Start: 1, End: 65535, Step: 1, MaxPorts: 4096}}
+	if err := cfg.CheckPortRange(); err == nil {
+		t.Error("端口数量超过上限时应当返回错误")
+	}
+
+	cfg.PortRange.MaxPorts = 0
+	if err := cfg.CheckPortRange(); err != nil {
+		t.Errorf("上限为0时不应限制: %v", err)
+	}
+
+	cfg.PortRange = PortRangeConfig{Start: 18000, End: 19000, Step: 1, MaxPorts: 4096}
+	if err := cfg.CheckPortRange(); err != nil {
+		t.Errorf("默认端口范围不应超过上限: %v", err)
+	}
+}
+
+func TestLoadConfigWithDir_Merge(t *testing.T) {
+	dir := t.TempDir()
+	mainFile := filepath.Join(dir, "config.yaml")
+	confDir := filepath.Join(dir, "conf.d")
+	if err := os.Mkdir(confDir, 0700); err != nil {
+		t.Fatalf("创建配置目录失败: %v", err)
+	}
+
+	files := map[string]string{
+		mainFile:                                 "port_range:\n  start: 8000\n  end: 8100\nadmin:\n  username: base\n  data_dir: " + dir + "\n",
+		filepath.Join(confDir, "10-range.yaml"):  "port_range:\n  end: 8200\n",
+		filepath.Join(confDir, "20-admin.yml"):   "admin:\n  username: override\n",
+		filepath.Join(confDir, "30-ignored.txt"): "admin:\n  username: ignored\n",
+		filepath.Join(confDir, ".hidden.yaml"):   "admin:\n  username: hidden\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("写入配置文件失败: %v", err)
+		}
+	}
+
+	cfg, err := LoadConfigWithDir(mainFile, confDir)
+	if err != nil {
+		t.Fatalf("加载配置失败: %v", err)
+	}
+
+	if cfg.PortRange.Start != 8000 || cfg.PortRange.End != 8200 {
+		t.Errorf("端口范围合并错误: %d-%d", cfg.PortRange.Start, cfg.PortRange.End)
+	}
+	if cfg.Admin.Username != "override" {
+		t.Errorf("后加载的文件应当覆盖前面的配置: %s", cfg.Admin.Username)
+	}
+	if cfg.Log.Level != "info" {
+		t.Errorf("未配置的项应当使用默认值: %s", cfg.Log.Level)
+	}
+}
+
+func TestLoadConfigWithDir_ValidatesMergedResult(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "10-base.yaml"), []byte("port_range:\n  start: 9000\n  end: 9100\n"), 0600); err != nil {
+		t.Fatalf("写入配置文件失败: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "20-bad.yaml"), []byte("port_range:\n  end: 8000\n"), 0600); err != nil {
+		t.Fatalf("写入配置文件失败: %v", err)
+	}
+
+	// 每个文件单独都合法，合并后起始端口大于结束端口
+	if _, err := LoadConfig(dir); err == nil {
+		t.Fatal("合并后的非法配置应当返回错误")
+	}
+}
+
+func TestValidate_PublicIPOverride(t *testing.T) {
+	for override, valid := range map[string]bool{
+		"":            true,
+		"203.0.113.7": true,
+		"2001:db8::1": true,
+		"192.168.1.1": false,
+		"100.64.0.1":  false,
+		"not-an-ip":   false,
+	} {
+		cfg := &Config{
+			PortRange:  PortRangeConfig{Start: 8000, End: 8100},
+			UPnP:       UPnPConfig{DiscoveryRetryMin: 1, DiscoveryRetryMax: 1},
+			ExternalIP: ExternalIPConfig{PublicIPOverride: override},
+		}
+		if err := cfg.Validate(); (err == nil) != valid {
+			t.Errorf("public_ip_override=%q 校验结果为 %v, 期望合法=%v", override, err, valid)
+		}
+	}
+}
+
+func TestValidate_GatewayPolicy(t *testing.T) {
+	for policy, valid := range map[string]bool{
+		"":                        true,
+		GatewayPolicyFirstHealthy: true,
+		GatewayPolicyRoundRobin:   true,
+		GatewayPolicyWeighted:     true,
+		"random":                  false,
+	} {
+		cfg := &Config{
+			PortRange: PortRangeConfig{Start: 8000, End: 8100},
+			UPnP:      UPnPConfig{DiscoveryRetryMin: 1, DiscoveryRetryMax: 1, GatewayPolicy: policy},
+		}
+		if err := cfg.Validate(); (err == nil) != valid {
+			t.Errorf("gateway_policy=%q 校验结果为 %v, 期望合法=%v", policy, err, valid)
+		}
+	}
+}
+
+func TestValidate_BindAddress(t *testing.T) {
+	for address, valid := range map[string]bool{
+		"":             true,
+		"192.168.1.10": true,
+		"2001:db8::10": true,
+		"eth0":         false,
+		"192.168.1":    false,
+	} {
+		cfg := &Config{
+			PortRange: PortRangeConfig{Start: 8000, End: 8100},
+			UPnP:      UPnPConfig{DiscoveryRetryMin: 1, DiscoveryRetryMax: 1},
+			Network:   NetworkConfig{BindAddress: address},
+		}
+		if err := cfg.Validate(); (err == nil) != valid {
+			t.Errorf("bind_address=%q 校验结果为 %v, 期望合法=%v", address, err, valid)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix 环境变量前缀，配置项的键转为大写、点号替换为下划线，
+// 例如 AUTO_UPNP_PORT_RANGE_START 对应 port_range.start
+const EnvPrefix = "AUTO_UPNP"
+
+// Overrides 命令行参数对配置的覆盖，优先级：命令行参数 > 环境变量 > 配置文件 > 默认值
+// 字段为零值（AdminPort为nil）时表示不覆盖
+type Overrides struct {
+	PortRange string // 端口范围，格式为 起始端口-结束端口，或单个端口
+	AdminPort *int   // 管理服务端口，0表示由系统分配空闲端口
+	DataDir   string // 数据目录
+	DryRun    bool   // 模拟模式，为false时使用配置文件中的upnp.dry_run
+}
+
+// ParsePortRange 解析 起始端口-结束端口 格式的端口范围，单个端口表示只监控该端口
+func ParsePortRange(value string) (int, int, error) {
+	startText, endText, isRange := strings.Cut(strings.TrimSpace(value), "-")
+	if !isRange {
+		endText = startText
+	}
+
+	start, err := strconv.Atoi(strings.TrimSpace(startText))
+	if err != nil {
+		return 0, 0, fmt.Errorf("端口范围格式错误: %q，应为 起始端口-结束端口", value)
+	}
+	end, err := strconv.Atoi(strings.TrimSpace(endText))
+	if err != nil {
+		return 0, 0, fmt.Errorf("端口范围格式错误: %q，应为 起始端口-结束端口", value)
+	}
+	return start, end, nil
+}
+
+// ApplyOverrides 在加载配置后应用命令行覆盖并重新校验，只修改内存中的配置，不会写回配置文件
+func (c *Config) ApplyOverrides(o Overrides) error {
+	if o.PortRange != "" {
+		start, end, err := ParsePortRange(o.PortRange)
+		if err != nil {
+			return err
+		}
+		c.PortRange.Start = start
+		c.PortRange.End = end
+	}
+	if o.AdminPort != nil {
+		c.Admin.Port = *o.AdminPort
+	}
+	if o.DataDir != "" {
+		dataDir, err := ExpandPath(o.DataDir)
+		if err != nil {
+			return fmt.Errorf("解析数据目录失败: %w", err)
+		}
+		c.Admin.DataDir = dataDir
+	}
+	if o.DryRun {
+		c.UPnP.DryRun = true
+	}
+
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("命令行参数校验失败: %w", err)
+	}
+	return nil
+}
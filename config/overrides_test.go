@@ -0,0 +1,87 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		input      string
+		start, end int
+		valid      bool
+	}{
+		{"18000-19000", 18000, 19000, true},
+		{" 8080 - 8090 ", 8080, 8090, true},
+		{"8080", 8080, 8080, true},
+		{"8080-", 0, 0, false},
+		{"abc", 0, 0, false},
+	}
+	for _, tt := range tests {
+		start, end, err := ParsePortRange(tt.input)
+		if tt.valid && (err != nil || start != tt.start || end != tt.end) {
+			t.Errorf("ParsePortRange(%q) = %d, %d, %v, 期望 %d-%d", tt.input, start, end, err, tt.start, tt.end)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("ParsePortRange(%q) 应返回错误", tt.input)
+		}
+	}
+}
+
+func TestApplyOverrides_Precedence(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	content := "port_range:\n  start: 8000\n  end: 8100\nadmin:\n  port: 8080\n  username: base\n  data_dir: " + dir + "\n"
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatalf("写入配置文件失败: %v", err)
+	}
+
+	// 环境变量覆盖配置文件
+	t.Setenv(EnvPrefix+"_ADMIN_PORT", "9090")
+	t.Setenv(EnvPrefix+"_ADMIN_USERNAME", "env")
+	cfg, err := LoadConfig(file)
+	if err != nil {
+		t.Fatalf("加载配置失败: %v", err)
+	}
+	if cfg.Admin.Port != 9090 || cfg.Admin.Username != "env" {
+		t.Errorf("环境变量应覆盖配置文件: port=%d, username=%s", cfg.Admin.Port, cfg.Admin.Username)
+	}
+
+	// 命令行参数覆盖环境变量
+	adminPort := 0
+	if err := cfg.ApplyOverrides(Overrides{PortRange: "18000-18010", AdminPort: &adminPort}); err != nil {
+		t.Fatalf("应用命令行参数失败: %v", err)
+	}
+	if cfg.PortRange.Start != 18000 || cfg.PortRange.End != 18010 {
+		t.Errorf("端口范围为 %d-%d, 期望 18000-18010", cfg.PortRange.Start, cfg.PortRange.End)
+	}
+	if cfg.Admin.Port != 0 {
+		t.Errorf("管理端口为 %d, 期望命令行参数的 0", cfg.Admin.Port)
+	}
+	if cfg.Admin.Username != "env" {
+		t.Errorf("未指定的项不应被覆盖: %s", cfg.Admin.Username)
+	}
+
+	// 覆盖只作用于内存中的配置
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("读取配置文件失败: %v", err)
+	}
+	if string(data) != content {
+		t.Errorf("配置文件被修改: %q", data)
+	}
+}
+
+func TestApplyOverrides_Validates(t *testing.T) {
+	cfg := &Config{
+		PortRange: PortRangeConfig{Start: 8000, End: 8100},
+		UPnP:      UPnPConfig{DiscoveryRetryMin: 1, DiscoveryRetryMax: 1},
+	}
+	if err := cfg.ApplyOverrides(Overrides{PortRange: "9000-8000"}); err == nil {
+		t.Error("起始端口大于结束端口时应返回错误")
+	}
+	if err := cfg.ApplyOverrides(Overrides{PortRange: "0-100"}); err == nil {
+		t.Error("端口超出范围时应返回错误")
+	}
+}
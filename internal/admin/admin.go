@@ -4,35 +4,88 @@ import (
 	"context"
 	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"auto-upnp/config"
+	"auto-upnp/internal/mdns"
+	"auto-upnp/internal/metrics"
 	"auto-upnp/internal/service"
+	"auto-upnp/internal/upnp"
 
 	"github.com/sirupsen/logrus"
 )
 
+// maxNoteLength 映射备注的最大字符数
+const maxNoteLength = 500
+
+// maxGroupNameLength 映射分组名称的最大字符数
+const maxGroupNameLength = 64
+
+// AddrFileName 数据目录中记录管理服务监听地址的文件名
+const AddrFileName = "admin.addr"
+
+// 状态长轮询的等待上限，以及写超时在等待时间之外预留的余量
+const (
+	maxStatusWait     = 2 * time.Minute
+	statusWriteMargin = 10 * time.Second
+)
+
 // AdminServer HTTP管理服务器
 type AdminServer struct {
 	config      *config.Config
 	logger      *logrus.Logger
 	autoService *service.AutoUPnPService
 	server      *http.Server
+	listener    net.Listener // Listen预先创建的监听器，Start时使用
 	port        int
+	csrfToken   string
+	metrics     http.Handler
 }
 
 // NewAdminServer 创建新的管理服务器
 func NewAdminServer(cfg *config.Config, logger *logrus.Logger, autoService *service.AutoUPnPService) *AdminServer {
-	return &AdminServer{
+	as := &AdminServer{
 		config:      cfg,
 		logger:      logger,
 		autoService: autoService,
+		csrfToken:   newCSRFToken(),
 	}
+	as.metrics = metrics.NewPrometheusHandler(func() []metrics.Sample { return as.autoService.Metrics() }, logger)
+	return as
+}
+
+// Listen 监听管理端口，端口在监控端口范围内时将其从自动映射中排除
+// 应在自动UPnP服务启动前调用，避免第一次端口扫描就把管理端口映射到公网；未调用时由Start调用
+func (as *AdminServer) Listen() error {
+	if !as.config.Admin.Enabled || as.listener != nil {
+		return nil
+	}
+
+	// 直接保留监听器，避免探测端口后到真正监听之间端口被占用
+	listener, err := as.listen()
+	if err != nil {
+		return fmt.Errorf("无法找到可用端口: %w", err)
+	}
+	as.listener = listener
+	as.port = listener.Addr().(*net.TCPAddr).Port
+
+	// 管理端口在监控范围内时不能被自动映射到公网
+	if as.port >= as.config.PortRange.Start && as.port <= as.config.PortRange.End {
+		as.logger.WithField("port", as.port).Info("管理服务端口在监控端口范围内，已从自动映射中排除")
+		as.autoService.ExcludeAutoPort(as.port)
+	}
+	return nil
 }
 
 // Start 启动管理服务器
@@ -42,23 +95,44 @@ func (as *AdminServer) Start() error {
 		return nil
 	}
 
-	// 找到可用的端口
-	port, err := as.findAvailablePort()
-	if err != nil {
-		return fmt.Errorf("无法找到可用端口: %w", err)
+	if err := as.Listen(); err != nil {
+		return err
 	}
-	as.port = port
+	listener, port := as.listener, as.port
 
 	// 设置路由
 	mux := http.NewServeMux()
+	// 就绪探针不需要认证，便于容器编排和监控系统调用
+	mux.HandleFunc("/readyz", as.handleReadyz)
+	if as.config.Admin.MetricsPublic {
+		mux.HandleFunc("/metrics", as.handleMetrics)
+	} else {
+		mux.HandleFunc("/metrics", as.authMiddleware(as.handleMetrics))
+	}
 	mux.HandleFunc("/", as.authMiddleware(as.handleIndex))
 	mux.HandleFunc("/api/status", as.authMiddleware(as.handleStatus))
+	mux.HandleFunc("/api/health", as.authMiddleware(as.handleHealth))
+	mux.HandleFunc("/api/capabilities", as.authMiddleware(as.handleCapabilities))
 	mux.HandleFunc("/api/mappings", as.authMiddleware(as.handleMappings))
+	mux.HandleFunc("/api/mappings/", as.authMiddleware(as.handleMappingByID))
+	mux.HandleFunc("/api/mappings/export", as.authMiddleware(as.handleExportMappings))
 	mux.HandleFunc("/api/manual-mappings", as.authMiddleware(as.handleManualMappings))
 	mux.HandleFunc("/api/add-mapping", as.authMiddleware(as.handleAddMapping))
 	mux.HandleFunc("/api/remove-mapping", as.authMiddleware(as.handleRemoveMapping))
+	mux.HandleFunc("/api/refresh-mapping", as.authMiddleware(as.handleRefreshMapping))
 	mux.HandleFunc("/api/ports", as.authMiddleware(as.handlePorts))
 	mux.HandleFunc("/api/upnp-status", as.authMiddleware(as.handleUPnPStatus))
+	mux.HandleFunc("/api/external-ip", as.authMiddleware(as.handleExternalIP))
+	mux.HandleFunc("/api/rediscover", as.authMiddleware(as.handleRediscover))
+	mux.HandleFunc("/api/router-mappings", as.authMiddleware(as.handleRouterMappings))
+	mux.HandleFunc("/api/router-mappings/adopt", as.authMiddleware(as.handleAdoptRouterMapping))
+	mux.HandleFunc("/api/drift", as.authMiddleware(as.handleDrift))
+	mux.HandleFunc("/api/drift/reconcile", as.authMiddleware(as.handleReconcileDrift))
+	mux.HandleFunc("/api/groups", as.authMiddleware(as.handleGroups))
+	mux.HandleFunc("/api/groups/", as.authMiddleware(as.handleGroupAction))
+	mux.HandleFunc("/api/openapi.json", as.authMiddleware(as.handleOpenAPI))
+	mux.HandleFunc("/api/docs", as.authMiddleware(as.handleAPIDocs))
+	mux.HandleFunc("/api/csrf-token", as.authMiddleware(as.handleCSRFToken))
 
 	// 创建HTTP服务器
 	as.server = &http.Server{
@@ -72,21 +146,43 @@ func (as *AdminServer) Start() error {
 	as.logger.WithFields(logrus.Fields{
 		"host": as.config.Admin.Host,
 		"port": port,
+		"url":  as.URL(),
 	}).Info("启动HTTP管理服务")
 
 	go func() {
-		if err := as.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := as.server.Serve(listener); err != nil && err != http.ErrServerClosed {
 			as.logger.WithError(err).Error("HTTP管理服务启动失败")
 		}
 	}()
 
+	// 记录实际监听地址，供top等命令行工具连接
+	if err := as.writeAddrFile(); err != nil {
+		as.logger.WithError(err).Warn("写入管理服务地址文件失败")
+	}
+
 	return nil
 }
 
+// writeAddrFile 将管理服务地址写入数据目录
+func (as *AdminServer) writeAddrFile() error {
+	addr := net.JoinHostPort(as.config.Admin.Host, strconv.Itoa(as.port))
+	return os.WriteFile(filepath.Join(as.config.Admin.DataDir, AddrFileName), []byte(addr), 0600)
+}
+
+// ReadAddrFile 读取运行中管理服务的地址
+func ReadAddrFile(dataDir string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dataDir, AddrFileName))
+	if err != nil {
+		return "", fmt.Errorf("读取管理服务地址失败（服务是否在运行？）: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
 // Stop 停止管理服务器
 func (as *AdminServer) Stop() error {
 	if as.server != nil {
 		as.logger.Info("停止HTTP管理服务")
+		os.Remove(filepath.Join(as.config.Admin.DataDir, AddrFileName))
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 		return as.server.Shutdown(ctx)
@@ -99,32 +195,62 @@ func (as *AdminServer) GetPort() int {
 	return as.port
 }
 
-// findAvailablePort 查找可用端口
-func (as *AdminServer) findAvailablePort() (int, error) {
+// URL 获取管理界面地址，监听在所有地址上时使用本机回环地址
+func (as *AdminServer) URL() string {
+	host := as.config.Admin.Host
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, strconv.Itoa(as.port))
+}
+
+// listen 按配置监听管理端口：端口为0时由系统分配空闲端口，小于0时在监控端口范围内查找可用端口
+func (as *AdminServer) listen() (net.Listener, error) {
+	if as.config.Admin.Port >= 0 {
+		return net.Listen("tcp", net.JoinHostPort(as.config.Admin.Host, strconv.Itoa(as.config.Admin.Port)))
+	}
+	return as.findAvailablePort()
+}
+
+// findAvailablePort 在监控端口范围内查找可用端口
+func (as *AdminServer) findAvailablePort() (net.Listener, error) {
 	startPort := as.config.PortRange.Start
 	endPort := as.config.PortRange.End
 
-	for port := startPort; port <= endPort; port += as.config.PortRange.Step {
-		addr := fmt.Sprintf("%s:%d", as.config.Admin.Host, port)
-		listener, err := net.Listen("tcp", addr)
+	for _, port := range as.config.GetPortRange() {
+		listener, err := net.Listen("tcp", net.JoinHostPort(as.config.Admin.Host, strconv.Itoa(port)))
 		if err == nil {
-			listener.Close()
-			return port, nil
+			return listener, nil
 		}
 	}
 
-	return 0, fmt.Errorf("在端口范围 %d-%d 内没有找到可用端口", startPort, endPort)
+	return nil, fmt.Errorf("在端口范围 %d-%d 内没有找到可用端口", startPort, endPort)
 }
 
 // authMiddleware 认证中间件
+// 使用Bearer令牌的请求不需要CSRF令牌；浏览器会自动附带缓存的Basic认证凭据，
+// 因此Basic认证的修改类请求必须携带X-CSRF-Token
 func (as *AdminServer) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if token, ok := bearerToken(r); ok {
+			if !as.checkAPIToken(token) {
+				http.Error(w, "API令牌无效", http.StatusUnauthorized)
+				return
+			}
+			next(w, r)
+			return
+		}
+
 		username, password, ok := r.BasicAuth()
 		if !ok || !as.checkCredentials(username, password) {
 			w.Header().Set("WWW-Authenticate", `Basic realm="Auto UPnP Admin"`)
 			http.Error(w, "需要认证", http.StatusUnauthorized)
 			return
 		}
+		if !isSafeMethod(r.Method) && !as.checkCSRF(r) {
+			as.writeJSONResponse(w, http.StatusForbidden, "CSRF令牌无效或缺失", nil)
+			return
+		}
 		next(w, r)
 	}
 }
@@ -147,7 +273,8 @@ func (as *AdminServer) handleIndex(w http.ResponseWriter, r *http.Request) {
 
 	tmpl := template.Must(template.New("index").Parse(adminHTML))
 	data := map[string]interface{}{
-		"Title": "Auto UPnP 管理界面",
+		"Title":     "Auto UPnP 管理界面",
+		"CSRFToken": as.csrfToken,
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -164,17 +291,61 @@ func (as *AdminServer) handleStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 长轮询：状态仍为since（或If-None-Match）指定的版本时等待变化，超时返回304
+	etag := as.autoService.StatusETag()
+	since := r.URL.Query().Get("since")
+	if since == "" {
+		since = r.Header.Get("If-None-Match")
+	}
+	if since != "" {
+		wait, err := parseStatusWait(r.URL.Query().Get("wait"))
+		if err != nil {
+			as.writeJSONResponse(w, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		// 等待时间可能超过服务器的写超时，按等待时间延长本次请求的写截止时间
+		if wait > 0 {
+			if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + statusWriteMargin)); err != nil {
+				as.logger.WithError(err).Debug("延长状态长轮询的写超时失败")
+			}
+		}
+
+		var changed bool
+		etag, changed = as.autoService.WaitStatusChange(r.Context(), since, wait)
+		if !changed {
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
 	status := as.autoService.GetStatus()
+	w.Header().Set("ETag", etag)
 
 	// 添加管理服务信息
 	status["admin_service"] = map[string]interface{}{
 		"enabled": as.config.Admin.Enabled,
 		"host":    as.config.Admin.Host,
 		"port":    as.port,
-		"url":     fmt.Sprintf("http://%s:%d", as.config.Admin.Host, as.port),
+		"url":     as.URL(),
 	}
 
-	as.writeJSON(w, status)
+	as.writeNegotiated(w, r, status)
+}
+
+// parseStatusWait 解析状态长轮询的等待时间，为空时不等待，超过上限时按上限等待
+func parseStatusWait(text string) (time.Duration, error) {
+	if text == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(text)
+	if err != nil || wait < 0 {
+		return 0, fmt.Errorf("wait参数格式错误: %q，应为时长，如 30s", text)
+	}
+	if wait > maxStatusWait {
+		wait = maxStatusWait
+	}
+	return wait, nil
 }
 
 // handleMappings 处理端口映射API
@@ -185,23 +356,36 @@ func (as *AdminServer) handleMappings(w http.ResponseWriter, r *http.Request) {
 	}
 
 	mappings := as.autoService.GetPortMappings()
+	correlationIDs := as.autoService.GetMappingCorrelationIDs()
+	schemes := as.autoService.GetMappingSchemes()
 
 	// 转换映射数据以包含活跃状态
-	response := make(map[string]interface{})
+	response := make(map[string]*PortMappingResponse, len(mappings))
 	for key, mapping := range mappings {
-		response[key] = map[string]interface{}{
-			"InternalPort":   mapping.InternalPort,
-			"ExternalPort":   mapping.ExternalPort,
-			"Protocol":       mapping.Protocol,
-			"InternalClient": mapping.InternalClient,
-			"Description":    mapping.Description,
-			"LeaseDuration":  mapping.LeaseDuration,
-			"CreatedAt":      mapping.CreatedAt,
-			"Active":         true, // 如果存在映射，则认为它是活跃的
-		}
+		response[key] = newPortMappingResponse(mapping, correlationIDs[key], schemes[key])
 	}
 
-	as.writeJSON(w, response)
+	as.writeNegotiated(w, r, response)
+}
+
+// newPortMappingResponse 将路由器映射的本地记录转换为API响应
+func newPortMappingResponse(mapping *upnp.PortMapping, correlationID, scheme string) *PortMappingResponse {
+	return &PortMappingResponse{
+		CorrelationID:  correlationID,
+		InternalPort:   mapping.InternalPort,
+		ExternalPort:   mapping.ExternalPort,
+		Protocol:       mapping.Protocol,
+		InternalClient: mapping.InternalClient,
+		Description:    mapping.Description,
+		Scheme:         scheme,
+		LeaseDuration:  mapping.LeaseDuration,
+		CreatedAt:      mapping.CreatedAt,
+		Adopted:        mapping.Adopted,
+		Gateway:        mapping.Gateway,
+		RemoteHost:     mapping.RemoteHost,
+		Simulated:      mapping.Simulated,
+		Active:         true, // 如果存在映射，则认为它是活跃的
+	}
 }
 
 // handleAddMapping 处理添加映射API
@@ -229,40 +413,90 @@ func (as *AdminServer) handleAddMapping(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// 验证必填字段
-	if req.InternalPort <= 0 || req.InternalPort > 65535 {
-		as.writeJSONResponse(w, http.StatusBadRequest, "内部端口格式错误", nil)
+	// 端口的范围以及与自动映射端口范围的冲突由服务统一校验
+	req.Group = strings.TrimSpace(req.Group)
+	if !isValidGroupName(req.Group) {
+		as.writeJSONResponse(w, http.StatusBadRequest, fmt.Sprintf("分组名称不能包含/且不能超过%d个字符", maxGroupNameLength), nil)
 		return
 	}
 
-	// 如果InternalPort在PortRange范围内，则返回错误
-	if req.InternalPort >= as.config.PortRange.Start && req.InternalPort <= as.config.PortRange.End {
-		as.writeJSONResponse(w, http.StatusBadRequest, "内部端口在端口范围内,请勿重复添加", nil)
+	req.MDNSType = strings.TrimSpace(req.MDNSType)
+	req.MDNSName = strings.TrimSpace(req.MDNSName)
+	if req.MDNSType != "" && !mdns.ValidServiceType(req.MDNSType) {
+		as.writeJSONResponse(w, http.StatusBadRequest, "mDNS服务类型格式错误，应为 _服务名._tcp 或 _服务名._udp", nil)
+		return
+	}
+	if len(req.MDNSName) > 63 {
+		as.writeJSONResponse(w, http.StatusBadRequest, "mDNS服务名称不能超过63个字节", nil)
 		return
 	}
 
-	if req.ExternalPort <= 0 || req.ExternalPort > 65535 {
-		as.writeJSONResponse(w, http.StatusBadRequest, "外部端口格式错误", nil)
+	if req.Priority < 0 || req.Priority > service.MaxMappingPriority {
+		as.writeJSONResponse(w, http.StatusBadRequest, fmt.Sprintf("优先级必须在1-%d之间，不填或为0时使用默认优先级%d", service.MaxMappingPriority, service.DefaultManualPriority), nil)
 		return
 	}
 
-	// 设置默认值
-	if req.Protocol == "" {
-		req.Protocol = "TCP"
+	protocol, err := service.NormalizeProtocol(req.Protocol)
+	if err != nil {
+		as.writeJSONResponse(w, http.StatusBadRequest, err.Error(), nil)
+		return
 	}
+	req.Protocol = protocol
 
+	// 设置默认值
 	if req.Description == "" {
 		req.Description = fmt.Sprintf("Manual %d->%d", req.InternalPort, req.ExternalPort)
 	}
 
 	// 添加映射
-	if err := as.autoService.AddManualMapping(req.InternalPort, req.ExternalPort, req.Protocol, req.Description); err != nil {
+	opts := service.ManualMappingOptions{
+		BackupExternalPort: req.BackupExternalPort,
+		Replace:            req.Replace,
+		Group:              req.Group,
+		MDNSType:           req.MDNSType,
+		MDNSName:           req.MDNSName,
+		Priority:           req.Priority,
+		Idempotent:         as.config.Admin.IdempotentAdd,
+		Reserved:           req.Reserved,
+		HealthCheck:        req.HealthCheck,
+		Scheme:             req.Scheme,
+		RemoteHost:         req.RemoteHost,
+	}
+	if req.Idempotent != nil {
+		opts.Idempotent = *req.Idempotent
+	}
+	result, err := as.autoService.AddManualMappingWithOptions(req.InternalPort, req.ExternalPort, req.Protocol, req.Description, opts)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidMappingPort) || errors.Is(err, service.ErrInvalidHealthCheck) ||
+			errors.Is(err, service.ErrInvalidScheme) || errors.Is(err, service.ErrInvalidRemoteHost) {
+			as.writeJSONResponse(w, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		if errors.Is(err, service.ErrMappingExists) || errors.Is(err, service.ErrMappingMismatch) {
+			as.writeJSONResponse(w, http.StatusConflict, err.Error(), nil)
+			return
+		}
+		if conflict, ok := service.IsMappingConflict(err); ok {
+			as.writeJSONResponse(w, http.StatusConflict, err.Error(), map[string]interface{}{
+				"conflict": conflict,
+			})
+			return
+		}
 		as.logger.WithError(err).Error("添加手动映射失败")
 		as.writeJSONResponse(w, http.StatusInternalServerError, fmt.Sprintf("添加映射失败: %v", err), nil)
 		return
 	}
 
-	as.writeJSONResponse(w, http.StatusOK, "映射添加成功", nil)
+	message := "映射添加成功"
+	switch {
+	case result.Existing:
+		message = "映射已存在"
+	case result.Status == service.ManualMappingStatusWaiting:
+		message = "映射添加成功，等待本地端口上线"
+	case result.Status == service.ManualMappingStatusReserved:
+		message = "映射添加成功，已预留外部端口"
+	}
+	as.writeJSONResponse(w, http.StatusOK, message, result)
 }
 
 // handleRemoveMapping 处理删除映射API
@@ -307,7 +541,12 @@ func (as *AdminServer) handleRemoveMapping(w http.ResponseWriter, r *http.Reques
 	}
 
 	// 删除映射
-	if err := as.autoService.RemoveManualMapping(req.InternalPort, req.ExternalPort, req.Protocol); err != nil {
+	opts := service.RemoveMappingOptions{Force: req.Force}
+	if err := as.autoService.RemoveManualMappingWithOptions(req.InternalPort, req.ExternalPort, req.Protocol, opts); err != nil {
+		if upnp.IsRemovalError(err) {
+			as.writeJSONResponse(w, http.StatusBadGateway, fmt.Sprintf("路由器删除映射失败，映射仍保留在路由器上，请稍后重试，路由器已不可用时可以强制删除: %v", err), nil)
+			return
+		}
 		as.logger.WithError(err).Error("删除手动映射失败")
 		as.writeJSONResponse(w, http.StatusInternalServerError, fmt.Sprintf("删除映射失败: %v", err), nil)
 		return
@@ -316,6 +555,289 @@ func (as *AdminServer) handleRemoveMapping(w http.ResponseWriter, r *http.Reques
 	as.writeJSONResponse(w, http.StatusOK, "映射删除成功", nil)
 }
 
+// handleRefreshMapping 按本地记录重新向路由器写入单个映射，路由器丢失映射时不需要删除后重新添加
+func (as *AdminServer) handleRefreshMapping(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		as.writeJSONResponse(w, http.StatusMethodNotAllowed, "方法不允许", nil)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		as.writeJSONResponse(w, http.StatusBadRequest, "读取请求体失败", nil)
+		return
+	}
+	defer r.Body.Close()
+
+	var req RefreshMappingRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		as.writeJSONResponse(w, http.StatusBadRequest, "JSON格式错误", nil)
+		return
+	}
+
+	if req.InternalPort <= 0 || req.InternalPort > 65535 {
+		as.writeJSONResponse(w, http.StatusBadRequest, "内部端口格式错误", nil)
+		return
+	}
+	if req.ExternalPort <= 0 || req.ExternalPort > 65535 {
+		as.writeJSONResponse(w, http.StatusBadRequest, "外部端口格式错误", nil)
+		return
+	}
+	protocol, err := service.NormalizeProtocol(req.Protocol)
+	if err != nil {
+		as.writeJSONResponse(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	if err := as.autoService.RefreshMapping(req.InternalPort, req.ExternalPort, protocol); err != nil {
+		if errors.Is(err, service.ErrMappingNotRegistered) {
+			as.writeJSONResponse(w, http.StatusNotFound, err.Error(), nil)
+			return
+		}
+		as.logger.WithError(err).Warn("重新写入映射失败")
+		as.writeJSONResponse(w, http.StatusBadGateway, fmt.Sprintf("路由器重新写入映射失败: %v", err), nil)
+		return
+	}
+
+	mapping, exists := as.autoService.GetPortMapping(req.InternalPort, req.ExternalPort, protocol)
+	if !exists {
+		// 刷新后映射恰好被删除
+		as.writeJSONResponse(w, http.StatusNotFound, service.ErrMappingNotRegistered.Error(), nil)
+		return
+	}
+	key := fmt.Sprintf("%d:%d:%s", mapping.InternalPort, mapping.ExternalPort, mapping.Protocol)
+	as.writeJSONResponse(w, http.StatusOK, "映射已重新写入路由器", newPortMappingResponse(
+		mapping,
+		as.autoService.GetMappingCorrelationIDs()[key],
+		as.autoService.GetMappingSchemes()[key],
+	))
+}
+
+// handleMappingByID 处理单个手动映射API，映射ID格式为 "内部端口:外部端口:协议"
+func (as *AdminServer) handleMappingByID(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/mappings/")
+	if strings.HasSuffix(id, "/test") {
+		as.handleMappingTest(w, r, strings.TrimSuffix(id, "/test"))
+		return
+	}
+	if strings.HasSuffix(id, "/owner") {
+		as.handleMappingOwner(w, r, strings.TrimSuffix(id, "/owner"))
+		return
+	}
+
+	if r.Method != http.MethodPatch {
+		as.writeJSONResponse(w, http.StatusMethodNotAllowed, "方法不允许", nil)
+		return
+	}
+
+	internalPort, externalPort, protocol, err := parseMappingID(id)
+	if err != nil {
+		as.writeJSONResponse(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		as.writeJSONResponse(w, http.StatusBadRequest, "读取请求体失败", nil)
+		return
+	}
+	defer r.Body.Close()
+
+	var req UpdateMappingRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		as.writeJSONResponse(w, http.StatusBadRequest, "JSON格式错误", nil)
+		return
+	}
+
+	if req.Note == nil && req.Scheme == nil {
+		as.writeJSONResponse(w, http.StatusBadRequest, "没有需要更新的字段", nil)
+		return
+	}
+
+	var note string
+	if req.Note != nil {
+		note = strings.TrimSpace(*req.Note)
+		if utf8.RuneCountInString(note) > maxNoteLength {
+			as.writeJSONResponse(w, http.StatusBadRequest, fmt.Sprintf("备注长度不能超过%d个字符", maxNoteLength), nil)
+			return
+		}
+	}
+	if req.Scheme != nil {
+		if _, err := service.NormalizeScheme(*req.Scheme); err != nil {
+			as.writeJSONResponse(w, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+	}
+
+	var mapping *service.ManualMapping
+	if req.Note != nil {
+		if mapping, err = as.autoService.UpdateManualMappingNote(internalPort, externalPort, protocol, note); err != nil {
+			as.writeMappingUpdateError(w, err)
+			return
+		}
+	}
+	if req.Scheme != nil {
+		if mapping, err = as.autoService.UpdateManualMappingScheme(internalPort, externalPort, protocol, *req.Scheme); err != nil {
+			as.writeMappingUpdateError(w, err)
+			return
+		}
+	}
+
+	as.writeJSONResponse(w, http.StatusOK, "映射更新成功", mapping)
+}
+
+// writeMappingUpdateError 映射不存在时返回404，保存失败等其他错误返回500
+func (as *AdminServer) writeMappingUpdateError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, service.ErrMappingNotFound) {
+		status = http.StatusNotFound
+	}
+	as.writeJSONResponse(w, status, fmt.Sprintf("更新映射失败: %v", err), nil)
+}
+
+// handleMappingTest 处理映射端到端测试API，依次检查本地服务、路由器映射和外部可达性
+func (as *AdminServer) handleMappingTest(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		as.writeJSONResponse(w, http.StatusMethodNotAllowed, "方法不允许", nil)
+		return
+	}
+
+	internalPort, externalPort, protocol, err := parseMappingID(id)
+	if err != nil {
+		as.writeJSONResponse(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	result, err := as.autoService.ProbeManualMapping(internalPort, externalPort, protocol)
+	if errors.Is(err, service.ErrMappingNotFound) {
+		as.writeJSONResponse(w, http.StatusNotFound, err.Error(), nil)
+		return
+	}
+	if err != nil {
+		as.writeJSONResponse(w, http.StatusInternalServerError, fmt.Sprintf("测试映射失败: %v", err), nil)
+		return
+	}
+
+	message := "映射测试通过"
+	if !result.Passed {
+		message = "映射测试失败: " + result.FailureReason
+	}
+	as.writeJSONResponse(w, http.StatusOK, message, result)
+}
+
+// handleExportMappings 处理导出手动映射为nftables/iptables规则API
+func (as *AdminServer) handleExportMappings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		as.writeJSONResponse(w, http.StatusMethodNotAllowed, "方法不允许", nil)
+		return
+	}
+
+	query := r.URL.Query()
+	opts := service.ExportOptions{
+		Format:       query.Get("format"),
+		InternalIP:   query.Get("internal_ip"),
+		WANInterface: query.Get("wan_iface"),
+	}
+
+	if opts.Format == "" {
+		opts.Format = service.ExportFormatNftables
+	}
+	if opts.Format != service.ExportFormatNftables && opts.Format != service.ExportFormatIptables {
+		as.writeJSONResponse(w, http.StatusBadRequest, "导出格式错误，支持 nftables 或 iptables", nil)
+		return
+	}
+
+	if opts.InternalIP != "" {
+		if ip := net.ParseIP(opts.InternalIP); ip == nil || ip.To4() == nil {
+			as.writeJSONResponse(w, http.StatusBadRequest, "internal_ip必须是IPv4地址", nil)
+			return
+		}
+	}
+
+	if opts.WANInterface != "" && !isValidInterfaceName(opts.WANInterface) {
+		as.writeJSONResponse(w, http.StatusBadRequest, "网卡名称格式错误", nil)
+		return
+	}
+
+	script, err := as.autoService.ExportMappings(opts)
+	if err != nil {
+		as.writeJSONResponse(w, http.StatusInternalServerError, fmt.Sprintf("导出映射失败: %v", err), nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"auto-upnp-%s.sh\"", opts.Format))
+	io.WriteString(w, script)
+}
+
+// isValidInterfaceName 检查网卡名称，避免在生成的脚本中注入命令
+func isValidInterfaceName(name string) bool {
+	if len(name) > 15 {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("._-@", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// handleMappingOwner 处理映射所属进程查询API，无法确定进程时返回known为false的结果
+func (as *AdminServer) handleMappingOwner(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		as.writeJSONResponse(w, http.StatusMethodNotAllowed, "方法不允许", nil)
+		return
+	}
+
+	internalPort, externalPort, protocol, err := parseMappingID(id)
+	if err != nil {
+		as.writeJSONResponse(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	result, err := as.autoService.GetMappingOwner(internalPort, externalPort, protocol)
+	if err != nil {
+		as.writeJSONResponse(w, http.StatusNotFound, err.Error(), nil)
+		return
+	}
+
+	message := "查询成功"
+	if !result.Owner.Known {
+		message = "所属进程未知: " + result.Owner.Reason
+	}
+	as.writeJSONResponse(w, http.StatusOK, message, result)
+}
+
+// parseMappingID 解析映射ID
+func parseMappingID(id string) (int, int, string, error) {
+	parts := strings.Split(id, ":")
+	if len(parts) != 3 {
+		return 0, 0, "", fmt.Errorf("映射ID格式错误，应为 内部端口:外部端口:协议")
+	}
+
+	internalPort, err := strconv.Atoi(parts[0])
+	if err != nil || internalPort <= 0 || internalPort > 65535 {
+		return 0, 0, "", fmt.Errorf("内部端口格式错误")
+	}
+
+	externalPort, err := strconv.Atoi(parts[1])
+	if err != nil || externalPort <= 0 || externalPort > 65535 {
+		return 0, 0, "", fmt.Errorf("外部端口格式错误")
+	}
+
+	if parts[2] == "" {
+		return 0, 0, "", fmt.Errorf("协议格式错误")
+	}
+	// 与添加映射时一样规范化协议，tcp和TCP指向同一个映射
+	protocol, err := service.NormalizeProtocol(parts[2])
+	if err != nil {
+		return 0, 0, "", err
+	}
+
+	return internalPort, externalPort, protocol, nil
+}
+
 // handlePorts 处理端口状态API
 func (as *AdminServer) handlePorts(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -323,12 +845,14 @@ func (as *AdminServer) handlePorts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	activePorts := as.autoService.GetActivePorts()
-	inactivePorts := as.autoService.GetInactivePorts()
+	// 没有端口时返回空数组而不是null，保持响应结构稳定
+	activePorts := append([]int{}, as.autoService.GetActivePorts()...)
+	inactivePorts := append([]int{}, as.autoService.GetInactivePorts()...)
 
-	response := map[string]interface{}{
-		"active_ports":   activePorts,
-		"inactive_ports": inactivePorts,
+	response := &PortsResponse{
+		ActivePorts:   activePorts,
+		InactivePorts: inactivePorts,
+		PortStates:    as.autoService.GetPortStates(),
 	}
 
 	as.writeJSON(w, response)
@@ -341,20 +865,28 @@ func (as *AdminServer) handleManualMappings(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// 从同一份快照中区分激活状态，保证数量与列表一致
 	allMappings := as.autoService.GetManualMappings()
-	activeMappings := as.autoService.GetActiveManualMappings()
-	inactiveMappings := as.autoService.GetInactiveManualMappings()
+	activeMappings := make([]*service.ManualMapping, 0)
+	inactiveMappings := make([]*service.ManualMapping, 0)
+	for _, mapping := range allMappings {
+		if mapping.Active {
+			activeMappings = append(activeMappings, mapping)
+		} else {
+			inactiveMappings = append(inactiveMappings, mapping)
+		}
+	}
 
-	response := map[string]interface{}{
-		"total_mappings":         len(allMappings),
-		"active_mappings":        len(activeMappings),
-		"inactive_mappings":      len(inactiveMappings),
-		"all_mappings":           allMappings,
-		"active_mappings_list":   activeMappings,
-		"inactive_mappings_list": inactiveMappings,
+	response := &ManualMappingsResponse{
+		TotalMappings:        len(allMappings),
+		ActiveMappings:       len(activeMappings),
+		InactiveMappings:     len(inactiveMappings),
+		AllMappings:          allMappings,
+		ActiveMappingsList:   activeMappings,
+		InactiveMappingsList: inactiveMappings,
 	}
 
-	as.writeJSON(w, response)
+	as.writeNegotiated(w, r, response)
 }
 
 // handleUPnPStatus 处理UPnP状态API
@@ -372,15 +904,219 @@ func (as *AdminServer) handleUPnPStatus(w http.ResponseWriter, r *http.Request)
 		status = "可用"
 	}
 
-	response := map[string]interface{}{
-		"client_count": clientCount,
-		"available":    isAvailable,
-		"status":       status,
+	response := &UPnPStatusResponse{
+		ClientCount: clientCount,
+		Available:   isAvailable,
+		Status:      status,
+		Clients:     as.autoService.GetUPnPClients(),
+	}
+	if response.Clients == nil {
+		response.Clients = []map[string]interface{}{}
 	}
 
 	as.writeJSON(w, response)
 }
 
+// handleExternalIP 返回路由器的WAN口地址，路由器不可用时返回503
+func (as *AdminServer) handleExternalIP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		as.writeJSONResponse(w, http.StatusMethodNotAllowed, "方法不允许", nil)
+		return
+	}
+
+	info, err := as.autoService.GetRouterExternalIP()
+	if err != nil {
+		as.writeJSONResponse(w, http.StatusServiceUnavailable, fmt.Sprintf("获取路由器外部IP失败: %v", err), nil)
+		return
+	}
+	as.writeJSONResponse(w, http.StatusOK, "获取路由器外部IP成功", info)
+}
+
+// handleRediscover 处理立即重新发现UPnP设备API
+func (as *AdminServer) handleRediscover(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		as.writeJSONResponse(w, http.StatusMethodNotAllowed, "方法不允许", nil)
+		return
+	}
+
+	clientCount, err := as.autoService.RediscoverUPnP()
+	data := &RediscoverResponse{ClientCount: clientCount}
+
+	if errors.Is(err, upnp.ErrDiscoveryInProgress) {
+		as.writeJSONResponse(w, http.StatusConflict, "UPnP设备发现正在进行中，请稍后再试", data)
+		return
+	}
+
+	if err != nil {
+		as.logger.WithError(err).Warn("重新发现UPnP设备失败")
+		as.writeJSONResponse(w, http.StatusServiceUnavailable, fmt.Sprintf("重新发现UPnP设备失败: %v", err), data)
+		return
+	}
+
+	as.writeJSONResponse(w, http.StatusOK, "UPnP设备重新发现成功", data)
+}
+
+// handleReadyz 处理就绪探针，任一子系统心跳超时或异常退出时返回503
+func (as *AdminServer) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		as.writeJSONResponse(w, http.StatusMethodNotAllowed, "方法不允许", nil)
+		return
+	}
+
+	statusCode := http.StatusOK
+	status := "ready"
+	if !as.autoService.IsReady() {
+		statusCode = http.StatusServiceUnavailable
+		status = "not_ready"
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(&ReadyzResponse{
+		Status:     status,
+		Subsystems: as.autoService.GetSubsystemStatus(),
+	}); err != nil {
+		as.logger.WithError(err).Error("编码JSON响应失败")
+	}
+}
+
+// handleCapabilities 返回各映射提供方支持的协议，以及无法映射的常见协议的替代方案
+func (as *AdminServer) handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		as.writeJSONResponse(w, http.StatusMethodNotAllowed, "方法不允许", nil)
+		return
+	}
+	as.writeJSONResponse(w, http.StatusOK, "获取实例能力成功", as.autoService.GetCapabilities())
+}
+
+// handleMetrics 以Prometheus格式导出服务指标，与InfluxDB推送使用同一组指标
+func (as *AdminServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		as.writeJSONResponse(w, http.StatusMethodNotAllowed, "方法不允许", nil)
+		return
+	}
+
+	as.metrics.ServeHTTP(w, r)
+}
+
+// handleHealth 返回服务健康汇总，UPnP不可用时返回503
+// 与/readyz不同，汇总中包含映射失败和问题列表，面向监控面板和告警而不是容器探针
+func (as *AdminServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		as.writeJSONResponse(w, http.StatusMethodNotAllowed, "方法不允许", nil)
+		return
+	}
+
+	summary := as.autoService.GetHealthSummary()
+	statusCode := http.StatusOK
+	if summary.Status == service.HealthStatusDown {
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(summary); err != nil {
+		as.logger.WithError(err).Error("编码JSON响应失败")
+	}
+}
+
+// handleOpenAPI 返回管理API的OpenAPI文档
+func (as *AdminServer) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		as.writeJSONResponse(w, http.StatusMethodNotAllowed, "方法不允许", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	io.WriteString(w, openAPISpec)
+}
+
+// handleAPIDocs 返回API文档页面
+func (as *AdminServer) handleAPIDocs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		as.writeJSONResponse(w, http.StatusMethodNotAllowed, "方法不允许", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, apiDocsPage)
+}
+
+// handleGroups 处理映射分组列表API
+func (as *AdminServer) handleGroups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		as.writeJSONResponse(w, http.StatusMethodNotAllowed, "方法不允许", nil)
+		return
+	}
+
+	groups := as.autoService.GetMappingGroups()
+	as.writeJSON(w, &GroupsResponse{
+		TotalGroups: len(groups),
+		Groups:      groups,
+	})
+}
+
+// handleGroupAction 处理映射分组启用/停用API，路径格式为 /api/groups/{name}/enable|disable
+func (as *AdminServer) handleGroupAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		as.writeJSONResponse(w, http.StatusMethodNotAllowed, "方法不允许", nil)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/groups/")
+	slash := strings.LastIndex(path, "/")
+	if slash <= 0 {
+		as.writeJSONResponse(w, http.StatusNotFound, "未知的分组操作", nil)
+		return
+	}
+	name, action := path[:slash], path[slash+1:]
+
+	var results []*service.GroupMemberResult
+	var err error
+	switch action {
+	case "enable":
+		results, err = as.autoService.EnableMappingGroup(name)
+	case "disable":
+		results, err = as.autoService.DisableMappingGroup(name)
+	default:
+		as.writeJSONResponse(w, http.StatusNotFound, "未知的分组操作", nil)
+		return
+	}
+
+	if errors.Is(err, service.ErrGroupNotFound) {
+		as.writeJSONResponse(w, http.StatusNotFound, fmt.Sprintf("映射分组不存在: %s", name), nil)
+		return
+	}
+	if err != nil {
+		as.writeJSONResponse(w, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+
+	failed := 0
+	for _, result := range results {
+		if !result.Success {
+			failed++
+		}
+	}
+
+	data := &GroupActionResponse{
+		Group:   name,
+		Action:  action,
+		Failed:  failed,
+		Results: results,
+	}
+	if failed > 0 {
+		as.writeJSONResponse(w, http.StatusMultiStatus, fmt.Sprintf("%d个映射操作失败", failed), data)
+		return
+	}
+	as.writeJSONResponse(w, http.StatusOK, "映射分组操作成功", data)
+}
+
+// isValidGroupName 检查分组名称是否合法，空名称表示不分组
+func isValidGroupName(name string) bool {
+	return !strings.Contains(name, "/") && utf8.RuneCountInString(name) <= maxGroupNameLength
+}
+
 // writeJSON 写入JSON响应
 func (as *AdminServer) writeJSON(w http.ResponseWriter, data interface{}) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
@@ -0,0 +1,135 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"auto-upnp/config"
+	"auto-upnp/internal/service"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestHandleMappingByID_UnknownMappingReturns404(t *testing.T) {
+	cfg := &config.Config{Admin: config.AdminConfig{DataDir: t.TempDir()}}
+	as := NewAdminServer(cfg, logrus.New(), service.NewAutoUPnPService(cfg, logrus.New()))
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/mappings/8080:8080:TCP", strings.NewReader(`{"note":"web"}`))
+	rec := httptest.NewRecorder()
+	as.handleMappingByID(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("映射不存在时状态码 = %d，期望 %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestParseMappingID_NormalizesProtocol(t *testing.T) {
+	for _, id := range []string{"8080:9090:TCP", "8080:9090:tcp", "8080:9090: Tcp "} {
+		internalPort, externalPort, protocol, err := parseMappingID(id)
+		if err != nil {
+			t.Fatalf("解析 %q 失败: %v", id, err)
+		}
+		if internalPort != 8080 || externalPort != 9090 || protocol != "TCP" {
+			t.Errorf("解析 %q = %d:%d:%s, 期望 8080:9090:TCP", id, internalPort, externalPort, protocol)
+		}
+	}
+	for _, id := range []string{"8080:9090:", "8080:9090:ICMP", "8080:9090"} {
+		if _, _, _, err := parseMappingID(id); err == nil {
+			t.Errorf("解析 %q 应返回错误", id)
+		}
+	}
+}
+
+func TestWriteMappingUpdateError(t *testing.T) {
+	as := NewAdminServer(&config.Config{}, logrus.New(), nil)
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"映射不存在", service.ErrMappingNotFound, http.StatusNotFound},
+		{"包装后的映射不存在", fmt.Errorf("%w: 8080:8080:TCP", service.ErrMappingNotFound), http.StatusNotFound},
+		{"保存失败", errors.New("写入手动映射文件失败"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			as.writeMappingUpdateError(rec, tt.err)
+			if rec.Code != tt.want {
+				t.Errorf("状态码 = %d，期望 %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestStart_WritesAddrFileUntilStop(t *testing.T) {
+	dataDir := t.TempDir()
+	cfg := &config.Config{Admin: config.AdminConfig{Enabled: true, Host: "127.0.0.1", Port: 0, DataDir: dataDir}}
+	as := NewAdminServer(cfg, logrus.New(), service.NewAutoUPnPService(cfg, logrus.New()))
+	if err := as.Start(); err != nil {
+		t.Fatalf("启动管理服务失败: %v", err)
+	}
+
+	addr, err := ReadAddrFile(dataDir)
+	if err != nil {
+		t.Fatalf("读取地址文件失败: %v", err)
+	}
+	if want := fmt.Sprintf("127.0.0.1:%d", as.GetPort()); addr != want {
+		t.Errorf("地址文件内容 = %q，期望 %q", addr, want)
+	}
+
+	if err := as.Stop(); err != nil {
+		t.Fatalf("停止管理服务失败: %v", err)
+	}
+	if _, err := ReadAddrFile(dataDir); err == nil {
+		t.Error("停止后应删除地址文件")
+	}
+}
+
+func TestListen_UsesConfiguredPort(t *testing.T) {
+	// 先占用一个端口，再释放给管理服务使用
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("获取空闲端口失败: %v", err)
+	}
+	freePort := probe.Addr().(*net.TCPAddr).Port
+	probe.Close()
+
+	tests := []struct {
+		name string
+		port int
+		want int
+	}{
+		{"指定端口", freePort, freePort},
+		{"系统分配", 0, 0},
+		{"监控范围内查找", -1, freePort},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Admin:     config.AdminConfig{Host: "127.0.0.1", Port: tt.port},
+				PortRange: config.PortRangeConfig{Start: freePort, End: freePort, Step: 1},
+			}
+			as := NewAdminServer(cfg, logrus.New(), nil)
+
+			listener, err := as.listen()
+			if err != nil {
+				t.Fatalf("监听失败: %v", err)
+			}
+			defer listener.Close()
+
+			port := listener.Addr().(*net.TCPAddr).Port
+			switch {
+			case tt.want == 0 && port == 0:
+				t.Error("端口为0时应由系统分配空闲端口")
+			case tt.want != 0 && port != tt.want:
+				t.Errorf("监听端口 = %d，期望 %d", port, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package admin
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// CSRFHeader 修改类请求携带CSRF令牌的请求头
+const CSRFHeader = "X-CSRF-Token"
+
+// newCSRFToken 生成随机的CSRF令牌，服务重启后失效
+func newCSRFToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic("生成CSRF令牌失败: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
+
+// isSafeMethod 判断请求方法是否只读，只读请求不需要CSRF令牌
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// checkCSRF 检查请求携带的CSRF令牌
+func (as *AdminServer) checkCSRF(r *http.Request) bool {
+	token := r.Header.Get(CSRFHeader)
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(as.csrfToken)) == 1
+}
+
+// bearerToken 读取Authorization头中的Bearer令牌
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", false
+	}
+	return auth[len(prefix):], true
+}
+
+// checkAPIToken 检查Bearer令牌，未配置admin.api_token时始终失败
+func (as *AdminServer) checkAPIToken(token string) bool {
+	expected := as.config.Admin.APIToken
+	return expected != "" && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+// handleCSRFToken 返回当前的CSRF令牌，供自定义界面使用
+func (as *AdminServer) handleCSRFToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		as.writeJSONResponse(w, http.StatusMethodNotAllowed, "方法不允许", nil)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	as.writeJSON(w, CSRFTokenResponse{Header: CSRFHeader, Token: as.csrfToken})
+}
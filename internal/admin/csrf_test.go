@@ -0,0 +1,110 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"auto-upnp/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newAuthTestServer 创建只用于认证检查的管理服务器，通过认证的请求返回204
+func newAuthTestServer() (*AdminServer, http.HandlerFunc) {
+	as := NewAdminServer(&config.Config{Admin: config.AdminConfig{
+		Username: "admin",
+		Password: "secret",
+		APIToken: "script-token",
+	}}, logrus.New(), nil)
+	handler := as.authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return as, handler
+}
+
+func TestAuthMiddleware_RequiresCSRFTokenForBasicAuthWrites(t *testing.T) {
+	as, handler := newAuthTestServer()
+
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		want   int
+	}{
+		{"POST缺少令牌", http.MethodPost, "", http.StatusForbidden},
+		{"POST令牌错误", http.MethodPost, "wrong", http.StatusForbidden},
+		{"PATCH缺少令牌", http.MethodPatch, "", http.StatusForbidden},
+		{"PATCH令牌错误", http.MethodPatch, "wrong", http.StatusForbidden},
+		{"DELETE缺少令牌", http.MethodDelete, "", http.StatusForbidden},
+		{"DELETE令牌错误", http.MethodDelete, "wrong", http.StatusForbidden},
+		{"POST令牌正确", http.MethodPost, as.csrfToken, http.StatusNoContent},
+		{"PATCH令牌正确", http.MethodPatch, as.csrfToken, http.StatusNoContent},
+		{"DELETE令牌正确", http.MethodDelete, as.csrfToken, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/mappings/1", nil)
+			req.SetBasicAuth("admin", "secret")
+			if tt.token != "" {
+				req.Header.Set(CSRFHeader, tt.token)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("状态码 = %d，期望 %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthMiddleware_SafeMethodsSkipCSRF(t *testing.T) {
+	_, handler := newAuthTestServer()
+
+	for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodOptions} {
+		req := httptest.NewRequest(method, "/api/status", nil)
+		req.SetBasicAuth("admin", "secret")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != http.StatusNoContent {
+			t.Errorf("%s 状态码 = %d，只读请求不需要CSRF令牌", method, rec.Code)
+		}
+	}
+}
+
+func TestAuthMiddleware_BearerTokenBypassesCSRF(t *testing.T) {
+	_, handler := newAuthTestServer()
+
+	for _, method := range []string{http.MethodPost, http.MethodPatch, http.MethodDelete} {
+		req := httptest.NewRequest(method, "/api/mappings/1", nil)
+		req.Header.Set("Authorization", "Bearer script-token")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != http.StatusNoContent {
+			t.Errorf("%s 使用Bearer令牌的状态码 = %d，不应要求CSRF令牌", method, rec.Code)
+		}
+	}
+
+	// 错误的Bearer令牌不能退回到Basic认证
+	req := httptest.NewRequest(http.MethodPost, "/api/mappings/1", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("错误的Bearer令牌状态码 = %d，期望 %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestAuthMiddleware_RejectsMissingCredentials(t *testing.T) {
+	_, handler := newAuthTestServer()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("未认证请求状态码 = %d，期望 %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec.Header().Get("WWW-Authenticate") == "" {
+		t.Error("未认证请求应返回WWW-Authenticate头")
+	}
+}
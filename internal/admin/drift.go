@@ -0,0 +1,49 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// handleDrift 返回本地映射记录与路由器映射表的差异报告
+// 默认返回最近一次定期校验的结果，refresh=true时立即重新校验
+func (as *AdminServer) handleDrift(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		as.writeJSONResponse(w, http.StatusMethodNotAllowed, "方法不允许", nil)
+		return
+	}
+
+	getReport := as.autoService.GetDriftReport
+	if r.URL.Query().Get("refresh") == "true" {
+		getReport = as.autoService.CheckDrift
+	}
+
+	report, err := getReport()
+	if err != nil {
+		as.logger.WithError(err).Warn("校验路由器映射失败")
+		as.writeJSONResponse(w, http.StatusServiceUnavailable, fmt.Sprintf("校验路由器映射失败: %v", err), nil)
+		return
+	}
+	as.writeJSONResponse(w, http.StatusOK, "获取映射差异报告成功", report)
+}
+
+// handleReconcileDrift 按差异报告修正路由器映射
+func (as *AdminServer) handleReconcileDrift(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		as.writeJSONResponse(w, http.StatusMethodNotAllowed, "方法不允许", nil)
+		return
+	}
+
+	result, err := as.autoService.ReconcileDrift()
+	if err != nil {
+		as.logger.WithError(err).Warn("修正路由器映射失败")
+		as.writeJSONResponse(w, http.StatusServiceUnavailable, fmt.Sprintf("修正路由器映射失败: %v", err), nil)
+		return
+	}
+
+	message := "映射差异已修正"
+	if len(result.Failed) > 0 {
+		message = fmt.Sprintf("%d 个差异修正失败", len(result.Failed))
+	}
+	as.writeJSONResponse(w, http.StatusOK, message, result)
+}
@@ -0,0 +1,54 @@
+package admin
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"auto-upnp/internal/msgpack"
+)
+
+// msgpackMediaTypes 表示MessagePack的媒体类型，客户端常用的几种写法都接受
+var msgpackMediaTypes = map[string]bool{
+	msgpack.ContentType:       true,
+	"application/msgpack":     true,
+	"application/vnd.msgpack": true,
+}
+
+// acceptsMsgPack 检查请求的Accept头是否要求MessagePack，q=0表示明确拒绝
+func acceptsMsgPack(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || !msgpackMediaTypes[mediaType] {
+			continue
+		}
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q <= 0 {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// writeNegotiated 根据Accept头以MessagePack或JSON写入响应，默认使用JSON
+// 两种格式使用同一份数据和字段名，客户端可以共用同一套模型
+func (as *AdminServer) writeNegotiated(w http.ResponseWriter, r *http.Request, data interface{}) {
+	w.Header().Add("Vary", "Accept")
+	if !acceptsMsgPack(r) {
+		as.writeJSON(w, data)
+		return
+	}
+
+	body, err := msgpack.Marshal(data)
+	if err != nil {
+		as.logger.WithError(err).Error("编码MessagePack响应失败")
+		http.Error(w, "内部服务器错误", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", msgpack.ContentType)
+	if _, err := w.Write(body); err != nil {
+		as.logger.WithError(err).Debug("写入MessagePack响应失败")
+	}
+}
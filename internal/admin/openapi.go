@@ -0,0 +1,2899 @@
+package admin
+
+// openAPISpec 管理API的OpenAPI 3文档，新增或修改接口时需要同步更新
+const openAPISpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "Auto UPnP 管理API",
+    "description": "自动UPnP服务的管理接口。除 /readyz 外所有接口都需要HTTP Basic认证或Bearer API令牌（admin.api_token）。使用Basic认证的POST、PATCH请求必须在X-CSRF-Token请求头中携带 /api/csrf-token 返回的令牌，否则返回403。",
+    "version": "1.0.0"
+  },
+  "security": [
+    {
+      "basicAuth": [],
+      "csrfToken": []
+    },
+    {
+      "bearerAuth": []
+    }
+  ],
+  "paths": {
+    "/api/status": {
+      "get": {
+        "summary": "获取服务状态",
+        "description": "请求头 Accept: application/x-msgpack 时返回字段相同的MessagePack编码，默认返回JSON。响应带有ETag，传入since（或If-None-Match）和wait可以长轮询：状态仍为该版本时最多等待wait，期间变化立即返回新状态，否则返回304",
+        "operationId": "getStatus",
+        "parameters": [
+          {
+            "name": "since",
+            "in": "query",
+            "description": "上次响应的ETag，可以省略W/前缀和引号",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "wait",
+            "in": "query",
+            "description": "状态未变化时的最长等待时间，如 30s，上限2m；省略时不等待",
+            "schema": {
+              "type": "string",
+              "example": "30s"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "服务状态",
+            "headers": {
+              "ETag": {
+                "description": "状态版本",
+                "schema": {
+                  "type": "string"
+                }
+              }
+            },
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Status"
+                }
+              },
+              "application/x-msgpack": {
+                "schema": {
+                  "$ref": "#/components/schemas/Status"
+                }
+              }
+            }
+          },
+          "304": {
+            "description": "等待期间状态没有变化"
+          },
+          "400": {
+            "description": "wait参数格式错误"
+          }
+        }
+      }
+    },
+    "/api/mappings": {
+      "get": {
+        "summary": "获取路由器上由本服务创建的端口映射",
+        "description": "请求头 Accept: application/x-msgpack 时返回字段相同的MessagePack编码，默认返回JSON",
+        "operationId": "listPortMappings",
+        "responses": {
+          "200": {
+            "description": "按 内部端口:外部端口:协议 索引的映射",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "additionalProperties": {
+                    "$ref": "#/components/schemas/PortMapping"
+                  }
+                }
+              },
+              "application/x-msgpack": {
+                "schema": {
+                  "type": "object",
+                  "additionalProperties": {
+                    "$ref": "#/components/schemas/PortMapping"
+                  }
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/api/mappings/export": {
+      "get": {
+        "summary": "导出手动映射为防火墙规则",
+        "operationId": "exportMappings",
+        "parameters": [
+          {
+            "name": "format",
+            "in": "query",
+            "schema": {
+              "type": "string",
+              "enum": [
+                "nftables",
+                "iptables"
+              ],
+              "default": "nftables"
+            }
+          },
+          {
+            "name": "internal_ip",
+            "in": "query",
+            "schema": {
+              "type": "string",
+              "format": "ipv4"
+            }
+          },
+          {
+            "name": "wan_iface",
+            "in": "query",
+            "schema": {
+              "type": "string",
+              "maxLength": 15
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Shell脚本",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "type": "string"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "参数错误",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/APIResponse"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/api/mappings/{id}": {
+      "patch": {
+        "summary": "更新手动映射的本地备注",
+        "operationId": "updateMapping",
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "required": true,
+            "description": "映射ID，格式为 内部端口:外部端口:协议，例如 8080:8080:TCP",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/UpdateMappingRequest"
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "更新成功",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "allOf": [
+                    {
+                      "$ref": "#/components/schemas/APIResponse"
+                    },
+                    {
+                      "type": "object",
+                      "properties": {
+                        "data": {
+                          "$ref": "#/components/schemas/ManualMapping"
+                        }
+                      }
+                    }
+                  ]
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "参数错误",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/APIResponse"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "映射不存在",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/APIResponse"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/api/mappings/{id}/test": {
+      "post": {
+        "summary": "端到端测试手动映射",
+        "operationId": "testMapping",
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "required": true,
+            "description": "映射ID，格式为 内部端口:外部端口:协议，例如 8080:8080:TCP",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "测试完成，data.passed表示是否通过",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "allOf": [
+                    {
+                      "$ref": "#/components/schemas/APIResponse"
+                    },
+                    {
+                      "type": "object",
+                      "properties": {
+                        "data": {
+                          "$ref": "#/components/schemas/MappingProbeResult"
+                        }
+                      }
+                    }
+                  ]
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "参数错误",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/APIResponse"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "映射不存在",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/APIResponse"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/api/mappings/{id}/owner": {
+      "get": {
+        "summary": "查询映射所属的本地进程",
+        "operationId": "getMappingOwner",
+        "description": "通过/proc查找监听映射内部端口的进程，进程运行在容器中时返回容器ID。仅支持Linux，无法确定进程（非Linux、权限不足、端口未监听）时返回200且owner.known为false",
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "required": true,
+            "description": "映射ID，格式为 内部端口:外部端口:协议，手动映射和自动映射均可",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "查询完成，data.owner.known表示是否找到进程",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "allOf": [
+                    {
+                      "$ref": "#/components/schemas/APIResponse"
+                    },
+                    {
+                      "type": "object",
+                      "properties": {
+                        "data": {
+                          "$ref": "#/components/schemas/MappingOwner"
+                        }
+                      }
+                    }
+                  ]
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "参数错误",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/APIResponse"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "映射不存在",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/APIResponse"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/api/manual-mappings": {
+      "get": {
+        "summary": "获取手动映射列表",
+        "description": "请求头 Accept: application/x-msgpack 时返回字段相同的MessagePack编码，默认返回JSON",
+        "operationId": "listManualMappings",
+        "responses": {
+          "200": {
+            "description": "手动映射列表",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/ManualMappingsResponse"
+                }
+              },
+              "application/x-msgpack": {
+                "schema": {
+                  "$ref": "#/components/schemas/ManualMappingsResponse"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/api/add-mapping": {
+      "post": {
+        "summary": "添加手动映射",
+        "operationId": "addMapping",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/AddMappingRequest"
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "添加成功",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "allOf": [
+                    {
+                      "$ref": "#/components/schemas/APIResponse"
+                    },
+                    {
+                      "type": "object",
+                      "properties": {
+                        "data": {
+                          "$ref": "#/components/schemas/ManualMappingResult"
+                        }
+                      }
+                    }
+                  ]
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "参数错误",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/APIResponse"
+                }
+              }
+            }
+          },
+          "409": {
+            "description": "外部端口冲突，或映射已存在（非幂等添加或参数不同）",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "allOf": [
+                    {
+                      "$ref": "#/components/schemas/APIResponse"
+                    },
+                    {
+                      "type": "object",
+                      "properties": {
+                        "data": {
+                          "type": "object",
+                          "properties": {
+                            "conflict": {
+                              "$ref": "#/components/schemas/MappingConflict"
+                            }
+                          }
+                        }
+                      }
+                    }
+                  ]
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "添加失败",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/APIResponse"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/api/remove-mapping": {
+      "post": {
+        "summary": "删除手动映射",
+        "operationId": "removeMapping",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/RemoveMappingRequest"
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "删除成功",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/APIResponse"
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "参数错误",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/APIResponse"
+                }
+              }
+            }
+          },
+          "500": {
+            "description": "删除失败",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/APIResponse"
+                }
+              }
+            }
+          },
+          "502": {
+            "description": "路由器删除映射失败（已按指数退避重试），映射仍保留在路由器上，本地记录未删除；路由器已不可用时可以设置force强制删除",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/APIResponse"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/api/refresh-mapping": {
+      "post": {
+        "summary": "重新写入单个映射",
+        "description": "立即向所有持有该映射的路由器重新写入一次，不等待下一次续期",
+        "operationId": "refreshMapping",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/RefreshMappingRequest"
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "重新写入成功，返回映射的最新状态",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "allOf": [
+                    {
+                      "$ref": "#/components/schemas/APIResponse"
+                    },
+                    {
+                      "type": "object",
+                      "properties": {
+                        "data": {
+                          "$ref": "#/components/schemas/PortMapping"
+                        }
+                      }
+                    }
+                  ]
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "参数错误",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/APIResponse"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "映射当前没有注册到路由器",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/APIResponse"
+                }
+              }
+            }
+          },
+          "502": {
+            "description": "路由器重新写入映射失败",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/APIResponse"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/api/ports": {
+      "get": {
+        "summary": "获取监控端口状态",
+        "operationId": "listPorts",
+        "responses": {
+          "200": {
+            "description": "端口状态",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/PortsResponse"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/api/upnp-status": {
+      "get": {
+        "summary": "获取UPnP状态",
+        "operationId": "getUPnPStatus",
+        "responses": {
+          "200": {
+            "description": "UPnP状态",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/UPnPStatusResponse"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/api/external-ip": {
+      "get": {
+        "summary": "获取路由器的WAN口地址",
+        "description": "返回UPnP健康检查缓存的路由器外部IP，每次健康检查时刷新，请求本身不调用路由器；尚未缓存时查询一次。多个网关时返回最近一次检查成功的网关的地址。",
+        "operationId": "getExternalIP",
+        "responses": {
+          "200": {
+            "description": "获取成功",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "allOf": [
+                    {
+                      "$ref": "#/components/schemas/APIResponse"
+                    },
+                    {
+                      "type": "object",
+                      "properties": {
+                        "data": {
+                          "$ref": "#/components/schemas/RouterExternalIP"
+                        }
+                      }
+                    }
+                  ]
+                }
+              }
+            }
+          },
+          "503": {
+            "description": "没有可用的UPnP客户端，或路由器WAN口暂时没有地址",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/APIResponse"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/api/router-mappings": {
+      "get": {
+        "summary": "列出路由器上的全部端口映射",
+        "description": "通过GetGenericPortMappingEntry枚举路由器映射表，包括其他程序创建的映射。managed表示映射由本实例管理，local表示映射指向本机。",
+        "operationId": "listRouterMappings",
+        "responses": {
+          "200": {
+            "description": "路由器映射表",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "allOf": [
+                    {
+                      "$ref": "#/components/schemas/APIResponse"
+                    },
+                    {
+                      "type": "object",
+                      "properties": {
+                        "data": {
+                          "$ref": "#/components/schemas/RouterMappingsResponse"
+                        }
+                      }
+                    }
+                  ]
+                }
+              }
+            }
+          },
+          "503": {
+            "description": "UPnP不可用或路由器不支持枚举",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/APIResponse"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/api/router-mappings/adopt": {
+      "post": {
+        "summary": "接管其他程序创建的映射",
+        "description": "将指向本机的路由器映射转为手动映射，之后由本服务维护。",
+        "operationId": "adoptRouterMapping",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "$ref": "#/components/schemas/AdoptMappingRequest"
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "接管成功",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "allOf": [
+                    {
+                      "$ref": "#/components/schemas/APIResponse"
+                    },
+                    {
+                      "type": "object",
+                      "properties": {
+                        "data": {
+                          "$ref": "#/components/schemas/ManualMappingResult"
+                        }
+                      }
+                    }
+                  ]
+                }
+              }
+            }
+          },
+          "400": {
+            "description": "映射指向其他主机或内部端口在自动映射范围内",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/APIResponse"
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "路由器上不存在该映射",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/APIResponse"
+                }
+              }
+            }
+          },
+          "409": {
+            "description": "映射已由本服务管理或外部端口冲突",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/APIResponse"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/api/drift": {
+      "get": {
+        "summary": "映射差异报告",
+        "description": "比较本地映射记录与路由器映射表，报告路由器上缺失、多余和参数不一致的映射。只报告不修正，默认返回最近一次定期校验的结果。",
+        "operationId": "getDrift",
+        "parameters": [
+          {
+            "name": "refresh",
+            "in": "query",
+            "required": false,
+            "description": "为true时立即重新校验",
+            "schema": {
+              "type": "boolean"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "获取成功",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "allOf": [
+                    {
+                      "$ref": "#/components/schemas/APIResponse"
+                    },
+                    {
+                      "type": "object",
+                      "properties": {
+                        "data": {
+                          "$ref": "#/components/schemas/DriftReport"
+                        }
+                      }
+                    }
+                  ]
+                }
+              }
+            }
+          },
+          "503": {
+            "description": "UPnP不可用或枚举路由器映射表失败",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/APIResponse"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/api/drift/reconcile": {
+      "post": {
+        "summary": "修正映射差异",
+        "description": "重新校验后重新写入路由器上缺失或被改动的映射，并删除路由器上本实例创建但没有本地记录的映射。",
+        "operationId": "reconcileDrift",
+        "responses": {
+          "200": {
+            "description": "修正完成，failed中列出修正失败的差异",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "allOf": [
+                    {
+                      "$ref": "#/components/schemas/APIResponse"
+                    },
+                    {
+                      "type": "object",
+                      "properties": {
+                        "data": {
+                          "$ref": "#/components/schemas/DriftReconcileResult"
+                        }
+                      }
+                    }
+                  ]
+                }
+              }
+            }
+          },
+          "503": {
+            "description": "UPnP不可用或枚举路由器映射表失败",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/APIResponse"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/api/capabilities": {
+      "get": {
+        "summary": "实例能力",
+        "description": "列出各映射提供方支持的协议和地址族、映射数量上限、公网IP来源和可选功能的启用状态。UPnP端口映射和IPv6针孔都只支持TCP和UDP；GRE、ESP等没有端口的协议无法映射，unsupported中给出替代方案。添加这些协议的映射时返回400。",
+        "operationId": "getCapabilities",
+        "responses": {
+          "200": {
+            "description": "获取成功",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "allOf": [
+                    {
+                      "$ref": "#/components/schemas/APIResponse"
+                    },
+                    {
+                      "type": "object",
+                      "properties": {
+                        "data": {
+                          "$ref": "#/components/schemas/Capabilities"
+                        }
+                      }
+                    }
+                  ]
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/api/rediscover": {
+      "post": {
+        "summary": "立即重新发现UPnP设备",
+        "operationId": "rediscover",
+        "responses": {
+          "200": {
+            "description": "发现成功",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "allOf": [
+                    {
+                      "$ref": "#/components/schemas/APIResponse"
+                    },
+                    {
+                      "type": "object",
+                      "properties": {
+                        "data": {
+                          "$ref": "#/components/schemas/RediscoverResponse"
+                        }
+                      }
+                    }
+                  ]
+                }
+              }
+            }
+          },
+          "409": {
+            "description": "发现正在进行中",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "allOf": [
+                    {
+                      "$ref": "#/components/schemas/APIResponse"
+                    },
+                    {
+                      "type": "object",
+                      "properties": {
+                        "data": {
+                          "$ref": "#/components/schemas/RediscoverResponse"
+                        }
+                      }
+                    }
+                  ]
+                }
+              }
+            }
+          },
+          "503": {
+            "description": "发现失败",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "allOf": [
+                    {
+                      "$ref": "#/components/schemas/APIResponse"
+                    },
+                    {
+                      "type": "object",
+                      "properties": {
+                        "data": {
+                          "$ref": "#/components/schemas/RediscoverResponse"
+                        }
+                      }
+                    }
+                  ]
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/api/groups": {
+      "get": {
+        "summary": "获取映射分组",
+        "operationId": "listGroups",
+        "responses": {
+          "200": {
+            "description": "映射分组",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/GroupsResponse"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/api/groups/{name}/{action}": {
+      "post": {
+        "summary": "启用或停用映射分组",
+        "operationId": "setGroupEnabled",
+        "parameters": [
+          {
+            "name": "name",
+            "in": "path",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "action",
+            "in": "path",
+            "required": true,
+            "schema": {
+              "type": "string",
+              "enum": [
+                "enable",
+                "disable"
+              ]
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "全部成功",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "allOf": [
+                    {
+                      "$ref": "#/components/schemas/APIResponse"
+                    },
+                    {
+                      "type": "object",
+                      "properties": {
+                        "data": {
+                          "$ref": "#/components/schemas/GroupActionResponse"
+                        }
+                      }
+                    }
+                  ]
+                }
+              }
+            }
+          },
+          "207": {
+            "description": "部分映射操作失败",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "allOf": [
+                    {
+                      "$ref": "#/components/schemas/APIResponse"
+                    },
+                    {
+                      "type": "object",
+                      "properties": {
+                        "data": {
+                          "$ref": "#/components/schemas/GroupActionResponse"
+                        }
+                      }
+                    }
+                  ]
+                }
+              }
+            }
+          },
+          "404": {
+            "description": "分组不存在",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/APIResponse"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/api/openapi.json": {
+      "get": {
+        "summary": "获取本OpenAPI文档",
+        "operationId": "getOpenAPI",
+        "responses": {
+          "200": {
+            "description": "OpenAPI 3文档",
+            "content": {
+              "application/json": {}
+            }
+          }
+        }
+      }
+    },
+    "/api/csrf-token": {
+      "get": {
+        "summary": "获取CSRF令牌",
+        "description": "使用Basic认证的修改类请求需要携带该令牌，服务重启后令牌变化",
+        "operationId": "getCSRFToken",
+        "security": [
+          {
+            "basicAuth": []
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "CSRF令牌",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/CSRFTokenResponse"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/readyz": {
+      "get": {
+        "summary": "就绪探针",
+        "operationId": "readyz",
+        "security": [],
+        "responses": {
+          "200": {
+            "description": "所有子系统正常",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/ReadyzResponse"
+                }
+              }
+            }
+          },
+          "503": {
+            "description": "有子系统心跳超时",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/ReadyzResponse"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/metrics": {
+      "get": {
+        "summary": "Prometheus指标",
+        "description": "以Prometheus文本格式导出服务指标，与InfluxDB推送使用同一组指标。admin.metrics_public为true时不需要认证。",
+        "operationId": "getMetrics",
+        "responses": {
+          "200": {
+            "description": "Prometheus文本格式的指标",
+            "content": {
+              "text/plain": {
+                "schema": {
+                  "type": "string"
+                },
+                "example": "# HELP auto_upnp_auto_mappings 已注册的自动映射数量\n# TYPE auto_upnp_auto_mappings gauge\nauto_upnp_auto_mappings 2\n"
+              }
+            }
+          }
+        }
+      }
+    },
+    "/api/health": {
+      "get": {
+        "summary": "服务健康汇总",
+        "description": "汇总UPnP可用性、失败和反复失败的映射数量以及子系统心跳，面向监控面板和告警。UPnP可用但存在问题时status为degraded，UPnP不可用时为down。",
+        "operationId": "getHealth",
+        "responses": {
+          "200": {
+            "description": "状态为ok或degraded",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/HealthSummary"
+                }
+              }
+            }
+          },
+          "503": {
+            "description": "状态为down，UPnP不可用",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/HealthSummary"
+                }
+              }
+            }
+          }
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "basicAuth": {
+        "type": "http",
+        "scheme": "basic"
+      },
+      "bearerAuth": {
+        "type": "http",
+        "scheme": "bearer",
+        "description": "admin.api_token配置的令牌，不需要CSRF令牌"
+      },
+      "csrfToken": {
+        "type": "apiKey",
+        "in": "header",
+        "name": "X-CSRF-Token",
+        "description": "只有POST、PATCH等修改类请求需要"
+      }
+    },
+    "schemas": {
+      "CSRFTokenResponse": {
+        "type": "object",
+        "properties": {
+          "header": {
+            "type": "string",
+            "example": "X-CSRF-Token"
+          },
+          "token": {
+            "type": "string"
+          }
+        }
+      },
+      "APIResponse": {
+        "type": "object",
+        "required": [
+          "status",
+          "message"
+        ],
+        "properties": {
+          "status": {
+            "type": "string",
+            "enum": [
+              "success",
+              "error"
+            ]
+          },
+          "message": {
+            "type": "string"
+          },
+          "data": {
+            "description": "操作相关的数据，不同接口内容不同"
+          }
+        }
+      },
+      "AddMappingRequest": {
+        "type": "object",
+        "required": [
+          "internal_port",
+          "external_port"
+        ],
+        "properties": {
+          "internal_port": {
+            "type": "integer",
+            "description": "本机服务监听的端口，不能在自动映射端口范围内",
+            "minimum": 1,
+            "maximum": 65535
+          },
+          "external_port": {
+            "type": "integer",
+            "description": "路由器对外开放并转发到内部端口的端口，可以与内部端口不同，不能在自动映射端口范围内",
+            "minimum": 1,
+            "maximum": 65535
+          },
+          "protocol": {
+            "type": "string",
+            "enum": [
+              "TCP",
+              "UDP"
+            ],
+            "default": "TCP"
+          },
+          "description": {
+            "type": "string"
+          },
+          "backup_external_port": {
+            "type": "integer",
+            "minimum": 1,
+            "maximum": 65535,
+            "description": "主外部端口冲突时使用的备用外部端口"
+          },
+          "replace": {
+            "type": "boolean",
+            "description": "外部端口冲突时替换已有映射"
+          },
+          "group": {
+            "type": "string",
+            "maxLength": 64,
+            "description": "所属分组，不能包含/"
+          },
+          "mdns_type": {
+            "type": "string",
+            "example": "_http._tcp",
+            "description": "在局域网通过mDNS广播的DNS-SD服务类型，为空时不广播"
+          },
+          "mdns_name": {
+            "type": "string",
+            "maxLength": 63,
+            "description": "DNS-SD服务实例名，为空时使用描述"
+          },
+          "priority": {
+            "type": "integer",
+            "minimum": 1,
+            "maximum": 1000,
+            "default": 100,
+            "description": "映射优先级，外部端口被优先级更低的本服务映射占用时抢占该端口；自动映射的优先级为0"
+          },
+          "idempotent": {
+            "type": "boolean",
+            "description": "映射已存在且参数相同时返回已有映射（200），参数不同时仍返回409；省略时使用admin.idempotent_add配置"
+          },
+          "reserved": {
+            "type": "boolean",
+            "description": "预留映射：本地端口未上线时也在路由器上注册，占用外部端口；端口下线后不会删除"
+          },
+          "health_check": {
+            "$ref": "#/components/schemas/HealthCheck"
+          },
+          "scheme": {
+            "type": "string",
+            "description": "访问协议（http、https、ssh、rdp或其他URI协议名），只用于生成访问链接；为空时按常见端口推断",
+            "example": "https"
+          },
+          "remote_host": {
+            "type": "string",
+            "description": "路由器只转发来自该IPv4地址的连接（UPnP的NewRemoteHost），不支持域名和网段；为空时不限制来源",
+            "example": "198.51.100.20"
+          }
+        }
+      },
+      "RemoveMappingRequest": {
+        "type": "object",
+        "required": [
+          "internal_port",
+          "external_port"
+        ],
+        "properties": {
+          "internal_port": {
+            "type": "integer",
+            "minimum": 1,
+            "maximum": 65535
+          },
+          "external_port": {
+            "type": "integer",
+            "minimum": 1,
+            "maximum": 65535
+          },
+          "protocol": {
+            "type": "string",
+            "enum": [
+              "TCP",
+              "UDP"
+            ],
+            "default": "TCP"
+          },
+          "force": {
+            "type": "boolean",
+            "default": false,
+            "description": "路由器删除失败时也删除本地记录，路由器已不可用时使用；只尝试一次路由器删除，不再退避重试"
+          }
+        }
+      },
+      "RefreshMappingRequest": {
+        "type": "object",
+        "required": [
+          "internal_port",
+          "external_port"
+        ],
+        "properties": {
+          "internal_port": {
+            "type": "integer",
+            "minimum": 1,
+            "maximum": 65535
+          },
+          "external_port": {
+            "type": "integer",
+            "minimum": 1,
+            "maximum": 65535
+          },
+          "protocol": {
+            "type": "string",
+            "enum": [
+              "TCP",
+              "UDP"
+            ],
+            "default": "TCP"
+          }
+        }
+      },
+      "UpdateMappingRequest": {
+        "type": "object",
+        "properties": {
+          "note": {
+            "type": "string",
+            "maxLength": 500
+          },
+          "scheme": {
+            "type": "string",
+            "description": "访问协议，为空字符串时按端口重新推断",
+            "example": "https"
+          }
+        }
+      },
+      "PortMapping": {
+        "type": "object",
+        "properties": {
+          "correlation_id": {
+            "type": "string",
+            "description": "映射的关联ID，日志中同一映射的所有记录都带有相同的correlation_id"
+          },
+          "internal_port": {
+            "type": "integer"
+          },
+          "external_port": {
+            "type": "integer"
+          },
+          "protocol": {
+            "type": "string"
+          },
+          "internal_client": {
+            "type": "string"
+          },
+          "description": {
+            "type": "string"
+          },
+          "scheme": {
+            "type": "string",
+            "description": "访问协议：手动映射指定的值，或按常见端口推断；无法确定时省略",
+            "example": "https"
+          },
+          "lease_duration": {
+            "type": "integer",
+            "description": "租期（秒），0表示永久"
+          },
+          "created_at": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "adopted": {
+            "type": "boolean",
+            "description": "启动时接管的路由器上已存在的映射"
+          },
+          "gateway": {
+            "type": "string",
+            "description": "映射所在网关的URL，删除和重新写入时只针对该网关"
+          },
+          "remote_host": {
+            "type": "string",
+            "description": "只允许该地址访问，为空时不限制来源"
+          },
+          "simulated": {
+            "type": "boolean",
+            "description": "模拟模式下添加，只记录在本地，没有写入路由器"
+          },
+          "active": {
+            "type": "boolean"
+          }
+        }
+      },
+      "ManualMapping": {
+        "type": "object",
+        "properties": {
+          "correlation_id": {
+            "type": "string",
+            "description": "映射的关联ID，创建时生成且不会改变，日志中同一映射的所有记录都带有相同的correlation_id"
+          },
+          "internal_port": {
+            "type": "integer"
+          },
+          "external_port": {
+            "type": "integer"
+          },
+          "protocol": {
+            "type": "string"
+          },
+          "description": {
+            "type": "string"
+          },
+          "created_at": {
+            "type": "string"
+          },
+          "active": {
+            "type": "boolean"
+          },
+          "backup_external_port": {
+            "type": "integer"
+          },
+          "live_external_port": {
+            "type": "integer",
+            "description": "当前生效的外部端口，备用端口生效时出现"
+          },
+          "switch_reason": {
+            "type": "string"
+          },
+          "switched_at": {
+            "type": "string"
+          },
+          "note": {
+            "type": "string"
+          },
+          "scheme": {
+            "type": "string",
+            "description": "访问协议，用于生成访问链接",
+            "example": "https"
+          },
+          "remote_host": {
+            "type": "string",
+            "description": "路由器只转发来自该地址的连接，为空时不限制来源"
+          },
+          "group": {
+            "type": "string"
+          },
+          "mdns_type": {
+            "type": "string"
+          },
+          "mdns_name": {
+            "type": "string"
+          },
+          "priority": {
+            "type": "integer"
+          },
+          "reserved": {
+            "type": "boolean",
+            "description": "预留映射，本地端口未上线时也占用路由器上的外部端口"
+          },
+          "health_check": {
+            "$ref": "#/components/schemas/HealthCheck"
+          },
+          "health": {
+            "$ref": "#/components/schemas/HealthResult"
+          },
+          "disabled": {
+            "type": "boolean"
+          },
+          "attempt_count": {
+            "type": "integer"
+          },
+          "failure_count": {
+            "type": "integer"
+          },
+          "last_error": {
+            "type": "string"
+          },
+          "last_error_at": {
+            "type": "string"
+          },
+          "last_success_at": {
+            "type": "string"
+          }
+        }
+      },
+      "HealthCheck": {
+        "type": "object",
+        "description": "应用层健康检查，端口在监听且检查通过时映射才视为上线，按端口检查间隔执行",
+        "required": [
+          "type"
+        ],
+        "properties": {
+          "type": {
+            "type": "string",
+            "enum": [
+              "http",
+              "tcp",
+              "exec"
+            ]
+          },
+          "url": {
+            "type": "string",
+            "description": "http: 请求地址，为空时请求 http://127.0.0.1:<内部端口>/"
+          },
+          "expect_status": {
+            "type": "integer",
+            "default": 200,
+            "description": "http: 期望的状态码，不跟随重定向"
+          },
+          "address": {
+            "type": "string",
+            "description": "tcp: 连接地址，为空时连接 127.0.0.1:<内部端口>"
+          },
+          "command": {
+            "type": "array",
+            "items": {
+              "type": "string"
+            },
+            "description": "exec: 命令及参数，退出码为0时健康；需要开启monitor.allow_exec_health_check"
+          },
+          "timeout_seconds": {
+            "type": "integer",
+            "minimum": 0,
+            "description": "超时秒数，为0时使用端口检查的超时"
+          }
+        }
+      },
+      "HealthResult": {
+        "type": "object",
+        "properties": {
+          "healthy": {
+            "type": "boolean"
+          },
+          "checked_at": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "error": {
+            "type": "string"
+          }
+        }
+      },
+      "ManualMappingResult": {
+        "type": "object",
+        "properties": {
+          "provider": {
+            "type": "string",
+            "enum": [
+              "upnp"
+            ]
+          },
+          "internal_port": {
+            "type": "integer"
+          },
+          "external_port": {
+            "type": "integer"
+          },
+          "external_address": {
+            "type": "string",
+            "description": "公网地址，公网IP尚未获取时省略"
+          },
+          "protocol": {
+            "type": "string"
+          },
+          "status": {
+            "type": "string",
+            "enum": [
+              "mapped",
+              "waiting",
+              "reserved"
+            ]
+          },
+          "existing": {
+            "type": "boolean",
+            "description": "幂等添加时映射已存在，未做任何修改"
+          },
+          "mapping": {
+            "$ref": "#/components/schemas/ManualMapping"
+          }
+        }
+      },
+      "ManualMappingsResponse": {
+        "type": "object",
+        "properties": {
+          "total_mappings": {
+            "type": "integer"
+          },
+          "active_mappings": {
+            "type": "integer"
+          },
+          "inactive_mappings": {
+            "type": "integer"
+          },
+          "all_mappings": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/ManualMapping"
+            }
+          },
+          "active_mappings_list": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/ManualMapping"
+            }
+          },
+          "inactive_mappings_list": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/ManualMapping"
+            }
+          }
+        }
+      },
+      "MappingConflict": {
+        "type": "object",
+        "properties": {
+          "source": {
+            "type": "string",
+            "enum": [
+              "manual",
+              "auto",
+              "router"
+            ]
+          },
+          "internal_port": {
+            "type": "integer"
+          },
+          "external_port": {
+            "type": "integer"
+          },
+          "protocol": {
+            "type": "string"
+          },
+          "internal_client": {
+            "type": "string"
+          },
+          "description": {
+            "type": "string"
+          },
+          "priority": {
+            "type": "integer",
+            "description": "冲突映射的优先级，路由器上其他客户端的映射固定为0且不会被自动抢占"
+          },
+          "replaceable": {
+            "type": "boolean"
+          }
+        }
+      },
+      "MappingProbeStep": {
+        "type": "object",
+        "properties": {
+          "name": {
+            "type": "string",
+            "enum": [
+              "local_service",
+              "router_mapping",
+              "external_reachability"
+            ]
+          },
+          "passed": {
+            "type": "boolean"
+          },
+          "skipped": {
+            "type": "boolean"
+          },
+          "duration_ms": {
+            "type": "number"
+          },
+          "detail": {
+            "type": "string"
+          },
+          "error": {
+            "type": "string"
+          }
+        }
+      },
+      "MappingProbeResult": {
+        "type": "object",
+        "properties": {
+          "internal_port": {
+            "type": "integer"
+          },
+          "external_port": {
+            "type": "integer"
+          },
+          "protocol": {
+            "type": "string"
+          },
+          "passed": {
+            "type": "boolean"
+          },
+          "failure_reason": {
+            "type": "string",
+            "enum": [
+              "local_service_down",
+              "router_mapping_broken",
+              "external_unreachable"
+            ]
+          },
+          "duration_ms": {
+            "type": "number"
+          },
+          "steps": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/MappingProbeStep"
+            }
+          }
+        }
+      },
+      "PortsResponse": {
+        "type": "object",
+        "properties": {
+          "active_ports": {
+            "type": "array",
+            "items": {
+              "type": "integer"
+            }
+          },
+          "inactive_ports": {
+            "type": "array",
+            "items": {
+              "type": "integer"
+            }
+          },
+          "port_states": {
+            "type": "object",
+            "description": "按端口号索引的探测状态",
+            "additionalProperties": {
+              "type": "string",
+              "enum": [
+                "listening",
+                "refused",
+                "filtered",
+                "free"
+              ]
+            }
+          }
+        }
+      },
+      "UPnPStatusResponse": {
+        "type": "object",
+        "properties": {
+          "client_count": {
+            "type": "integer"
+          },
+          "available": {
+            "type": "boolean"
+          },
+          "status": {
+            "type": "string"
+          },
+          "clients": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/UPnPClient"
+            }
+          }
+        }
+      },
+      "RouterExternalIP": {
+        "type": "object",
+        "properties": {
+          "ip": {
+            "type": "string"
+          },
+          "device": {
+            "type": "string"
+          },
+          "gateway": {
+            "type": "string",
+            "description": "网关的URL"
+          },
+          "updated_at": {
+            "type": "string",
+            "format": "date-time",
+            "description": "最近一次从路由器读到该地址的时间"
+          }
+        }
+      },
+      "UPnPClient": {
+        "type": "object",
+        "properties": {
+          "device_name": {
+            "type": "string"
+          },
+          "url": {
+            "type": "string"
+          },
+          "igd_version": {
+            "type": "integer",
+            "enum": [
+              1,
+              2
+            ],
+            "description": "IGD版本，WANIPConnection:2为2，其余为1"
+          },
+          "service": {
+            "type": "string",
+            "enum": [
+              "WANIPConnection:1",
+              "WANPPPConnection:1",
+              "WANIPConnection:2"
+            ],
+            "description": "使用的WAN连接服务"
+          },
+          "is_healthy": {
+            "type": "boolean"
+          },
+          "fail_count": {
+            "type": "integer"
+          },
+          "last_seen": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "transient_fail_count": {
+            "type": "integer"
+          },
+          "transient_errors": {
+            "type": "integer"
+          }
+        }
+      },
+      "DriftEntry": {
+        "type": "object",
+        "properties": {
+          "kind": {
+            "type": "string",
+            "enum": [
+              "missing_on_router",
+              "extra_on_router",
+              "param_mismatch"
+            ]
+          },
+          "internal_port": {
+            "type": "integer"
+          },
+          "external_port": {
+            "type": "integer"
+          },
+          "protocol": {
+            "type": "string"
+          },
+          "expected": {
+            "type": "string",
+            "description": "本地记录的内部地址和端口"
+          },
+          "actual": {
+            "type": "string",
+            "description": "路由器上的内部地址和端口"
+          },
+          "detail": {
+            "type": "string"
+          }
+        }
+      },
+      "DriftReport": {
+        "type": "object",
+        "properties": {
+          "checked_at": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "in_sync": {
+            "type": "boolean"
+          },
+          "records": {
+            "type": "integer"
+          },
+          "router_entries": {
+            "type": "integer"
+          },
+          "missing_on_router": {
+            "type": "integer"
+          },
+          "extra_on_router": {
+            "type": "integer"
+          },
+          "param_mismatch": {
+            "type": "integer"
+          },
+          "entries": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/DriftEntry"
+            }
+          }
+        }
+      },
+      "DriftReconcileResult": {
+        "type": "object",
+        "properties": {
+          "corrected": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/DriftEntry"
+            }
+          },
+          "failed": {
+            "type": "array",
+            "items": {
+              "allOf": [
+                {
+                  "$ref": "#/components/schemas/DriftEntry"
+                },
+                {
+                  "type": "object",
+                  "properties": {
+                    "error": {
+                      "type": "string"
+                    }
+                  }
+                }
+              ]
+            }
+          }
+        }
+      },
+      "Capabilities": {
+        "type": "object",
+        "description": "运行中的实例支持的映射能力，管理界面据此显示或隐藏功能",
+        "properties": {
+          "providers": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "provider": {
+                  "type": "string",
+                  "enum": [
+                    "upnp",
+                    "ipv6_pinhole"
+                  ]
+                },
+                "protocols": {
+                  "type": "array",
+                  "items": {
+                    "type": "string"
+                  }
+                },
+                "families": {
+                  "type": "array",
+                  "items": {
+                    "type": "string",
+                    "enum": [
+                      "IPv4",
+                      "IPv6"
+                    ]
+                  }
+                },
+                "available": {
+                  "type": "boolean"
+                }
+              }
+            }
+          },
+          "max_mappings": {
+            "type": "integer"
+          },
+          "external_ip_sources": {
+            "type": "array",
+            "items": {
+              "type": "string",
+              "enum": [
+                "router",
+                "stun",
+                "http"
+              ]
+            }
+          },
+          "features": {
+            "type": "object",
+            "additionalProperties": {
+              "type": "boolean"
+            },
+            "description": "功能名称到是否启用的映射，未列出的功能本实例不支持",
+            "example": {
+              "mapping_groups": true,
+              "reserved_mappings": true,
+              "remote_host": true,
+              "health_checks": true,
+              "exec_health_checks": false,
+              "mdns": false,
+              "ddns": false,
+              "drift_check": true,
+              "remove_grace_period": false,
+              "idempotent_add": false
+            }
+          },
+          "unsupported": {
+            "type": "object",
+            "additionalProperties": {
+              "type": "string"
+            },
+            "description": "协议到替代方案的映射",
+            "example": {
+              "GRE": "在路由器上开启PPTP/GRE直通，或手动添加转发规则"
+            }
+          }
+        }
+      },
+      "RouterMapping": {
+        "type": "object",
+        "properties": {
+          "index": {
+            "type": "integer"
+          },
+          "remote_host": {
+            "type": "string"
+          },
+          "external_port": {
+            "type": "integer"
+          },
+          "internal_port": {
+            "type": "integer"
+          },
+          "protocol": {
+            "type": "string",
+            "enum": [
+              "TCP",
+              "UDP"
+            ]
+          },
+          "internal_client": {
+            "type": "string"
+          },
+          "enabled": {
+            "type": "boolean"
+          },
+          "description": {
+            "type": "string"
+          },
+          "lease_duration": {
+            "type": "integer"
+          },
+          "device": {
+            "type": "string"
+          },
+          "local": {
+            "type": "boolean",
+            "description": "映射指向本机"
+          },
+          "managed": {
+            "type": "boolean",
+            "description": "映射由本实例管理"
+          }
+        }
+      },
+      "RouterMappingsResponse": {
+        "type": "object",
+        "properties": {
+          "mappings": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/RouterMapping"
+            }
+          },
+          "total": {
+            "type": "integer"
+          },
+          "managed": {
+            "type": "integer"
+          }
+        }
+      },
+      "AdoptMappingRequest": {
+        "type": "object",
+        "required": [
+          "external_port"
+        ],
+        "properties": {
+          "external_port": {
+            "type": "integer",
+            "minimum": 1,
+            "maximum": 65535
+          },
+          "protocol": {
+            "type": "string",
+            "enum": [
+              "TCP",
+              "UDP"
+            ],
+            "default": "TCP"
+          }
+        }
+      },
+      "RediscoverResponse": {
+        "type": "object",
+        "properties": {
+          "client_count": {
+            "type": "integer"
+          }
+        }
+      },
+      "SubsystemStatus": {
+        "type": "object",
+        "properties": {
+          "name": {
+            "type": "string"
+          },
+          "healthy": {
+            "type": "boolean"
+          },
+          "interval": {
+            "type": "string"
+          },
+          "last_heartbeat": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "since_last_heartbeat": {
+            "type": "string"
+          },
+          "error": {
+            "type": "string"
+          }
+        }
+      },
+      "ReadyzResponse": {
+        "type": "object",
+        "properties": {
+          "status": {
+            "type": "string",
+            "enum": [
+              "ready",
+              "not_ready"
+            ]
+          },
+          "subsystems": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/SubsystemStatus"
+            }
+          }
+        }
+      },
+      "MappingOwner": {
+        "type": "object",
+        "properties": {
+          "internal_port": {
+            "type": "integer"
+          },
+          "external_port": {
+            "type": "integer"
+          },
+          "protocol": {
+            "type": "string"
+          },
+          "owner": {
+            "type": "object",
+            "properties": {
+              "known": {
+                "type": "boolean"
+              },
+              "pid": {
+                "type": "integer"
+              },
+              "name": {
+                "type": "string"
+              },
+              "command": {
+                "type": "string"
+              },
+              "container_id": {
+                "type": "string",
+                "description": "进程运行在Docker、containerd、Podman或CRI-O容器中时的容器ID"
+              },
+              "reason": {
+                "type": "string",
+                "description": "known为false时无法确定进程的原因"
+              }
+            }
+          }
+        }
+      },
+      "HealthSummary": {
+        "type": "object",
+        "properties": {
+          "status": {
+            "type": "string",
+            "enum": [
+              "ok",
+              "degraded",
+              "down"
+            ]
+          },
+          "upnp_available": {
+            "type": "boolean"
+          },
+          "upnp_clients": {
+            "type": "integer"
+          },
+          "healthy_upnp_clients": {
+            "type": "integer"
+          },
+          "failed_mappings": {
+            "type": "integer",
+            "description": "所有尝试都失败的映射数量"
+          },
+          "flapping_mappings": {
+            "type": "integer",
+            "description": "成功过但近期失败3次以上的映射数量，映射持续稳定后失败计数清零"
+          },
+          "subsystems": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/SubsystemStatus"
+            }
+          },
+          "problems": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "component": {
+                  "type": "string",
+                  "enum": [
+                    "upnp",
+                    "mapping",
+                    "subsystem"
+                  ]
+                },
+                "message": {
+                  "type": "string"
+                }
+              }
+            }
+          }
+        }
+      },
+      "MappingGroup": {
+        "type": "object",
+        "properties": {
+          "name": {
+            "type": "string"
+          },
+          "status": {
+            "type": "string",
+            "enum": [
+              "enabled",
+              "disabled",
+              "partial"
+            ]
+          },
+          "total": {
+            "type": "integer"
+          },
+          "enabled": {
+            "type": "integer"
+          },
+          "active": {
+            "type": "integer"
+          },
+          "mappings": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/ManualMapping"
+            }
+          }
+        }
+      },
+      "GroupsResponse": {
+        "type": "object",
+        "properties": {
+          "total_groups": {
+            "type": "integer"
+          },
+          "groups": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/MappingGroup"
+            }
+          }
+        }
+      },
+      "GroupMemberResult": {
+        "type": "object",
+        "properties": {
+          "internal_port": {
+            "type": "integer"
+          },
+          "external_port": {
+            "type": "integer"
+          },
+          "protocol": {
+            "type": "string"
+          },
+          "success": {
+            "type": "boolean"
+          },
+          "error": {
+            "type": "string"
+          }
+        }
+      },
+      "GroupActionResponse": {
+        "type": "object",
+        "properties": {
+          "group": {
+            "type": "string"
+          },
+          "action": {
+            "type": "string",
+            "enum": [
+              "enable",
+              "disable"
+            ]
+          },
+          "failed": {
+            "type": "integer"
+          },
+          "results": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/GroupMemberResult"
+            }
+          }
+        }
+      },
+      "ExternalIP": {
+        "type": "object",
+        "nullable": true,
+        "properties": {
+          "ip": {
+            "type": "string"
+          },
+          "source": {
+            "type": "string",
+            "enum": [
+              "router",
+              "stun",
+              "http"
+            ]
+          },
+          "updated_at": {
+            "type": "string",
+            "format": "date-time"
+          }
+        }
+      },
+      "GatewayUsage": {
+        "type": "object",
+        "properties": {
+          "gateway": {
+            "type": "string"
+          },
+          "device_name": {
+            "type": "string"
+          },
+          "available": {
+            "type": "boolean",
+            "description": "当前是否为健康的UPnP客户端"
+          },
+          "weight": {
+            "type": "integer"
+          },
+          "mappings": {
+            "type": "integer"
+          }
+        }
+      },
+      "WANStatus": {
+        "type": "object",
+        "nullable": true,
+        "description": "UPnP健康检查从路由器读到的WAN地址，地址变化时重新写入所有映射",
+        "properties": {
+          "address": {
+            "type": "string",
+            "description": "当前WAN地址，路由器返回空地址或0.0.0.0时为空"
+          },
+          "observed": {
+            "type": "boolean",
+            "description": "是否已经通过健康检查读到过WAN地址"
+          },
+          "changed_at": {
+            "type": "string",
+            "format": "date-time",
+            "description": "最近一次变化的时间，尚未变化过时为零值"
+          },
+          "reassert_enabled": {
+            "type": "boolean"
+          },
+          "last_reassert": {
+            "type": "object",
+            "nullable": true,
+            "properties": {
+              "previous": {
+                "type": "string"
+              },
+              "current": {
+                "type": "string"
+              },
+              "at": {
+                "type": "string",
+                "format": "date-time"
+              },
+              "reasserted": {
+                "type": "integer"
+              },
+              "failed": {
+                "type": "integer"
+              },
+              "skipped": {
+                "type": "string",
+                "description": "未重新写入的原因"
+              }
+            }
+          }
+        }
+      },
+      "InfluxDBStatus": {
+        "type": "object",
+        "nullable": true,
+        "properties": {
+          "last_push_at": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "last_error": {
+            "type": "string"
+          },
+          "pending_batches": {
+            "type": "integer"
+          },
+          "dropped_batches": {
+            "type": "integer"
+          }
+        }
+      },
+      "NotificationStatus": {
+        "type": "object",
+        "nullable": true,
+        "description": "映射事件Webhook推送状态，未配置notifications.webhook_url时为null",
+        "properties": {
+          "sent": {
+            "type": "integer"
+          },
+          "failed": {
+            "type": "integer",
+            "description": "重试后仍然推送失败而放弃的事件数量"
+          },
+          "dropped": {
+            "type": "integer",
+            "description": "队列已满而丢弃的事件数量"
+          },
+          "last_error": {
+            "type": "string"
+          },
+          "last_error_at": {
+            "type": "string",
+            "format": "date-time"
+          }
+        }
+      },
+      "MDNSStatus": {
+        "type": "object",
+        "nullable": true,
+        "properties": {
+          "host_name": {
+            "type": "string",
+            "example": "nas.local"
+          },
+          "services": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "instance": {
+                  "type": "string"
+                },
+                "type": {
+                  "type": "string"
+                },
+                "port": {
+                  "type": "integer"
+                },
+                "txt": {
+                  "type": "array",
+                  "items": {
+                    "type": "string"
+                  }
+                }
+              }
+            }
+          }
+        }
+      },
+      "DDNSStatus": {
+        "type": "object",
+        "nullable": true,
+        "properties": {
+          "provider": {
+            "type": "string",
+            "enum": [
+              "cloudflare",
+              "duckdns",
+              "webhook"
+            ]
+          },
+          "ip": {
+            "type": "string"
+          },
+          "last_updated_at": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "last_attempt_at": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "last_result": {
+            "type": "string",
+            "enum": [
+              "success",
+              "error",
+              "rate_limited"
+            ]
+          },
+          "last_error": {
+            "type": "string"
+          },
+          "next_allowed_at": {
+            "type": "string",
+            "format": "date-time"
+          }
+        }
+      },
+      "PendingRemoval": {
+        "type": "object",
+        "properties": {
+          "type": {
+            "type": "string",
+            "enum": [
+              "auto",
+              "manual"
+            ]
+          },
+          "internal_port": {
+            "type": "integer"
+          },
+          "external_port": {
+            "type": "integer"
+          },
+          "protocol": {
+            "type": "string"
+          },
+          "scheduled_at": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "remove_at": {
+            "type": "string",
+            "format": "date-time"
+          }
+        }
+      },
+      "Status": {
+        "type": "object",
+        "description": "服务状态快照，各部分来自同一时刻",
+        "properties": {
+          "service_status": {
+            "type": "string"
+          },
+          "snapshot_at": {
+            "type": "string",
+            "format": "date-time"
+          },
+          "dry_run": {
+            "type": "boolean",
+            "description": "是否处于模拟模式，模拟模式下不会向路由器添加或删除映射"
+          },
+          "port_range": {
+            "type": "object",
+            "properties": {
+              "start": {
+                "type": "integer"
+              },
+              "end": {
+                "type": "integer"
+              },
+              "step": {
+                "type": "integer"
+              },
+              "port_count": {
+                "type": "integer"
+              },
+              "max_ports": {
+                "type": "integer"
+              },
+              "excluded": {
+                "type": "array",
+                "description": "不参与自动映射的端口，例如管理服务占用的端口",
+                "items": {
+                  "type": "integer"
+                }
+              }
+            }
+          },
+          "port_status": {
+            "type": "object",
+            "properties": {
+              "total_ports": {
+                "type": "integer"
+              },
+              "active_ports": {
+                "type": "integer"
+              },
+              "inactive_ports": {
+                "type": "integer"
+              },
+              "active_ports_list": {
+                "type": "array",
+                "items": {
+                  "type": "integer"
+                }
+              },
+              "inactive_ports_list": {
+                "type": "array",
+                "items": {
+                  "type": "integer"
+                }
+              }
+            }
+          },
+          "upnp_mappings": {
+            "type": "object",
+            "properties": {
+              "total_mappings": {
+                "type": "integer"
+              },
+              "active_mappings": {
+                "type": "array",
+                "items": {
+                  "type": "integer"
+                }
+              },
+              "mappings": {
+                "type": "object",
+                "additionalProperties": {
+                  "$ref": "#/components/schemas/PortMapping"
+                }
+              },
+              "stats": {
+                "type": "object",
+                "description": "按端口号索引的自动映射尝试计数",
+                "additionalProperties": {
+                  "type": "object"
+                }
+              }
+            }
+          },
+          "manual_mappings": {
+            "type": "object",
+            "properties": {
+              "total_mappings": {
+                "type": "integer"
+              },
+              "active_mappings": {
+                "type": "integer"
+              },
+              "inactive_mappings": {
+                "type": "integer"
+              },
+              "mappings": {
+                "type": "array",
+                "items": {
+                  "$ref": "#/components/schemas/ManualMapping"
+                }
+              },
+              "active_mappings_list": {
+                "type": "array",
+                "items": {
+                  "$ref": "#/components/schemas/ManualMapping"
+                }
+              },
+              "inactive_mappings_list": {
+                "type": "array",
+                "items": {
+                  "$ref": "#/components/schemas/ManualMapping"
+                }
+              }
+            }
+          },
+          "upnp_status": {
+            "type": "object",
+            "properties": {
+              "client_count": {
+                "type": "integer"
+              },
+              "available": {
+                "type": "boolean"
+              },
+              "discovered": {
+                "type": "boolean"
+              },
+              "gateway_policy": {
+                "type": "string",
+                "enum": [
+                  "first_healthy",
+                  "round_robin",
+                  "weighted"
+                ]
+              },
+              "gateways": {
+                "type": "array",
+                "description": "各网关上的映射数量，gateway为空的条目是没有记录网关的映射",
+                "items": {
+                  "$ref": "#/components/schemas/GatewayUsage"
+                }
+              }
+            }
+          },
+          "ipv6_pinholes": {
+            "type": "object",
+            "properties": {
+              "enabled": {
+                "type": "boolean"
+              },
+              "available": {
+                "type": "boolean"
+              },
+              "total_pinholes": {
+                "type": "integer"
+              },
+              "pinholes": {
+                "type": "object",
+                "additionalProperties": {
+                  "type": "object"
+                }
+              }
+            }
+          },
+          "pending_removals": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/PendingRemoval"
+            }
+          },
+          "external_ip": {
+            "$ref": "#/components/schemas/ExternalIP"
+          },
+          "wan": {
+            "$ref": "#/components/schemas/WANStatus"
+          },
+          "ddns": {
+            "$ref": "#/components/schemas/DDNSStatus"
+          },
+          "mdns": {
+            "$ref": "#/components/schemas/MDNSStatus"
+          },
+          "influxdb": {
+            "$ref": "#/components/schemas/InfluxDBStatus"
+          },
+          "notifications": {
+            "$ref": "#/components/schemas/NotificationStatus"
+          },
+          "subsystems": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/SubsystemStatus"
+            }
+          },
+          "config": {
+            "type": "object",
+            "additionalProperties": true
+          },
+          "admin_service": {
+            "type": "object",
+            "properties": {
+              "enabled": {
+                "type": "boolean"
+              },
+              "host": {
+                "type": "string"
+              },
+              "port": {
+                "type": "integer"
+              },
+              "url": {
+                "type": "string"
+              }
+            }
+          }
+        }
+      }
+    }
+  }
+}
+`
+
+// apiDocsPage 基于Swagger UI的API文档页面，Swagger UI的静态资源从CDN加载
+const apiDocsPage = `<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Auto UPnP API 文档</title>
+    <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+    <div id="swagger-ui"></div>
+    <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+    <script>
+        window.onload = function () {
+            // 修改类请求需要携带CSRF令牌
+            const csrfToken = fetch('/api/csrf-token').then(r => r.json()).then(d => d.token);
+            window.ui = SwaggerUIBundle({
+                url: '/api/openapi.json',
+                dom_id: '#swagger-ui',
+                requestInterceptor: async function (req) {
+                    req.headers['X-CSRF-Token'] = await csrfToken;
+                    return req;
+                }
+            });
+        };
+    </script>
+</body>
+</html>
+`
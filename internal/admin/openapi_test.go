@@ -0,0 +1,92 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"auto-upnp/config"
+	"auto-upnp/internal/service"
+	"auto-upnp/internal/upnp"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestHandleOpenAPI_CoversRegisteredRoutes(t *testing.T) {
+	as := NewAdminServer(&config.Config{}, logrus.New(), nil)
+	rec := httptest.NewRecorder()
+	as.handleOpenAPI(rec, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+
+	var spec struct {
+		OpenAPI string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("OpenAPI文档不是合法的JSON: %v", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Errorf("openapi版本 = %q，期望3.x", spec.OpenAPI)
+	}
+
+	// 从Start中注册的路由检查文档是否同步更新
+	source, err := os.ReadFile("admin.go")
+	if err != nil {
+		t.Fatalf("读取路由注册代码失败: %v", err)
+	}
+	routes := regexp.MustCompile(`mux\.HandleFunc\("([^"]+)"`).FindAllStringSubmatch(string(source), -1)
+	if len(routes) == 0 {
+		t.Fatal("没有找到注册的路由")
+	}
+	for _, route := range routes {
+		path := route[1]
+		switch path {
+		case "/", "/api/docs", "/api/openapi.json":
+			// 管理页面和文档本身不在文档中描述
+			continue
+		}
+		if _, exists := spec.Paths[path]; exists {
+			continue
+		}
+		// 带路径参数的路由以 /api/xxx/ 注册，文档中以 /api/xxx/{...} 描述
+		documented := false
+		for specPath := range spec.Paths {
+			if strings.HasSuffix(path, "/") && strings.HasPrefix(specPath, path) {
+				documented = true
+				break
+			}
+		}
+		if !documented {
+			t.Errorf("路由 %s 没有写入OpenAPI文档", path)
+		}
+	}
+}
+
+func TestHandleMappingsAndPorts_UseStableJSONFields(t *testing.T) {
+	cfg := &config.Config{Admin: config.AdminConfig{DataDir: t.TempDir()}}
+	as := NewAdminServer(cfg, logrus.New(), service.NewAutoUPnPService(cfg, logrus.New()))
+
+	// 没有端口时返回空数组而不是null
+	rec := httptest.NewRecorder()
+	as.handlePorts(rec, httptest.NewRequest(http.MethodGet, "/api/ports", nil))
+	body := rec.Body.String()
+	for _, want := range []string{`"active_ports":[]`, `"inactive_ports":[]`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("端口响应缺少 %s: %s", want, body)
+		}
+	}
+
+	// 映射字段使用snake_case
+	data, err := json.Marshal(newPortMappingResponse(&upnp.PortMapping{InternalPort: 8080, ExternalPort: 80, Protocol: "TCP"}, "", ""))
+	if err != nil {
+		t.Fatalf("序列化映射失败: %v", err)
+	}
+	for _, want := range []string{`"internal_port":8080`, `"external_port":80`, `"protocol":"TCP"`, `"active":true`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("映射响应缺少 %s: %s", want, data)
+		}
+	}
+}
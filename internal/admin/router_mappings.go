@@ -0,0 +1,84 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"auto-upnp/internal/service"
+)
+
+// handleRouterMappings 列出路由器上的全部端口映射，包括其他程序创建的映射
+func (as *AdminServer) handleRouterMappings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		as.writeJSONResponse(w, http.StatusMethodNotAllowed, "方法不允许", nil)
+		return
+	}
+
+	entries, err := as.autoService.ListRouterMappings()
+	if err != nil {
+		as.logger.WithError(err).Warn("获取路由器映射表失败")
+		as.writeJSONResponse(w, http.StatusServiceUnavailable, fmt.Sprintf("获取路由器映射表失败: %v", err), nil)
+		return
+	}
+
+	data := &RouterMappingsResponse{Mappings: entries, Total: len(entries)}
+	for _, entry := range entries {
+		if entry.Managed {
+			data.Managed++
+		}
+	}
+	as.writeJSONResponse(w, http.StatusOK, "获取路由器映射表成功", data)
+}
+
+// handleAdoptRouterMapping 将路由器上其他程序创建的映射接管为手动映射
+func (as *AdminServer) handleAdoptRouterMapping(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		as.writeJSONResponse(w, http.StatusMethodNotAllowed, "方法不允许", nil)
+		return
+	}
+
+	var req AdoptMappingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		as.writeJSONResponse(w, http.StatusBadRequest, "JSON格式错误", nil)
+		return
+	}
+	defer r.Body.Close()
+
+	if req.ExternalPort <= 0 || req.ExternalPort > 65535 {
+		as.writeJSONResponse(w, http.StatusBadRequest, "外部端口格式错误", nil)
+		return
+	}
+	protocol, err := service.NormalizeProtocol(req.Protocol)
+	if err != nil {
+		as.writeJSONResponse(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	req.Protocol = protocol
+
+	result, err := as.autoService.AdoptRouterMapping(req.ExternalPort, req.Protocol)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrRouterMappingNotFound):
+			as.writeJSONResponse(w, http.StatusNotFound, err.Error(), nil)
+		case errors.Is(err, service.ErrRouterMappingManaged):
+			as.writeJSONResponse(w, http.StatusConflict, err.Error(), nil)
+		case errors.Is(err, service.ErrRouterMappingForeign), errors.Is(err, service.ErrRouterMappingInRange),
+			errors.Is(err, service.ErrInvalidMappingPort):
+			as.writeJSONResponse(w, http.StatusBadRequest, err.Error(), nil)
+		default:
+			if conflict, ok := service.IsMappingConflict(err); ok {
+				as.writeJSONResponse(w, http.StatusConflict, err.Error(), map[string]interface{}{
+					"conflict": conflict,
+				})
+				return
+			}
+			as.logger.WithError(err).Error("接管路由器映射失败")
+			as.writeJSONResponse(w, http.StatusInternalServerError, fmt.Sprintf("接管映射失败: %v", err), nil)
+		}
+		return
+	}
+
+	as.writeJSONResponse(w, http.StatusOK, "映射接管成功", result)
+}
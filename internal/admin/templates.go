@@ -6,6 +6,7 @@ const adminHTML = `<!DOCTYPE html>
 <head>
     <meta charset="UTF-8">
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta name="csrf-token" content="{{.CSRFToken}}">
     <title>{{.Title}}</title>
     <style>
         * {
@@ -182,6 +183,32 @@ const adminHTML = `<!DOCTYPE html>
             color: #1976d2;
         }
         
+        .status-badge.disabled {
+            background: #eeeeee;
+            color: #757575;
+        }
+        
+        .status-badge.reserved {
+            background: #fff8e1;
+            color: #f57f17;
+        }
+        
+        .status-badge.simulated {
+            background: #f3e5f5;
+            color: #7b1fa2;
+        }
+        
+        .group-row {
+            background: #f5f7fa;
+            cursor: pointer;
+            font-weight: 500;
+        }
+        
+        .group-row .btn {
+            padding: 4px 12px;
+            font-size: 12px;
+        }
+        
         .btn {
             background: #4facfe;
             color: white;
@@ -206,6 +233,72 @@ const adminHTML = `<!DOCTYPE html>
             background: #ff5252;
         }
         
+        .btn-secondary {
+            background: #9e9e9e;
+        }
+        
+        .btn-secondary:hover {
+            background: #757575;
+        }
+        
+        .modal {
+            display: none;
+            position: fixed;
+            top: 0;
+            left: 0;
+            width: 100%;
+            height: 100%;
+            background: rgba(0, 0, 0, 0.4);
+            align-items: center;
+            justify-content: center;
+            z-index: 1000;
+        }
+        
+        .modal.show {
+            display: flex;
+        }
+        
+        .modal-content {
+            background: white;
+            padding: 25px;
+            border-radius: 10px;
+            max-width: 480px;
+            width: 90%;
+        }
+        
+        .modal-content h3 {
+            margin-bottom: 15px;
+            color: #333;
+        }
+        
+        .modal-actions {
+            display: flex;
+            gap: 10px;
+            justify-content: flex-end;
+            margin-top: 20px;
+        }
+        
+        .mapping-link {
+            display: block;
+            font-size: 12px;
+            color: #667eea;
+        }
+        
+        .note-input {
+            width: 100%;
+            padding: 6px 8px;
+            border: 1px solid transparent;
+            border-radius: 4px;
+            background: transparent;
+            font-size: 14px;
+        }
+        
+        .note-input:hover,
+        .note-input:focus {
+            border-color: #ddd;
+            background: white;
+        }
+        
         .form-group {
             margin-bottom: 20px;
         }
@@ -268,6 +361,28 @@ const adminHTML = `<!DOCTYPE html>
             color: #666;
         }
         
+        .port-item.refused {
+            background: #ffebee;
+            color: #c62828;
+            border-color: #ef9a9a;
+        }
+        
+        .port-item.filtered {
+            background: #fff3e0;
+            color: #e65100;
+            border-color: #ffcc80;
+        }
+        
+        .stats-failing {
+            color: #c62828;
+            cursor: help;
+        }
+        
+        .stats-flapping {
+            color: #e65100;
+            cursor: help;
+        }
+        
         .loading {
             text-align: center;
             padding: 20px;
@@ -341,6 +456,7 @@ const adminHTML = `<!DOCTYPE html>
                 <div class="status-grid" id="statusGrid">
                     <div class="loading">加载中...</div>
                 </div>
+                <button class="btn" id="rediscoverBtn" onclick="rediscoverUPnP()">重新发现UPnP设备</button>
             </div>
             
             <!-- 手动映射管理 -->
@@ -390,23 +506,83 @@ const adminHTML = `<!DOCTYPE html>
                                 <option value="UDP">UDP</option>
                             </select>
                         </div>
+                        <div class="form-group">
+                            <label for="backupExternalPort">备用外部端口</label>
+                            <input type="number" id="backupExternalPort" name="backup_external_port" min="1" max="65535" placeholder="可选">
+                        </div>
                         <div class="form-group">
                             <label for="description">描述</label>
                             <input type="text" id="description" name="description" placeholder="可选">
                         </div>
+                        <div class="form-group">
+                            <label for="group">分组</label>
+                            <input type="text" id="group" name="group" maxlength="64" placeholder="可选">
+                        </div>
+                        <div class="form-group">
+                            <label for="mdnsType">局域网广播类型</label>
+                            <input type="text" id="mdnsType" name="mdns_type" placeholder="可选，如 _http._tcp">
+                        </div>
+                        <div class="form-group">
+                            <label for="mdnsName">局域网广播名称</label>
+                            <input type="text" id="mdnsName" name="mdns_name" maxlength="63" placeholder="可选，默认使用描述">
+                        </div>
+                        <div class="form-group">
+                            <label for="priority">优先级</label>
+                            <input type="number" id="priority" name="priority" min="1" max="1000" placeholder="可选，默认100">
+                        </div>
+                        <div class="form-group">
+                            <label for="scheme">访问协议</label>
+                            <input type="text" id="scheme" name="scheme" list="schemeOptions" maxlength="32" placeholder="可选，默认按端口推断">
+                            <datalist id="schemeOptions">
+                                <option value="http">
+                                <option value="https">
+                                <option value="ssh">
+                                <option value="rdp">
+                            </datalist>
+                        </div>
+                        <div class="form-group">
+                            <label for="remoteHost">来源地址</label>
+                            <input type="text" id="remoteHost" name="remote_host" maxlength="15" placeholder="可选，只允许该IPv4地址访问">
+                        </div>
+                        <div class="form-group">
+                            <label for="reserved">预留外部端口</label>
+                            <select id="reserved" name="reserved">
+                                <option value="">否，等待本地端口上线后注册</option>
+                                <option value="true">是，立即在路由器上占用</option>
+                            </select>
+                        </div>
                     </div>
                     <button type="submit" class="btn">添加映射</button>
                 </form>
             </div>
+            
+            <!-- 外部端口冲突处理对话框 -->
+            <div class="modal" id="conflictDialog">
+                <div class="modal-content">
+                    <h3>外部端口冲突</h3>
+                    <p id="conflictText"></p>
+                    <div class="modal-actions">
+                        <button class="btn btn-danger" id="conflictReplaceBtn">替换</button>
+                        <button class="btn" id="conflictPickBtn">更换端口</button>
+                        <button class="btn btn-secondary" onclick="closeConflictDialog()">取消</button>
+                    </div>
+                </div>
+            </div>
         </div>
     </div>
 
     <script>
         // 全局变量
         let refreshInterval;
+        // 公网IP，用于生成映射的访问链接
+        let externalIP = '';
+        const collapsedGroups = new Set();
+        // 修改类请求需要携带CSRF令牌
+        const csrfToken = document.querySelector('meta[name="csrf-token"]').content;
         
         // 页面加载完成后初始化
         document.addEventListener('DOMContentLoaded', function() {
+            loadCapabilities();
             loadStatus();
             loadManualMappings();
             loadMappings();
@@ -424,6 +600,40 @@ const adminHTML = `<!DOCTYPE html>
             document.getElementById('addMappingForm').addEventListener('submit', handleAddMapping);
         });
         
+        // 加载实例能力，按能力显示或隐藏添加映射表单中的控件
+        async function loadCapabilities() {
+            try {
+                const response = await fetch('/api/capabilities');
+                if (!response.ok) {
+                    throw new Error('HTTP ' + response.status + ': ' + response.statusText);
+                }
+                const result = await response.json();
+                applyCapabilities(result.data || {});
+            } catch (error) {
+                // 获取失败时保留默认的表单控件
+                console.error('加载实例能力失败:', error);
+            }
+        }
+        
+        function applyCapabilities(caps) {
+            const features = caps.features || {};
+            const setVisible = function(id, visible) {
+                document.getElementById(id).closest('.form-group').style.display = visible ? '' : 'none';
+            };
+            setVisible('mdnsType', !!features.mdns);
+            setVisible('mdnsName', !!features.mdns);
+            setVisible('reserved', !!features.reserved_mappings);
+            setVisible('group', !!features.mapping_groups);
+            
+            // 协议选项使用UPnP提供方支持的协议
+            const upnp = (caps.providers || []).find(provider => provider.provider === 'upnp');
+            if (upnp && upnp.protocols && upnp.protocols.length > 0) {
+                const select = document.getElementById('protocol');
+                select.innerHTML = upnp.protocols.map(protocol =>
+                    '<option value="' + escapeHTML(protocol) + '">' + escapeHTML(protocol) + '</option>').join('');
+            }
+        }
+        
         // 加载服务状态
         async function loadStatus() {
             try {
@@ -438,6 +648,7 @@ const adminHTML = `<!DOCTYPE html>
                 }
                 
                 const data = await response.json();
+                externalIP = data.external_ip ? data.external_ip.ip : '';
                 
                 const statusGrid = document.getElementById('statusGrid');
                 statusGrid.innerHTML = 
@@ -454,12 +665,20 @@ const adminHTML = `<!DOCTYPE html>
                         '<div class="value">' + (data.manual_mappings?.total_mappings || 0) + '</div>' +
                     '</div>' +
                     '<div class="status-card">' +
-                        '<h3>UPnP状态</h3>' +
-                        '<div class="value">' + (data.upnp_status?.available ? '可用' : '不可用') + '</div>' +
+                        '<h3>UPnP状态' + (data.upnp_status?.available && data.wan?.address ? ' (WAN IP)' : '') + (data.dry_run ? ' (模拟模式)' : '') + '</h3>' +
+                        '<div class="value">' + formatUPnPStatus(data) + '</div>' +
                     '</div>' +
                     '<div class="status-card">' +
                         '<h3>UPnP客户端</h3>' +
                         '<div class="value">' + (data.upnp_status?.client_count || 0) + '</div>' +
+                    '</div>' +
+                    '<div class="status-card">' +
+                        '<h3>子系统</h3>' +
+                        '<div class="value">' + formatSubsystems(data.subsystems) + '</div>' +
+                    '</div>' +
+                    '<div class="status-card">' +
+                        '<h3>公网IP' + (data.external_ip ? ' (' + escapeHTML(data.external_ip.source) + ')' : '') + '</h3>' +
+                        '<div class="value">' + (data.external_ip ? escapeHTML(data.external_ip.ip) : '-') + '</div>' +
                     '</div>';
             } catch (error) {
                 console.error('加载状态失败:', error);
@@ -469,6 +688,27 @@ const adminHTML = `<!DOCTYPE html>
             }
         }
         
+        // UPnP可用时显示路由器报告的WAN口地址
+        function formatUPnPStatus(data) {
+            if (!data.upnp_status?.available) {
+                return '不可用';
+            }
+            return data.wan?.address ? escapeHTML(data.wan.address) : '可用';
+        }
+        
+        // 汇总子系统存活状态，列出心跳超时的子系统
+        function formatSubsystems(subsystems) {
+            if (!subsystems || subsystems.length === 0) {
+                return '-';
+            }
+            const unhealthy = subsystems.filter(s => !s.healthy);
+            if (unhealthy.length === 0) {
+                return '正常';
+            }
+            return '<span title="' + escapeHTML(unhealthy.map(s => s.name + ': ' + (s.error || '')).join('\n')) + '" style="color: #c62828">' +
+                unhealthy.length + '个异常</span>';
+        }
+        
         // 加载手动映射
         async function loadManualMappings() {
             try {
@@ -503,6 +743,11 @@ const adminHTML = `<!DOCTYPE html>
                 // 更新映射表格
                 const mappingsTable = document.getElementById('manualMappingsTable');
                 
+                // 正在编辑备注时不刷新表格，避免覆盖输入内容
+                if (mappingsTable.contains(document.activeElement) && document.activeElement.classList.contains('note-input')) {
+                    return;
+                }
+                
                 if (!data.all_mappings || data.all_mappings.length === 0) {
                     mappingsTable.innerHTML = '<p>暂无手动映射</p>';
                     return;
@@ -516,31 +761,43 @@ const adminHTML = `<!DOCTYPE html>
                                 '<th>外部端口</th>' +
                                 '<th>协议</th>' +
                                 '<th>描述</th>' +
+                                '<th>备注</th>' +
                                 '<th>激活状态</th>' +
+                                '<th>失败/尝试</th>' +
                                 '<th>创建时间</th>' +
                                 '<th>操作</th>' +
                             '</tr>' +
                         '</thead>' +
                         '<tbody>';
                 
+                // 未分组的映射在前，分组映射按组名排列并可折叠
+                const groups = {};
                 data.all_mappings.forEach(mapping => {
-                    const statusClass = mapping.active ? 'active' : 'inactive';
-                    const statusText = mapping.active ? '活跃' : '非活跃';
+                    if (!mapping.group) {
+                        tableHTML += renderManualMappingRow(mapping, '');
+                        return;
+                    }
+                    (groups[mapping.group] = groups[mapping.group] || []).push(mapping);
+                });
+                
+                Object.keys(groups).sort().forEach(name => {
+                    const members = groups[name];
+                    const enabled = members.filter(m => !m.disabled).length;
+                    const active = members.filter(m => m.active).length;
+                    const collapsed = collapsedGroups.has(name);
                     
                     tableHTML += 
-                        '<tr>' +
-                            '<td>' + (mapping.internal_port || '-') + '</td>' +
-                            '<td>' + (mapping.external_port || '-') + '</td>' +
-                            '<td>' + (mapping.protocol || '-') + '</td>' +
-                            '<td>' + (mapping.description || '-') + '</td>' +
-                            '<td><span class="status-badge ' + statusClass + '">' + statusText + '</span></td>' +
-                            '<td>' + (mapping.created_at || '-') + '</td>' +
+                        '<tr class="group-row" data-group="' + escapeHTML(name) + '" onclick="toggleGroup(this.dataset.group)">' +
+                            '<td colspan="8">' + (collapsed ? '▸ ' : '▾ ') + escapeHTML(name) +
+                                ' (' + members.length + '个映射，' + enabled + '个启用，' + active + '个活跃)</td>' +
                             '<td>' +
-                                '<button class="btn btn-danger" onclick="removeMapping(' + (mapping.internal_port || 0) + ', ' + (mapping.external_port || 0) + ', \'' + (mapping.protocol || 'TCP') + '\')">' +
-                                    '删除' +
-                                '</button>' +
+                                '<button class="btn" onclick="event.stopPropagation(); setGroupEnabled(this.closest(\'tr\').dataset.group, true)">启用</button> ' +
+                                '<button class="btn btn-secondary" onclick="event.stopPropagation(); setGroupEnabled(this.closest(\'tr\').dataset.group, false)">停用</button>' +
                             '</td>' +
                         '</tr>';
+                    members.forEach(mapping => {
+                        tableHTML += renderManualMappingRow(mapping, collapsed ? ' style="display:none"' : '');
+                    });
                 });
                 
                 tableHTML += '</tbody></table>';
@@ -553,6 +810,118 @@ const adminHTML = `<!DOCTYPE html>
             }
         }
         
+        // 生成手动映射表格行
+        function renderManualMappingRow(mapping, attrs) {
+            let statusClass = mapping.active ? 'active' : 'inactive';
+            let statusText = mapping.active ? '活跃' : '非活跃';
+            let statusTitle = '';
+            if (!mapping.active && mapping.health && !mapping.health.healthy) {
+                statusText = '健康检查失败';
+                statusTitle = mapping.health.error || '';
+            }
+            if (!mapping.active && mapping.reserved) {
+                statusClass = 'reserved';
+                statusText = '已预留';
+            }
+            if (mapping.disabled) {
+                statusClass = 'disabled';
+                statusText = '已停用';
+            }
+            
+            return '<tr' + attrs + '>' +
+                    '<td>' + (mapping.internal_port || '-') + '</td>' +
+                    '<td>' + formatExternalPort(mapping) + formatRemoteHost(mapping.remote_host) + formatMappingLink(mapping.scheme, mapping.live_external_port || mapping.external_port) + '</td>' +
+                    '<td>' + (mapping.protocol || '-') + '</td>' +
+                    '<td>' + (mapping.description || '-') + '</td>' +
+                    '<td><input type="text" class="note-input" value="' + escapeHTML(mapping.note || '') + '" placeholder="添加备注" ' +
+                        'onchange="updateMappingNote(\'' + mapping.internal_port + ':' + mapping.external_port + ':' + mapping.protocol + '\', this.value)"></td>' +
+                    '<td><span class="status-badge ' + statusClass + '" title="' + escapeHTML(statusTitle) + '">' + statusText + '</span></td>' +
+                    '<td>' + formatMappingStats(mapping) + '</td>' +
+                    '<td>' + (mapping.created_at || '-') + '</td>' +
+                    '<td>' +
+                        '<button class="btn btn-danger" onclick="removeMapping(' + (mapping.internal_port || 0) + ', ' + (mapping.external_port || 0) + ', \'' + (mapping.protocol || 'TCP') + '\')">' +
+                            '删除' +
+                        '</button>' +
+                    '</td>' +
+                '</tr>';
+        }
+        
+        // 显示映射的失败次数和尝试次数，悬停查看最近一次错误
+        function formatMappingStats(mapping) {
+            const attempts = mapping.attempt_count || 0;
+            const failures = mapping.failure_count || 0;
+            if (attempts === 0) {
+                return '-';
+            }
+            const text = failures + '/' + attempts;
+            if (!mapping.last_error) {
+                return text;
+            }
+            const title = escapeHTML(mapping.last_error + (mapping.last_error_at ? ' (' + mapping.last_error_at + ')' : ''));
+            const cls = failures === attempts ? 'stats-failing' : 'stats-flapping';
+            return '<span class="' + cls + '" title="' + title + '">' + text + '</span>';
+        }
+        
+        // 折叠或展开映射分组
+        function toggleGroup(name) {
+            if (collapsedGroups.has(name)) {
+                collapsedGroups.delete(name);
+            } else {
+                collapsedGroups.add(name);
+            }
+            loadManualMappings();
+        }
+        
+        // 启用或停用映射分组
+        async function setGroupEnabled(name, enabled) {
+            try {
+                const response = await fetch('/api/groups/' + encodeURIComponent(name) + (enabled ? '/enable' : '/disable'), {
+                    method: 'POST',
+                    headers: {
+                        'X-CSRF-Token': csrfToken
+                    }
+                });
+                
+                const result = await response.json();
+                
+                if (response.status === 200) {
+                    showMessage(result.message || '映射分组操作成功', 'success');
+                } else {
+                    showMessage(result.message || '映射分组操作失败', 'error');
+                }
+                loadManualMappings();
+            } catch (error) {
+                console.error('映射分组操作失败:', error);
+                showMessage('网络错误: ' + error.message, 'error');
+            }
+        }
+        
+        // 格式化手动映射的外部端口，备用端口生效时显示切换原因
+        function formatExternalPort(mapping) {
+            let text = String(mapping.external_port || '-');
+            if (mapping.live_external_port && mapping.live_external_port !== mapping.external_port) {
+                text = mapping.live_external_port + ' (备用，' + (mapping.switch_reason || '已切换') + ')';
+            } else if (mapping.backup_external_port) {
+                text += ' (备用: ' + mapping.backup_external_port + ')';
+            }
+            return text;
+        }
+        
+        // 显示映射限制的来源地址，不限制来源时不显示
+        function formatRemoteHost(remoteHost) {
+            return remoteHost ? ' (仅 ' + escapeHTML(remoteHost) + ')' : '';
+        }
+        
+        // 按访问协议生成映射的访问链接，没有访问协议或尚未获取公网IP时不显示
+        function formatMappingLink(scheme, port) {
+            if (!scheme || !externalIP || !port) {
+                return '';
+            }
+            const host = externalIP.includes(':') ? '[' + externalIP + ']' : externalIP;
+            const url = scheme + '://' + host + ':' + port;
+            return '<a class="mapping-link" href="' + escapeHTML(url) + '" target="_blank" rel="noopener">' + escapeHTML(url) + '</a>';
+        }
+        
         // 加载端口映射
         async function loadMappings() {
             try {
@@ -592,19 +961,26 @@ const adminHTML = `<!DOCTYPE html>
                 
                 for (const [key, mapping] of Object.entries(mappings)) {
                     if (mapping && typeof mapping === 'object') {
-                        const statusClass = mapping.Active ? 'active' : 'inactive';
-                        const statusText = mapping.Active ? '活跃' : '非活跃';
+                        let statusClass = mapping.active ? 'active' : 'inactive';
+                        let statusText = mapping.active ? '活跃' : '非活跃';
+                        if (mapping.simulated) {
+                            statusClass = 'simulated';
+                            statusText = '模拟';
+                        }
                         
                         tableHTML += 
                             '<tr>' +
-                                '<td>' + (mapping.InternalPort || '-') + '</td>' +
-                                '<td>' + (mapping.ExternalPort || '-') + '</td>' +
-                                '<td>' + (mapping.Protocol || '-') + '</td>' +
-                                '<td>' + (mapping.Description || '-') + '</td>' +
+                                '<td>' + (mapping.internal_port || '-') + '</td>' +
+                                '<td>' + (mapping.external_port || '-') + formatRemoteHost(mapping.remote_host) + formatMappingLink(mapping.scheme, mapping.external_port) + '</td>' +
+                                '<td>' + (mapping.protocol || '-') + '</td>' +
+                                '<td>' + (mapping.description || '-') + '</td>' +
                                 '<td><span class="status-badge">自动</span></td>' +
                                 '<td><span class="status-badge ' + statusClass + '">' + statusText + '</span></td>' +
                                 '<td>' +
-                                    '<button class="btn btn-danger" onclick="removeMapping(' + (mapping.InternalPort || 0) + ', ' + (mapping.ExternalPort || 0) + ', \'' + (mapping.Protocol || 'TCP') + '\')">' +
+                                    '<button class="btn btn-secondary" onclick="refreshMapping(' + (mapping.internal_port || 0) + ', ' + (mapping.external_port || 0) + ', \'' + (mapping.protocol || 'TCP') + '\')">' +
+                                        '刷新' +
+                                    '</button> ' +
+                                    '<button class="btn btn-danger" onclick="removeMapping(' + (mapping.internal_port || 0) + ', ' + (mapping.external_port || 0) + ', \'' + (mapping.protocol || 'TCP') + '\')">' +
                                         '删除' +
                                     '</button>' +
                                 '</td>' +
@@ -639,19 +1015,24 @@ const adminHTML = `<!DOCTYPE html>
                 
                 const portsStatus = document.getElementById('portsStatus');
                 
-                // 确保数据是数组类型，只获取活跃端口
-                const activePorts = Array.isArray(data.active_ports) ? data.active_ports : [];
+                // 显示非空闲的端口：监听中、拒绝连接、被过滤
+                const portStates = data.port_states || {};
+                const stateText = { listening: '监听中', refused: '拒绝连接', filtered: '被过滤' };
+                const ports = Object.keys(portStates)
+                    .filter(port => stateText[portStates[port]])
+                    .map(port => parseInt(port));
                 
-                if (activePorts.length === 0) {
+                if (ports.length === 0) {
                     portsStatus.innerHTML = '<p>暂无活跃端口</p>';
                     return;
                 }
                 
                 let portsHTML = '<div class="ports-grid">';
                 
-                // 只显示活跃端口
-                activePorts.sort((a, b) => a - b).forEach(port => {
-                    portsHTML += '<div class="port-item active">' + port + '</div>';
+                ports.sort((a, b) => a - b).forEach(port => {
+                    const state = portStates[port];
+                    const stateClass = state === 'listening' ? 'active' : state;
+                    portsHTML += '<div class="port-item ' + stateClass + '" title="' + stateText[state] + '">' + port + '</div>';
                 });
                 
                 portsHTML += '</div>';
@@ -668,12 +1049,21 @@ const adminHTML = `<!DOCTYPE html>
         async function handleAddMapping(event) {
             event.preventDefault();
             
-            const formData = new FormData(event.target);
+            const form = event.target;
+            const formData = new FormData(form);
             const requestData = {
                 internal_port: parseInt(formData.get('internal_port')),
                 external_port: parseInt(formData.get('external_port')),
                 protocol: formData.get('protocol') || 'TCP',
-                description: formData.get('description') || ''
+                description: formData.get('description') || '',
+                backup_external_port: parseInt(formData.get('backup_external_port')) || 0,
+                group: (formData.get('group') || '').trim(),
+                mdns_type: (formData.get('mdns_type') || '').trim(),
+                mdns_name: (formData.get('mdns_name') || '').trim(),
+                priority: parseInt(formData.get('priority')) || 0,
+                reserved: formData.get('reserved') === 'true',
+                scheme: (formData.get('scheme') || '').trim(),
+                remote_host: (formData.get('remote_host') || '').trim()
             };
             
             // 验证输入
@@ -687,11 +1077,17 @@ const adminHTML = `<!DOCTYPE html>
                 return;
             }
             
+            await submitMapping(requestData, form);
+        }
+        
+        // 提交添加映射请求，外部端口冲突时弹出处理对话框
+        async function submitMapping(requestData, form) {
             try {
                 const response = await fetch('/api/add-mapping', {
                     method: 'POST',
                     headers: {
-                        'Content-Type': 'application/json'
+                        'Content-Type': 'application/json',
+                        'X-CSRF-Token': csrfToken
                     },
                     body: JSON.stringify(requestData)
                 });
@@ -699,11 +1095,24 @@ const adminHTML = `<!DOCTYPE html>
                 const result = await response.json();
                 
                 if (response.ok) {
-                    showMessage('映射添加成功', 'success');
-                    event.target.reset();
+                    let message = requestData.replace ? '映射替换成功' : '映射添加成功';
+                    const added = result.data;
+                    if (added && added.status === 'waiting') {
+                        message += '，等待本地端口上线';
+                    } else if (added && added.status === 'reserved') {
+                        message += '，已预留外部端口 ' + added.external_port;
+                    } else if (added && added.external_address) {
+                        message += '，公网地址 ' + added.external_address;
+                    } else if (added && added.external_port !== requestData.external_port) {
+                        message += '，已使用备用外部端口 ' + added.external_port;
+                    }
+                    showMessage(message, 'success');
+                    form.reset();
                     loadManualMappings();
                     loadMappings();
                     loadStatus();
+                } else if (response.status === 409 && result.data && result.data.conflict) {
+                    showConflictDialog(result.data.conflict, requestData, form);
                 } else {
                     // 处理不同的错误状态
                     let errorMessage = result.message || '添加映射失败';
@@ -724,23 +1133,57 @@ const adminHTML = `<!DOCTYPE html>
             }
         }
         
+        // 显示外部端口冲突处理对话框
+        function showConflictDialog(conflict, requestData, form) {
+            const owner = (conflict.internal_client || '本机') + ':' + conflict.internal_port;
+            const sourceText = { manual: '手动映射', auto: '自动映射', router: '路由器上的其他映射' }[conflict.source] || '映射';
+            
+            document.getElementById('conflictText').textContent =
+                '外部端口 ' + conflict.external_port + '/' + conflict.protocol + ' 已被' + sourceText + ' ' + owner +
+                (conflict.description ? '（' + conflict.description + '）' : '') + ' 占用';
+            
+            const replaceBtn = document.getElementById('conflictReplaceBtn');
+            replaceBtn.style.display = conflict.replaceable ? '' : 'none';
+            replaceBtn.onclick = function() {
+                closeConflictDialog();
+                submitMapping(Object.assign({}, requestData, { replace: true }), form);
+            };
+            
+            document.getElementById('conflictPickBtn').onclick = function() {
+                closeConflictDialog();
+                const externalPortInput = document.getElementById('externalPort');
+                externalPortInput.value = '';
+                externalPortInput.focus();
+            };
+            
+            document.getElementById('conflictDialog').classList.add('show');
+        }
+        
+        // 关闭冲突处理对话框
+        function closeConflictDialog() {
+            document.getElementById('conflictDialog').classList.remove('show');
+        }
+        
         // 删除映射
-        async function removeMapping(internalPort, externalPort, protocol) {
-            if (!confirm('确定要删除这个端口映射吗？')) {
+        // force为true时路由器删除失败也删除本地记录
+        async function removeMapping(internalPort, externalPort, protocol, force) {
+            if (!force && !confirm('确定要删除这个端口映射吗？')) {
                 return;
             }
             
             const requestData = {
                 internal_port: parseInt(internalPort),
                 external_port: parseInt(externalPort),
-                protocol: protocol || 'TCP'
+                protocol: protocol || 'TCP',
+                force: !!force
             };
             
             try {
                 const response = await fetch('/api/remove-mapping', {
                     method: 'POST',
                     headers: {
-                        'Content-Type': 'application/json'
+                        'Content-Type': 'application/json',
+                        'X-CSRF-Token': csrfToken
                     },
                     body: JSON.stringify(requestData)
                 });
@@ -762,6 +1205,11 @@ const adminHTML = `<!DOCTYPE html>
                         errorMessage = result.message || '请求参数错误';
                     } else if (response.status === 500) {
                         errorMessage = result.message || '服务器内部错误';
+                    } else if (response.status === 502 && !force) {
+                        // 路由器已不可用时可以只删除本地记录
+                        if (confirm(errorMessage + '\n\n是否强制删除本地记录？路由器上的映射将在租期到期后失效。')) {
+                            return removeMapping(internalPort, externalPort, protocol, true);
+                        }
                     }
                     
                     showMessage(errorMessage, 'error');
@@ -772,6 +1220,106 @@ const adminHTML = `<!DOCTYPE html>
             }
         }
         
+        // 按本地记录重新写入路由器上的映射，路由器丢失映射时使用
+        async function refreshMapping(internalPort, externalPort, protocol) {
+            const requestData = {
+                internal_port: parseInt(internalPort),
+                external_port: parseInt(externalPort),
+                protocol: protocol || 'TCP'
+            };
+            
+            try {
+                const response = await fetch('/api/refresh-mapping', {
+                    method: 'POST',
+                    headers: {
+                        'Content-Type': 'application/json',
+                        'X-CSRF-Token': csrfToken
+                    },
+                    body: JSON.stringify(requestData)
+                });
+                
+                const result = await response.json();
+                
+                if (response.ok) {
+                    showMessage(result.message || '映射已重新写入路由器', 'success');
+                    loadMappings();
+                    loadStatus();
+                } else {
+                    showMessage(result.message || '重新写入映射失败', 'error');
+                }
+            } catch (error) {
+                console.error('重新写入映射失败:', error);
+                showMessage('网络错误: ' + error.message, 'error');
+            }
+        }
+        
+        // 更新映射备注（仅保存在本地，不修改路由器映射）
+        async function updateMappingNote(mappingId, note) {
+            try {
+                const response = await fetch('/api/mappings/' + encodeURIComponent(mappingId), {
+                    method: 'PATCH',
+                    headers: {
+                        'Content-Type': 'application/json',
+                        'X-CSRF-Token': csrfToken
+                    },
+                    body: JSON.stringify({ note: note })
+                });
+                
+                const result = await response.json();
+                
+                if (response.ok) {
+                    showMessage('备注已保存', 'success');
+                } else {
+                    showMessage(result.message || '保存备注失败', 'error');
+                }
+            } catch (error) {
+                console.error('保存备注失败:', error);
+                showMessage('网络错误: ' + error.message, 'error');
+            }
+        }
+        
+        // 转义HTML特殊字符
+        function escapeHTML(text) {
+            return String(text)
+                .replace(/&/g, '&amp;')
+                .replace(/</g, '&lt;')
+                .replace(/>/g, '&gt;')
+                .replace(/"/g, '&quot;')
+                .replace(/'/g, '&#39;');
+        }
+        
+        // 立即重新发现UPnP设备
+        async function rediscoverUPnP() {
+            const button = document.getElementById('rediscoverBtn');
+            button.disabled = true;
+            
+            try {
+                const response = await fetch('/api/rediscover', {
+                    method: 'POST',
+                    headers: {
+                        'X-CSRF-Token': csrfToken
+                    }
+                });
+                
+                const result = await response.json();
+                
+                if (response.ok) {
+                    const clientCount = result.data ? result.data.client_count : 0;
+                    showMessage('UPnP设备重新发现成功，客户端数量: ' + clientCount, 'success');
+                    loadStatus();
+                    loadMappings();
+                    loadManualMappings();
+                } else {
+                    showMessage(result.message || '重新发现UPnP设备失败', 'error');
+                }
+            } catch (error) {
+                console.error('重新发现UPnP设备失败:', error);
+                showMessage('网络错误: ' + error.message, 'error');
+            } finally {
+                button.disabled = false;
+            }
+        }
+        
         // 显示消息
         function showMessage(message, type) {
             // 移除现有的消息
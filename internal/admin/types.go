@@ -1,20 +1,60 @@
 package admin
 
+import (
+	"time"
+
+	"auto-upnp/internal/liveness"
+	"auto-upnp/internal/portmonitor"
+	"auto-upnp/internal/service"
+	"auto-upnp/internal/upnp"
+)
+
 // AddMappingRequest 添加映射请求
 type AddMappingRequest struct {
+	InternalPort       int                      `json:"internal_port"` // 本机服务监听的端口，不能在自动映射端口范围内
+	ExternalPort       int                      `json:"external_port"` // 路由器对外开放的端口，不能在自动映射端口范围内
+	Protocol           string                   `json:"protocol"`
+	Description        string                   `json:"description"`
+	BackupExternalPort int                      `json:"backup_external_port,omitempty"`
+	Replace            bool                     `json:"replace,omitempty"`
+	Group              string                   `json:"group,omitempty"`
+	MDNSType           string                   `json:"mdns_type,omitempty"`
+	MDNSName           string                   `json:"mdns_name,omitempty"`
+	Priority           int                      `json:"priority,omitempty"`
+	Idempotent         *bool                    `json:"idempotent,omitempty"`   // 为空时使用admin.idempotent_add配置
+	Reserved           bool                     `json:"reserved,omitempty"`     // 本地端口未上线时也在路由器上占用外部端口
+	HealthCheck        *portmonitor.HealthCheck `json:"health_check,omitempty"` // 应用层健康检查，检查通过时映射才生效
+	Scheme             string                   `json:"scheme,omitempty"`       // 访问协议，为空时按常见端口推断
+	RemoteHost         string                   `json:"remote_host,omitempty"`  // 只允许该IPv4地址访问，为空时不限制来源
+}
+
+// RemoveMappingRequest 删除映射请求
+type RemoveMappingRequest struct {
 	InternalPort int    `json:"internal_port"`
 	ExternalPort int    `json:"external_port"`
 	Protocol     string `json:"protocol"`
-	Description  string `json:"description"`
+	Force        bool   `json:"force,omitempty"` // 路由器删除失败时也删除本地记录，路由器已不可用时使用
 }
 
-// RemoveMappingRequest 删除映射请求
-type RemoveMappingRequest struct {
+// RefreshMappingRequest 重新写入映射请求，手动映射使用添加时的外部端口
+type RefreshMappingRequest struct {
 	InternalPort int    `json:"internal_port"`
 	ExternalPort int    `json:"external_port"`
 	Protocol     string `json:"protocol"`
 }
 
+// UpdateMappingRequest 更新映射请求
+type UpdateMappingRequest struct {
+	Note   *string `json:"note"`
+	Scheme *string `json:"scheme"` // 为空字符串时按端口重新推断
+}
+
+// CSRFTokenResponse CSRF令牌
+type CSRFTokenResponse struct {
+	Header string `json:"header"`
+	Token  string `json:"token"`
+}
+
 // APIResponse API响应
 type APIResponse struct {
 	Status  string      `json:"status"`
@@ -22,18 +62,83 @@ type APIResponse struct {
 	Data    interface{} `json:"data,omitempty"`
 }
 
-// StatusResponse 状态响应
-type StatusResponse struct {
-	ServiceStatus  map[string]interface{} `json:"service_status"`
-	PortRange      map[string]interface{} `json:"port_range"`
-	PortStatus     map[string]interface{} `json:"port_status"`
-	UPnPMappings   map[string]interface{} `json:"upnp_mappings"`
-	ManualMappings map[string]interface{} `json:"manual_mappings"`
-	Config         map[string]interface{} `json:"config"`
+// PortMappingResponse 端口映射列表中的单个映射
+type PortMappingResponse struct {
+	CorrelationID  string    `json:"correlation_id,omitempty"`
+	InternalPort   int       `json:"internal_port"`
+	ExternalPort   int       `json:"external_port"`
+	Protocol       string    `json:"protocol"`
+	InternalClient string    `json:"internal_client"`
+	Description    string    `json:"description"`
+	Scheme         string    `json:"scheme,omitempty"` // 访问协议，用于生成访问链接
+	LeaseDuration  uint32    `json:"lease_duration"`
+	CreatedAt      time.Time `json:"created_at"`
+	Adopted        bool      `json:"adopted,omitempty"`
+	Gateway        string    `json:"gateway,omitempty"`     // 映射所在网关的URL
+	RemoteHost     string    `json:"remote_host,omitempty"` // 只允许该地址访问，为空时不限制来源
+	Simulated      bool      `json:"simulated,omitempty"`   // 模拟模式下添加，没有写入路由器
+	Active         bool      `json:"active"`
+}
+
+// ManualMappingsResponse 手动映射列表响应
+type ManualMappingsResponse struct {
+	TotalMappings        int                      `json:"total_mappings"`
+	ActiveMappings       int                      `json:"active_mappings"`
+	InactiveMappings     int                      `json:"inactive_mappings"`
+	AllMappings          []*service.ManualMapping `json:"all_mappings"`
+	ActiveMappingsList   []*service.ManualMapping `json:"active_mappings_list"`
+	InactiveMappingsList []*service.ManualMapping `json:"inactive_mappings_list"`
 }
 
 // PortsResponse 端口状态响应
 type PortsResponse struct {
-	ActivePorts   []int `json:"active_ports"`
-	InactivePorts []int `json:"inactive_ports"`
+	ActivePorts   []int                         `json:"active_ports"`
+	InactivePorts []int                         `json:"inactive_ports"`
+	PortStates    map[int]portmonitor.PortState `json:"port_states"`
+}
+
+// UPnPStatusResponse UPnP状态响应
+type UPnPStatusResponse struct {
+	ClientCount int                      `json:"client_count"`
+	Available   bool                     `json:"available"`
+	Status      string                   `json:"status"`
+	Clients     []map[string]interface{} `json:"clients"`
+}
+
+// RediscoverResponse 重新发现UPnP设备响应数据
+type RediscoverResponse struct {
+	ClientCount int `json:"client_count"`
+}
+
+// ReadyzResponse 就绪探针响应
+type ReadyzResponse struct {
+	Status     string                      `json:"status"`
+	Subsystems []*liveness.SubsystemStatus `json:"subsystems"`
+}
+
+// GroupsResponse 映射分组列表响应
+type GroupsResponse struct {
+	TotalGroups int                     `json:"total_groups"`
+	Groups      []*service.MappingGroup `json:"groups"`
+}
+
+// GroupActionResponse 映射分组操作响应数据
+type GroupActionResponse struct {
+	Group   string                       `json:"group"`
+	Action  string                       `json:"action"`
+	Failed  int                          `json:"failed"`
+	Results []*service.GroupMemberResult `json:"results"`
+}
+
+// RouterMappingsResponse 路由器映射表响应数据
+type RouterMappingsResponse struct {
+	Mappings []*upnp.RouterMapping `json:"mappings"`
+	Total    int                   `json:"total"`
+	Managed  int                   `json:"managed"`
+}
+
+// AdoptMappingRequest 接管路由器映射请求
+type AdoptMappingRequest struct {
+	ExternalPort int    `json:"external_port"`
+	Protocol     string `json:"protocol"`
 }
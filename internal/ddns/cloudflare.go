@@ -0,0 +1,124 @@
+package ddns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// cloudflareAPIURL Cloudflare API地址
+const cloudflareAPIURL = "https://api.cloudflare.com/client/v4"
+
+// cloudflareProvider Cloudflare DNS服务
+type cloudflareProvider struct {
+	apiToken   string
+	zoneID     string
+	recordName string
+	client     *http.Client
+	baseURL    string
+}
+
+// cloudflareResponse Cloudflare API响应
+type cloudflareResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result json.RawMessage `json:"result"`
+}
+
+// NewCloudflareProvider 创建Cloudflare服务，记录需要事先在Cloudflare中创建
+func NewCloudflareProvider(apiToken, zoneID, recordName string, client *http.Client) (Provider, error) {
+	if apiToken == "" || zoneID == "" || recordName == "" {
+		return nil, fmt.Errorf("Cloudflare需要配置api_token、zone_id和record_name")
+	}
+	return &cloudflareProvider{
+		apiToken:   apiToken,
+		zoneID:     zoneID,
+		recordName: recordName,
+		client:     client,
+		baseURL:    cloudflareAPIURL,
+	}, nil
+}
+
+// Name 服务名称
+func (p *cloudflareProvider) Name() string {
+	return ProviderCloudflare
+}
+
+// Update 查找记录并更新记录内容，IPv4更新A记录，IPv6更新AAAA记录
+func (p *cloudflareProvider) Update(ctx context.Context, ip net.IP) error {
+	recordType := "A"
+	if ip.To4() == nil {
+		recordType = "AAAA"
+	}
+
+	recordID, err := p.findRecord(ctx, recordType)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(map[string]string{"content": ip.String()})
+	if err != nil {
+		return err
+	}
+	_, err = p.call(ctx, http.MethodPatch, fmt.Sprintf("/zones/%s/dns_records/%s", p.zoneID, recordID), payload)
+	return err
+}
+
+// findRecord 查找记录ID
+func (p *cloudflareProvider) findRecord(ctx context.Context, recordType string) (string, error) {
+	query := url.Values{}
+	query.Set("type", recordType)
+	query.Set("name", p.recordName)
+
+	result, err := p.call(ctx, http.MethodGet, fmt.Sprintf("/zones/%s/dns_records?%s", p.zoneID, query.Encode()), nil)
+	if err != nil {
+		return "", err
+	}
+
+	var records []struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(result, &records); err != nil {
+		return "", fmt.Errorf("解析Cloudflare记录失败: %w", err)
+	}
+	if len(records) == 0 {
+		return "", fmt.Errorf("Cloudflare中不存在%s记录 %s", recordType, p.recordName)
+	}
+	return records[0].ID, nil
+}
+
+// call 调用Cloudflare API并检查响应中的success字段
+func (p *cloudflareProvider) call(ctx context.Context, method, path string, payload []byte) (json.RawMessage, error) {
+	req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.apiToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	body, err := doRequest(p.client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var response cloudflareResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("解析Cloudflare响应失败: %w", err)
+	}
+	if !response.Success {
+		messages := make([]string, 0, len(response.Errors))
+		for _, e := range response.Errors {
+			messages = append(messages, fmt.Sprintf("%d %s", e.Code, e.Message))
+		}
+		return nil, fmt.Errorf("Cloudflare返回错误: %s", strings.Join(messages, "; "))
+	}
+	return response.Result, nil
+}
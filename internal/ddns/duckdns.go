@@ -0,0 +1,61 @@
+package ddns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"auto-upnp/internal/redact"
+)
+
+// duckDNSUpdateURL DuckDNS更新接口
+const duckDNSUpdateURL = "https://www.duckdns.org/update"
+
+// duckDNSProvider DuckDNS服务
+type duckDNSProvider struct {
+	token   string
+	domains []string
+	client  *http.Client
+}
+
+// NewDuckDNSProvider 创建DuckDNS服务，domains为不含.duckdns.org后缀的子域名
+func NewDuckDNSProvider(token string, domains []string, client *http.Client) (Provider, error) {
+	if token == "" || len(domains) == 0 {
+		return nil, fmt.Errorf("DuckDNS需要配置token和domains")
+	}
+	return &duckDNSProvider{token: token, domains: domains, client: client}, nil
+}
+
+// Name 服务名称
+func (p *duckDNSProvider) Name() string {
+	return ProviderDuckDNS
+}
+
+// Update 更新DuckDNS域名解析，服务返回OK表示成功
+func (p *duckDNSProvider) Update(ctx context.Context, ip net.IP) error {
+	query := url.Values{}
+	query.Set("domains", strings.Join(p.domains, ","))
+	query.Set("token", p.token)
+	if ip.To4() != nil {
+		query.Set("ip", ip.String())
+	} else {
+		query.Set("ipv6", ip.String())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, duckDNSUpdateURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return redact.Error(err)
+	}
+
+	body, err := doRequest(p.client, req)
+	if err != nil {
+		return err
+	}
+	if result := strings.TrimSpace(string(body)); !strings.HasPrefix(result, "OK") {
+		return fmt.Errorf("DuckDNS更新失败: %s", result)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package ddns
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"auto-upnp/internal/redact"
+)
+
+// 支持的DDNS服务
+const (
+	ProviderCloudflare = "cloudflare"
+	ProviderDuckDNS    = "duckdns"
+	ProviderWebhook    = "webhook"
+)
+
+// Provider DDNS服务，新增服务只需实现该接口
+type Provider interface {
+	// Name 服务名称
+	Name() string
+	// Update 把域名解析更新为指定的IP
+	Update(ctx context.Context, ip net.IP) error
+}
+
+// RateLimitError DDNS服务限流，RetryAfter之后才能再次更新
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+// Error 实现error接口
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("DDNS服务限流，%s后重试", e.RetryAfter)
+}
+
+// defaultRetryAfter 服务限流但没有返回Retry-After时的等待时间
+const defaultRetryAfter = 10 * time.Minute
+
+// doRequest 发送请求并读取响应，429时返回RateLimitError，其他非2xx状态返回错误
+// 请求失败时错误中不包含完整URL，DuckDNS等服务的令牌在查询参数中，错误会写入状态接口和日志
+func doRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, redact.Error(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, &RateLimitError{RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("服务返回错误: %s %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// parseRetryAfter 解析Retry-After头，支持秒数和HTTP日期两种格式
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return defaultRetryAfter
+}
@@ -0,0 +1,130 @@
+package ddns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Status DDNS更新状态
+type Status struct {
+	Provider      string    `json:"provider"`
+	IP            string    `json:"ip,omitempty"`              // 最近一次成功更新的IP
+	LastUpdatedAt time.Time `json:"last_updated_at,omitempty"` // 最近一次成功更新的时间
+	LastAttemptAt time.Time `json:"last_attempt_at,omitempty"`
+	LastResult    string    `json:"last_result,omitempty"` // success、error 或 rate_limited
+	LastError     string    `json:"last_error,omitempty"`
+	NextAllowedAt time.Time `json:"next_allowed_at,omitempty"` // 限流或节流结束的时间
+}
+
+// 更新结果
+const (
+	ResultSuccess     = "success"
+	ResultError       = "error"
+	ResultRateLimited = "rate_limited"
+)
+
+// Updater 在公网IP变化时更新DDNS解析，两次更新之间至少间隔minInterval
+type Updater struct {
+	provider    Provider
+	minInterval time.Duration
+	timeout     time.Duration
+	logger      *logrus.Logger
+
+	syncMutex sync.Mutex // 串行化更新，避免并发请求DDNS服务
+	mutex     sync.Mutex
+	status    Status
+}
+
+// NewUpdater 创建DDNS更新器
+func NewUpdater(provider Provider, minInterval, timeout time.Duration, logger *logrus.Logger) *Updater {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &Updater{
+		provider:    provider,
+		minInterval: minInterval,
+		timeout:     timeout,
+		logger:      logger,
+		status:      Status{Provider: provider.Name()},
+	}
+}
+
+// Status 返回当前更新状态
+func (u *Updater) Status() Status {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.status
+}
+
+// Sync 把解析同步为指定的IP，IP未变化或处于节流、限流期间时不请求DDNS服务
+// 返回是否实际发起了更新
+func (u *Updater) Sync(ctx context.Context, ip string) (bool, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false, fmt.Errorf("无效的IP地址: %q", ip)
+	}
+
+	u.syncMutex.Lock()
+	defer u.syncMutex.Unlock()
+
+	now := time.Now()
+	status := u.Status()
+	if status.IP == ip && status.LastResult == ResultSuccess {
+		return false, nil
+	}
+	if now.Before(status.NextAllowedAt) {
+		u.logger.WithFields(logrus.Fields{
+			"provider":        u.provider.Name(),
+			"ip":              ip,
+			"next_allowed_at": status.NextAllowedAt,
+		}).Debug("DDNS更新被节流，稍后重试")
+		return false, nil
+	}
+
+	// 请求DDNS服务时不持有状态锁，避免查询状态被阻塞
+	updateCtx, cancel := context.WithTimeout(ctx, u.timeout)
+	err := u.provider.Update(updateCtx, parsed)
+	cancel()
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	u.status.LastAttemptAt = now
+	var rateLimit *RateLimitError
+	switch {
+	case err == nil:
+		u.status.IP = ip
+		u.status.LastUpdatedAt = now
+		u.status.LastResult = ResultSuccess
+		u.status.LastError = ""
+		u.status.NextAllowedAt = now.Add(u.minInterval)
+		u.logger.WithFields(logrus.Fields{
+			"provider":    u.provider.Name(),
+			"ip":          ip,
+			"previous_ip": status.IP,
+		}).Info("DDNS解析已更新")
+		return true, nil
+	case errors.As(err, &rateLimit):
+		u.status.LastResult = ResultRateLimited
+		u.status.LastError = err.Error()
+		u.status.NextAllowedAt = now.Add(rateLimit.RetryAfter)
+	default:
+		u.status.LastResult = ResultError
+		u.status.LastError = err.Error()
+		u.status.NextAllowedAt = now.Add(u.minInterval)
+	}
+
+	u.logger.WithFields(logrus.Fields{
+		"provider":        u.provider.Name(),
+		"ip":              ip,
+		"next_allowed_at": u.status.NextAllowedAt,
+		"error":           err,
+	}).Warn("DDNS更新失败")
+	return true, fmt.Errorf("更新DDNS失败: %w", err)
+}
@@ -0,0 +1,138 @@
+package ddns
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fakeProvider 记录调用次数并按顺序返回预设结果的服务
+type fakeProvider struct {
+	results []error
+	calls   []string
+}
+
+func (p *fakeProvider) Name() string { return "fake" }
+
+func (p *fakeProvider) Update(ctx context.Context, ip net.IP) error {
+	p.calls = append(p.calls, ip.String())
+	if len(p.results) == 0 {
+		return nil
+	}
+	err := p.results[0]
+	p.results = p.results[1:]
+	return err
+}
+
+func newTestUpdater(provider Provider, minInterval time.Duration) *Updater {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return NewUpdater(provider, minInterval, time.Second, logger)
+}
+
+func TestUpdater_SkipsUnchangedIP(t *testing.T) {
+	provider := &fakeProvider{}
+	updater := newTestUpdater(provider, 0)
+
+	for i := 0; i < 3; i++ {
+		if _, err := updater.Sync(context.Background(), "203.0.113.7"); err != nil {
+			t.Fatalf("更新失败: %v", err)
+		}
+	}
+	if len(provider.calls) != 1 {
+		t.Fatalf("IP未变化时不应重复更新，实际调用%d次", len(provider.calls))
+	}
+
+	status := updater.Status()
+	if status.IP != "203.0.113.7" || status.LastResult != ResultSuccess || status.LastUpdatedAt.IsZero() {
+		t.Errorf("状态错误: %+v", status)
+	}
+}
+
+func TestUpdater_ThrottlesChanges(t *testing.T) {
+	provider := &fakeProvider{}
+	updater := newTestUpdater(provider, time.Hour)
+
+	updater.Sync(context.Background(), "203.0.113.7")
+	updated, err := updater.Sync(context.Background(), "203.0.113.8")
+	if err != nil || updated {
+		t.Fatalf("节流期间不应更新: updated=%v err=%v", updated, err)
+	}
+	if len(provider.calls) != 1 {
+		t.Fatalf("节流期间调用了服务: %v", provider.calls)
+	}
+}
+
+func TestUpdater_HonorsRateLimit(t *testing.T) {
+	provider := &fakeProvider{results: []error{&RateLimitError{RetryAfter: time.Hour}}}
+	updater := newTestUpdater(provider, 0)
+
+	if _, err := updater.Sync(context.Background(), "203.0.113.7"); err == nil {
+		t.Fatal("限流时应当返回错误")
+	}
+	status := updater.Status()
+	if status.LastResult != ResultRateLimited || time.Until(status.NextAllowedAt) < 59*time.Minute {
+		t.Fatalf("限流状态错误: %+v", status)
+	}
+
+	if updated, _ := updater.Sync(context.Background(), "203.0.113.7"); updated {
+		t.Fatal("限流期间不应再次请求服务")
+	}
+}
+
+func TestUpdater_RetriesAfterError(t *testing.T) {
+	provider := &fakeProvider{results: []error{errors.New("网络错误")}}
+	updater := newTestUpdater(provider, 0)
+
+	if _, err := updater.Sync(context.Background(), "203.0.113.7"); err == nil {
+		t.Fatal("服务失败时应当返回错误")
+	}
+	if _, err := updater.Sync(context.Background(), "203.0.113.7"); err != nil {
+		t.Fatalf("重试应当成功: %v", err)
+	}
+	if len(provider.calls) != 2 {
+		t.Fatalf("失败后应当重试，实际调用%d次", len(provider.calls))
+	}
+}
+
+// failingTransport 模拟网络故障的传输层
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestUpdater_DoesNotLeakDuckDNSToken(t *testing.T) {
+	const token = "0a1b2c3d-secret-token"
+	provider, err := NewDuckDNSProvider(token, []string{"home"}, &http.Client{Transport: failingTransport{}})
+	if err != nil {
+		t.Fatalf("创建DuckDNS服务失败: %v", err)
+	}
+
+	var logs bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&logs)
+	updater := NewUpdater(provider, 0, time.Second, logger)
+
+	_, err = updater.Sync(context.Background(), "203.0.113.7")
+	if err == nil {
+		t.Fatal("网络故障时应当返回错误")
+	}
+	if strings.Contains(err.Error(), token) {
+		t.Errorf("返回的错误包含令牌: %v", err)
+	}
+	if lastError := updater.Status().LastError; lastError == "" || strings.Contains(lastError, token) {
+		t.Errorf("LastError应记录错误且不包含令牌: %q", lastError)
+	}
+	if strings.Contains(logs.String(), token) {
+		t.Errorf("日志包含令牌:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,48 @@
+package ddns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"auto-upnp/internal/redact"
+)
+
+// webhookIPPlaceholder 回调地址中的IP占位符
+const webhookIPPlaceholder = "{ip}"
+
+// webhookProvider 通过GET请求回调地址更新解析，适用于提供更新URL的DDNS服务
+type webhookProvider struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookProvider 创建回调服务，地址中的{ip}会被替换为新的公网IP
+func NewWebhookProvider(rawURL string, client *http.Client) (Provider, error) {
+	if rawURL == "" {
+		return nil, fmt.Errorf("webhook需要配置url")
+	}
+	if _, err := url.Parse(strings.ReplaceAll(rawURL, webhookIPPlaceholder, "0.0.0.0")); err != nil {
+		return nil, fmt.Errorf("webhook地址格式错误: %w", err)
+	}
+	return &webhookProvider{url: rawURL, client: client}, nil
+}
+
+// Name 服务名称
+func (p *webhookProvider) Name() string {
+	return ProviderWebhook
+}
+
+// Update 请求回调地址，2xx状态表示成功
+func (p *webhookProvider) Update(ctx context.Context, ip net.IP) error {
+	target := strings.ReplaceAll(p.url, webhookIPPlaceholder, url.QueryEscape(ip.String()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return redact.Error(err)
+	}
+	_, err = doRequest(p.client, req)
+	return err
+}
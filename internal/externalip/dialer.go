@@ -0,0 +1,33 @@
+package externalip
+
+import (
+	"net"
+	"net/http"
+)
+
+// newDialer 创建拨号器，bindIP不为空时从该本地地址发出连接，使流量经过指定的网络接口
+func newDialer(network string, bindIP net.IP) *net.Dialer {
+	dialer := &net.Dialer{}
+	if bindIP == nil {
+		return dialer
+	}
+
+	switch network {
+	case "udp", "udp4", "udp6":
+		dialer.LocalAddr = &net.UDPAddr{IP: bindIP}
+	default:
+		dialer.LocalAddr = &net.TCPAddr{IP: bindIP}
+	}
+	return dialer
+}
+
+// NewHTTPClient 创建HTTP客户端，bindIP不为空时从该本地地址发出请求
+func NewHTTPClient(bindIP net.IP) *http.Client {
+	if bindIP == nil {
+		return &http.Client{}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = newDialer("tcp", bindIP).DialContext
+	return &http.Client{Transport: transport}
+}
@@ -0,0 +1,62 @@
+package externalip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPSource_SendsFromBindAddress(t *testing.T) {
+	remoteHosts := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		remoteHosts <- host
+		fmt.Fprint(w, "203.0.113.7\n")
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ip, err := NewHTTPSource([]string{server.URL}, net.ParseIP("127.0.0.1")).Lookup(ctx)
+	if err != nil {
+		t.Fatalf("查询公网IP失败: %v", err)
+	}
+	if ip.String() != "203.0.113.7" {
+		t.Errorf("公网IP = %s, 期望 203.0.113.7", ip)
+	}
+	if host := <-remoteHosts; host != "127.0.0.1" {
+		t.Errorf("请求来源地址 = %s, 期望绑定地址 127.0.0.1", host)
+	}
+
+	// 绑定本机不存在的地址时无法发出请求
+	if _, err := NewHTTPSource([]string{server.URL}, net.ParseIP("192.0.2.1")).Lookup(ctx); err == nil {
+		t.Error("绑定地址不在本机时请求应失败")
+	}
+}
+
+func TestNewDialer_BindsLocalAddress(t *testing.T) {
+	bindIP := net.ParseIP("192.168.1.10")
+	tests := []struct {
+		network string
+		want    net.Addr
+	}{
+		{"tcp", &net.TCPAddr{IP: bindIP}},
+		{"udp", &net.UDPAddr{IP: bindIP}},
+		{"udp4", &net.UDPAddr{IP: bindIP}},
+	}
+	for _, tt := range tests {
+		dialer := newDialer(tt.network, bindIP)
+		if dialer.LocalAddr == nil || dialer.LocalAddr.Network() != tt.want.Network() || dialer.LocalAddr.String() != tt.want.String() {
+			t.Errorf("%s 拨号器的本地地址 = %v, 期望 %v", tt.network, dialer.LocalAddr, tt.want)
+		}
+	}
+
+	if dialer := newDialer("tcp", nil); dialer.LocalAddr != nil {
+		t.Errorf("未配置绑定地址时不应指定本地地址, 实际为 %v", dialer.LocalAddr)
+	}
+}
@@ -0,0 +1,165 @@
+package externalip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 公网IP来源名称
+const (
+	SourceRouter = "router"
+	SourceSTUN   = "stun"
+	SourceHTTP   = "http"
+	SourceStatic = "static" // 配置的固定公网IP
+)
+
+// Source 公网IP来源
+type Source interface {
+	Name() string
+	Lookup(ctx context.Context) (net.IP, error)
+}
+
+// Result 公网IP解析结果
+type Result struct {
+	IP        string    `json:"ip"`
+	Source    string    `json:"source"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Resolver 按配置的优先级从多个来源获取公网IP，并在TTL内缓存结果
+type Resolver struct {
+	sources []Source
+	ttl     time.Duration
+	timeout time.Duration
+	logger  *logrus.Logger
+
+	mutex    sync.Mutex // 串行化解析，避免并发请求重复访问外部服务
+	cacheMu  sync.RWMutex
+	current  *Result
+	onChange []func(*Result)
+}
+
+// NewResolver 创建公网IP解析器，sources的顺序即优先级
+func NewResolver(sources []Source, ttl, timeout time.Duration, logger *logrus.Logger) *Resolver {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &Resolver{
+		sources: sources,
+		ttl:     ttl,
+		timeout: timeout,
+		logger:  logger,
+	}
+}
+
+// OnChange 注册公网IP变化时的回调，需要在开始解析前注册
+// 回调在解析协程中同步执行，不能阻塞
+func (r *Resolver) OnChange(fn func(*Result)) {
+	r.onChange = append(r.onChange, fn)
+}
+
+// Current 返回缓存的解析结果，不触发解析
+func (r *Resolver) Current() *Result {
+	r.cacheMu.RLock()
+	defer r.cacheMu.RUnlock()
+
+	if r.current == nil {
+		return nil
+	}
+	result := *r.current
+	return &result
+}
+
+// Resolve 获取公网IP，缓存未过期且force为false时直接返回缓存
+func (r *Resolver) Resolve(ctx context.Context, force bool) (*Result, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !force {
+		if cached := r.Current(); cached != nil && time.Since(cached.UpdatedAt) < r.ttl {
+			return cached, nil
+		}
+	}
+
+	var errs []string
+	for _, source := range r.sources {
+		lookupCtx, cancel := context.WithTimeout(ctx, r.timeout)
+		ip, err := source.Lookup(lookupCtx)
+		cancel()
+
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", source.Name(), err))
+			r.logger.WithFields(logrus.Fields{
+				"source": source.Name(),
+				"error":  err,
+			}).Debug("公网IP来源查询失败，尝试下一个来源")
+			continue
+		}
+
+		// 多层NAT下路由器返回的是上级网络的私有地址，不能作为公网IP
+		if !IsPublicIP(ip) {
+			errs = append(errs, fmt.Sprintf("%s: %s 不是公网地址", source.Name(), ip))
+			r.logger.WithFields(logrus.Fields{
+				"source": source.Name(),
+				"ip":     ip.String(),
+			}).Warn("公网IP来源返回了私有地址，可能处于多层NAT之后")
+			continue
+		}
+
+		result := &Result{
+			IP:        ip.String(),
+			Source:    source.Name(),
+			UpdatedAt: time.Now(),
+		}
+		r.store(result)
+		return result, nil
+	}
+
+	if len(r.sources) == 0 {
+		return nil, fmt.Errorf("未配置公网IP来源")
+	}
+	return nil, fmt.Errorf("所有公网IP来源均失败: %s", strings.Join(errs, "; "))
+}
+
+// store 更新缓存，IP变化时记录日志
+func (r *Resolver) store(result *Result) {
+	r.cacheMu.Lock()
+	previous := r.current
+	r.current = result
+	r.cacheMu.Unlock()
+
+	if previous == nil || previous.IP != result.IP {
+		fields := logrus.Fields{
+			"ip":     result.IP,
+			"source": result.Source,
+		}
+		if previous != nil {
+			fields["previous_ip"] = previous.IP
+		}
+		r.logger.WithFields(fields).Info("公网IP已更新")
+
+		for _, fn := range r.onChange {
+			changed := *result
+			fn(&changed)
+		}
+	}
+}
+
+// IsPublicIP 检查IP是否为公网地址
+func IsPublicIP(ip net.IP) bool {
+	if ip == nil || ip.IsUnspecified() || ip.IsLoopback() || ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+		return false
+	}
+	// 100.64.0.0/10 运营商级NAT地址
+	if ip4 := ip.To4(); ip4 != nil && ip4[0] == 100 && ip4[1]&0xc0 == 64 {
+		return false
+	}
+	return true
+}
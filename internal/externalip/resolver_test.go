@@ -0,0 +1,153 @@
+package externalip
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type fakeSource struct {
+	name  string
+	ip    string
+	err   error
+	calls int
+}
+
+func (s *fakeSource) Name() string { return s.name }
+
+func (s *fakeSource) Lookup(ctx context.Context) (net.IP, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return net.ParseIP(s.ip), nil
+}
+
+func newTestLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func TestResolver_Preference(t *testing.T) {
+	router := &fakeSource{name: SourceRouter, ip: "100.64.1.2"} // 多层NAT下的运营商级NAT地址
+	stun := &fakeSource{name: SourceSTUN, err: fmt.Errorf("timeout")}
+	httpSrc := &fakeSource{name: SourceHTTP, ip: "203.0.113.7"}
+
+	resolver := NewResolver([]Source{router, stun, httpSrc}, time.Minute, time.Second, newTestLogger())
+
+	result, err := resolver.Resolve(context.Background(), false)
+	if err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	if result.IP != "203.0.113.7" || result.Source != SourceHTTP {
+		t.Errorf("结果 = %s (%s)，期望 203.0.113.7 (http)", result.IP, result.Source)
+	}
+
+	// 缓存未过期时不再查询
+	if _, err := resolver.Resolve(context.Background(), false); err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	if httpSrc.calls != 1 {
+		t.Errorf("HTTP来源被调用 %d 次，期望使用缓存", httpSrc.calls)
+	}
+
+	// 强制刷新时按优先级重新查询
+	router.ip = "198.51.100.1"
+	result, err = resolver.Resolve(context.Background(), true)
+	if err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	if result.Source != SourceRouter {
+		t.Errorf("来源 = %s，期望 router", result.Source)
+	}
+}
+
+func TestParseSTUNResponse(t *testing.T) {
+	transactionID := []byte("0123456789ab")
+	ip := net.ParseIP("203.0.113.7").To4()
+
+	response := make([]byte, stunHeaderSize+12)
+	binary.BigEndian.PutUint16(response[0:2], stunBindingResponse)
+	binary.BigEndian.PutUint16(response[2:4], 12)
+	binary.BigEndian.PutUint32(response[4:8], stunMagicCookie)
+	copy(response[8:20], transactionID)
+
+	attr := response[stunHeaderSize:]
+	binary.BigEndian.PutUint16(attr[0:2], stunAttrXorMappedAddress)
+	binary.BigEndian.PutUint16(attr[2:4], 8)
+	attr[5] = 0x01
+	binary.BigEndian.PutUint16(attr[6:8], 54321^(stunMagicCookie>>16))
+	for i := 0; i < 4; i++ {
+		attr[8+i] = ip[i] ^ response[4+i]
+	}
+
+	got, err := parseSTUNResponse(response, transactionID)
+	if err != nil {
+		t.Fatalf("解析STUN响应失败: %v", err)
+	}
+	if !got.Equal(ip) {
+		t.Errorf("映射地址 = %s，期望 %s", got, ip)
+	}
+
+	if _, err := parseSTUNResponse(response, []byte("other-txn-id")); err == nil {
+		t.Errorf("事务ID不匹配时应返回错误")
+	}
+}
+
+func TestParseSTUNResponse_IPv6(t *testing.T) {
+	transactionID := []byte("0123456789ab")
+	ip := net.ParseIP("2001:db8::1234")
+
+	response := make([]byte, stunHeaderSize+24)
+	binary.BigEndian.PutUint16(response[0:2], stunBindingResponse)
+	binary.BigEndian.PutUint16(response[2:4], 24)
+	binary.BigEndian.PutUint32(response[4:8], stunMagicCookie)
+	copy(response[8:20], transactionID)
+
+	// IPv6地址与魔术字加事务ID异或
+	attr := response[stunHeaderSize:]
+	binary.BigEndian.PutUint16(attr[0:2], stunAttrXorMappedAddress)
+	binary.BigEndian.PutUint16(attr[2:4], 20)
+	attr[5] = 0x02
+	for i := 0; i < net.IPv6len; i++ {
+		attr[8+i] = ip[i] ^ response[4+i]
+	}
+
+	got, err := parseSTUNResponse(response, transactionID)
+	if err != nil {
+		t.Fatalf("解析STUN响应失败: %v", err)
+	}
+	if !got.Equal(ip) {
+		t.Errorf("映射地址 = %s，期望 %s", got, ip)
+	}
+
+	// IPv4请求得到IPv6地址时应视为失败，而不是当作公网IPv4使用
+	if matchesNetwork(got, stunNetwork(nil)) {
+		t.Errorf("默认的IPv4请求不应接受IPv6地址")
+	}
+	if !matchesNetwork(got, stunNetwork(net.ParseIP("2001:db8::1"))) {
+		t.Errorf("绑定IPv6地址时应接受IPv6地址")
+	}
+	if IsPublicIP(net.ParseIP("fe80::1")) || !IsPublicIP(got) {
+		t.Errorf("IPv6地址的公网判断错误")
+	}
+}
+
+func TestNewSTUNSource_DefaultServers(t *testing.T) {
+	source := NewSTUNSource(nil, nil).(*stunSource)
+	if len(source.servers) != len(DefaultSTUNServers) || source.servers[0] != DefaultSTUNServers[0] {
+		t.Fatalf("未配置服务器时应使用默认服务器, got %v", source.servers)
+	}
+
+	source = NewSTUNSource([]string{"stun.example.com:3478"}, nil).(*stunSource)
+	if len(source.servers) != 1 || source.servers[0] != "stun.example.com:3478" {
+		t.Fatalf("应使用配置的服务器, got %v", source.servers)
+	}
+}
@@ -0,0 +1,128 @@
+package externalip
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RouterClient 能够查询路由器WAN口地址的客户端
+type RouterClient interface {
+	GetExternalIP() (string, error)
+}
+
+// routerSource 通过UPnP从路由器获取公网IP
+type routerSource struct {
+	client RouterClient
+}
+
+// NewRouterSource 创建路由器来源
+func NewRouterSource(client RouterClient) Source {
+	return &routerSource{client: client}
+}
+
+// Name 来源名称
+func (s *routerSource) Name() string {
+	return SourceRouter
+}
+
+// Lookup 查询路由器WAN口地址
+func (s *routerSource) Lookup(ctx context.Context) (net.IP, error) {
+	address, err := s.client.GetExternalIP()
+	if err != nil {
+		return nil, err
+	}
+	return parseIP(address)
+}
+
+// staticSource 配置的固定公网IP，用于路由器位于已知的1:1 NAT之后、自动获取的地址不可达的场景
+type staticSource struct {
+	ip net.IP
+}
+
+// NewStaticSource 创建固定公网IP来源
+func NewStaticSource(ip net.IP) Source {
+	return &staticSource{ip: ip}
+}
+
+// Name 来源名称
+func (s *staticSource) Name() string {
+	return SourceStatic
+}
+
+// Lookup 返回配置的公网IP
+func (s *staticSource) Lookup(ctx context.Context) (net.IP, error) {
+	return s.ip, nil
+}
+
+// httpSource 通过HTTP服务获取公网IP，服务返回纯文本格式的IP
+type httpSource struct {
+	urls   []string
+	client *http.Client
+}
+
+// NewHTTPSource 创建HTTP来源，按顺序尝试各个URL，bindIP不为空时从该本地地址发出请求
+func NewHTTPSource(urls []string, bindIP net.IP) Source {
+	return &httpSource{
+		urls:   urls,
+		client: NewHTTPClient(bindIP),
+	}
+}
+
+// Name 来源名称
+func (s *httpSource) Name() string {
+	return SourceHTTP
+}
+
+// Lookup 依次请求HTTP服务直到成功
+func (s *httpSource) Lookup(ctx context.Context) (net.IP, error) {
+	if len(s.urls) == 0 {
+		return nil, fmt.Errorf("未配置HTTP服务地址")
+	}
+
+	var lastErr error
+	for _, url := range s.urls {
+		ip, err := s.fetch(ctx, url)
+		if err == nil {
+			return ip, nil
+		}
+		lastErr = fmt.Errorf("%s: %w", url, err)
+	}
+	return nil, lastErr
+}
+
+// fetch 请求单个HTTP服务
+func (s *httpSource) fetch(ctx context.Context, url string) (net.IP, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("服务返回错误: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return nil, err
+	}
+	return parseIP(string(body))
+}
+
+// parseIP 解析文本格式的IP地址
+func parseIP(text string) (net.IP, error) {
+	ip := net.ParseIP(strings.TrimSpace(text))
+	if ip == nil {
+		return nil, fmt.Errorf("无效的IP地址: %q", strings.TrimSpace(text))
+	}
+	return ip, nil
+}
@@ -0,0 +1,191 @@
+package externalip
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// STUN协议常量（RFC 5389）
+const (
+	stunBindingRequest  = 0x0001
+	stunBindingResponse = 0x0101
+	stunMagicCookie     = 0x2112A442
+	stunHeaderSize      = 20
+
+	stunAttrMappedAddress    = 0x0001
+	stunAttrXorMappedAddress = 0x0020
+)
+
+// DefaultSTUNServers 未配置STUN服务器时使用的公共服务器
+var DefaultSTUNServers = []string{"stun.l.google.com:19302", "stun.cloudflare.com:3478"}
+
+// stunSource 通过STUN绑定请求获取公网IP
+type stunSource struct {
+	servers []string
+	bindIP  net.IP
+}
+
+// NewSTUNSource 创建STUN来源，按顺序尝试各个服务器，bindIP不为空时从该本地地址发出请求
+// servers为空时使用DefaultSTUNServers
+func NewSTUNSource(servers []string, bindIP net.IP) Source {
+	if len(servers) == 0 {
+		servers = DefaultSTUNServers
+	}
+	return &stunSource{servers: servers, bindIP: bindIP}
+}
+
+// Name 来源名称
+func (s *stunSource) Name() string {
+	return SourceSTUN
+}
+
+// Lookup 依次向STUN服务器发送绑定请求直到成功
+func (s *stunSource) Lookup(ctx context.Context) (net.IP, error) {
+	var lastErr error
+	for _, server := range s.servers {
+		ip, err := stunBinding(ctx, server, s.bindIP)
+		if err == nil {
+			return ip, nil
+		}
+		lastErr = fmt.Errorf("%s: %w", server, err)
+	}
+	return nil, lastErr
+}
+
+// stunNetwork 选择STUN请求的地址族：默认使用IPv4，与路由器映射的公网地址一致；绑定IPv6本地地址时使用IPv6
+// 双栈主机上直接使用"udp"可能解析到服务器的IPv6地址，得到的是本机的IPv6地址而不是NAT的公网IPv4地址
+func stunNetwork(bindIP net.IP) string {
+	if bindIP != nil && bindIP.To4() == nil {
+		return "udp6"
+	}
+	return "udp4"
+}
+
+// stunBinding 向单个STUN服务器发送绑定请求，只有IPv6地址的服务器在IPv4下会解析失败，由调用方尝试下一个服务器
+func stunBinding(ctx context.Context, server string, bindIP net.IP) (net.IP, error) {
+	network := stunNetwork(bindIP)
+	conn, err := newDialer(network, bindIP).DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+	conn.SetDeadline(deadline)
+
+	request := make([]byte, stunHeaderSize)
+	binary.BigEndian.PutUint16(request[0:2], stunBindingRequest)
+	binary.BigEndian.PutUint32(request[4:8], stunMagicCookie)
+	if _, err := rand.Read(request[8:20]); err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	buffer := make([]byte, 1024)
+	n, err := conn.Read(buffer)
+	if err != nil {
+		return nil, err
+	}
+	ip, err := parseSTUNResponse(buffer[:n], request[8:20])
+	if err != nil {
+		return nil, err
+	}
+	if !matchesNetwork(ip, network) {
+		return nil, fmt.Errorf("STUN服务器返回的地址 %s 与请求的地址族 %s 不一致", ip, network)
+	}
+	return ip, nil
+}
+
+// matchesNetwork 检查地址是否属于指定的地址族
+func matchesNetwork(ip net.IP, network string) bool {
+	isIPv4 := ip.To4() != nil
+	if network == "udp6" {
+		return !isIPv4
+	}
+	return isIPv4
+}
+
+// parseSTUNResponse 从绑定响应中解析映射地址，优先使用XOR-MAPPED-ADDRESS
+func parseSTUNResponse(data, transactionID []byte) (net.IP, error) {
+	if len(data) < stunHeaderSize {
+		return nil, fmt.Errorf("STUN响应过短")
+	}
+	if binary.BigEndian.Uint16(data[0:2]) != stunBindingResponse {
+		return nil, fmt.Errorf("非绑定成功响应: 0x%04x", binary.BigEndian.Uint16(data[0:2]))
+	}
+	if binary.BigEndian.Uint32(data[4:8]) != stunMagicCookie || string(data[8:20]) != string(transactionID) {
+		return nil, fmt.Errorf("STUN响应事务ID不匹配")
+	}
+
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+	if stunHeaderSize+length > len(data) {
+		return nil, fmt.Errorf("STUN响应长度不完整")
+	}
+
+	var mapped net.IP
+	attrs := data[stunHeaderSize : stunHeaderSize+length]
+	for len(attrs) >= 4 {
+		attrType := binary.BigEndian.Uint16(attrs[0:2])
+		attrLen := int(binary.BigEndian.Uint16(attrs[2:4]))
+		if 4+attrLen > len(attrs) {
+			break
+		}
+		value := attrs[4 : 4+attrLen]
+
+		switch attrType {
+		case stunAttrXorMappedAddress:
+			if ip := decodeSTUNAddress(value, data[4:20]); ip != nil {
+				return ip, nil
+			}
+		case stunAttrMappedAddress:
+			mapped = decodeSTUNAddress(value, nil)
+		}
+
+		// 属性按4字节对齐
+		attrs = attrs[4+(attrLen+3)&^3:]
+	}
+
+	if mapped != nil {
+		return mapped, nil
+	}
+	return nil, fmt.Errorf("STUN响应中没有映射地址")
+}
+
+// decodeSTUNAddress 解析地址属性，xorKey为魔术字加事务ID，为nil时表示未做异或
+func decodeSTUNAddress(value, xorKey []byte) net.IP {
+	if len(value) < 4 {
+		return nil
+	}
+
+	var size int
+	switch value[1] {
+	case 0x01:
+		size = net.IPv4len
+	case 0x02:
+		size = net.IPv6len
+	default:
+		return nil
+	}
+	if len(value) < 4+size {
+		return nil
+	}
+
+	ip := make(net.IP, size)
+	copy(ip, value[4:4+size])
+	if xorKey != nil {
+		for i := range ip {
+			ip[i] ^= xorKey[i]
+		}
+	}
+	return ip
+}
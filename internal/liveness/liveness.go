@@ -0,0 +1,144 @@
+package liveness
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// missedBeats 连续错过多少次心跳后认为子系统不健康
+const missedBeats = 3
+
+// minGrace 心跳超时的最小宽限时间，避免短周期子系统因调度抖动被误判
+const minGrace = 10 * time.Second
+
+// SubsystemStatus 子系统存活状态
+type SubsystemStatus struct {
+	Name          string    `json:"name"`
+	Healthy       bool      `json:"healthy"`
+	Interval      string    `json:"interval"`
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+	SinceLastBeat string    `json:"since_last_heartbeat"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// subsystem 已注册的子系统
+type subsystem struct {
+	interval time.Duration
+	last     time.Time
+	err      string
+}
+
+// Registry 记录关键协程的心跳，用于发现已退出或卡住的子系统
+type Registry struct {
+	logger     *logrus.Logger
+	mutex      sync.RWMutex
+	subsystems map[string]*subsystem
+}
+
+// NewRegistry 创建心跳注册表
+func NewRegistry(logger *logrus.Logger) *Registry {
+	return &Registry{
+		logger:     logger,
+		subsystems: make(map[string]*subsystem),
+	}
+}
+
+// Register 注册子系统，interval为子系统的预期心跳周期，注册时视为刚完成一次心跳
+func (r *Registry) Register(name string, interval time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.subsystems[name] = &subsystem{
+		interval: interval,
+		last:     time.Now(),
+	}
+}
+
+// Unregister 注销子系统，用于正常停止的协程
+func (r *Registry) Unregister(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.subsystems, name)
+}
+
+// Beat 记录子系统心跳
+func (r *Registry) Beat(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if s, exists := r.subsystems[name]; exists {
+		s.last = time.Now()
+	}
+}
+
+// Fail 标记子系统失败，之后的心跳不会清除失败状态
+func (r *Registry) Fail(name string, reason string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if s, exists := r.subsystems[name]; exists {
+		s.err = reason
+	}
+}
+
+// Recover 恢复协程中的panic并标记子系统失败，必须直接通过defer调用
+func (r *Registry) Recover(name string) {
+	if p := recover(); p != nil {
+		r.logger.WithFields(logrus.Fields{
+			"subsystem": name,
+			"error":     p,
+		}).Error("子系统协程异常退出")
+		r.Fail(name, fmt.Sprintf("panic: %v", p))
+	}
+}
+
+// Snapshot 获取所有子系统的存活状态，按名称排序
+func (r *Registry) Snapshot() []*SubsystemStatus {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	now := time.Now()
+	result := make([]*SubsystemStatus, 0, len(r.subsystems))
+	for name, s := range r.subsystems {
+		since := now.Sub(s.last)
+		status := &SubsystemStatus{
+			Name:          name,
+			Healthy:       s.err == "" && since <= s.timeout(),
+			Interval:      s.interval.String(),
+			LastHeartbeat: s.last,
+			SinceLastBeat: since.Truncate(time.Second).String(),
+			Error:         s.err,
+		}
+		if status.Error == "" && !status.Healthy {
+			status.Error = fmt.Sprintf("超过%s未收到心跳", s.timeout())
+		}
+		result = append(result, status)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// Healthy 检查所有子系统是否健康
+func (r *Registry) Healthy() bool {
+	for _, status := range r.Snapshot() {
+		if !status.Healthy {
+			return false
+		}
+	}
+	return true
+}
+
+// timeout 心跳超时时间
+func (s *subsystem) timeout() time.Duration {
+	timeout := s.interval * missedBeats
+	if timeout < minGrace {
+		timeout = minGrace
+	}
+	return timeout
+}
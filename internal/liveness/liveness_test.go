@@ -0,0 +1,48 @@
+package liveness
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestRegistry_StaleAndPanic(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	registry := NewRegistry(logger)
+
+	registry.Register("monitor", time.Second)
+	registry.Register("cleanup", time.Second)
+	if !registry.Healthy() {
+		t.Fatal("刚注册的子系统应当健康")
+	}
+
+	// 模拟协程卡住，超过超时时间未心跳
+	registry.mutex.Lock()
+	registry.subsystems["monitor"].last = time.Now().Add(-time.Minute)
+	registry.mutex.Unlock()
+
+	if registry.Healthy() {
+		t.Error("心跳超时的子系统应当不健康")
+	}
+	registry.Beat("monitor")
+	if !registry.Healthy() {
+		t.Error("恢复心跳后子系统应当健康")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer registry.Recover("cleanup")
+		panic("boom")
+	}()
+	<-done
+
+	for _, status := range registry.Snapshot() {
+		if status.Name == "cleanup" && (status.Healthy || status.Error == "") {
+			t.Errorf("panic退出的子系统状态不正确: %+v", status)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sampleKeyFields 区分采样对象的字段，消息相同但这些字段不同的日志分别采样
+// 例如不同端口的"端口下线"日志互不抑制
+var sampleKeyFields = []string{"port", "internal_port", "external_port", "protocol", "type"}
+
+// sampleRecord 单条日志消息的采样记录
+type sampleRecord struct {
+	level      logrus.Level
+	message    string
+	fields     logrus.Fields
+	windowFrom time.Time
+	suppressed int
+}
+
+// SamplingHook 日志采样钩子
+//
+// 钩子接管日志输出：同一级别、同一消息且端口、协议等标识字段相同的日志在一个采样周期内只输出第一条，
+// 其余被计数并在周期结束时汇总输出。Warn及以上级别的日志从不采样。
+// 使用时需要将logger的输出设置为io.Discard，由钩子负责写出。
+type SamplingHook struct {
+	out       io.Writer
+	formatter logrus.Formatter
+	logger    *logrus.Logger
+	interval  time.Duration
+	records   map[string]*sampleRecord
+	mutex     sync.Mutex
+	stopChan  chan struct{}
+	stopOnce  sync.Once
+}
+
+// NewSamplingHook 创建日志采样钩子
+func NewSamplingHook(logger *logrus.Logger, out io.Writer, interval time.Duration) *SamplingHook {
+	h := &SamplingHook{
+		out:       out,
+		formatter: logger.Formatter,
+		logger:    logger,
+		interval:  interval,
+		records:   make(map[string]*sampleRecord),
+		stopChan:  make(chan struct{}),
+	}
+
+	go h.flushRoutine()
+
+	return h
+}
+
+// Install 将钩子安装到logger并接管输出
+func (h *SamplingHook) Install() {
+	h.logger.AddHook(h)
+	h.logger.SetOutput(io.Discard)
+}
+
+// Levels 返回支持的日志级别
+func (h *SamplingHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire 处理日志事件
+func (h *SamplingHook) Fire(entry *logrus.Entry) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	// 失败等关键事件从不采样
+	if entry.Level <= logrus.WarnLevel {
+		return h.write(entry)
+	}
+
+	key, fields := sampleKey(entry)
+	record, exists := h.records[key]
+	if !exists || entry.Time.Sub(record.windowFrom) >= h.interval {
+		if exists && record.suppressed > 0 {
+			h.writeSummary(record)
+		}
+		h.records[key] = &sampleRecord{level: entry.Level, message: entry.Message, fields: fields, windowFrom: entry.Time}
+		return h.write(entry)
+	}
+
+	record.suppressed++
+	return nil
+}
+
+// sampleKey 生成日志的采样键，返回键和参与区分的标识字段
+func sampleKey(entry *logrus.Entry) (string, logrus.Fields) {
+	var key strings.Builder
+	key.WriteString(entry.Level.String())
+	key.WriteString("|")
+	key.WriteString(entry.Message)
+
+	fields := logrus.Fields{}
+	for _, name := range sampleKeyFields {
+		if value, exists := entry.Data[name]; exists {
+			fields[name] = value
+			fmt.Fprintf(&key, "|%s=%v", name, value)
+		}
+	}
+	return key.String(), fields
+}
+
+// Stop 停止钩子并输出剩余的汇总
+func (h *SamplingHook) Stop() {
+	h.stopOnce.Do(func() {
+		close(h.stopChan)
+		h.flush(true)
+	})
+}
+
+// flushRoutine 定期输出采样汇总
+func (h *SamplingHook) flushRoutine() {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.stopChan:
+			return
+		case <-ticker.C:
+			h.flush(false)
+		}
+	}
+}
+
+// flush 输出已结束采样周期的汇总
+func (h *SamplingHook) flush(all bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	now := time.Now()
+	for key, record := range h.records {
+		if !all && now.Sub(record.windowFrom) < h.interval {
+			continue
+		}
+		if record.suppressed > 0 {
+			h.writeSummary(record)
+		}
+		delete(h.records, key)
+	}
+}
+
+// writeSummary 输出单条消息的采样汇总，附带区分采样对象的标识字段（调用者需要持有锁）
+func (h *SamplingHook) writeSummary(record *sampleRecord) {
+	summary := logrus.NewEntry(h.logger).WithFields(record.fields).WithFields(logrus.Fields{
+		"sampled_message": record.message,
+		"suppressed":      record.suppressed,
+		"window":          h.interval.String(),
+	})
+	summary.Time = time.Now()
+	summary.Level = record.level
+	summary.Message = "日志采样汇总"
+
+	h.write(summary)
+}
+
+// write 格式化并写出日志（调用者需要持有锁）
+func (h *SamplingHook) write(entry *logrus.Entry) error {
+	data, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	_, err = h.out.Write(data)
+	return err
+}
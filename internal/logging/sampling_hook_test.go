@@ -0,0 +1,132 @@
+package logging
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newTestSamplingHook 创建输出到缓冲区的采样钩子，采样周期足够长，不会被定时汇总干扰
+func newTestSamplingHook(t *testing.T, interval time.Duration) (*SamplingHook, *bytes.Buffer) {
+	logger := logrus.New()
+	logger.SetFormatter(&logrus.TextFormatter{DisableTimestamp: true, DisableColors: true})
+	var out bytes.Buffer
+	hook := NewSamplingHook(logger, &out, interval)
+	t.Cleanup(hook.Stop)
+	return hook, &out
+}
+
+// fire 以指定时间向钩子发送一条日志
+func fire(t *testing.T, hook *SamplingHook, level logrus.Level, message string, at time.Time) {
+	entry := logrus.NewEntry(hook.logger)
+	entry.Level = level
+	entry.Message = message
+	entry.Time = at
+	if err := hook.Fire(entry); err != nil {
+		t.Fatalf("处理日志失败: %v", err)
+	}
+}
+
+func TestSamplingHook_SuppressesRepeatsWithinWindow(t *testing.T) {
+	hook, out := newTestSamplingHook(t, time.Hour)
+	start := time.Now()
+
+	for i := 0; i < 3; i++ {
+		fire(t, hook, logrus.InfoLevel, "端口状态变化", start.Add(time.Duration(i)*time.Minute))
+	}
+	// 不同消息分别采样
+	fire(t, hook, logrus.InfoLevel, "添加端口映射", start)
+
+	if got := strings.Count(out.String(), "端口状态变化"); got != 1 {
+		t.Errorf("采样周期内重复的日志输出了%d次，期望1次:\n%s", got, out.String())
+	}
+	if !strings.Contains(out.String(), "添加端口映射") {
+		t.Error("不同消息的第一条日志应输出")
+	}
+
+	// Warn及以上级别从不采样
+	for i := 0; i < 3; i++ {
+		fire(t, hook, logrus.WarnLevel, "删除端口映射失败", start)
+	}
+	if got := strings.Count(out.String(), "删除端口映射失败"); got != 3 {
+		t.Errorf("Warn日志输出了%d次，期望3次", got)
+	}
+}
+
+func TestSamplingHook_LogsFirstEntryAfterWindow(t *testing.T) {
+	hook, out := newTestSamplingHook(t, time.Hour)
+	start := time.Now()
+
+	fire(t, hook, logrus.InfoLevel, "端口状态变化", start)
+	fire(t, hook, logrus.InfoLevel, "端口状态变化", start.Add(time.Minute))
+	fire(t, hook, logrus.InfoLevel, "端口状态变化", start.Add(2*time.Minute))
+
+	out.Reset()
+	fire(t, hook, logrus.InfoLevel, "端口状态变化", start.Add(time.Hour))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("新周期的第一条日志前应先输出上一周期的汇总，实际输出:\n%s", out.String())
+	}
+	if !strings.Contains(lines[0], "日志采样汇总") || !strings.Contains(lines[0], "suppressed=2") {
+		t.Errorf("汇总应记录被抑制的2条日志: %s", lines[0])
+	}
+	if !strings.Contains(lines[1], `msg="端口状态变化"`) {
+		t.Errorf("采样周期结束后的第一条日志应输出: %s", lines[1])
+	}
+
+	// 新周期内的重复日志再次被抑制
+	out.Reset()
+	fire(t, hook, logrus.InfoLevel, "端口状态变化", start.Add(time.Hour+time.Minute))
+	if out.Len() != 0 {
+		t.Errorf("新周期内的重复日志应被抑制:\n%s", out.String())
+	}
+}
+
+func TestSamplingHook_StopFlushesSummary(t *testing.T) {
+	hook, out := newTestSamplingHook(t, time.Hour)
+	start := time.Now()
+
+	fire(t, hook, logrus.InfoLevel, "端口状态变化", start)
+	fire(t, hook, logrus.InfoLevel, "端口状态变化", start.Add(time.Minute))
+
+	hook.Stop()
+	if !strings.Contains(out.String(), "suppressed=1") {
+		t.Errorf("停止时应输出剩余的汇总:\n%s", out.String())
+	}
+}
+
+func TestSamplingHook_SamplesEachPortSeparately(t *testing.T) {
+	hook, out := newTestSamplingHook(t, time.Hour)
+	start := time.Now()
+
+	// fireWithFields 以指定字段发送一条日志
+	fireWithFields := func(fields logrus.Fields, at time.Time) {
+		entry := logrus.NewEntry(hook.logger).WithFields(fields)
+		entry.Level = logrus.InfoLevel
+		entry.Message = "端口下线"
+		entry.Time = at
+		if err := hook.Fire(entry); err != nil {
+			t.Fatalf("处理日志失败: %v", err)
+		}
+	}
+
+	fireWithFields(logrus.Fields{"port": 8080, "protocol": "TCP"}, start)
+	fireWithFields(logrus.Fields{"port": 8081, "protocol": "TCP"}, start)
+	fireWithFields(logrus.Fields{"port": 8080, "protocol": "UDP"}, start)
+	// 非标识字段不参与区分
+	fireWithFields(logrus.Fields{"port": 8080, "protocol": "TCP", "state": "refused"}, start.Add(time.Minute))
+
+	if got := strings.Count(out.String(), "端口下线"); got != 3 {
+		t.Errorf("不同端口和协议的日志应分别输出，实际输出%d条:\n%s", got, out.String())
+	}
+
+	out.Reset()
+	hook.Stop()
+	if !strings.Contains(out.String(), "suppressed=1") || !strings.Contains(out.String(), "port=8080") || !strings.Contains(out.String(), "protocol=TCP") {
+		t.Errorf("汇总应带有被抑制日志的端口和协议:\n%s", out.String())
+	}
+}
@@ -0,0 +1,184 @@
+package mdns
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// DNS记录类型
+const (
+	typeA   uint16 = 1
+	typePTR uint16 = 12
+	typeTXT uint16 = 16
+	typeSRV uint16 = 33
+	typeANY uint16 = 255
+)
+
+const (
+	classIN         uint16 = 1
+	classCacheFlush uint16 = 0x8000 // 唯一记录的缓存刷新位
+	classUnicast    uint16 = 0x8000 // 问题中的单播响应位（QU）
+
+	flagResponse      uint16 = 0x8000
+	flagAuthoritative uint16 = 0x0400
+
+	headerLen    = 12
+	maxPointers  = 16
+	maxLabelLen  = 63
+	maxMessageSz = 9000
+)
+
+var errMalformed = errors.New("mDNS报文格式错误")
+
+// question DNS查询中的问题
+type question struct {
+	name    string // 小写、以.结尾的完整域名
+	qtype   uint16
+	unicast bool
+}
+
+// query 解析后的DNS查询
+type query struct {
+	id        uint16
+	questions []question
+}
+
+// record DNS资源记录
+type record struct {
+	name  []string // 域名的各个标签，实例名可以包含空格和点
+	rtype uint16
+	class uint16
+	ttl   uint32
+	data  []byte
+}
+
+// parseQuery 解析DNS查询报文，响应报文返回nil
+func parseQuery(msg []byte) (*query, error) {
+	if len(msg) < headerLen {
+		return nil, errMalformed
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&flagResponse != 0 {
+		return nil, nil
+	}
+
+	q := &query{id: binary.BigEndian.Uint16(msg[0:])}
+	count := int(binary.BigEndian.Uint16(msg[4:]))
+	offset := headerLen
+	for i := 0; i < count; i++ {
+		name, next, err := readName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(msg) {
+			return nil, errMalformed
+		}
+		class := binary.BigEndian.Uint16(msg[next+2:])
+		q.questions = append(q.questions, question{
+			name:    name,
+			qtype:   binary.BigEndian.Uint16(msg[next:]),
+			unicast: class&classUnicast != 0,
+		})
+		offset = next + 4
+	}
+	return q, nil
+}
+
+// readName 读取offset处的域名，返回小写域名和域名之后的位置
+func readName(msg []byte, offset int) (string, int, error) {
+	var labels []string
+	next := -1
+	for pointers := 0; ; {
+		if offset >= len(msg) {
+			return "", 0, errMalformed
+		}
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.ToLower(strings.Join(labels, ".")) + ".", next, nil
+		case length&0xC0 == 0xC0:
+			// 压缩指针
+			if offset+1 >= len(msg) || pointers >= maxPointers {
+				return "", 0, errMalformed
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:]) & 0x3FFF)
+			pointers++
+		case length > maxLabelLen || offset+1+length > len(msg):
+			return "", 0, errMalformed
+		default:
+			labels = append(labels, string(msg[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
+
+// buildResponse 生成权威应答报文，answers和extra分别放入应答区和附加区
+func buildResponse(id uint16, answers, extra []record) []byte {
+	msg := make([]byte, headerLen, 512)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], flagResponse|flagAuthoritative)
+	binary.BigEndian.PutUint16(msg[6:], uint16(len(answers)))
+	binary.BigEndian.PutUint16(msg[10:], uint16(len(extra)))
+
+	for _, rr := range append(answers, extra...) {
+		msg = appendName(msg, rr.name)
+		msg = binary.BigEndian.AppendUint16(msg, rr.rtype)
+		msg = binary.BigEndian.AppendUint16(msg, rr.class)
+		msg = binary.BigEndian.AppendUint32(msg, rr.ttl)
+		msg = binary.BigEndian.AppendUint16(msg, uint16(len(rr.data)))
+		msg = append(msg, rr.data...)
+	}
+	return msg
+}
+
+// appendName 按标签写入域名，不使用压缩
+func appendName(b []byte, labels []string) []byte {
+	for _, label := range labels {
+		if len(label) > maxLabelLen {
+			label = label[:maxLabelLen]
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// nameKey 域名标签的比较键，与readName的结果格式一致
+func nameKey(labels []string) string {
+	return strings.ToLower(strings.Join(labels, ".")) + "."
+}
+
+// ptrData PTR记录的数据
+func ptrData(target []string) []byte {
+	return appendName(nil, target)
+}
+
+// srvData SRV记录的数据
+func srvData(port int, target []string) []byte {
+	b := make([]byte, 6)
+	binary.BigEndian.PutUint16(b[4:], uint16(port))
+	return appendName(b, target)
+}
+
+// txtData TXT记录的数据，没有键值时写入一个空字符串
+func txtData(txt []string) []byte {
+	if len(txt) == 0 {
+		return []byte{0}
+	}
+	var b []byte
+	for _, entry := range txt {
+		if len(entry) > 255 {
+			entry = entry[:255]
+		}
+		b = append(b, byte(len(entry)))
+		b = append(b, entry...)
+	}
+	return b
+}
@@ -0,0 +1,323 @@
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// recordTTL 服务记录的TTL（秒），与常见实现一致
+	recordTTL uint32 = 120
+	// hostTTL 主机地址记录的TTL（秒）
+	hostTTL uint32 = 120
+	// goodbyeTTL 注销服务时发送TTL为0的记录
+	goodbyeTTL uint32 = 0
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// serviceEnumeration DNS-SD服务类型枚举域名
+var serviceEnumeration = []string{"_services", "_dns-sd", "_udp", "local"}
+
+// Service 通过DNS-SD广播的服务
+type Service struct {
+	Instance string   `json:"instance"` // 服务实例名，例如 "NAS Web"
+	Type     string   `json:"type"`     // 服务类型，例如 "_http._tcp"
+	Port     int      `json:"port"`
+	TXT      []string `json:"txt,omitempty"`
+}
+
+// Key 服务的唯一标识
+func (s Service) Key() string {
+	return nameKey(s.instanceName())
+}
+
+// typeName 服务类型域名，例如 _http._tcp.local
+func (s Service) typeName() []string {
+	return append(strings.Split(s.Type, "."), "local")
+}
+
+// instanceName 服务实例域名，实例名作为单个标签
+func (s Service) instanceName() []string {
+	return append([]string{s.Instance}, s.typeName()...)
+}
+
+// ValidServiceType 检查服务类型格式，例如 _http._tcp
+func ValidServiceType(serviceType string) bool {
+	parts := strings.Split(serviceType, ".")
+	if len(parts) != 2 || (parts[1] != "_tcp" && parts[1] != "_udp") {
+		return false
+	}
+	name := parts[0]
+	if len(name) < 2 || len(name) > 16 || name[0] != '_' {
+		return false
+	}
+	for _, c := range name[1:] {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// Responder mDNS应答器，在局域网内广播已注册的服务
+type Responder struct {
+	hostName []string // 例如 nas.local
+	logger   *logrus.Logger
+
+	mutex    sync.RWMutex
+	ip       net.IP
+	services map[string]Service
+	conn     *net.UDPConn
+	wg       sync.WaitGroup
+}
+
+// NewResponder 创建mDNS应答器，hostName不含.local后缀
+func NewResponder(hostName string, logger *logrus.Logger) *Responder {
+	return &Responder{
+		hostName: []string{hostName, "local"},
+		logger:   logger,
+		services: make(map[string]Service),
+	}
+}
+
+// HostName 返回应答器使用的主机域名
+func (r *Responder) HostName() string {
+	return strings.Join(r.hostName, ".")
+}
+
+// Start 加入mDNS组播组并开始应答查询
+func (r *Responder) Start(iface *net.Interface) error {
+	conn, err := net.ListenMulticastUDP("udp4", iface, mdnsGroup)
+	if err != nil {
+		return fmt.Errorf("加入mDNS组播组失败: %w", err)
+	}
+
+	r.mutex.Lock()
+	r.conn = conn
+	r.mutex.Unlock()
+
+	r.wg.Add(1)
+	go r.serve(conn)
+	return nil
+}
+
+// Close 注销所有服务并停止应答
+func (r *Responder) Close() {
+	r.mutex.Lock()
+	conn := r.conn
+	r.conn = nil
+	var goodbyes []record
+	for _, svc := range r.services {
+		goodbyes = append(goodbyes, r.serviceRecords(svc, goodbyeTTL)...)
+	}
+	r.services = make(map[string]Service)
+	r.mutex.Unlock()
+
+	if conn == nil {
+		return
+	}
+	if len(goodbyes) > 0 {
+		r.send(conn, mdnsGroup, buildResponse(0, goodbyes, nil))
+	}
+	conn.Close()
+	r.wg.Wait()
+}
+
+// SetIP 设置主机地址记录使用的IPv4地址
+func (r *Responder) SetIP(ip net.IP) {
+	ip = ip.To4()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if ip == nil || ip.Equal(r.ip) {
+		return
+	}
+	r.ip = ip
+	r.announceUnsafe(r.hostRecords(hostTTL))
+}
+
+// Sync 将广播的服务同步为services，新增的服务立即公告，移除的服务发送注销记录
+func (r *Responder) Sync(services []Service) (added, removed int) {
+	wanted := make(map[string]Service, len(services))
+	for _, svc := range services {
+		wanted[svc.Key()] = svc
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var announce, goodbyes []record
+	for key, svc := range r.services {
+		if current, ok := wanted[key]; ok && current.Port == svc.Port {
+			continue
+		}
+		goodbyes = append(goodbyes, r.serviceRecords(svc, goodbyeTTL)...)
+		delete(r.services, key)
+		removed++
+	}
+	for key, svc := range wanted {
+		if _, ok := r.services[key]; ok {
+			continue
+		}
+		r.services[key] = svc
+		announce = append(announce, r.serviceRecords(svc, recordTTL)...)
+		added++
+	}
+
+	r.announceUnsafe(goodbyes)
+	if len(announce) > 0 {
+		r.announceUnsafe(append(announce, r.hostRecords(hostTTL)...))
+	}
+	return added, removed
+}
+
+// Services 返回当前广播的服务，按实例名排序
+func (r *Responder) Services() []Service {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	services := make([]Service, 0, len(r.services))
+	for _, svc := range r.services {
+		services = append(services, svc)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Key() < services[j].Key()
+	})
+	return services
+}
+
+// announceUnsafe 向组播组发送记录（调用者需要持有mutex）
+func (r *Responder) announceUnsafe(records []record) {
+	if r.conn == nil || len(records) == 0 {
+		return
+	}
+	r.send(r.conn, mdnsGroup, buildResponse(0, records, nil))
+}
+
+// send 发送报文，失败只记录日志
+func (r *Responder) send(conn *net.UDPConn, addr *net.UDPAddr, msg []byte) {
+	if _, err := conn.WriteToUDP(msg, addr); err != nil {
+		r.logger.WithError(err).Debug("发送mDNS报文失败")
+	}
+}
+
+// serve 读取查询并应答
+func (r *Responder) serve(conn *net.UDPConn) {
+	defer r.wg.Done()
+
+	buf := make([]byte, maxMessageSz)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		q, err := parseQuery(buf[:n])
+		if err != nil || q == nil {
+			continue
+		}
+		r.handleQuery(conn, q, from)
+	}
+}
+
+// handleQuery 应答一个查询，非5353端口发来的查询按传统单播DNS应答
+func (r *Responder) handleQuery(conn *net.UDPConn, q *query, from *net.UDPAddr) {
+	r.mutex.RLock()
+	var answers, extra []record
+	unicast := from.Port != mdnsGroup.Port
+	for _, question := range q.questions {
+		a, e := r.answerUnsafe(question)
+		answers = append(answers, a...)
+		extra = append(extra, e...)
+		unicast = unicast || question.unicast
+	}
+	r.mutex.RUnlock()
+
+	if len(answers) == 0 {
+		return
+	}
+
+	if from.Port != mdnsGroup.Port {
+		// 传统单播查询需要回显查询ID
+		r.send(conn, from, buildResponse(q.id, answers, extra))
+		return
+	}
+	if unicast {
+		r.send(conn, from, buildResponse(0, answers, extra))
+		return
+	}
+	r.send(conn, mdnsGroup, buildResponse(0, answers, extra))
+}
+
+// answerUnsafe 生成单个问题的应答记录和附加记录（调用者需要持有读锁）
+func (r *Responder) answerUnsafe(q question) (answers, extra []record) {
+	matches := func(t uint16) bool { return q.qtype == t || q.qtype == typeANY }
+
+	if q.name == nameKey(r.hostName) {
+		if matches(typeA) {
+			answers = append(answers, r.hostRecords(hostTTL)...)
+		}
+		return answers, nil
+	}
+
+	if q.name == nameKey(serviceEnumeration) && matches(typePTR) {
+		seen := make(map[string]bool)
+		for _, svc := range r.services {
+			typeName := svc.typeName()
+			if seen[nameKey(typeName)] {
+				continue
+			}
+			seen[nameKey(typeName)] = true
+			answers = append(answers, record{
+				name: serviceEnumeration, rtype: typePTR, class: classIN, ttl: recordTTL,
+				data: ptrData(typeName),
+			})
+		}
+		return answers, nil
+	}
+
+	for key, svc := range r.services {
+		switch {
+		case q.name == nameKey(svc.typeName()) && matches(typePTR):
+			records := r.serviceRecords(svc, recordTTL)
+			answers = append(answers, records[0])
+			extra = append(extra, records[1:]...)
+		case q.name == key:
+			records := r.serviceRecords(svc, recordTTL)
+			if matches(typeSRV) {
+				answers = append(answers, records[1])
+			}
+			if matches(typeTXT) {
+				answers = append(answers, records[2])
+			}
+			extra = append(extra, records[3:]...)
+		}
+	}
+	return answers, extra
+}
+
+// serviceRecords 生成服务的PTR、SRV、TXT记录和主机地址记录，顺序固定
+func (r *Responder) serviceRecords(svc Service, ttl uint32) []record {
+	instance := svc.instanceName()
+	records := []record{
+		{name: svc.typeName(), rtype: typePTR, class: classIN, ttl: ttl, data: ptrData(instance)},
+		{name: instance, rtype: typeSRV, class: classIN | classCacheFlush, ttl: ttl, data: srvData(svc.Port, r.hostName)},
+		{name: instance, rtype: typeTXT, class: classIN | classCacheFlush, ttl: ttl, data: txtData(svc.TXT)},
+	}
+	if ttl == goodbyeTTL {
+		return records
+	}
+	return append(records, r.hostRecords(ttl)...)
+}
+
+// hostRecords 生成主机的A记录，未设置地址时为空
+func (r *Responder) hostRecords(ttl uint32) []record {
+	if r.ip == nil {
+		return nil
+	}
+	return []record{{name: r.hostName, rtype: typeA, class: classIN | classCacheFlush, ttl: ttl, data: []byte(r.ip)}}
+}
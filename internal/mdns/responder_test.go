@@ -0,0 +1,71 @@
+package mdns
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// buildQuery 生成只包含一个问题的查询报文
+func buildQuery(name []string, qtype uint16) []byte {
+	msg := make([]byte, headerLen)
+	binary.BigEndian.PutUint16(msg[0:], 0x1234)
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	msg = appendName(msg, name)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	return binary.BigEndian.AppendUint16(msg, classIN)
+}
+
+func TestParseQuery(t *testing.T) {
+	q, err := parseQuery(buildQuery([]string{"_HTTP", "_tcp", "local"}, typePTR))
+	if err != nil || q == nil {
+		t.Fatalf("解析查询失败: %v", err)
+	}
+	if q.id != 0x1234 || len(q.questions) != 1 {
+		t.Fatalf("查询头错误: %+v", q)
+	}
+	if q.questions[0].name != "_http._tcp.local." || q.questions[0].qtype != typePTR {
+		t.Fatalf("问题解析错误: %+v", q.questions[0])
+	}
+
+	if q, err := parseQuery(buildResponse(0, nil, nil)); err != nil || q != nil {
+		t.Fatalf("应答报文应被忽略: %+v, %v", q, err)
+	}
+	if _, err := parseQuery([]byte{0, 1, 2}); err == nil {
+		t.Fatal("截断的报文应返回错误")
+	}
+}
+
+func TestResponderAnswers(t *testing.T) {
+	r := NewResponder("nas", logrus.New())
+	r.SetIP(net.IPv4(192, 168, 1, 10))
+	added, removed := r.Sync([]Service{{Instance: "NAS Web", Type: "_http._tcp", Port: 8080}})
+	if added != 1 || removed != 0 {
+		t.Fatalf("同步结果错误: added=%d removed=%d", added, removed)
+	}
+
+	answers, extra := r.answerUnsafe(question{name: "_http._tcp.local.", qtype: typePTR})
+	if len(answers) != 1 || answers[0].rtype != typePTR {
+		t.Fatalf("PTR应答错误: %+v", answers)
+	}
+	if len(extra) != 3 || extra[0].rtype != typeSRV || extra[2].rtype != typeA {
+		t.Fatalf("附加记录错误: %+v", extra)
+	}
+	if port := binary.BigEndian.Uint16(extra[0].data[4:]); port != 8080 {
+		t.Fatalf("SRV端口错误: %d", port)
+	}
+
+	answers, _ = r.answerUnsafe(question{name: "nas web._http._tcp.local.", qtype: typeANY})
+	if len(answers) != 2 {
+		t.Fatalf("实例ANY查询应返回SRV和TXT: %+v", answers)
+	}
+
+	if _, removed := r.Sync(nil); removed != 1 {
+		t.Fatalf("移除服务失败: removed=%d", removed)
+	}
+	if answers, _ := r.answerUnsafe(question{name: "_http._tcp.local.", qtype: typePTR}); len(answers) != 0 {
+		t.Fatalf("移除后不应再应答: %+v", answers)
+	}
+}
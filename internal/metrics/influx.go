@@ -0,0 +1,162 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultMaxPending 推送失败时最多保留的批次数
+const defaultMaxPending = 10
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
+
+// EncodeLineProtocol 将采样值编码为InfluxDB行协议，每个指标一行，值写入value字段
+func EncodeLineProtocol(samples []Sample, tags map[string]string, ts time.Time) []byte {
+	var b bytes.Buffer
+	for _, sample := range samples {
+		b.WriteString(measurementEscaper.Replace(sample.Name))
+
+		merged := make(map[string]string, len(tags)+len(sample.Labels))
+		for k, v := range tags {
+			merged[k] = v
+		}
+		for k, v := range sample.Labels {
+			merged[k] = v
+		}
+		keys := make([]string, 0, len(merged))
+		for k := range merged {
+			if merged[k] != "" {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			b.WriteByte(',')
+			b.WriteString(tagEscaper.Replace(k))
+			b.WriteByte('=')
+			b.WriteString(tagEscaper.Replace(merged[k]))
+		}
+
+		b.WriteString(" value=")
+		b.WriteString(strconv.FormatFloat(sample.Value, 'g', -1, 64))
+		b.WriteByte(' ')
+		b.WriteString(strconv.FormatInt(ts.UnixNano(), 10))
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+// InfluxConfig InfluxDB行协议推送参数
+type InfluxConfig struct {
+	URL        string            // 写入地址，例如 http://influxdb:8086/api/v2/write?org=home&bucket=net
+	Token      string            // InfluxDB v2令牌，为空时不发送Authorization头
+	Timeout    time.Duration     // 单次推送超时
+	MaxPending int               // 推送失败时最多保留的批次数，超过时丢弃最旧的批次
+	Tags       map[string]string // 附加到每个指标的标签
+}
+
+// PushStatus 推送状态
+type PushStatus struct {
+	LastPushAt   time.Time `json:"last_push_at,omitempty"`
+	LastError    string    `json:"last_error,omitempty"`
+	PendingCount int       `json:"pending_batches"`
+	DroppedCount int       `json:"dropped_batches"`
+}
+
+// InfluxPusher 定期将指标推送到InfluxDB或Telegraf，推送失败的批次在内存中保留并随下一次推送重发
+type InfluxPusher struct {
+	config InfluxConfig
+	client *http.Client
+
+	mutex   sync.Mutex
+	pending [][]byte
+	status  PushStatus
+}
+
+// NewInfluxPusher 创建InfluxDB推送器
+func NewInfluxPusher(cfg InfluxConfig, client *http.Client) *InfluxPusher {
+	if cfg.MaxPending <= 0 {
+		cfg.MaxPending = defaultMaxPending
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &InfluxPusher{config: cfg, client: client}
+}
+
+// Push 编码本次采样并连同积压的批次一起推送，失败时保留批次等待下次重试
+func (p *InfluxPusher) Push(ctx context.Context, samples []Sample, now time.Time) error {
+	p.mutex.Lock()
+	p.pending = append(p.pending, EncodeLineProtocol(samples, p.config.Tags, now))
+	if overflow := len(p.pending) - p.config.MaxPending; overflow > 0 {
+		p.pending = p.pending[overflow:]
+		p.status.DroppedCount += overflow
+	}
+	body := bytes.Join(p.pending, nil)
+	sent := len(p.pending)
+	p.mutex.Unlock()
+
+	err := p.send(ctx, body)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if err != nil {
+		p.status.LastError = err.Error()
+	} else {
+		// 推送期间不会有新批次加入，直接移除已发送的批次
+		p.pending = p.pending[sent:]
+		p.status.LastPushAt = now
+		p.status.LastError = ""
+	}
+	p.status.PendingCount = len(p.pending)
+	return err
+}
+
+// Status 获取推送状态
+func (p *InfluxPusher) Status() PushStatus {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.status
+}
+
+// send 发送一次写入请求
+func (p *InfluxPusher) send(ctx context.Context, body []byte) error {
+	if p.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.config.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("创建InfluxDB写入请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if p.config.Token != "" {
+		req.Header.Set("Authorization", "Token "+p.config.Token)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("推送指标失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("推送指标失败: HTTP %d %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
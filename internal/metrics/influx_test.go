@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEncodeLineProtocol(t *testing.T) {
+	samples := []Sample{
+		Gauge("auto_upnp_manual_mappings", "", 3, map[string]string{"state": "active"}),
+		Gauge("auto_upnp_upnp_available", "", 1, nil),
+	}
+	got := string(EncodeLineProtocol(samples, map[string]string{"host": "nas 1"}, time.Unix(1, 0)))
+	want := "auto_upnp_manual_mappings,host=nas\\ 1,state=active value=3 1000000000\n" +
+		"auto_upnp_upnp_available,host=nas\\ 1 value=1 1000000000\n"
+	if got != want {
+		t.Fatalf("行协议编码错误:\n%s\n期望:\n%s", got, want)
+	}
+}
+
+func TestInfluxPusherKeepsFailedBatches(t *testing.T) {
+	var fail atomic.Bool
+	var lastBody atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		lastBody.Store(string(body))
+		if fail.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	pusher := NewInfluxPusher(InfluxConfig{URL: server.URL, MaxPending: 2}, server.Client())
+	samples := []Sample{Gauge("m", "", 1, nil)}
+
+	fail.Store(true)
+	for i := 0; i < 3; i++ {
+		if err := pusher.Push(context.Background(), samples, time.Unix(int64(i), 0)); err == nil {
+			t.Fatal("端点失败时应返回错误")
+		}
+	}
+	status := pusher.Status()
+	if status.PendingCount != 2 || status.DroppedCount != 1 {
+		t.Fatalf("积压批次错误: %+v", status)
+	}
+
+	fail.Store(false)
+	if err := pusher.Push(context.Background(), samples, time.Unix(3, 0)); err != nil {
+		t.Fatalf("推送失败: %v", err)
+	}
+	// 积压上限为2，本次推送包含最近的一个积压批次和本次批次
+	if lines := strings.Count(lastBody.Load().(string), "\n"); lines != 2 {
+		t.Fatalf("重发的行数错误: %d", lines)
+	}
+	if status := pusher.Status(); status.PendingCount != 0 || status.LastError != "" {
+		t.Fatalf("推送成功后应清空积压: %+v", status)
+	}
+}
@@ -0,0 +1,34 @@
+package metrics
+
+// 指标类型
+const (
+	TypeGauge   = "gauge"
+	TypeCounter = "counter"
+)
+
+// Sample 一个指标采样值，各导出方式共用同一组指标定义
+type Sample struct {
+	Name   string
+	Help   string
+	Type   string
+	Labels map[string]string
+	Value  float64
+}
+
+// Gauge 创建仪表类型的采样值
+func Gauge(name, help string, value float64, labels map[string]string) Sample {
+	return Sample{Name: name, Help: help, Type: TypeGauge, Labels: labels, Value: value}
+}
+
+// Counter 创建计数器类型的采样值，值只增不减，服务重启后从0开始
+func Counter(name, help string, value float64, labels map[string]string) Sample {
+	return Sample{Name: name, Help: help, Type: TypeCounter, Labels: labels, Value: value}
+}
+
+// Bool 将布尔状态转换为0或1
+func Bool(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
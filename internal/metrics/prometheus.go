@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// sampleCollector 每次抓取时调用collect获取最新的采样值，转换为Prometheus常量指标
+type sampleCollector struct {
+	collect func() []Sample
+}
+
+// Describe 指标集合随运行状态变化，不预先声明，注册为未检查的Collector
+func (c *sampleCollector) Describe(chan<- *prometheus.Desc) {}
+
+// Collect 将采样值转换为Prometheus指标，空值的标签不输出
+func (c *sampleCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, sample := range c.collect() {
+		var names, values []string
+		for k, v := range sample.Labels {
+			if v != "" {
+				names = append(names, k)
+			}
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			values = append(values, sample.Labels[k])
+		}
+
+		desc := prometheus.NewDesc(sample.Name, sample.Help, names, nil)
+		metric, err := prometheus.NewConstMetric(desc, valueType(sample.Type), sample.Value, values...)
+		if err != nil {
+			metric = prometheus.NewInvalidMetric(desc, err)
+		}
+		ch <- metric
+	}
+}
+
+// valueType 将采样值类型转换为Prometheus指标类型
+func valueType(t string) prometheus.ValueType {
+	switch t {
+	case TypeCounter:
+		return prometheus.CounterValue
+	case TypeGauge:
+		return prometheus.GaugeValue
+	}
+	return prometheus.UntypedValue
+}
+
+// NewPrometheusHandler 创建以Prometheus格式导出采样值的HTTP处理器，格式由抓取请求协商
+// 使用独立的注册表，只导出collect返回的指标；个别指标无效时跳过并通过errorLog记录
+func NewPrometheusHandler(collect func() []Sample, errorLog promhttp.Logger) http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(&sampleCollector{collect: collect})
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+		ErrorLog:      errorLog,
+		ErrorHandling: promhttp.ContinueOnError,
+	})
+}
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrometheusHandler(t *testing.T) {
+	samples := []Sample{
+		Gauge("auto_upnp_manual_mappings", "手动映射数量", 3, map[string]string{"state": "active"}),
+		Gauge("auto_upnp_upnp_available", "UPnP是否可用", 1, nil),
+		Gauge("auto_upnp_manual_mappings", "手动映射数量", 1, map[string]string{"state": "disabled"}),
+		Counter("auto_upnp_mappings_created_total", "添加次数", 7, map[string]string{"type": "auto", "note": `a"b`}),
+	}
+	handler := NewPrometheusHandler(func() []Sample { return samples }, nil)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("未协商格式时应返回文本格式, Content-Type = %q", rec.Header().Get("Content-Type"))
+	}
+	body, _ := io.ReadAll(rec.Body)
+	got := string(body)
+
+	for _, want := range []string{
+		"# HELP auto_upnp_manual_mappings 手动映射数量\n" +
+			"# TYPE auto_upnp_manual_mappings gauge\n" +
+			"auto_upnp_manual_mappings{state=\"active\"} 3\n" +
+			"auto_upnp_manual_mappings{state=\"disabled\"} 1\n",
+		"# TYPE auto_upnp_upnp_available gauge\nauto_upnp_upnp_available 1\n",
+		"# TYPE auto_upnp_mappings_created_total counter\n" +
+			"auto_upnp_mappings_created_total{note=\"a\\\"b\",type=\"auto\"} 7\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Prometheus输出缺少:\n%s\n实际输出:\n%s", want, got)
+		}
+	}
+}
+
+func TestPrometheusHandler_SkipsInvalidSamples(t *testing.T) {
+	samples := []Sample{
+		Gauge("auto_upnp_upnp_available", "UPnP是否可用", 1, nil),
+		Gauge("invalid-name", "名称不合法", 1, nil),
+	}
+	handler := NewPrometheusHandler(func() []Sample { return samples }, nil)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != 200 {
+		t.Fatalf("个别指标无效时仍应返回200, 实际 %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "auto_upnp_upnp_available 1") {
+		t.Errorf("有效的指标应正常输出:\n%s", rec.Body.String())
+	}
+}
@@ -0,0 +1,74 @@
+package msgpack
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMarshal_Scalars(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  []byte
+	}{
+		{"nil", nil, []byte{0xc0}},
+		{"true", true, []byte{0xc3}},
+		{"positive fixint", 7, []byte{0x07}},
+		{"negative fixint", -1, []byte{0xff}},
+		{"uint8", 200, []byte{0xcc, 0xc8}},
+		{"uint16", 8080, []byte{0xcd, 0x1f, 0x90}},
+		{"int8", -100, []byte{0xd0, 0x9c}},
+		{"float64", 1.5, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{"fixstr", "TCP", []byte{0xa3, 'T', 'C', 'P'}},
+		{"fixarray", []int{1, 2}, []byte{0x92, 0x01, 0x02}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Marshal(tt.value)
+			if err != nil {
+				t.Fatalf("编码失败: %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("编码结果为 % x, 期望 % x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarshal_StructUsesJSONFieldRules(t *testing.T) {
+	type mapping struct {
+		InternalPort int    `json:"internal_port"`
+		Protocol     string `json:"protocol"`
+		Note         string `json:"note,omitempty"`
+		ignored      int
+	}
+
+	got, err := Marshal(mapping{InternalPort: 80, Protocol: "TCP", ignored: 1})
+	if err != nil {
+		t.Fatalf("编码失败: %v", err)
+	}
+
+	// 两个字段，按键排序，省略空的note
+	want := []byte{0x82}
+	want = append(want, 0xad)
+	want = append(want, "internal_port"...)
+	want = append(want, 0x50)
+	want = append(want, 0xa8)
+	want = append(want, "protocol"...)
+	want = append(want, 0xa3, 'T', 'C', 'P')
+	if !bytes.Equal(got, want) {
+		t.Errorf("编码结果为 % x, 期望 % x", got, want)
+	}
+}
+
+func TestMarshal_LongString(t *testing.T) {
+	got, err := Marshal(strings.Repeat("a", 300))
+	if err != nil {
+		t.Fatalf("编码失败: %v", err)
+	}
+	if got[0] != 0xda || got[1] != 0x01 || got[2] != 0x2c || len(got) != 303 {
+		t.Errorf("长字符串应使用str16格式: % x", got[:3])
+	}
+}
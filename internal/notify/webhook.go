@@ -0,0 +1,239 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"auto-upnp/internal/redact"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 映射事件
+const (
+	EventMappingCreated = "mapping_created"
+	EventMappingRemoved = "mapping_removed"
+	EventMappingFailed  = "mapping_failed"
+)
+
+// 默认参数
+const (
+	defaultQueueSize   = 100
+	defaultMaxAttempts = 3
+	defaultRetryDelay  = 2 * time.Second
+)
+
+// Event 推送到Webhook的映射事件
+type Event struct {
+	Event        string    `json:"event"`
+	Type         string    `json:"type"` // auto 或 manual
+	InternalPort int       `json:"internal_port"`
+	ExternalPort int       `json:"external_port"`
+	Protocol     string    `json:"protocol"`
+	Provider     string    `json:"provider"`
+	Operation    string    `json:"operation,omitempty"` // 失败的操作: add 或 remove
+	Timestamp    time.Time `json:"timestamp"`
+	Error        string    `json:"error,omitempty"`
+	Text         string    `json:"text"` // 可读的摘要，Slack等聊天工具的Incoming Webhook直接显示该字段
+}
+
+// summary 生成事件的可读摘要
+func (e Event) summary() string {
+	var action string
+	switch e.Event {
+	case EventMappingCreated:
+		action = "已添加"
+	case EventMappingRemoved:
+		action = "已删除"
+	default:
+		action = "操作失败"
+	}
+	text := fmt.Sprintf("auto-upnp: %s映射 %d -> %d/%s %s", e.Type, e.ExternalPort, e.InternalPort, e.Protocol, action)
+	if e.Error != "" {
+		text += ": " + e.Error
+	}
+	return text
+}
+
+// WebhookConfig Webhook推送参数
+type WebhookConfig struct {
+	URL         string        // 接收事件的地址，以JSON格式POST
+	Timeout     time.Duration // 单次请求超时
+	QueueSize   int           // 等待推送的事件数量上限，队列满时丢弃新事件
+	MaxAttempts int           // 每个事件最多尝试的次数
+	RetryDelay  time.Duration // 第一次重试前的等待时间，之后每次翻倍
+}
+
+// Status 推送状态
+type Status struct {
+	Sent        int       `json:"sent"`
+	Failed      int       `json:"failed"`  // 重试后仍然推送失败而放弃的事件数量
+	Dropped     int       `json:"dropped"` // 队列已满而丢弃的事件数量
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at,omitempty"`
+}
+
+// Dispatcher 在独立协程中把映射事件推送到Webhook，推送失败只记录日志，不影响映射操作
+type Dispatcher struct {
+	config WebhookConfig
+	client *http.Client
+	logger *logrus.Logger
+	queue  chan Event
+
+	mutex  sync.Mutex
+	status Status
+}
+
+// NewDispatcher 创建Webhook推送器，需要调用Run开始推送
+func NewDispatcher(cfg WebhookConfig, client *http.Client, logger *logrus.Logger) *Dispatcher {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultQueueSize
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = defaultRetryDelay
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Dispatcher{
+		config: cfg,
+		client: client,
+		logger: logger,
+		queue:  make(chan Event, cfg.QueueSize),
+	}
+}
+
+// Notify 将事件加入推送队列，不会阻塞；队列已满时丢弃事件并返回false
+func (d *Dispatcher) Notify(event Event) bool {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	if event.Text == "" {
+		event.Text = event.summary()
+	}
+
+	select {
+	case d.queue <- event:
+		return true
+	default:
+		d.mutex.Lock()
+		d.status.Dropped++
+		d.mutex.Unlock()
+		d.logger.WithFields(logrus.Fields{
+			"event":         event.Event,
+			"external_port": event.ExternalPort,
+			"protocol":      event.Protocol,
+		}).Warn("Webhook推送队列已满，丢弃映射事件")
+		return false
+	}
+}
+
+// Run 依次推送队列中的事件，直到ctx取消
+func (d *Dispatcher) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-d.queue:
+			d.deliver(ctx, event)
+		}
+	}
+}
+
+// Status 获取推送状态
+func (d *Dispatcher) Status() Status {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.status
+}
+
+// deliver 推送一个事件，失败时按指数退避重试
+func (d *Dispatcher) deliver(ctx context.Context, event Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		d.logger.WithError(err).Error("编码Webhook事件失败")
+		return
+	}
+
+	delay := d.config.RetryDelay
+	for attempt := 1; ; attempt++ {
+		err = d.send(ctx, body)
+		if err == nil {
+			d.mutex.Lock()
+			d.status.Sent++
+			d.mutex.Unlock()
+			return
+		}
+		if attempt >= d.config.MaxAttempts || ctx.Err() != nil {
+			break
+		}
+
+		d.logger.WithFields(logrus.Fields{
+			"event":   event.Event,
+			"attempt": attempt,
+			"delay":   delay,
+			"error":   err,
+		}).Debug("推送Webhook事件失败，稍后重试")
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+
+	d.mutex.Lock()
+	d.status.Failed++
+	d.status.LastError = err.Error()
+	d.status.LastErrorAt = time.Now()
+	d.mutex.Unlock()
+
+	d.logger.WithFields(logrus.Fields{
+		"event":         event.Event,
+		"external_port": event.ExternalPort,
+		"protocol":      event.Protocol,
+		"attempts":      d.config.MaxAttempts,
+	}).WithError(err).Warn("推送Webhook事件失败，已放弃")
+}
+
+// send 发送一次推送请求
+func (d *Dispatcher) send(ctx context.Context, body []byte) error {
+	if d.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.config.Timeout)
+		defer cancel()
+	}
+
+	// Webhook地址经常在路径或查询参数中携带密钥，错误会写入状态接口和日志，包装前先隐藏URL
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("创建Webhook请求失败: %w", redact.Error(err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("推送Webhook事件失败: %w", redact.Error(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("推送Webhook事件失败: HTTP %d %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
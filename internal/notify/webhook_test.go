@@ -0,0 +1,125 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestDispatcher_RetriesUntilDelivered(t *testing.T) {
+	var requests atomic.Int32
+	received := make(chan Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 前两次请求失败，第三次成功
+		if requests.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var event Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("解析事件失败: %v", err)
+		}
+		received <- event
+	}))
+	defer server.Close()
+
+	d := NewDispatcher(WebhookConfig{URL: server.URL, MaxAttempts: 3, RetryDelay: time.Millisecond}, nil, logrus.New())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	d.Notify(Event{Event: EventMappingFailed, Type: "auto", InternalPort: 8080, ExternalPort: 8080, Protocol: "TCP", Provider: "upnp", Operation: "add", Error: "ConflictInMappingEntry"})
+
+	select {
+	case event := <-received:
+		if event.Event != EventMappingFailed || event.ExternalPort != 8080 || event.Error == "" || event.Timestamp.IsZero() || event.Text == "" {
+			t.Errorf("收到的事件不完整: %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("重试后仍未收到事件")
+	}
+	// 服务端收到事件时响应可能还没有返回
+	deadline := time.Now().Add(5 * time.Second)
+	for d.Status().Sent == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if status := d.Status(); status.Sent != 1 || status.Failed != 0 {
+		t.Errorf("推送状态 = %+v，期望成功1次", status)
+	}
+}
+
+func TestDispatcher_DropsWhenQueueFull(t *testing.T) {
+	// 没有调用Run，队列不会被消费
+	d := NewDispatcher(WebhookConfig{URL: "http://127.0.0.1:1", QueueSize: 1}, nil, logrus.New())
+
+	if !d.Notify(Event{Event: EventMappingCreated}) {
+		t.Fatal("队列未满时应加入事件")
+	}
+	if d.Notify(Event{Event: EventMappingRemoved}) {
+		t.Error("队列已满时应丢弃事件而不是阻塞")
+	}
+	if dropped := d.Status().Dropped; dropped != 1 {
+		t.Errorf("丢弃的事件数量 = %d，期望1", dropped)
+	}
+}
+
+// syncBuffer 可以被多个协程同时写入的日志缓冲区
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+func TestDispatcher_DoesNotLeakWebhookURL(t *testing.T) {
+	// 服务已关闭，推送时返回带完整URL的连接错误
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	const secret = "B000-secret-key"
+
+	var logs syncBuffer
+	logger := logrus.New()
+	logger.SetOutput(&logs)
+	logger.SetLevel(logrus.DebugLevel)
+	d := NewDispatcher(WebhookConfig{URL: server.URL + "/hooks/" + secret + "?token=" + secret, MaxAttempts: 2, RetryDelay: time.Millisecond}, nil, logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	d.Notify(Event{Event: EventMappingFailed, Type: "auto", InternalPort: 8080, ExternalPort: 8080, Protocol: "TCP"})
+
+	deadline := time.Now().Add(5 * time.Second)
+	// 状态更新后才写放弃推送的日志
+	for !strings.Contains(logs.String(), "已放弃") && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	status := d.Status()
+	if status.Failed != 1 || status.LastError == "" {
+		t.Fatalf("推送状态 = %+v，期望失败1次", status)
+	}
+	if strings.Contains(status.LastError, secret) {
+		t.Errorf("LastError包含Webhook密钥: %q", status.LastError)
+	}
+	if strings.Contains(logs.String(), secret) {
+		t.Errorf("日志包含Webhook密钥:\n%s", logs.String())
+	}
+}
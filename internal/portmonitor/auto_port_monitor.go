@@ -2,8 +2,7 @@ package portmonitor
 
 import (
 	"context"
-	"fmt"
-	"net"
+	"sort"
 	"sync"
 	"time"
 
@@ -14,6 +13,7 @@ import (
 type AutoPortStatus struct {
 	Port     int
 	IsActive bool
+	State    PortState
 	LastSeen time.Time
 }
 
@@ -26,6 +26,10 @@ type AutoPortMonitor struct {
 	ctx        context.Context
 	cancel     context.CancelFunc
 	callbacks  []AutoPortStatusCallback
+	queue      *callbackQueue
+	heartbeat  func()
+	excluded   map[int]bool // 不监控的端口，例如管理服务自身占用的端口
+	lastFull   time.Time    // 上次逐端口全量扫描的时间
 
 	// 添加对象池
 	statusPool sync.Pool
@@ -33,10 +37,12 @@ type AutoPortMonitor struct {
 
 // Config 自动端口监控配置
 type Config struct {
-	CheckInterval time.Duration
-	PortRange     []int
-	Timeout       time.Duration
-	EnablePool    bool // 是否启用对象池
+	CheckInterval    time.Duration
+	PortRange        []int
+	Timeout          time.Duration
+	EnablePool       bool          // 是否启用对象池
+	FastScan         bool          // Linux下通过/proc/net/tcp一次性获取监听端口
+	FullScanInterval time.Duration // 逐端口检测时两次全量扫描的间隔，期间只检测近期活跃的端口，0表示每轮都全量扫描
 }
 
 // AutoPortStatusCallback 自动端口状态变化回调函数
@@ -53,6 +59,8 @@ func NewAutoPortMonitor(config *Config, logger *logrus.Logger) *AutoPortMonitor
 		ctx:        ctx,
 		cancel:     cancel,
 		callbacks:  make([]AutoPortStatusCallback, 0),
+		queue:      newCallbackQueue(logger),
+		excluded:   make(map[int]bool),
 	}
 
 	// 初始化对象池
@@ -74,14 +82,27 @@ func (apm *AutoPortMonitor) AddCallback(callback AutoPortStatusCallback) {
 	apm.callbacks = append(apm.callbacks, callback)
 }
 
+// SetHeartbeat 设置每轮检查完成后调用的心跳函数，需要在Start之前调用
+func (apm *AutoPortMonitor) SetHeartbeat(heartbeat func()) {
+	apm.heartbeat = heartbeat
+}
+
 // Start 启动自动端口监控
 func (apm *AutoPortMonitor) Start() {
 	apm.logger.Info("启动自动端口监控器")
 
 	// 初始化端口状态
+	apm.mutex.Lock()
 	for _, port := range apm.config.PortRange {
-		apm.portStatus[port] = apm.getStatusFromPool()
+		if apm.excluded[port] {
+			continue
+		}
+		status := apm.getStatusFromPool()
+		status.Port = port
+		status.State = PortStateFree
+		apm.portStatus[port] = status
 	}
+	apm.mutex.Unlock()
 
 	// 启动监控协程
 	go apm.monitorLoop()
@@ -104,15 +125,85 @@ func (apm *AutoPortMonitor) monitorLoop() {
 			return
 		case <-ticker.C:
 			apm.checkAllPorts()
+			if apm.heartbeat != nil {
+				apm.heartbeat()
+			}
 		}
 	}
 }
 
+// ExcludePort 停止监控端口，之后不会再为该端口触发状态变化回调
+func (apm *AutoPortMonitor) ExcludePort(port int) {
+	apm.mutex.Lock()
+	defer apm.mutex.Unlock()
+
+	apm.excluded[port] = true
+	if status, exists := apm.portStatus[port]; exists {
+		delete(apm.portStatus, port)
+		apm.putStatusToPool(status)
+	}
+	apm.logger.WithField("port", port).Info("端口已从自动端口监控中排除")
+}
+
+// IsExcluded 检查端口是否被排除在监控之外
+func (apm *AutoPortMonitor) IsExcluded(port int) bool {
+	apm.mutex.RLock()
+	defer apm.mutex.RUnlock()
+	return apm.excluded[port]
+}
+
+// GetExcludedPorts 获取被排除在监控之外的端口
+func (apm *AutoPortMonitor) GetExcludedPorts() []int {
+	apm.mutex.RLock()
+	defer apm.mutex.RUnlock()
+
+	ports := make([]int, 0, len(apm.excluded))
+	for port := range apm.excluded {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	return ports
+}
+
+// monitoredPorts 获取端口范围内需要检查的端口
+func (apm *AutoPortMonitor) monitoredPorts() []int {
+	apm.mutex.RLock()
+	defer apm.mutex.RUnlock()
+
+	if len(apm.excluded) == 0 {
+		return apm.config.PortRange
+	}
+	ports := make([]int, 0, len(apm.config.PortRange))
+	for _, port := range apm.config.PortRange {
+		if !apm.excluded[port] {
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
 // checkAllPorts 检查所有端口状态
 func (apm *AutoPortMonitor) checkAllPorts() {
+	ports := apm.monitoredPorts()
+
+	if apm.config.FastScan {
+		listeningPorts, err := readListeningTCPPorts()
+		if err == nil {
+			for _, port := range ports {
+				state := PortStateFree
+				if listeningPorts[port] {
+					state = PortStateListening
+				}
+				apm.updatePortStatus(port, state)
+			}
+			return
+		}
+		apm.logger.WithError(err).Debug("快速扫描失败，回退到逐端口检测")
+	}
+
 	var wg sync.WaitGroup
 
-	for _, port := range apm.config.PortRange {
+	for _, port := range apm.scanTargets(ports, time.Now()) {
 		wg.Add(1)
 		go func(p int) {
 			defer wg.Done()
@@ -123,11 +214,51 @@ func (apm *AutoPortMonitor) checkAllPorts() {
 	wg.Wait()
 }
 
+// scanTargets 获取本轮逐端口检测需要检查的端口
+// 配置了全量扫描间隔时，两次全量扫描之间只检查当前活跃或在间隔内活跃过的端口，
+// 新启动的服务最迟在下一次全量扫描时被发现
+func (apm *AutoPortMonitor) scanTargets(ports []int, now time.Time) []int {
+	if apm.config.FullScanInterval <= 0 {
+		return ports
+	}
+
+	apm.mutex.Lock()
+	defer apm.mutex.Unlock()
+
+	if apm.lastFull.IsZero() || now.Sub(apm.lastFull) >= apm.config.FullScanInterval {
+		apm.lastFull = now
+		return ports
+	}
+
+	targets := make([]int, 0)
+	for _, port := range ports {
+		status, exists := apm.portStatus[port]
+		if exists && (status.IsActive || now.Sub(status.LastSeen) < apm.config.FullScanInterval) {
+			targets = append(targets, port)
+		}
+	}
+	apm.logger.WithFields(logrus.Fields{
+		"targets": len(targets),
+		"total":   len(ports),
+	}).Debug("跳过全量扫描，只检测近期活跃的端口")
+	return targets
+}
+
 // checkPort 检查单个端口状态
 func (apm *AutoPortMonitor) checkPort(port int) {
-	isActive := apm.isPortActive(port)
+	apm.updatePortStatus(port, probeTCPPortState(port, apm.config.Timeout))
+}
+
+// updatePortStatus 更新端口状态，只有监听状态变化时才触发回调
+func (apm *AutoPortMonitor) updatePortStatus(port int, state PortState) {
+	isActive := state.IsListening()
 
 	apm.mutex.Lock()
+	// 检查期间端口可能已被排除
+	if apm.excluded[port] {
+		apm.mutex.Unlock()
+		return
+	}
 	status, exists := apm.portStatus[port]
 	if !exists {
 		status = apm.getStatusFromPool()
@@ -136,41 +267,36 @@ func (apm *AutoPortMonitor) checkPort(port int) {
 
 	// 检查状态是否发生变化
 	statusChanged := status.IsActive != isActive
+	previousState := status.State
 
 	if isActive {
 		status.LastSeen = time.Now()
 	}
 
 	status.IsActive = isActive
+	status.State = state
 	apm.mutex.Unlock()
 
+	if !statusChanged && previousState != state && previousState != "" {
+		apm.logger.WithFields(logrus.Fields{
+			"port":       port,
+			"prev_state": previousState,
+			"state":      state,
+		}).Debug("自动端口详细状态发生变化")
+	}
+
 	// 如果状态发生变化，触发回调
 	if statusChanged {
 		apm.logger.WithFields(logrus.Fields{
 			"port":     port,
 			"isActive": isActive,
+			"state":    state,
 		}).Info("自动端口状态发生变化")
 
 		apm.triggerCallbacks(port, isActive)
 	}
 }
 
-// isPortActive 检查端口是否活跃
-func (apm *AutoPortMonitor) isPortActive(port int) bool {
-	address := fmt.Sprintf(":%d", port)
-
-	// 尝试监听端口
-	listener, err := net.Listen("tcp", address)
-	if err != nil {
-		// 端口被占用，说明有服务在运行
-		return true
-	}
-
-	// 端口可用，关闭监听器
-	listener.Close()
-	return false
-}
-
 // triggerCallbacks 触发回调函数
 func (apm *AutoPortMonitor) triggerCallbacks(port int, isActive bool) {
 	apm.mutex.RLock()
@@ -178,16 +304,13 @@ func (apm *AutoPortMonitor) triggerCallbacks(port int, isActive bool) {
 	copy(callbacks, apm.callbacks)
 	apm.mutex.RUnlock()
 
-	for _, callback := range callbacks {
-		go func(cb AutoPortStatusCallback) {
-			defer func() {
-				if r := recover(); r != nil {
-					apm.logger.WithField("error", r).Error("自动端口状态回调函数执行出错")
-				}
-			}()
-			cb(port, isActive)
-		}(callback)
+	// 同一端口的回调按顺序执行，不同端口之间并发
+	fns := make([]func(), len(callbacks))
+	for i, callback := range callbacks {
+		callback := callback
+		fns[i] = func() { callback(port, isActive) }
 	}
+	apm.queue.enqueue(port, fns...)
 }
 
 // GetPortStatus 获取端口状态
@@ -204,6 +327,7 @@ func (apm *AutoPortMonitor) GetPortStatus(port int) (*AutoPortStatus, bool) {
 	return &AutoPortStatus{
 		Port:     status.Port,
 		IsActive: status.IsActive,
+		State:    status.State,
 		LastSeen: status.LastSeen,
 	}, true
 }
@@ -218,6 +342,7 @@ func (apm *AutoPortMonitor) GetAllPortStatus() map[int]*AutoPortStatus {
 		result[port] = &AutoPortStatus{
 			Port:     status.Port,
 			IsActive: status.IsActive,
+			State:    status.State,
 			LastSeen: status.LastSeen,
 		}
 	}
@@ -282,6 +407,7 @@ func (apm *AutoPortMonitor) putStatusToPool(status *AutoPortStatus) {
 		// 重置状态
 		status.Port = 0
 		status.IsActive = false
+		status.State = ""
 		status.LastSeen = time.Time{}
 		apm.statusPool.Put(status)
 	}
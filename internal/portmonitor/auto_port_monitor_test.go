@@ -0,0 +1,93 @@
+package portmonitor
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestAutoPortMonitor_ExcludedPortNeverReported(t *testing.T) {
+	admin, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("创建监听失败: %v", err)
+	}
+	defer admin.Close()
+	service, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("创建监听失败: %v", err)
+	}
+	defer service.Close()
+
+	adminPort := admin.Addr().(*net.TCPAddr).Port
+	servicePort := service.Addr().(*net.TCPAddr).Port
+
+	apm := NewAutoPortMonitor(&Config{
+		CheckInterval: time.Hour,
+		PortRange:     []int{adminPort, servicePort},
+		Timeout:       time.Second,
+	}, logrus.New())
+
+	var mutex sync.Mutex
+	reported := make(map[int]bool)
+	done := make(chan struct{})
+	apm.AddCallback(func(port int, isActive bool) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		reported[port] = isActive
+		if port == servicePort {
+			close(done)
+		}
+	})
+
+	apm.ExcludePort(adminPort)
+	apm.Start()
+	defer apm.Stop()
+	apm.checkAllPorts()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("未排除的端口应触发状态变化回调")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, exists := reported[adminPort]; exists {
+		t.Error("被排除的管理端口不应触发回调")
+	}
+	if _, exists := apm.GetPortStatus(adminPort); exists {
+		t.Error("被排除的管理端口不应出现在端口状态中")
+	}
+	if excluded := apm.GetExcludedPorts(); len(excluded) != 1 || excluded[0] != adminPort {
+		t.Errorf("排除的端口为 %v, 期望 [%d]", excluded, adminPort)
+	}
+}
+
+func TestAutoPortMonitor_ScanTargetsBetweenFullScans(t *testing.T) {
+	apm := NewAutoPortMonitor(&Config{
+		CheckInterval:    time.Second,
+		PortRange:        []int{18000, 18001, 18002},
+		Timeout:          time.Second,
+		FullScanInterval: time.Minute,
+	}, logrus.New())
+	apm.Start()
+	defer apm.Stop()
+
+	now := time.Now()
+	if targets := apm.scanTargets(apm.monitoredPorts(), now); len(targets) != 3 {
+		t.Fatalf("首次扫描应检查全部端口, 实际为 %v", targets)
+	}
+
+	apm.updatePortStatus(18001, PortStateListening)
+	targets := apm.scanTargets(apm.monitoredPorts(), now.Add(10*time.Second))
+	if len(targets) != 1 || targets[0] != 18001 {
+		t.Errorf("两次全量扫描之间只应检查活跃的端口, 实际为 %v", targets)
+	}
+
+	if targets := apm.scanTargets(apm.monitoredPorts(), now.Add(time.Minute)); len(targets) != 3 {
+		t.Errorf("达到全量扫描间隔后应检查全部端口, 实际为 %v", targets)
+	}
+}
@@ -0,0 +1,66 @@
+package portmonitor
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// callbackQueue 按端口串行投递状态变化回调，同一端口的回调按发生顺序执行，不同端口之间并发
+type callbackQueue struct {
+	logger  *logrus.Logger
+	mutex   sync.Mutex
+	pending map[int][]func() // 存在键表示该端口有正在运行的投递协程
+}
+
+// newCallbackQueue 创建回调队列
+func newCallbackQueue(logger *logrus.Logger) *callbackQueue {
+	return &callbackQueue{
+		logger:  logger,
+		pending: make(map[int][]func()),
+	}
+}
+
+// enqueue 将回调加入端口队列，不会阻塞调用方
+// 同一次状态变化的多个回调一起入队，保持相邻，每个回调单独执行，一个回调panic不影响其他回调
+func (q *callbackQueue) enqueue(port int, fns ...func()) {
+	q.mutex.Lock()
+	queue, running := q.pending[port]
+	q.pending[port] = append(queue, fns...)
+	q.mutex.Unlock()
+
+	if !running {
+		go q.drain(port)
+	}
+}
+
+// drain 依次执行端口队列中的回调，队列为空时退出
+func (q *callbackQueue) drain(port int) {
+	for {
+		q.mutex.Lock()
+		queue := q.pending[port]
+		if len(queue) == 0 {
+			delete(q.pending, port)
+			q.mutex.Unlock()
+			return
+		}
+		fn := queue[0]
+		q.pending[port] = queue[1:]
+		q.mutex.Unlock()
+
+		q.run(port, fn)
+	}
+}
+
+// run 执行回调，回调panic不影响后续回调
+func (q *callbackQueue) run(port int, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			q.logger.WithFields(logrus.Fields{
+				"port":  port,
+				"error": r,
+			}).Error("端口状态回调函数执行出错")
+		}
+	}()
+	fn()
+}
@@ -0,0 +1,89 @@
+package portmonitor
+
+import (
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestCallbackQueue_RapidToggleKeepsOrder(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	mpm := NewManualPortMonitor(time.Second, time.Second, logger)
+
+	const toggles = 200
+	var (
+		mutex     sync.Mutex
+		mapped    bool
+		delivered int
+		done      = make(chan struct{})
+	)
+	mpm.AddCallback(func(port int, isActive bool, protocol string) {
+		// 模拟添加/删除映射的耗时差异
+		time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		mapped = isActive
+		delivered++
+		if delivered == toggles {
+			close(done)
+		}
+	})
+
+	final := false
+	for i := 0; i < toggles; i++ {
+		final = i%2 == 0
+		mpm.triggerCallbacks(8080, final, "tcp")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("回调未全部执行")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if mapped != final {
+		t.Errorf("映射状态 = %v，期望与最终端口状态 %v 一致", mapped, final)
+	}
+}
+
+func TestCallbackQueue_PanicDoesNotBlockQueue(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	queue := newCallbackQueue(logger)
+
+	done := make(chan struct{})
+	queue.enqueue(80, func() { panic("boom") })
+	queue.enqueue(80, func() { close(done) })
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("回调panic后队列未继续执行")
+	}
+}
+
+func TestTriggerCallbacks_PanicDoesNotSkipSiblingCallbacks(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	mpm := NewManualPortMonitor(time.Second, time.Second, logger)
+
+	done := make(chan struct{})
+	mpm.AddCallback(func(port int, isActive bool, protocol string) { panic("boom") })
+	mpm.AddCallback(func(port int, isActive bool, protocol string) { close(done) })
+
+	mpm.triggerCallbacks(8080, true, "tcp")
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("前一个回调panic后，同一次状态变化的其他回调未执行")
+	}
+}
@@ -0,0 +1,160 @@
+package portmonitor
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 应用层健康检查的类型
+const (
+	HealthCheckHTTP = "http" // HTTP GET，返回期望的状态码时健康
+	HealthCheckTCP  = "tcp"  // TCP连接成功时健康
+	HealthCheckExec = "exec" // 命令退出码为0时健康
+)
+
+// defaultHealthCheckStatus HTTP健康检查默认期望的状态码
+const defaultHealthCheckStatus = http.StatusOK
+
+// HealthCheck 映射的应用层健康检查，端口在监听且检查通过时映射才视为活跃
+type HealthCheck struct {
+	Type           string   `json:"type"`
+	URL            string   `json:"url,omitempty"`             // http: 为空时请求 http://127.0.0.1:<内部端口>/
+	ExpectStatus   int      `json:"expect_status,omitempty"`   // http: 期望的状态码，为0时为200
+	Address        string   `json:"address,omitempty"`         // tcp: 为空时连接 127.0.0.1:<内部端口>
+	Command        []string `json:"command,omitempty"`         // exec: 命令及参数，不经过shell
+	TimeoutSeconds int      `json:"timeout_seconds,omitempty"` // 为0时使用端口检查的超时
+}
+
+// HealthResult 最近一次健康检查的结果
+type HealthResult struct {
+	Healthy   bool      `json:"healthy"`
+	CheckedAt time.Time `json:"checked_at"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Validate 检查健康检查配置是否完整
+func (hc *HealthCheck) Validate() error {
+	switch hc.Type {
+	case HealthCheckHTTP:
+		if hc.URL != "" {
+			u, err := url.Parse(hc.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("健康检查URL格式错误: %s", hc.URL)
+			}
+		}
+		if hc.ExpectStatus != 0 && (hc.ExpectStatus < 100 || hc.ExpectStatus > 599) {
+			return fmt.Errorf("健康检查期望的状态码无效: %d", hc.ExpectStatus)
+		}
+	case HealthCheckTCP:
+		if hc.Address != "" {
+			if _, _, err := net.SplitHostPort(hc.Address); err != nil {
+				return fmt.Errorf("健康检查地址格式错误: %s", hc.Address)
+			}
+		}
+	case HealthCheckExec:
+		if len(hc.Command) == 0 || hc.Command[0] == "" {
+			return fmt.Errorf("exec健康检查需要指定命令")
+		}
+	default:
+		return fmt.Errorf("不支持的健康检查类型: %q，可选 http、tcp、exec", hc.Type)
+	}
+	if hc.TimeoutSeconds < 0 {
+		return fmt.Errorf("健康检查超时不能为负数")
+	}
+	return nil
+}
+
+// Equal 比较两个健康检查配置是否相同，都为nil时相同
+func (hc *HealthCheck) Equal(other *HealthCheck) bool {
+	if hc == nil || other == nil {
+		return hc == other
+	}
+	return hc.Type == other.Type &&
+		hc.URL == other.URL &&
+		hc.ExpectStatus == other.ExpectStatus &&
+		hc.Address == other.Address &&
+		strings.Join(hc.Command, "\x00") == strings.Join(other.Command, "\x00") &&
+		hc.TimeoutSeconds == other.TimeoutSeconds
+}
+
+// Run 对内部端口执行健康检查，失败时返回原因
+func (hc *HealthCheck) Run(ctx context.Context, port int, timeout time.Duration) error {
+	if hc.TimeoutSeconds > 0 {
+		timeout = time.Duration(hc.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	switch hc.Type {
+	case HealthCheckHTTP:
+		return hc.runHTTP(ctx, port)
+	case HealthCheckTCP:
+		address := hc.Address
+		if address == "" {
+			address = net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+		}
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return fmt.Errorf("连接%s失败: %w", address, err)
+		}
+		return conn.Close()
+	case HealthCheckExec:
+		output, err := exec.CommandContext(ctx, hc.Command[0], hc.Command[1:]...).CombinedOutput()
+		if err != nil {
+			if text := strings.TrimSpace(string(output)); text != "" {
+				return fmt.Errorf("命令执行失败: %w: %s", err, truncate(text, 200))
+			}
+			return fmt.Errorf("命令执行失败: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("不支持的健康检查类型: %q", hc.Type)
+	}
+}
+
+// runHTTP 发送HTTP GET请求并检查状态码
+func (hc *HealthCheck) runHTTP(ctx context.Context, port int) error {
+	target := hc.URL
+	if target == "" {
+		target = fmt.Sprintf("http://127.0.0.1:%d/", port)
+	}
+	expect := hc.ExpectStatus
+	if expect == 0 {
+		expect = defaultHealthCheckStatus
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return fmt.Errorf("创建健康检查请求失败: %w", err)
+	}
+	// 不跟随重定向，否则重定向后的状态码会掩盖期望的3xx状态码
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("请求%s失败: %w", target, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != expect {
+		return fmt.Errorf("%s 返回状态码 %d，期望 %d", target, resp.StatusCode, expect)
+	}
+	return nil
+}
+
+// truncate 截断过长的输出
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max] + "..."
+}
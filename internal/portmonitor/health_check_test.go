@@ -0,0 +1,74 @@
+package portmonitor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestManualPortMonitor_HealthCheckGatesActive(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusServiceUnavailable)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	mpm := NewManualPortMonitor(time.Hour, time.Second, logrus.New())
+	defer mpm.Stop()
+	mpm.AddPort(port, "TCP")
+	mpm.SetHealthCheck(port, &HealthCheck{Type: HealthCheckHTTP, URL: server.URL + "/health"})
+
+	mpm.checkManualPort(port)
+	portStatus, _ := mpm.GetPortStatus(port)
+	if portStatus.IsActive {
+		t.Error("端口在监听但健康检查失败时不应视为活跃")
+	}
+	if portStatus.Health == nil || portStatus.Health.Healthy || portStatus.Health.Error == "" {
+		t.Errorf("健康检查结果应记录失败原因: %+v", portStatus.Health)
+	}
+
+	status.Store(http.StatusOK)
+	mpm.checkManualPort(port)
+	portStatus, _ = mpm.GetPortStatus(port)
+	if !portStatus.IsActive || portStatus.Health == nil || !portStatus.Health.Healthy {
+		t.Errorf("健康检查通过后应视为活跃: active=%v health=%+v", portStatus.IsActive, portStatus.Health)
+	}
+}
+
+func TestHealthCheck_Validate(t *testing.T) {
+	tests := []struct {
+		name  string
+		check HealthCheck
+		valid bool
+	}{
+		{"http默认地址", HealthCheck{Type: HealthCheckHTTP}, true},
+		{"http错误地址", HealthCheck{Type: HealthCheckHTTP, URL: "ftp://example.com"}, false},
+		{"http错误状态码", HealthCheck{Type: HealthCheckHTTP, ExpectStatus: 42}, false},
+		{"tcp地址", HealthCheck{Type: HealthCheckTCP, Address: "127.0.0.1:5432"}, true},
+		{"tcp缺少端口", HealthCheck{Type: HealthCheckTCP, Address: "127.0.0.1"}, false},
+		{"exec缺少命令", HealthCheck{Type: HealthCheckExec}, false},
+		{"未知类型", HealthCheck{Type: "grpc"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.check.Validate(); (err == nil) != tt.valid {
+				t.Errorf("Validate() = %v, 期望有效=%v", err, tt.valid)
+			}
+		})
+	}
+}
@@ -2,8 +2,6 @@ package portmonitor
 
 import (
 	"context"
-	"fmt"
-	"net"
 	"sync"
 	"time"
 
@@ -14,8 +12,11 @@ import (
 type ManualPortStatus struct {
 	Port     int
 	IsActive bool
+	State    PortState
 	LastSeen time.Time
 	Protocol string
+	Health   *HealthResult // 配置了健康检查时最近一次检查的结果
+	check    *HealthCheck
 }
 
 // ManualPortMonitor 手动端口监控器
@@ -28,6 +29,8 @@ type ManualPortMonitor struct {
 	callbacks     []ManualPortStatusCallback
 	checkInterval time.Duration
 	timeout       time.Duration
+	queue         *callbackQueue
+	heartbeat     func()
 }
 
 // ManualPortStatusCallback 手动端口状态变化回调函数
@@ -45,6 +48,7 @@ func NewManualPortMonitor(checkInterval, timeout time.Duration, logger *logrus.L
 		callbacks:     make([]ManualPortStatusCallback, 0),
 		checkInterval: checkInterval,
 		timeout:       timeout,
+		queue:         newCallbackQueue(logger),
 	}
 }
 
@@ -55,6 +59,11 @@ func (mpm *ManualPortMonitor) AddCallback(callback ManualPortStatusCallback) {
 	mpm.callbacks = append(mpm.callbacks, callback)
 }
 
+// SetHeartbeat 设置每轮检查完成后调用的心跳函数，需要在Start之前调用
+func (mpm *ManualPortMonitor) SetHeartbeat(heartbeat func()) {
+	mpm.heartbeat = heartbeat
+}
+
 // AddPort 添加要监控的端口
 func (mpm *ManualPortMonitor) AddPort(port int, protocol string) {
 	mpm.mutex.Lock()
@@ -64,6 +73,7 @@ func (mpm *ManualPortMonitor) AddPort(port int, protocol string) {
 		mpm.portStatus[port] = &ManualPortStatus{
 			Port:     port,
 			IsActive: false,
+			State:    PortStateFree,
 			LastSeen: time.Time{},
 			Protocol: protocol,
 		}
@@ -74,6 +84,21 @@ func (mpm *ManualPortMonitor) AddPort(port int, protocol string) {
 	}
 }
 
+// SetHealthCheck 设置端口的应用层健康检查，端口在监听且检查通过时才视为活跃，check为nil时取消检查
+func (mpm *ManualPortMonitor) SetHealthCheck(port int, check *HealthCheck) {
+	mpm.mutex.Lock()
+	defer mpm.mutex.Unlock()
+
+	status, exists := mpm.portStatus[port]
+	if !exists {
+		return
+	}
+	status.check = check
+	if check == nil {
+		status.Health = nil
+	}
+}
+
 // RemovePort 移除端口监控
 func (mpm *ManualPortMonitor) RemovePort(port int) {
 	mpm.mutex.Lock()
@@ -108,6 +133,9 @@ func (mpm *ManualPortMonitor) monitorLoop() {
 			return
 		case <-ticker.C:
 			mpm.checkAllManualPorts()
+			if mpm.heartbeat != nil {
+				mpm.heartbeat()
+			}
 		}
 	}
 }
@@ -142,9 +170,27 @@ func (mpm *ManualPortMonitor) checkManualPort(port int) {
 		return
 	}
 	protocol := status.Protocol
+	check := status.check
 	mpm.mutex.RUnlock()
 
-	isActive := mpm.isManualPortActive(port, protocol)
+	state := mpm.probeManualPortState(port, protocol)
+	isActive := state.IsListening()
+
+	// 端口在监听时再执行应用层健康检查，端口未监听时检查必然失败
+	var health *HealthResult
+	if check != nil {
+		health = &HealthResult{CheckedAt: time.Now()}
+		if isActive {
+			if err := check.Run(mpm.ctx, port, mpm.timeout); err != nil {
+				health.Error = err.Error()
+			} else {
+				health.Healthy = true
+			}
+		} else {
+			health.Error = "端口未监听"
+		}
+		isActive = health.Healthy
+	}
 
 	mpm.mutex.Lock()
 	status, exists = mpm.portStatus[port]
@@ -155,92 +201,52 @@ func (mpm *ManualPortMonitor) checkManualPort(port int) {
 
 	// 检查状态是否发生变化
 	statusChanged := status.IsActive != isActive
+	previousState := status.State
 
 	if isActive {
 		status.LastSeen = time.Now()
 	}
 
 	status.IsActive = isActive
+	status.State = state
+	if status.check != nil {
+		status.Health = health
+	}
 	mpm.mutex.Unlock()
 
+	if health != nil && !health.Healthy && state.IsListening() {
+		mpm.logger.WithFields(logrus.Fields{
+			"port":     port,
+			"protocol": protocol,
+			"error":    health.Error,
+		}).Debug("手动端口在监听但健康检查失败")
+	}
+
+	if !statusChanged && previousState != state && previousState != "" {
+		mpm.logger.WithFields(logrus.Fields{
+			"port":       port,
+			"protocol":   protocol,
+			"prev_state": previousState,
+			"state":      state,
+		}).Debug("手动端口详细状态发生变化")
+	}
+
 	// 如果状态发生变化，触发回调
 	if statusChanged {
 		mpm.logger.WithFields(logrus.Fields{
 			"port":     port,
 			"protocol": protocol,
 			"isActive": isActive,
+			"state":    state,
 		}).Info("手动端口状态发生变化")
 
 		mpm.triggerCallbacks(port, isActive, protocol)
 	}
 }
 
-// isManualPortActive 检查手动端口是否活跃
-func (mpm *ManualPortMonitor) isManualPortActive(port int, protocol string) bool {
-	address := fmt.Sprintf(":%d", port)
-
-	// 根据协议类型检查端口
-	switch protocol {
-	case "TCP":
-		return mpm.isTCPPortActive(address)
-	case "UDP":
-		return mpm.isUDPPortActive(address)
-	default:
-		// 默认检查TCP
-		return mpm.isTCPPortActive(address)
-	}
-}
-
-// isTCPPortActive 检查TCP端口是否活跃
-func (mpm *ManualPortMonitor) isTCPPortActive(address string) bool {
-	// 尝试监听端口
-	listener, err := net.Listen("tcp", address)
-	if err != nil {
-		// 端口被占用，说明有服务在运行
-		return true
-	}
-
-	// 端口可用，关闭监听器
-	listener.Close()
-	return false
-}
-
-// isUDPPortActive 检查UDP端口是否活跃
-func (mpm *ManualPortMonitor) isUDPPortActive(address string) bool {
-	// 尝试监听UDP端口
-	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: 0})
-	if err != nil {
-		// 无法创建UDP连接，可能是权限问题
-		return false
-	}
-	defer conn.Close()
-
-	// 尝试连接到目标端口
-	remoteAddr, err := net.ResolveUDPAddr("udp", address)
-	if err != nil {
-		return false
-	}
-
-	// 设置超时
-	conn.SetDeadline(time.Now().Add(mpm.timeout))
-
-	// 尝试发送数据包
-	_, err = conn.WriteToUDP([]byte("ping"), remoteAddr)
-	if err != nil {
-		// 无法发送，可能端口不可用
-		return false
-	}
-
-	// 尝试接收响应
-	buffer := make([]byte, 1024)
-	_, _, err = conn.ReadFromUDP(buffer)
-	if err != nil {
-		// 没有响应，但端口可能仍然活跃（UDP是无连接的）
-		// 这里我们假设如果无法连接，端口就是不活跃的
-		return false
-	}
-
-	return true
+// probeManualPortState 探测手动端口的详细状态
+func (mpm *ManualPortMonitor) probeManualPortState(port int, protocol string) PortState {
+	return ProbePortState(port, protocol, mpm.timeout)
 }
 
 // triggerCallbacks 触发回调函数
@@ -250,16 +256,13 @@ func (mpm *ManualPortMonitor) triggerCallbacks(port int, isActive bool, protocol
 	copy(callbacks, mpm.callbacks)
 	mpm.mutex.RUnlock()
 
-	for _, callback := range callbacks {
-		go func(cb ManualPortStatusCallback) {
-			defer func() {
-				if r := recover(); r != nil {
-					mpm.logger.WithField("error", r).Error("手动端口状态回调函数执行出错")
-				}
-			}()
-			cb(port, isActive, protocol)
-		}(callback)
+	// 同一端口的回调按顺序执行，避免快速切换时"删除后添加"被乱序执行
+	fns := make([]func(), len(callbacks))
+	for i, callback := range callbacks {
+		callback := callback
+		fns[i] = func() { callback(port, isActive, protocol) }
 	}
+	mpm.queue.enqueue(port, fns...)
 }
 
 // GetPortStatus 获取端口状态
@@ -276,8 +279,10 @@ func (mpm *ManualPortMonitor) GetPortStatus(port int) (*ManualPortStatus, bool)
 	return &ManualPortStatus{
 		Port:     status.Port,
 		IsActive: status.IsActive,
+		State:    status.State,
 		LastSeen: status.LastSeen,
 		Protocol: status.Protocol,
+		Health:   status.Health,
 	}, true
 }
 
@@ -291,8 +296,10 @@ func (mpm *ManualPortMonitor) GetAllPortStatus() map[int]*ManualPortStatus {
 		result[port] = &ManualPortStatus{
 			Port:     status.Port,
 			IsActive: status.IsActive,
+			State:    status.State,
 			LastSeen: status.LastSeen,
 			Protocol: status.Protocol,
+			Health:   status.Health,
 		}
 	}
 
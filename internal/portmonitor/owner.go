@@ -0,0 +1,168 @@
+package portmonitor
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// maxOwnerCommandLength 返回的命令行最大长度
+const maxOwnerCommandLength = 256
+
+// containerIDPattern cgroup路径中的容器ID，适用于Docker、containerd、Podman和CRI-O
+var containerIDPattern = regexp.MustCompile(`(?:docker|containerd|libpod|crio|cri-containerd)[-/:]([0-9a-f]{64})`)
+
+// PortOwner 占用本地端口的进程，无法确定时Known为false并在Reason中说明原因
+type PortOwner struct {
+	Known       bool   `json:"known"`
+	PID         int    `json:"pid,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Command     string `json:"command,omitempty"`
+	ContainerID string `json:"container_id,omitempty"` // 进程运行在容器中时的容器ID
+	Reason      string `json:"reason,omitempty"`
+}
+
+// unknownOwner 无法确定进程时的结果
+func unknownOwner(format string, args ...interface{}) *PortOwner {
+	return &PortOwner{Reason: fmt.Sprintf(format, args...)}
+}
+
+// FindPortOwner 查找监听本地端口的进程，仅支持Linux
+// 通过/proc/net找到套接字的inode，再在/proc/<pid>/fd中查找持有该套接字的进程
+func FindPortOwner(port int, protocol string) *PortOwner {
+	if runtime.GOOS != "linux" {
+		return unknownOwner("当前系统不支持查询端口所属进程: %s", runtime.GOOS)
+	}
+
+	files := procNetFiles
+	listenOnly := true
+	if strings.EqualFold(protocol, "UDP") {
+		files = []string{"/proc/net/udp", "/proc/net/udp6"}
+		listenOnly = false
+	}
+
+	inodes := make(map[string]bool)
+	for _, path := range files {
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		err = parseProcNetInodes(file, port, listenOnly, inodes)
+		file.Close()
+		if err != nil {
+			return unknownOwner("解析%s失败: %v", path, err)
+		}
+	}
+	if len(inodes) == 0 {
+		return unknownOwner("本地端口%d/%s未被监听", port, strings.ToUpper(protocol))
+	}
+
+	pid := findSocketPID(inodes)
+	if pid == 0 {
+		return unknownOwner("找不到持有端口%d的进程，可能需要root权限，或进程位于其他网络命名空间", port)
+	}
+
+	owner := &PortOwner{Known: true, PID: pid}
+	procDir := filepath.Join("/proc", strconv.Itoa(pid))
+	if comm, err := os.ReadFile(filepath.Join(procDir, "comm")); err == nil {
+		owner.Name = strings.TrimSpace(string(comm))
+	}
+	if cmdline, err := os.ReadFile(filepath.Join(procDir, "cmdline")); err == nil {
+		command := strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " "))
+		if len(command) > maxOwnerCommandLength {
+			command = command[:maxOwnerCommandLength]
+		}
+		owner.Command = command
+	}
+	if cgroup, err := os.Open(filepath.Join(procDir, "cgroup")); err == nil {
+		owner.ContainerID = parseContainerID(cgroup)
+		cgroup.Close()
+	}
+	return owner
+}
+
+// parseProcNetInodes 解析/proc/net/tcp或/proc/net/udp格式的内容，将本地端口为port的套接字inode写入inodes
+// listenOnly为true时只统计LISTEN状态的TCP套接字
+func parseProcNetInodes(r io.Reader, port int, listenOnly bool, inodes map[string]bool) error {
+	scanner := bufio.NewScanner(r)
+
+	// 跳过表头
+	if !scanner.Scan() {
+		return scanner.Err()
+	}
+
+	for scanner.Scan() {
+		// 格式: sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			return fmt.Errorf("行格式错误: %q", scanner.Text())
+		}
+		if listenOnly && fields[3] != tcpStateListen {
+			continue
+		}
+
+		sep := strings.LastIndexByte(fields[1], ':')
+		if sep < 0 {
+			return fmt.Errorf("本地地址格式错误: %q", fields[1])
+		}
+		localPort, err := strconv.ParseUint(fields[1][sep+1:], 16, 16)
+		if err != nil {
+			return fmt.Errorf("端口格式错误: %q", fields[1])
+		}
+
+		// inode为0表示套接字已关闭但仍在TIME_WAIT等状态
+		if int(localPort) == port && fields[9] != "0" {
+			inodes[fields[9]] = true
+		}
+	}
+
+	return scanner.Err()
+}
+
+// findSocketPID 在/proc/<pid>/fd中查找持有任一套接字的进程，没有权限读取的进程会被跳过
+func findSocketPID(inodes map[string]bool) int {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0
+	}
+
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+
+		fdDir := filepath.Join("/proc", entry.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			if inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")] {
+				return pid
+			}
+		}
+	}
+	return 0
+}
+
+// parseContainerID 从/proc/<pid>/cgroup中解析容器ID，不在容器中时返回空字符串
+func parseContainerID(r io.Reader) string {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if match := containerIDPattern.FindStringSubmatch(scanner.Text()); match != nil {
+			return match[1]
+		}
+	}
+	return ""
+}
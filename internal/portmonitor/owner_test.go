@@ -0,0 +1,66 @@
+package portmonitor
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseProcNetInodes(t *testing.T) {
+	inodes := make(map[string]bool)
+	if err := parseProcNetInodes(strings.NewReader(sampleProcNetTCP), 8080, true, inodes); err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	if len(inodes) != 1 || !inodes["12345"] {
+		t.Errorf("监听8080的套接字inode为 %v, 期望 [12345]", inodes)
+	}
+
+	// ESTABLISHED连接只在不要求LISTEN状态时计入
+	inodes = make(map[string]bool)
+	if err := parseProcNetInodes(strings.NewReader(sampleProcNetTCP), 54321, false, inodes); err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	if !inodes["12347"] {
+		t.Errorf("非LISTEN套接字的inode未解析: %v", inodes)
+	}
+}
+
+func TestParseContainerID(t *testing.T) {
+	id := strings.Repeat("ab12", 16)
+	tests := []struct {
+		name   string
+		cgroup string
+		want   string
+	}{
+		{"cgroup v1 docker", "12:pids:/docker/" + id + "\n", id},
+		{"cgroup v2 systemd", "0::/system.slice/docker-" + id + ".scope\n", id},
+		{"host process", "0::/user.slice/user-1000.slice/session-2.scope\n", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseContainerID(strings.NewReader(tt.cgroup)); got != tt.want {
+				t.Errorf("容器ID为 %q, 期望 %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindPortOwner_CurrentProcess(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("仅支持Linux")
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("创建监听失败: %v", err)
+	}
+	defer listener.Close()
+
+	owner := FindPortOwner(listener.Addr().(*net.TCPAddr).Port, "TCP")
+	if !owner.Known || owner.PID != os.Getpid() {
+		t.Errorf("应找到当前进程 %d, 实际为 %+v", os.Getpid(), owner)
+	}
+}
@@ -0,0 +1,124 @@
+package portmonitor
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// PortState 端口的详细状态
+type PortState string
+
+const (
+	PortStateListening PortState = "listening" // 有服务在监听
+	PortStateRefused   PortState = "refused"   // 端口被占用但拒绝连接（服务崩溃或尚未开始监听）
+	PortStateFiltered  PortState = "filtered"  // 探测无响应，可能被本地防火墙丢弃
+	PortStateFree      PortState = "free"      // 端口空闲
+)
+
+// IsListening 检查端口是否有服务在监听，只有监听状态的端口才需要映射
+func (s PortState) IsListening() bool {
+	return s == PortStateListening
+}
+
+// ProbePortState 按协议探测本机端口的详细状态
+func ProbePortState(port int, protocol string, timeout time.Duration) PortState {
+	// 根据协议类型检查端口
+	switch protocol {
+	case "UDP":
+		return probeUDPPortState(port, timeout)
+	default:
+		// 默认检查TCP
+		return probeTCPPortState(port, timeout)
+	}
+}
+
+// probeTCPPortState 探测TCP端口的详细状态
+func probeTCPPortState(port int, timeout time.Duration) PortState {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err == nil {
+		listener.Close()
+		return PortStateFree
+	}
+
+	// 端口无法绑定（被占用或无权限），通过连接确认是否真的在监听
+	return dialTCPPortState(port, timeout, errors.Is(err, syscall.EADDRINUSE))
+}
+
+// dialTCPPortState 通过连接本机端口判断状态，inUse表示端口已确认被占用
+func dialTCPPortState(port int, timeout time.Duration, inUse bool) PortState {
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), timeout)
+	if err == nil {
+		conn.Close()
+		return PortStateListening
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		if inUse {
+			return PortStateRefused
+		}
+		return PortStateFree
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return PortStateFiltered
+	default:
+		// 服务只监听在其他地址上时回环连接会失败，端口被占用即视为在监听
+		if inUse {
+			return PortStateListening
+		}
+		return PortStateFiltered
+	}
+}
+
+// probeUDPPortState 探测UDP端口的详细状态
+func probeUDPPortState(port int, timeout time.Duration) PortState {
+	conn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", port))
+	if err == nil {
+		conn.Close()
+		return PortStateFree
+	}
+	if errors.Is(err, syscall.EADDRINUSE) {
+		// UDP无连接，端口被绑定即视为有服务在监听
+		return PortStateListening
+	}
+
+	// 无权限绑定时发送探测包，根据响应判断
+	return pingUDPPortState(port, timeout)
+}
+
+// pingUDPPortState 向本机UDP端口发送探测包判断状态
+func pingUDPPortState(port int, timeout time.Duration) PortState {
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+
+	conn, err := net.Dial("udp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return PortStateFiltered
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		return PortStateFiltered
+	}
+
+	buffer := make([]byte, 1024)
+	_, err = conn.Read(buffer)
+	switch {
+	case err == nil:
+		return PortStateListening
+	case errors.Is(err, syscall.ECONNREFUSED):
+		// 收到ICMP端口不可达，说明没有服务
+		return PortStateFree
+	default:
+		return PortStateFiltered
+	}
+}
@@ -0,0 +1,25 @@
+package portmonitor
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestProbeTCPPortState(t *testing.T) {
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("创建监听失败: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	if state := probeTCPPortState(port, time.Second); state != PortStateListening {
+		t.Errorf("监听中的端口状态为 %s, 期望 %s", state, PortStateListening)
+	}
+
+	listener.Close()
+
+	if state := probeTCPPortState(port, time.Second); state != PortStateFree {
+		t.Errorf("关闭后的端口状态为 %s, 期望 %s", state, PortStateFree)
+	}
+}
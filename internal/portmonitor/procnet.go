@@ -0,0 +1,85 @@
+package portmonitor
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// procNetFiles Linux内核导出的TCP套接字表
+var procNetFiles = []string{"/proc/net/tcp", "/proc/net/tcp6"}
+
+// tcpStateListen /proc/net/tcp中LISTEN状态的十六进制值
+const tcpStateListen = "0A"
+
+// readListeningTCPPorts 一次性读取处于LISTEN状态的所有TCP端口，仅支持Linux
+func readListeningTCPPorts() (map[int]bool, error) {
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("当前系统不支持读取/proc/net/tcp: %s", runtime.GOOS)
+	}
+
+	ports := make(map[int]bool)
+	readCount := 0
+	for _, path := range procNetFiles {
+		file, err := os.Open(path)
+		if err != nil {
+			// 禁用IPv6时tcp6不存在
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("打开%s失败: %w", path, err)
+		}
+
+		err = parseProcNetTCP(file, ports)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("解析%s失败: %w", path, err)
+		}
+		readCount++
+	}
+
+	if readCount == 0 {
+		return nil, fmt.Errorf("没有可读取的/proc/net/tcp文件")
+	}
+	return ports, nil
+}
+
+// parseProcNetTCP 解析/proc/net/tcp格式的内容，将LISTEN状态的本地端口写入ports
+func parseProcNetTCP(r io.Reader, ports map[int]bool) error {
+	scanner := bufio.NewScanner(r)
+
+	// 跳过表头
+	if !scanner.Scan() {
+		return scanner.Err()
+	}
+
+	for scanner.Scan() {
+		// 格式: sl local_address rem_address st ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			return fmt.Errorf("行格式错误: %q", scanner.Text())
+		}
+
+		if fields[3] != tcpStateListen {
+			continue
+		}
+
+		// local_address格式为 十六进制IP:十六进制端口
+		sep := strings.LastIndexByte(fields[1], ':')
+		if sep < 0 {
+			return fmt.Errorf("本地地址格式错误: %q", fields[1])
+		}
+
+		port, err := strconv.ParseUint(fields[1][sep+1:], 16, 16)
+		if err != nil {
+			return fmt.Errorf("端口格式错误: %q", fields[1])
+		}
+		ports[int(port)] = true
+	}
+
+	return scanner.Err()
+}
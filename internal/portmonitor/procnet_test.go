@@ -0,0 +1,70 @@
+package portmonitor
+
+import (
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const sampleProcNetTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 12345 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:0CEA 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 12346 1 0000000000000000 100 0 0 10 0
+   2: 0F02000A:D431 8E2E5ED8:01BB 01 00000000:00000000 02:00000B1A 00000000  1000        0 12347 2 0000000000000000 20 4 30 10 -1
+`
+
+func TestParseProcNetTCP(t *testing.T) {
+	ports := make(map[int]bool)
+	if err := parseProcNetTCP(strings.NewReader(sampleProcNetTCP), ports); err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+
+	if !ports[8080] || !ports[3306] {
+		t.Errorf("LISTEN端口未解析: %v", ports)
+	}
+	// ESTABLISHED连接的本地端口不应计入
+	if ports[54321] {
+		t.Error("非LISTEN状态的端口不应计入")
+	}
+}
+
+func newBenchmarkMonitor(fastScan bool) *AutoPortMonitor {
+	ports := make([]int, 0, 5000)
+	for port := 40000; port < 45000; port++ {
+		ports = append(ports, port)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	return NewAutoPortMonitor(&Config{
+		CheckInterval: time.Minute,
+		PortRange:     ports,
+		Timeout:       time.Second,
+		FastScan:      fastScan,
+	}, logger)
+}
+
+func BenchmarkCheckAllPorts_Probe(b *testing.B) {
+	apm := newBenchmarkMonitor(false)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		apm.checkAllPorts()
+	}
+}
+
+func BenchmarkCheckAllPorts_ProcNet(b *testing.B) {
+	if runtime.GOOS != "linux" {
+		b.Skip("快速扫描仅支持Linux")
+	}
+	apm := newBenchmarkMonitor(true)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		apm.checkAllPorts()
+	}
+}
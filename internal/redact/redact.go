@@ -0,0 +1,33 @@
+// Package redact 去掉错误和日志中URL携带的凭据
+//
+// DDNS和Webhook的地址经常在路径或查询参数中携带令牌，net/http返回的*url.Error会在错误信息中带上完整URL，
+// 写入状态接口或日志前需要先去掉这些部分。
+package redact
+
+import (
+	"errors"
+	"net/url"
+)
+
+// URL 只保留URL的协议和主机，去掉用户信息、路径和查询参数，无法解析时整体隐藏
+func URL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "[已隐藏]"
+	}
+	redacted := u.Scheme + "://" + u.Host
+	if u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+		redacted += "/..."
+	}
+	return redacted
+}
+
+// Error 隐藏*url.Error中的完整URL，其他错误原样返回
+// 只处理err本身是*url.Error的情况，调用者需要在包装错误之前调用
+func Error(err error) error {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) || err != error(urlErr) {
+		return err
+	}
+	return &url.Error{Op: urlErr.Op, URL: URL(urlErr.URL), Err: urlErr.Err}
+}
@@ -3,10 +3,19 @@ package service
 import (
 	"context"
 	"fmt"
+	"net"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
 	"auto-upnp/config"
+	"auto-upnp/internal/ddns"
+	"auto-upnp/internal/externalip"
+	"auto-upnp/internal/liveness"
+	"auto-upnp/internal/mdns"
+	"auto-upnp/internal/metrics"
+	"auto-upnp/internal/notify"
 	"auto-upnp/internal/portmonitor"
 	"auto-upnp/internal/upnp"
 
@@ -15,17 +24,37 @@ import (
 
 // AutoUPnPService 自动UPnP服务
 type AutoUPnPService struct {
-	config            *config.Config
-	logger            *logrus.Logger
-	autoPortMonitor   *portmonitor.AutoPortMonitor
-	manualPortMonitor *portmonitor.ManualPortMonitor
-	upnpManager       *upnp.UPnPManager
-	manualManager     *ManualMappingManager
-	ctx               context.Context
-	cancel            context.CancelFunc
-	wg                sync.WaitGroup
-	activeMappings    map[int]bool
-	mappingMutex      sync.RWMutex
+	config             *config.Config
+	logger             *logrus.Logger
+	autoPortMonitor    *portmonitor.AutoPortMonitor
+	manualPortMonitor  *portmonitor.ManualPortMonitor
+	upnpManager        *upnp.UPnPManager
+	manualManager      *ManualMappingManager
+	ipResolver         *externalip.Resolver
+	ddnsUpdater        *ddns.Updater
+	ddnsTrigger        chan struct{}
+	mdnsResponder      *mdns.Responder
+	mdnsTrigger        chan struct{}
+	influxPusher       *metrics.InfluxPusher
+	notifier           *notify.Dispatcher // 映射事件的Webhook推送，未配置时为nil
+	heartbeats         *liveness.Registry
+	ctx                context.Context
+	cancel             context.CancelFunc
+	wg                 sync.WaitGroup
+	activeMappings     map[int]bool
+	autoMappingStats   map[int]*MappingStats
+	autoCorrelationIDs map[int]string // 自动映射的关联ID，受mappingMutex保护
+	excludedAutoPorts  map[int]bool   // 排除在自动映射之外的端口，受mappingMutex保护
+	mappingMutex       sync.RWMutex
+	manualMutex        sync.Mutex // 串行化手动映射的添加和删除，保证替换操作的原子性
+	pendingRemovals    map[string]*PendingRemoval
+	pendingMutex       sync.Mutex
+	lastDrift          *DriftReport // 最近一次影子校验的结果
+	driftMutex         sync.Mutex
+	statusChanges      *statusNotifier    // 状态长轮询的变化通知
+	lastWANReassert    *WANReassertResult // 最近一次WAN地址变化后重新写入映射的结果
+	wanMutex           sync.Mutex
+	mappingEvents      mappingEvents // 映射添加、删除和失败的累计次数
 }
 
 // NewAutoUPnPService 创建新的自动UPnP服务
@@ -34,14 +63,24 @@ func NewAutoUPnPService(cfg *config.Config, logger *logrus.Logger) *AutoUPnPServ
 
 	// 创建手动映射管理器，使用admin.data_dir
 	manualManager := NewManualMappingManager(cfg.Admin.DataDir, logger)
+	statusChanges := newStatusNotifier()
+	manualManager.OnChange(statusChanges.notify)
 
 	return &AutoUPnPService{
-		config:         cfg,
-		logger:         logger,
-		manualManager:  manualManager,
-		ctx:            ctx,
-		cancel:         cancel,
-		activeMappings: make(map[int]bool),
+		config:             cfg,
+		logger:             logger,
+		manualManager:      manualManager,
+		ctx:                ctx,
+		cancel:             cancel,
+		activeMappings:     make(map[int]bool),
+		autoMappingStats:   make(map[int]*MappingStats),
+		autoCorrelationIDs: make(map[int]string),
+		excludedAutoPorts:  make(map[int]bool),
+		pendingRemovals:    make(map[string]*PendingRemoval),
+		heartbeats:         liveness.NewRegistry(logger),
+		ddnsTrigger:        make(chan struct{}, 1),
+		mdnsTrigger:        make(chan struct{}, 1),
+		statusChanges:      statusChanges,
 	}
 }
 
@@ -49,6 +88,19 @@ func NewAutoUPnPService(cfg *config.Config, logger *logrus.Logger) *AutoUPnPServ
 func (as *AutoUPnPService) Start() error {
 	as.logger.Info("启动自动UPnP服务")
 
+	// 启动顺序: 映射存储 -> UPnP管理器 -> 接管遗留映射 -> 手动映射恢复 -> 端口监控
+	// 自动映射依赖已加载的手动映射判断外部端口占用，所以自动端口监控在手动映射恢复之后启动
+
+	// 加载手动映射存储，加载失败时保留文件不被覆盖，之后的保存也会失败
+	if err := as.manualManager.LoadMappings(); err != nil {
+		as.logger.WithError(err).Warn("加载手动映射失败")
+	}
+
+	// 映射事件通知需要在恢复映射之前启动，否则启动期间的映射事件不会推送
+	if as.config.Notifications.WebhookURL != "" {
+		as.startNotifications()
+	}
+
 	// 初始化UPnP管理器
 	upnpConfig := &upnp.Config{
 		DiscoveryTimeout:    as.config.UPnP.DiscoveryTimeout,
@@ -59,9 +111,24 @@ func (as *AutoUPnPService) Start() error {
 		HealthCheckInterval: as.config.UPnP.HealthCheckInterval,
 		MaxFailCount:        as.config.UPnP.MaxFailCount,
 		KeepAliveInterval:   as.config.UPnP.KeepAliveInterval,
+		EnableIPv6Pinhole:   as.config.UPnP.EnableIPv6Pinhole,
+		UserAgent:           as.config.UPnP.UserAgent,
+		RemoveOnShutdown:    as.config.UPnP.RemoveOnShutdown,
+		InstanceID:          as.config.UPnP.InstanceID,
+		BindAddress:         as.config.Network.BindAddress,
+		ControlURL:          as.config.UPnP.ControlURL,
+		GatewayURLs:         as.config.UPnP.GatewayURLs,
+		GatewayPolicy:       as.config.UPnP.GatewayPolicy,
+		GatewayWeights:      as.config.UPnP.GatewayWeightMap(),
+		DryRun:              as.config.UPnP.DryRun,
 	}
 
 	as.upnpManager = upnp.NewUPnPManager(upnpConfig, as.logger)
+	as.heartbeats.Register(SubsystemUPnPHealthCheck, upnpConfig.HealthCheckInterval)
+	as.upnpManager.SetHeartbeat(func() { as.heartbeats.Beat(SubsystemUPnPHealthCheck) })
+	if !as.config.UPnP.RemoveManualOnShutdown {
+		as.upnpManager.SetKeepOnShutdown(as.isManualRouterMapping)
+	}
 
 	// 发现UPnP设备
 	if err := as.upnpManager.Discover(); err != nil {
@@ -69,22 +136,33 @@ func (as *AutoUPnPService) Start() error {
 		// 不返回错误，继续运行服务
 	}
 
+	// 接管上次运行留在路由器上的映射，需要在恢复手动映射和启动端口监控之前完成
+	as.adoptLeftoverMappings()
+
 	timeout := as.config.Monitor.CheckInterval
 
 	// 初始化自动端口监控器
 	autoPortConfig := &portmonitor.Config{
-		CheckInterval: as.config.Monitor.CheckInterval,
-		PortRange:     as.config.GetPortRange(),
-		Timeout:       timeout,
+		CheckInterval:    as.config.Monitor.CheckInterval,
+		PortRange:        as.config.GetPortRange(),
+		Timeout:          timeout,
+		FastScan:         as.config.Monitor.FastScan,
+		FullScanInterval: as.config.Monitor.FullScanInterval,
 	}
 
-	as.autoPortMonitor = portmonitor.NewAutoPortMonitor(autoPortConfig, as.logger)
+	autoPortMonitor := portmonitor.NewAutoPortMonitor(autoPortConfig, as.logger)
+	// 启动前调用ExcludeAutoPort排除的端口在第一次扫描前生效
+	as.mappingMutex.Lock()
+	for port := range as.excludedAutoPorts {
+		autoPortMonitor.ExcludePort(port)
+	}
+	as.autoPortMonitor = autoPortMonitor
+	as.mappingMutex.Unlock()
 
 	// 添加自动端口状态变化回调
 	as.autoPortMonitor.AddCallback(as.onAutoPortStatusChanged)
-
-	// 启动自动端口监控
-	as.autoPortMonitor.Start()
+	as.heartbeats.Register(SubsystemAutoPortMonitor, as.config.Monitor.CheckInterval)
+	as.autoPortMonitor.SetHeartbeat(func() { as.heartbeats.Beat(SubsystemAutoPortMonitor) })
 
 	// 初始化手动端口监控器
 	as.manualPortMonitor = portmonitor.NewManualPortMonitor(
@@ -95,21 +173,71 @@ func (as *AutoUPnPService) Start() error {
 
 	// 添加手动端口状态变化回调
 	as.manualPortMonitor.AddCallback(as.onManualPortStatusChanged)
+	as.heartbeats.Register(SubsystemManualPortMonitor, as.config.Monitor.CheckInterval)
+	as.manualPortMonitor.SetHeartbeat(func() { as.heartbeats.Beat(SubsystemManualPortMonitor) })
 
 	// 启动手动端口监控
 	as.manualPortMonitor.Start()
 
+	// 恢复手动映射
+	if err := as.restoreManualMappings(); err != nil {
+		as.logger.WithError(err).Warn("恢复手动映射失败")
+	}
+
+	// 手动映射恢复后再启动自动端口监控
+	as.autoPortMonitor.Start()
+
 	// 启动清理协程
+	as.heartbeats.Register(SubsystemCleanup, as.config.Monitor.CleanupInterval)
 	as.wg.Add(1)
 	go as.cleanupRoutine()
 
 	// 启动UPnP重试协程
+	_, maxRetryDelay := as.discoveryRetryBounds()
+	as.heartbeats.Register(SubsystemUPnPRetry, maxRetryDelay)
 	as.wg.Add(1)
 	go as.upnpRetryRoutine()
 
-	// 加载并恢复手动映射
-	if err := as.restoreManualMappings(); err != nil {
-		as.logger.WithError(err).Warn("恢复手动映射失败")
+	// 启动映射影子校验协程
+	if as.config.UPnP.DriftCheckInterval > 0 {
+		as.heartbeats.Register(SubsystemDrift, as.config.UPnP.DriftCheckInterval)
+		as.wg.Add(1)
+		go as.driftRoutine()
+	}
+
+	// 启动公网IP刷新协程
+	as.ipResolver = as.newExternalIPResolver()
+	as.ipResolver.OnChange(func(*externalip.Result) { as.notifyStatusChanged() })
+
+	// 路由器WAN地址变化时重新写入映射，依赖上面创建的公网IP解析器
+	as.upnpManager.SetWANChangeHandler(as.onWANChange)
+
+	// 启动DDNS协程，公网IP变化时更新解析
+	ddnsUpdater, err := as.newDDNSUpdater()
+	if err != nil {
+		as.logger.WithError(err).Error("DDNS配置错误，不更新动态域名")
+	} else if ddnsUpdater != nil {
+		as.ddnsUpdater = ddnsUpdater
+		as.ipResolver.OnChange(as.notifyDDNS)
+		as.heartbeats.Register(SubsystemDDNS, as.ddnsInterval())
+		as.wg.Add(1)
+		go as.ddnsRoutine()
+	}
+
+	as.heartbeats.Register(SubsystemExternalIP, as.externalIPInterval())
+	as.wg.Add(1)
+	go as.externalIPRoutine()
+
+	// 启动指标推送
+	if as.config.Exporters.InfluxDB.Enabled {
+		as.startInfluxExporter()
+	}
+
+	// 启动mDNS广播
+	if as.config.MDNS.Enabled {
+		if err := as.startMDNS(); err != nil {
+			as.logger.WithError(err).Warn("启动mDNS广播失败")
+		}
 	}
 
 	as.logger.Info("自动UPnP服务启动完成")
@@ -133,6 +261,9 @@ func (as *AutoUPnPService) Stop() {
 	// 取消上下文
 	as.cancel()
 
+	// 取消等待中的延迟删除
+	as.cancelAllRemovals()
+
 	// 等待所有协程完成
 	as.wg.Wait()
 
@@ -148,20 +279,32 @@ func (as *AutoUPnPService) Stop() {
 func (as *AutoUPnPService) onAutoPortStatusChanged(port int, isActive bool) {
 	as.mappingMutex.Lock()
 	defer as.mappingMutex.Unlock()
+	defer as.notifyStatusChanged()
 
 	// 处理自动映射
 	if isActive {
+		// 宽限期内恢复的端口，映射仍然存在
+		as.cancelRemoval("auto", port, port, "TCP")
+
 		// 端口变为活跃状态，添加UPnP映射
 		if !as.activeMappings[port] {
-			as.logger.WithField("port", port).Info("检测到自动端口上线，添加UPnP映射")
+			// 排除前已经排队的回调
+			if as.autoPortMonitor != nil && as.autoPortMonitor.IsExcluded(port) {
+				return
+			}
+			if as.manualHoldsExternalPort(port, "TCP") {
+				as.logger.WithField("port", port).Info("外部端口被优先级更高的手动映射占用，跳过自动映射")
+				return
+			}
+
+			entry := as.autoLogEntry(port)
+			entry.Info("检测到自动端口上线，添加UPnP映射")
 
 			description := fmt.Sprintf("AutoUPnP-%d", port)
-			err := as.upnpManager.AddPortMapping(port, port, "TCP", description)
+			err := as.upnpManager.AddPortMapping(port, port, "TCP", description, "")
+			as.recordAutoMappingAttempt(port, err)
 			if err != nil {
-				as.logger.WithFields(logrus.Fields{
-					"port":  port,
-					"error": err,
-				}).Error("添加自动UPnP端口映射失败")
+				entry.WithError(err).Error("添加自动UPnP端口映射失败")
 
 				// 添加重试机制
 				go as.retryAddMapping(port, description)
@@ -169,62 +312,113 @@ func (as *AutoUPnPService) onAutoPortStatusChanged(port int, isActive bool) {
 			}
 
 			as.activeMappings[port] = true
-			as.logger.WithField("port", port).Info("自动UPnP端口映射添加成功")
+			entry.Info("自动UPnP端口映射添加成功")
 		}
 	} else {
 		// 端口变为非活跃状态，删除UPnP映射
 		if as.activeMappings[port] {
-			as.logger.WithField("port", port).Info("检测到自动端口下线，删除UPnP映射")
+			scheduled := as.scheduleRemoval(&PendingRemoval{
+				Type:         "auto",
+				InternalPort: port,
+				ExternalPort: port,
+				Protocol:     "TCP",
+			}, func() {
+				as.mappingMutex.Lock()
+				defer as.mappingMutex.Unlock()
+				if as.activeMappings[port] {
+					as.removeAutoMapping(port)
+				}
+			})
+			if !scheduled {
+				as.removeAutoMapping(port)
+			}
+		}
+	}
+}
 
-			err := as.upnpManager.RemovePortMapping(port, port, "TCP")
-			if err != nil {
-				as.logger.WithFields(logrus.Fields{
-					"port":  port,
-					"error": err,
-				}).Error("删除自动UPnP端口映射失败")
+// ExcludeAutoPort 将端口排除在自动映射之外，已经注册的自动映射会被删除
+// 管理服务监听在监控端口范围内时调用，避免把管理界面映射到公网；服务启动前调用时在启动监控前生效
+func (as *AutoUPnPService) ExcludeAutoPort(port int) {
+	as.mappingMutex.Lock()
+	defer as.mappingMutex.Unlock()
 
-				// 添加重试机制
-				go as.retryRemoveMapping(port)
-				return
-			}
+	as.excludedAutoPorts[port] = true
+	if as.autoPortMonitor == nil {
+		return
+	}
+	as.autoPortMonitor.ExcludePort(port)
 
-			delete(as.activeMappings, port)
-			as.logger.WithField("port", port).Info("自动UPnP端口映射删除成功")
-		}
+	as.cancelRemoval("auto", port, port, "TCP")
+	if as.activeMappings[port] {
+		as.removeAutoMapping(port)
 	}
 }
 
+// removeAutoMapping 删除自动映射（调用者需要持有mappingMutex）
+func (as *AutoUPnPService) removeAutoMapping(port int) {
+	entry := as.autoLogEntry(port)
+	entry.Info("检测到自动端口下线，删除UPnP映射")
+
+	err := as.upnpManager.RemovePortMapping(port, port, "TCP")
+	as.recordMappingRemoval("auto", port, port, "TCP", err)
+	if err != nil {
+		entry.WithError(err).Error("删除自动UPnP端口映射失败")
+
+		// 添加重试机制
+		go as.retryRemoveMapping(port)
+		return
+	}
+
+	delete(as.activeMappings, port)
+	delete(as.autoCorrelationIDs, port)
+	entry.Info("自动UPnP端口映射删除成功")
+}
+
 // retryAddMapping 重试添加映射
 func (as *AutoUPnPService) retryAddMapping(port int, description string) {
+	as.mappingMutex.Lock()
+	entry := as.autoLogEntry(port)
+	as.mappingMutex.Unlock()
+
 	maxRetries := 3
 	retryDelay := time.Second * 5
 
 	for i := 0; i < maxRetries; i++ {
 		time.Sleep(retryDelay)
 
-		err := as.upnpManager.AddPortMapping(port, port, "TCP", description)
+		if as.manualHoldsExternalPort(port, "TCP") {
+			entry.Info("外部端口被优先级更高的手动映射占用，停止重试自动映射")
+			return
+		}
+
+		err := as.upnpManager.AddPortMapping(port, port, "TCP", description, "")
+		as.mappingMutex.Lock()
+		as.recordAutoMappingAttempt(port, err)
+		as.mappingMutex.Unlock()
 		if err == nil {
 			as.mappingMutex.Lock()
 			as.activeMappings[port] = true
 			as.mappingMutex.Unlock()
 
-			as.logger.WithField("port", port).Info("重试添加UPnP映射成功")
+			entry.Info("重试添加UPnP映射成功")
 			return
 		}
 
-		as.logger.WithFields(logrus.Fields{
-			"port":       port,
+		entry.WithError(err).WithFields(logrus.Fields{
 			"attempt":    i + 1,
 			"maxRetries": maxRetries,
-			"error":      err,
 		}).Warn("重试添加UPnP映射失败")
 	}
 
-	as.logger.WithField("port", port).Error("重试添加UPnP映射最终失败")
+	entry.Error("重试添加UPnP映射最终失败")
 }
 
 // retryRemoveMapping 重试删除映射
 func (as *AutoUPnPService) retryRemoveMapping(port int) {
+	as.mappingMutex.Lock()
+	entry := as.autoLogEntry(port)
+	as.mappingMutex.Unlock()
+
 	maxRetries := 3
 	retryDelay := time.Second * 5
 
@@ -232,24 +426,24 @@ func (as *AutoUPnPService) retryRemoveMapping(port int) {
 		time.Sleep(retryDelay)
 
 		err := as.upnpManager.RemovePortMapping(port, port, "TCP")
+		as.recordMappingRemoval("auto", port, port, "TCP", err)
 		if err == nil {
 			as.mappingMutex.Lock()
 			delete(as.activeMappings, port)
+			delete(as.autoCorrelationIDs, port)
 			as.mappingMutex.Unlock()
 
-			as.logger.WithField("port", port).Info("重试删除UPnP映射成功")
+			entry.Info("重试删除UPnP映射成功")
 			return
 		}
 
-		as.logger.WithFields(logrus.Fields{
-			"port":       port,
+		entry.WithError(err).WithFields(logrus.Fields{
 			"attempt":    i + 1,
 			"maxRetries": maxRetries,
-			"error":      err,
 		}).Warn("重试删除UPnP映射失败")
 	}
 
-	as.logger.WithField("port", port).Error("重试删除UPnP映射最终失败")
+	entry.Error("重试删除UPnP映射最终失败")
 }
 
 // onManualPortStatusChanged 手动端口状态变化回调
@@ -265,12 +459,15 @@ func (as *AutoUPnPService) onPortStatusChanged(port int, isActive bool) {
 
 	// 处理自动映射
 	if isActive {
+		// 宽限期内恢复的端口，映射仍然存在
+		as.cancelRemoval("auto", port, port, "TCP")
+
 		// 端口变为活跃状态，添加UPnP映射
 		if !as.activeMappings[port] {
 			as.logger.WithField("port", port).Info("检测到端口上线，添加UPnP映射")
 
 			description := fmt.Sprintf("AutoUPnP-%d", port)
-			err := as.upnpManager.AddPortMapping(port, port, "TCP", description)
+			err := as.upnpManager.AddPortMapping(port, port, "TCP", description, "")
 			if err != nil {
 				as.logger.WithFields(logrus.Fields{
 					"port":  port,
@@ -307,11 +504,16 @@ func (as *AutoUPnPService) onPortStatusChanged(port int, isActive bool) {
 
 // handleManualMappingStatus 处理手动映射的状态变化
 func (as *AutoUPnPService) handleManualMappingStatus(port int, isActive bool) {
+	defer as.notifyStatusChanged()
+
 	// 获取所有手动映射
 	manualMappings := as.manualManager.GetMappings()
 
 	for _, mapping := range manualMappings {
 		if mapping.InternalPort == port {
+			// GetMappings返回的是副本，记录更新前的激活状态
+			wasActive := mapping.Active
+
 			// 更新映射的激活状态
 			err := as.manualManager.UpdateMappingActiveStatus(
 				mapping.InternalPort,
@@ -329,71 +531,99 @@ func (as *AutoUPnPService) handleManualMappingStatus(port int, isActive bool) {
 				continue
 			}
 
+			// 被停用的映射只跟踪端口状态，不注册到路由器
+			if mapping.Disabled {
+				continue
+			}
+
+			// 宽限期内恢复的端口，路由器上的映射仍然存在
+			if isActive && !wasActive && as.cancelRemoval("manual", mapping.InternalPort, mapping.ExternalPort, mapping.Protocol) {
+				continue
+			}
+
+			// 预留映射在端口下线期间仍保留在路由器上，上线时只需打开IPv6针孔
+			if isActive && !wasActive && mapping.Reserved && as.upnpManager.HasPortMapping(mapping.InternalPort, mapping.CurrentExternalPort(), mapping.Protocol) {
+				as.logger.WithFields(mapping.logFields()).Info("预留映射的本地端口上线")
+				as.openPinhole(mapping.InternalPort, mapping.ExternalPort, mapping.Protocol, mapping.RemoteHost)
+				continue
+			}
+
 			// 如果端口上线且映射之前是非激活状态，尝试重新注册UPnP映射
-			if isActive && !mapping.Active {
-				as.logger.WithFields(logrus.Fields{
-					"internal_port": mapping.InternalPort,
-					"external_port": mapping.ExternalPort,
-					"protocol":      mapping.Protocol,
-				}).Info("手动映射端口恢复，重新注册UPnP映射")
-
-				err := as.upnpManager.AddPortMapping(
-					mapping.InternalPort,
-					mapping.ExternalPort,
-					mapping.Protocol,
-					mapping.Description,
-				)
+			if isActive && !wasActive {
+				as.logger.WithFields(mapping.logFields()).Info("手动映射端口恢复，重新注册UPnP映射")
+
+				err := as.addManualUPnPMapping(mapping)
 				if err != nil {
-					as.logger.WithFields(logrus.Fields{
-						"internal_port": mapping.InternalPort,
-						"external_port": mapping.ExternalPort,
-						"protocol":      mapping.Protocol,
-						"error":         err,
-					}).Error("重新注册手动映射UPnP失败")
+					as.logger.WithFields(mapping.logFields()).WithError(err).Error("重新注册手动映射UPnP失败")
 				} else {
-					as.logger.WithFields(logrus.Fields{
-						"internal_port": mapping.InternalPort,
-						"external_port": mapping.ExternalPort,
-						"protocol":      mapping.Protocol,
-					}).Info("手动映射UPnP重新注册成功")
+					as.logger.WithFields(mapping.logFields()).Info("手动映射UPnP重新注册成功")
 				}
+
+				as.openPinhole(mapping.InternalPort, mapping.ExternalPort, mapping.Protocol, mapping.RemoteHost)
+			}
+
+			// 预留映射在端口下线后继续占用外部端口
+			if !isActive && wasActive && mapping.Reserved {
+				as.logger.WithFields(mapping.logFields()).Info("预留映射的本地端口下线，保留路由器上的映射")
+				as.closePinhole(mapping.InternalPort, mapping.ExternalPort, mapping.Protocol)
+				continue
 			}
 
 			// 如果端口下线且映射之前是激活状态，取消UPnP映射
-			if !isActive && mapping.Active {
-				as.logger.WithFields(logrus.Fields{
-					"internal_port": mapping.InternalPort,
-					"external_port": mapping.ExternalPort,
-					"protocol":      mapping.Protocol,
-				}).Info("手动映射端口下线，取消UPnP映射")
-
-				err := as.upnpManager.RemovePortMapping(
-					mapping.InternalPort,
-					mapping.ExternalPort,
-					mapping.Protocol,
-				)
-				if err != nil {
-					as.logger.WithFields(logrus.Fields{
-						"internal_port": mapping.InternalPort,
-						"external_port": mapping.ExternalPort,
-						"protocol":      mapping.Protocol,
-						"error":         err,
-					}).Error("取消手动映射UPnP失败")
-				} else {
-					as.logger.WithFields(logrus.Fields{
-						"internal_port": mapping.InternalPort,
-						"external_port": mapping.ExternalPort,
-						"protocol":      mapping.Protocol,
-					}).Info("手动映射UPnP取消成功")
+			if !isActive && wasActive {
+				mapping := mapping
+				scheduled := as.scheduleRemoval(&PendingRemoval{
+					Type:         "manual",
+					InternalPort: mapping.InternalPort,
+					ExternalPort: mapping.ExternalPort,
+					Protocol:     mapping.Protocol,
+				}, func() {
+					as.removeOfflineManualMapping(mapping)
+				})
+				if !scheduled {
+					as.cancelManualUPnPMapping(mapping)
 				}
 			}
 		}
 	}
 }
 
+// removeOfflineManualMapping 宽限期结束后取消手动映射在路由器上的注册
+// 宽限期内映射可能已被删除、重新添加、停用或端口已经恢复，持有manualMutex重新检查后再删除
+func (as *AutoUPnPService) removeOfflineManualMapping(mapping *ManualMapping) {
+	as.manualMutex.Lock()
+	defer as.manualMutex.Unlock()
+
+	current, exists := as.manualManager.GetMapping(mapping.InternalPort, mapping.ExternalPort, mapping.Protocol)
+	if !exists || current.CorrelationID != mapping.CorrelationID || current.Active || current.Disabled || current.Reserved {
+		return
+	}
+	as.cancelManualUPnPMapping(current)
+}
+
+// cancelManualUPnPMapping 端口下线时取消手动映射在路由器上的注册，保留本地记录
+func (as *AutoUPnPService) cancelManualUPnPMapping(mapping *ManualMapping) {
+	as.logger.WithFields(mapping.logFields()).Info("手动映射端口下线，取消UPnP映射")
+
+	err := as.upnpManager.RemovePortMapping(
+		mapping.InternalPort,
+		mapping.CurrentExternalPort(),
+		mapping.Protocol,
+	)
+	as.recordMappingRemoval("manual", mapping.InternalPort, mapping.CurrentExternalPort(), mapping.Protocol, err)
+	if err != nil {
+		as.logger.WithFields(mapping.logFields()).WithError(err).Error("取消手动映射UPnP失败")
+	} else {
+		as.logger.WithFields(mapping.logFields()).Info("手动映射UPnP取消成功")
+	}
+
+	as.closePinhole(mapping.InternalPort, mapping.ExternalPort, mapping.Protocol)
+}
+
 // cleanupRoutine 清理协程
 func (as *AutoUPnPService) cleanupRoutine() {
 	defer as.wg.Done()
+	defer as.heartbeats.Recover(SubsystemCleanup)
 
 	ticker := time.NewTicker(as.config.Monitor.CleanupInterval)
 	defer ticker.Stop()
@@ -404,6 +634,7 @@ func (as *AutoUPnPService) cleanupRoutine() {
 			return
 		case <-ticker.C:
 			as.cleanupExpiredMappings()
+			as.heartbeats.Beat(SubsystemCleanup)
 		}
 	}
 }
@@ -414,6 +645,7 @@ func (as *AutoUPnPService) cleanupExpiredMappings() {
 
 	// 清理UPnP管理器中的过期映射
 	as.upnpManager.CleanupExpiredMappings()
+	as.releaseLeftoverMappings()
 
 	// 检查本地记录的映射状态
 	as.mappingMutex.Lock()
@@ -427,31 +659,121 @@ func (as *AutoUPnPService) cleanupExpiredMappings() {
 			delete(as.activeMappings, port)
 		}
 	}
+
+	as.resetSustainedMappingStats()
 }
 
-// upnpRetryRoutine UPnP重试协程
+// upnpRetryRoutine UPnP不可用时按指数退避重新发现设备，发现成功后重置为最大间隔
+// UPnP可用时按最大间隔重新发现，以便重试待处理的映射
 func (as *AutoUPnPService) upnpRetryRoutine() {
 	defer as.wg.Done()
+	defer as.heartbeats.Recover(SubsystemUPnPRetry)
 
-	// 每5分钟尝试重新发现UPnP设备
-	ticker := time.NewTicker(5 * time.Minute)
-	defer ticker.Stop()
+	minDelay, maxDelay := as.discoveryRetryBounds()
+	failures := 0
+	delay := maxDelay
+	if !as.upnpManager.IsUPnPAvailable() {
+		delay = minDelay
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
 
 	for {
 		select {
 		case <-as.ctx.Done():
 			return
-		case <-ticker.C:
-			// 检查是否有活跃的端口映射需要处理
-			activePorts := as.autoPortMonitor.GetActivePorts()
-			if len(activePorts) > 0 {
-				as.logger.Info("检测到活跃端口，尝试重新发现UPnP设备")
-				if err := as.upnpManager.Discover(); err != nil {
-					as.logger.WithError(err).Debug("UPnP设备发现失败，继续等待")
-				} else {
-					as.logger.Info("UPnP设备重新发现成功")
+		case <-timer.C:
+		}
+
+		available := as.upnpManager.IsUPnPAvailable()
+		// UPnP可用时只在有活跃端口时重新发现，不可用时无论是否有活跃端口都尝试，避免路由器重启后映射迟迟不能恢复
+		if !available || len(as.autoPortMonitor.GetActivePorts()) > 0 {
+			if err := as.upnpManager.Discover(); err != nil {
+				failures++
+				delay = discoveryBackoff(failures, minDelay, maxDelay)
+				as.logger.WithError(err).WithFields(logrus.Fields{
+					"failures":   failures,
+					"next_retry": delay,
+				}).Debug("UPnP设备发现失败，稍后重试")
+			} else {
+				if !available {
+					as.logger.WithField("failures", failures).Info("UPnP设备重新发现成功")
+					as.notifyStatusChanged()
 				}
+				failures = 0
+				delay = maxDelay
+				as.retryPendingMappings()
 			}
+		} else {
+			failures = 0
+			delay = maxDelay
+		}
+		as.heartbeats.Beat(SubsystemUPnPRetry)
+		timer.Reset(delay)
+	}
+}
+
+// discoveryRetryBounds 重新发现的最小和最大间隔，未配置时使用默认值
+func (as *AutoUPnPService) discoveryRetryBounds() (time.Duration, time.Duration) {
+	minDelay, maxDelay := as.config.UPnP.DiscoveryRetryMin, as.config.UPnP.DiscoveryRetryMax
+	if minDelay <= 0 {
+		minDelay = 5 * time.Second
+	}
+	if maxDelay <= 0 {
+		maxDelay = 5 * time.Minute
+	}
+	if maxDelay < minDelay {
+		maxDelay = minDelay
+	}
+	return minDelay, maxDelay
+}
+
+// discoveryBackoff 第failures次连续失败后的重试间隔：从最小间隔开始每次翻倍，不超过最大间隔
+func discoveryBackoff(failures int, minDelay, maxDelay time.Duration) time.Duration {
+	delay := minDelay
+	for i := 1; i < failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		return maxDelay
+	}
+	return delay
+}
+
+// RediscoverUPnP 立即重新发现UPnP设备并重试待处理的映射，返回客户端数量
+func (as *AutoUPnPService) RediscoverUPnP() (int, error) {
+	if as.upnpManager == nil {
+		return 0, fmt.Errorf("UPnP管理器未初始化")
+	}
+
+	clientCount, err := as.upnpManager.Rediscover()
+	defer as.notifyStatusChanged()
+	if err != nil {
+		return clientCount, err
+	}
+
+	as.retryPendingMappings()
+	return clientCount, nil
+}
+
+// retryPendingMappings 重试端口活跃但尚未在路由器上生效的映射
+func (as *AutoUPnPService) retryPendingMappings() {
+	// 自动映射：回调内部会跳过已映射的端口
+	if as.autoPortMonitor != nil {
+		for _, port := range as.autoPortMonitor.GetActivePorts() {
+			as.onAutoPortStatusChanged(port, true)
+		}
+	}
+
+	// 手动映射
+	for _, mapping := range as.manualManager.GetActiveMappings() {
+		if mapping.Disabled || as.upnpManager.HasPortMapping(mapping.InternalPort, mapping.CurrentExternalPort(), mapping.Protocol) {
+			continue
+		}
+
+		if err := as.addManualUPnPMapping(mapping); err != nil {
+			as.logger.WithError(err).WithFields(mapping.logFields()).Warn("重试手动映射UPnP失败")
 		}
 	}
 }
@@ -466,15 +788,25 @@ func (as *AutoUPnPService) GetStatus() map[string]interface{} {
 	var activePorts []int
 	var inactivePorts []int
 
+	// 各部分分别从一次快照中派生，保证数量与列表一致
+	excludedPorts := make([]int, 0)
 	if as.autoPortMonitor != nil {
 		autoPortStatus = as.autoPortMonitor.GetAllPortStatus()
-		activePorts = as.autoPortMonitor.GetActivePorts()
-		inactivePorts = as.autoPortMonitor.GetInactivePorts()
+		excludedPorts = as.autoPortMonitor.GetExcludedPorts()
 	} else {
 		autoPortStatus = make(map[int]*portmonitor.AutoPortStatus)
-		activePorts = []int{}
-		inactivePorts = []int{}
 	}
+	activePorts = []int{}
+	inactivePorts = []int{}
+	for port, status := range autoPortStatus {
+		if status.IsActive {
+			activePorts = append(activePorts, port)
+		} else {
+			inactivePorts = append(inactivePorts, port)
+		}
+	}
+	sort.Ints(activePorts)
+	sort.Ints(inactivePorts)
 
 	// 获取UPnP映射状态
 	var upnpMappings map[string]*upnp.PortMapping
@@ -496,12 +828,17 @@ func (as *AutoUPnPService) GetStatus() map[string]interface{} {
 	var inactiveManualMappings []*ManualMapping
 	if as.manualManager != nil {
 		manualMappings = as.manualManager.GetMappings()
-		activeManualMappings = as.manualManager.GetActiveMappings()
-		inactiveManualMappings = as.manualManager.GetInactiveMappings()
 	} else {
 		manualMappings = []*ManualMapping{}
-		activeManualMappings = []*ManualMapping{}
-		inactiveManualMappings = []*ManualMapping{}
+	}
+	activeManualMappings = []*ManualMapping{}
+	inactiveManualMappings = []*ManualMapping{}
+	for _, mapping := range manualMappings {
+		if mapping.Active {
+			activeManualMappings = append(activeManualMappings, mapping)
+		} else {
+			inactiveManualMappings = append(inactiveManualMappings, mapping)
+		}
 	}
 
 	// 获取UPnP客户端数量
@@ -512,12 +849,33 @@ func (as *AutoUPnPService) GetStatus() map[string]interface{} {
 		upnpClientCount = 0
 	}
 
+	// 获取各网关上的映射分布
+	gatewayUsage := []upnp.GatewayUsage{}
+	if as.upnpManager != nil {
+		gatewayUsage = as.upnpManager.GetGatewayUsage()
+	}
+
+	// 获取IPv6针孔状态
+	var pinholes map[string]*upnp.Pinhole
+	var pinholeAvailable bool
+	if as.upnpManager != nil {
+		pinholes = as.upnpManager.GetPinholes()
+		pinholeAvailable = as.upnpManager.IsPinholeAvailable()
+	} else {
+		pinholes = make(map[string]*upnp.Pinhole)
+	}
+
 	return map[string]interface{}{
 		"service_status": "running",
+		"snapshot_at":    time.Now(),
+		"dry_run":        as.config.UPnP.DryRun,
 		"port_range": map[string]interface{}{
-			"start": as.config.PortRange.Start,
-			"end":   as.config.PortRange.End,
-			"step":  as.config.PortRange.Step,
+			"start":      as.config.PortRange.Start,
+			"end":        as.config.PortRange.End,
+			"step":       as.config.PortRange.Step,
+			"port_count": as.config.PortCount(),
+			"max_ports":  as.config.PortRange.MaxPorts,
+			"excluded":   excludedPorts,
 		},
 		"port_status": map[string]interface{}{
 			"total_ports":         len(autoPortStatus),
@@ -530,6 +888,7 @@ func (as *AutoUPnPService) GetStatus() map[string]interface{} {
 			"total_mappings":  len(upnpMappings),
 			"active_mappings": activeMappings,
 			"mappings":        upnpMappings,
+			"stats":           as.autoMappingStatsSnapshot(),
 		},
 		"manual_mappings": map[string]interface{}{
 			"total_mappings":         len(manualMappings),
@@ -540,26 +899,55 @@ func (as *AutoUPnPService) GetStatus() map[string]interface{} {
 			"inactive_mappings_list": inactiveManualMappings,
 		},
 		"upnp_status": map[string]interface{}{
-			"client_count": upnpClientCount,
-			"available":    upnpClientCount > 0,
-			"discovered":   as.upnpManager != nil && len(upnpMappings) > 0,
+			"client_count":   upnpClientCount,
+			"available":      upnpClientCount > 0,
+			"discovered":     as.upnpManager != nil && len(upnpMappings) > 0,
+			"gateway_policy": as.config.UPnP.GatewayPolicy,
+			"gateways":       gatewayUsage,
+		},
+		"ipv6_pinholes": map[string]interface{}{
+			"enabled":        as.config.UPnP.EnableIPv6Pinhole,
+			"available":      pinholeAvailable,
+			"total_pinholes": len(pinholes),
+			"pinholes":       pinholes,
 		},
+		"pending_removals": as.GetPendingRemovals(),
+		"external_ip":      as.externalIPStatus(),
+		"wan":              as.wanStatus(),
+		"ddns":             as.ddnsStatus(),
+		"mdns":             as.mdnsStatus(),
+		"influxdb":         as.influxStatus(),
+		"notifications":    as.notificationStatus(),
+		"subsystems":       as.GetSubsystemStatus(),
 		"config": map[string]interface{}{
-			"check_interval":   as.config.Monitor.CheckInterval.String(),
-			"cleanup_interval": as.config.Monitor.CleanupInterval.String(),
-			"mapping_duration": as.config.UPnP.MappingDuration.String(),
-			"max_mappings":     as.config.Monitor.MaxMappings,
+			"instance_id":         as.instanceID(),
+			"check_interval":      as.config.Monitor.CheckInterval.String(),
+			"cleanup_interval":    as.config.Monitor.CleanupInterval.String(),
+			"mapping_duration":    as.config.UPnP.MappingDuration.String(),
+			"max_mappings":        as.config.Monitor.MaxMappings,
+			"remove_grace_period": as.config.Monitor.RemoveGracePeriod.String(),
 		},
 	}
 }
 
-// restoreManualMappings 恢复手动映射
-func (as *AutoUPnPService) restoreManualMappings() error {
-	// 加载手动映射文件
-	if err := as.manualManager.LoadMappings(); err != nil {
-		return fmt.Errorf("加载手动映射失败: %w", err)
+// instanceID 获取实例标识，UPnP管理器未初始化时返回配置值
+func (as *AutoUPnPService) instanceID() string {
+	if as.upnpManager != nil {
+		return as.upnpManager.InstanceID()
 	}
+	return as.config.UPnP.InstanceID
+}
+
+// 手动映射恢复结果
+const (
+	restoreResultMapped   = "mapped"    // 已在路由器上注册
+	restoreResultWaiting  = "waiting"   // 端口未上线或映射已停用，跳过注册
+	restoreResultFailed   = "failed"    // 注册失败
+	restoreResultTimedOut = "timed_out" // 超时，注册在后台继续进行
+)
 
+// restoreManualMappings 恢复手动映射，按upnp.restore_concurrency并发注册，单个映射缓慢或失败不会拖慢其他映射
+func (as *AutoUPnPService) restoreManualMappings() error {
 	// 获取所有手动映射
 	mappings := as.manualManager.GetMappings()
 	if len(mappings) == 0 {
@@ -567,72 +955,265 @@ func (as *AutoUPnPService) restoreManualMappings() error {
 		return nil
 	}
 
-	as.logger.Infof("开始恢复 %d 个手动映射", len(mappings))
+	concurrency := as.config.UPnP.RestoreConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	as.logger.Infof("开始恢复 %d 个手动映射，并发数 %d", len(mappings), concurrency)
+	start := time.Now()
 
-	// 恢复每个映射
+	results := make(chan string, len(mappings))
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
 	for _, mapping := range mappings {
-		// 检查端口当前状态
-		var isPortActive bool
-		if as.manualPortMonitor != nil {
-			status, exists := as.manualPortMonitor.GetPortStatus(mapping.InternalPort)
-			isPortActive = exists && status.IsActive
+		mapping := mapping
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results <- as.restoreManualMappingWithTimeout(mapping)
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	counts := make(map[string]int)
+	for result := range results {
+		counts[result]++
+	}
+	as.logger.WithFields(logrus.Fields{
+		"total":     len(mappings),
+		"restored":  counts[restoreResultMapped],
+		"skipped":   counts[restoreResultWaiting],
+		"failed":    counts[restoreResultFailed],
+		"timed_out": counts[restoreResultTimedOut],
+		"duration":  time.Since(start).Round(time.Millisecond).String(),
+	}).Info("手动映射恢复完成")
+
+	return nil
+}
+
+// restoreManualMappingWithTimeout 恢复单个手动映射，超过upnp.restore_timeout时不再等待
+func (as *AutoUPnPService) restoreManualMappingWithTimeout(mapping *ManualMapping) string {
+	timeout := as.config.UPnP.RestoreTimeout
+	if timeout <= 0 {
+		return as.restoreManualMapping(mapping)
+	}
+
+	done := make(chan string, 1)
+	go func() {
+		done <- as.restoreManualMapping(mapping)
+	}()
+
+	select {
+	case result := <-done:
+		return result
+	case <-time.After(timeout):
+		as.logger.WithFields(mapping.logFields()).WithField("timeout", timeout).Warn("恢复手动映射超时，继续恢复其他映射")
+		return restoreResultTimedOut
+	}
+}
+
+// restoreManualMapping 按端口当前状态恢复单个手动映射
+func (as *AutoUPnPService) restoreManualMapping(mapping *ManualMapping) string {
+	// 检查端口当前状态
+	var isPortActive bool
+	if as.manualPortMonitor != nil {
+		status, exists := as.manualPortMonitor.GetPortStatus(mapping.InternalPort)
+		isPortActive = exists && status.IsActive
+	}
+
+	// 更新映射的激活状态
+	if err := as.manualManager.UpdateMappingActiveStatus(
+		mapping.InternalPort,
+		mapping.ExternalPort,
+		mapping.Protocol,
+		isPortActive,
+	); err != nil {
+		as.logger.WithError(err).WithFields(mapping.logFields()).Warn("更新手动映射激活状态失败")
+	}
+
+	// 添加到手动端口监控器
+	if as.manualPortMonitor != nil {
+		as.manualPortMonitor.AddPort(mapping.InternalPort, mapping.Protocol)
+	}
+	as.applyHealthCheck(mapping)
+
+	// 只有当端口活跃（或映射为预留映射）且映射未被停用时才注册UPnP映射
+	if (!isPortActive && !mapping.Reserved) || mapping.Disabled {
+		as.logger.WithFields(mapping.logFields()).WithFields(logrus.Fields{
+			"active":   isPortActive,
+			"disabled": mapping.Disabled,
+		}).Info("手动映射端口非活跃或已停用，等待端口上线")
+		return restoreResultWaiting
+	}
+
+	result := restoreResultMapped
+	if err := as.addManualUPnPMapping(mapping); err != nil {
+		as.logger.WithError(err).WithFields(mapping.logFields()).Warn("恢复手动映射UPnP失败")
+		result = restoreResultFailed
+	} else {
+		as.logger.WithFields(logrus.Fields{
+			"internal_port": mapping.InternalPort,
+			"external_port": mapping.ExternalPort,
+			"protocol":      mapping.Protocol,
+			"active":        isPortActive,
+		}).Info("成功恢复手动映射")
+	}
+
+	if isPortActive {
+		as.openPinhole(mapping.InternalPort, mapping.ExternalPort, mapping.Protocol, mapping.RemoteHost)
+	}
+	return result
+}
+
+// 手动映射添加结果的状态
+const (
+	ManualMappingStatusMapped   = "mapped"   // 已在路由器上注册
+	ManualMappingStatusWaiting  = "waiting"  // 本地端口未上线，上线后自动注册
+	ManualMappingStatusReserved = "reserved" // 本地端口未上线，已在路由器上预留外部端口
+)
+
+// ManualMappingResult 添加手动映射的结果
+type ManualMappingResult struct {
+	Provider        string         `json:"provider"`
+	InternalPort    int            `json:"internal_port"`
+	ExternalPort    int            `json:"external_port"`              // 当前生效的外部端口，备用端口生效时与请求的端口不同
+	ExternalAddress string         `json:"external_address,omitempty"` // 公网地址，公网IP尚未获取时为空
+	Protocol        string         `json:"protocol"`
+	Status          string         `json:"status"`
+	Existing        bool           `json:"existing,omitempty"` // 幂等添加时映射已存在，未做任何修改
+	Mapping         *ManualMapping `json:"mapping"`
+}
+
+// AddManualMapping 手动添加端口映射
+func (as *AutoUPnPService) AddManualMapping(internalPort, externalPort int, protocol, description string) (*ManualMappingResult, error) {
+	return as.AddManualMappingWithOptions(internalPort, externalPort, protocol, description, ManualMappingOptions{})
+}
+
+// AddManualMappingWithOptions 手动添加带可选参数的端口映射
+// 外部端口被优先级更低的本服务映射占用时抢占该端口，被抢占的手动映射切换到备用端口或等待端口释放；
+// 被其他映射占用时返回MappingConflictError，设置opts.Replace时先删除冲突映射再添加
+func (as *AutoUPnPService) AddManualMappingWithOptions(internalPort, externalPort int, protocol, description string, opts ManualMappingOptions) (*ManualMappingResult, error) {
+	as.manualMutex.Lock()
+	defer as.manualMutex.Unlock()
+
+	protocol, err := NormalizeProtocol(protocol)
+	if err != nil {
+		return nil, err
+	}
+	if err := as.validateMappingPorts(internalPort, externalPort, opts.BackupExternalPort); err != nil {
+		return nil, err
+	}
+	if opts.Scheme, err = NormalizeScheme(opts.Scheme); err != nil {
+		return nil, err
+	}
+	if opts.Scheme == "" {
+		opts.Scheme = InferScheme(internalPort, externalPort, protocol)
+	}
+	if opts.RemoteHost, err = NormalizeRemoteHost(opts.RemoteHost); err != nil {
+		return nil, err
+	}
+	if opts.Priority == 0 {
+		opts.Priority = DefaultManualPriority
+	}
+	if description == "" {
+		description = fmt.Sprintf("Manual-%d", internalPort)
+	}
+	if err := as.validateHealthCheck(opts.HealthCheck); err != nil {
+		return nil, err
+	}
+
+	// 重复添加：参数相同时按幂等处理，参数不同时总是报错
+	if existing, exists := as.manualManager.GetMapping(internalPort, externalPort, protocol); exists {
+		key := fmt.Sprintf("%d:%d:%s", internalPort, externalPort, protocol)
+		if !existing.matches(description, opts) {
+			return nil, fmt.Errorf("%w: %s", ErrMappingMismatch, key)
 		}
+		if !opts.Idempotent {
+			return nil, fmt.Errorf("%w: %s", ErrMappingExists, key)
+		}
+		as.logger.WithFields(existing.logFields()).Debug("映射已存在，幂等添加返回已有映射")
+		result, err := as.manualMappingResult(internalPort, externalPort, protocol)
+		if err == nil {
+			result.Existing = true
+		}
+		return result, err
+	}
 
-		// 更新映射的激活状态
-		if err := as.manualManager.UpdateMappingActiveStatus(
-			mapping.InternalPort,
-			mapping.ExternalPort,
-			mapping.Protocol,
-			isPortActive,
-		); err != nil {
-			as.logger.WithError(err).WithFields(logrus.Fields{
-				"internal_port": mapping.InternalPort,
-				"external_port": mapping.ExternalPort,
-				"protocol":      mapping.Protocol,
-			}).Warn("更新手动映射激活状态失败")
+	var evicted *MappingConflict
+	conflict := as.findMappingConflict(internalPort, externalPort, protocol)
+	if conflict != nil {
+		switch {
+		case conflict.evictableBy(opts.Priority):
+			if err := as.evictMapping(conflict, opts.Priority); err != nil {
+				return nil, fmt.Errorf("抢占低优先级映射失败: %w", err)
+			}
+			evicted, conflict = conflict, nil
+		case !opts.Replace || !conflict.Replaceable:
+			return nil, &MappingConflictError{Conflict: conflict}
+		default:
+			if err := as.resolveMappingConflict(conflict); err != nil {
+				return nil, fmt.Errorf("删除冲突映射失败: %w", err)
+			}
 		}
+	}
 
-		// 添加到手动端口监控器
-		if as.manualPortMonitor != nil {
-			as.manualPortMonitor.AddPort(mapping.InternalPort, mapping.Protocol)
+	err = as.addManualMapping(internalPort, externalPort, protocol, description, opts)
+	if err == nil {
+		if evicted != nil {
+			as.rehomeEvictedMapping(evicted)
 		}
+		as.notifyMDNS()
+		return as.manualMappingResult(internalPort, externalPort, protocol)
+	}
 
-		// 只有当端口活跃时才注册UPnP映射
-		if isPortActive {
-			if err := as.upnpManager.AddPortMapping(
-				mapping.InternalPort,
-				mapping.ExternalPort,
-				mapping.Protocol,
-				mapping.Description,
-			); err != nil {
-				as.logger.WithError(err).WithFields(logrus.Fields{
-					"internal_port": mapping.InternalPort,
-					"external_port": mapping.ExternalPort,
-					"protocol":      mapping.Protocol,
-				}).Warn("恢复手动映射UPnP失败")
-			} else {
-				as.logger.WithFields(logrus.Fields{
-					"internal_port": mapping.InternalPort,
-					"external_port": mapping.ExternalPort,
-					"protocol":      mapping.Protocol,
-					"active":        isPortActive,
-				}).Info("成功恢复手动映射")
-			}
-		} else {
-			as.logger.WithFields(logrus.Fields{
-				"internal_port": mapping.InternalPort,
-				"external_port": mapping.ExternalPort,
-				"protocol":      mapping.Protocol,
-				"active":        isPortActive,
-			}).Info("手动映射端口非活跃，等待端口上线")
+	// 路由器拒绝映射时撤销本地记录，以便调用方选择替换或更换端口
+	err = as.routerConflictFromError(internalPort, externalPort, protocol, err)
+	if _, isConflict := IsMappingConflict(err); isConflict || conflict != nil || evicted != nil {
+		if removeErr := as.removeManualMapping(internalPort, externalPort, protocol); removeErr != nil {
+			as.logger.WithError(removeErr).Warn("撤销手动映射失败")
 		}
 	}
+	if conflict != nil {
+		as.restoreReplacedMapping(conflict)
+	}
+	if evicted != nil {
+		as.reclaimExternalPort(externalPort, protocol)
+	}
+	return nil, err
+}
 
-	return nil
+// manualMappingResult 根据映射的当前记录生成添加结果
+func (as *AutoUPnPService) manualMappingResult(internalPort, externalPort int, protocol string) (*ManualMappingResult, error) {
+	mapping, exists := as.manualManager.GetMapping(internalPort, externalPort, protocol)
+	if !exists {
+		return nil, fmt.Errorf("%w: %d:%d:%s", ErrMappingNotFound, internalPort, externalPort, protocol)
+	}
+
+	result := &ManualMappingResult{
+		Provider:     ProviderUPnP,
+		InternalPort: mapping.InternalPort,
+		ExternalPort: mapping.CurrentExternalPort(),
+		Protocol:     mapping.Protocol,
+		Status:       ManualMappingStatusWaiting,
+		Mapping:      mapping,
+	}
+	switch {
+	case mapping.Active:
+		result.Status = ManualMappingStatusMapped
+	case mapping.Reserved:
+		result.Status = ManualMappingStatusReserved
+	}
+	if ip := as.externalIPStatus(); ip != nil {
+		result.ExternalAddress = net.JoinHostPort(ip.IP, strconv.Itoa(result.ExternalPort))
+	}
+	return result, nil
 }
 
-// AddManualMapping 手动添加端口映射
-func (as *AutoUPnPService) AddManualMapping(internalPort, externalPort int, protocol, description string) error {
+// addManualMapping 添加手动映射（调用者需要持有manualMutex）
+func (as *AutoUPnPService) addManualMapping(internalPort, externalPort int, protocol, description string, opts ManualMappingOptions) error {
 	if description == "" {
 		description = fmt.Sprintf("Manual-%d", internalPort)
 	}
@@ -645,7 +1226,7 @@ func (as *AutoUPnPService) AddManualMapping(internalPort, externalPort int, prot
 	}
 
 	// 保存到手动映射管理器（包含激活状态）
-	if err := as.manualManager.AddMapping(internalPort, externalPort, protocol, description); err != nil {
+	if err := as.manualManager.AddMappingWithOptions(internalPort, externalPort, protocol, description, opts); err != nil {
 		return err
 	}
 
@@ -659,56 +1240,257 @@ func (as *AutoUPnPService) AddManualMapping(internalPort, externalPort int, prot
 		as.manualPortMonitor.AddPort(internalPort, protocol)
 	}
 
-	// 只有当端口活跃时才添加到UPnP管理器
-	if isPortActive {
-		if err := as.upnpManager.AddPortMapping(internalPort, externalPort, protocol, description); err != nil {
-			as.logger.WithError(err).Warn("添加UPnP映射失败，但已保存手动映射")
+	mapping, _ := as.manualManager.GetMapping(internalPort, externalPort, protocol)
+	as.applyHealthCheck(mapping)
+
+	// 只有当端口活跃或映射为预留映射时才添加到UPnP管理器
+	if isPortActive || opts.Reserved {
+		if err := as.addManualUPnPMapping(mapping); err != nil {
+			as.logger.WithFields(mapping.logFields()).WithError(err).Warn("添加UPnP映射失败，但已保存手动映射")
 			return err
 		}
-		as.logger.WithFields(logrus.Fields{
-			"internal_port": internalPort,
-			"external_port": externalPort,
-			"protocol":      protocol,
-			"active":        isPortActive,
-		}).Info("成功添加手动映射并注册UPnP")
+
+		// 双栈网络下同时打开IPv6针孔，映射注册成功后再打开，避免注册失败时留下针孔
+		if isPortActive {
+			as.openPinhole(internalPort, externalPort, protocol, mapping.RemoteHost)
+		}
+		as.logger.WithFields(mapping.logFields()).WithField("active", isPortActive).Info("成功添加手动映射并注册UPnP")
 	} else {
-		as.logger.WithFields(logrus.Fields{
-			"internal_port": internalPort,
-			"external_port": externalPort,
-			"protocol":      protocol,
-			"active":        isPortActive,
-		}).Info("添加手动映射，等待端口上线")
+		as.logger.WithFields(mapping.logFields()).WithField("active", isPortActive).Info("添加手动映射，等待端口上线")
 	}
 
 	return nil
 }
 
+// UpdateManualMappingNote 更新手动映射的本地备注，不会修改路由器上的映射
+func (as *AutoUPnPService) UpdateManualMappingNote(internalPort, externalPort int, protocol, note string) (*ManualMapping, error) {
+	return as.manualManager.UpdateMappingNote(internalPort, externalPort, protocol, note)
+}
+
+// RemoveMappingOptions 删除手动映射的可选参数
+type RemoveMappingOptions struct {
+	Force bool // 路由器删除失败时也删除本地记录，路由器已不可用时使用
+}
+
 // RemoveManualMapping 手动删除端口映射
 func (as *AutoUPnPService) RemoveManualMapping(internalPort, externalPort int, protocol string) error {
-	// 从UPnP管理器中删除（如果存在）
-	if err := as.upnpManager.RemovePortMapping(internalPort, externalPort, protocol); err != nil {
-		as.logger.WithError(err).Warn("删除UPnP映射失败，但继续删除手动映射")
+	return as.RemoveManualMappingWithOptions(internalPort, externalPort, protocol, RemoveMappingOptions{})
+}
+
+// RemoveManualMappingWithOptions 删除带可选参数的手动映射
+// 路由器删除及其退避重试在manualMutex之外进行，重试期间不阻塞其他手动映射的添加和删除
+func (as *AutoUPnPService) RemoveManualMappingWithOptions(internalPort, externalPort int, protocol string, opts RemoveMappingOptions) error {
+	defer as.notifyMDNS()
+
+	mapping, exists := as.manualManager.GetMapping(internalPort, externalPort, protocol)
+	if !exists {
+		return fmt.Errorf("%w: %d:%d:%s", ErrMappingNotFound, internalPort, externalPort, protocol)
 	}
 
-	// 从手动映射管理器中删除
-	if err := as.manualManager.RemoveMapping(internalPort, externalPort, protocol); err != nil {
+	// 被抢占的映射在外部端口释放后重新占用
+	liveExternalPort := mapping.CurrentExternalPort()
+	if err := as.removeManualRouterMapping(mapping, opts.Force); err != nil {
 		return err
 	}
 
+	as.manualMutex.Lock()
+	defer as.manualMutex.Unlock()
+
+	if err := as.forgetManualMapping(mapping); err != nil {
+		return err
+	}
+	as.reclaimExternalPort(liveExternalPort, protocol)
+	return nil
+}
+
+// removeManualMapping 删除手动映射（调用者需要持有manualMutex）
+func (as *AutoUPnPService) removeManualMapping(internalPort, externalPort int, protocol string) error {
+	mapping, exists := as.manualManager.GetMapping(internalPort, externalPort, protocol)
+	if !exists {
+		return fmt.Errorf("%w: %d:%d:%s", ErrMappingNotFound, internalPort, externalPort, protocol)
+	}
+
+	if err := as.removeManualRouterMapping(mapping, false); err != nil {
+		return err
+	}
+	return as.forgetManualMapping(mapping)
+}
+
+// removeManualRouterMapping 从路由器删除手动映射，路由器删除失败时按指数退避重试
+// 路由器仍保留映射时返回错误，由调用方保留本地记录，避免路由器上留下无人管理的映射；force为true时只尝试一次，失败后也丢弃路由器映射的本地记录
+func (as *AutoUPnPService) removeManualRouterMapping(mapping *ManualMapping, force bool) error {
+	// 备用端口生效时，路由器上的映射使用的是备用端口
+	liveExternalPort := mapping.CurrentExternalPort()
+
+	remove := as.upnpManager.RemovePortMappingWithRetry
+	if force {
+		remove = as.upnpManager.RemovePortMapping
+	}
+	err := remove(mapping.InternalPort, liveExternalPort, mapping.Protocol)
+	if err == nil || upnp.IsRemovalError(err) {
+		as.recordMappingRemoval("manual", mapping.InternalPort, liveExternalPort, mapping.Protocol, err)
+	}
+	if upnp.IsRemovalError(err) {
+		if !force {
+			as.logger.WithFields(mapping.logFields()).WithError(err).Error("路由器删除映射失败，保留手动映射")
+			return fmt.Errorf("删除手动映射失败: %w", err)
+		}
+		as.logger.WithFields(mapping.logFields()).WithError(err).Warn("路由器删除映射失败，强制删除手动映射")
+		as.upnpManager.ForgetPortMapping(mapping.InternalPort, liveExternalPort, mapping.Protocol)
+	}
+	as.closePinhole(mapping.InternalPort, mapping.ExternalPort, mapping.Protocol)
+	return nil
+}
+
+// forgetManualMapping 删除手动映射的本地记录并停止监控端口（调用者需要持有manualMutex）
+func (as *AutoUPnPService) forgetManualMapping(mapping *ManualMapping) error {
+	if err := as.manualManager.RemoveMapping(mapping.InternalPort, mapping.ExternalPort, mapping.Protocol); err != nil {
+		return err
+	}
+	as.dropRemoval("manual", mapping.InternalPort, mapping.ExternalPort, mapping.Protocol)
+
 	// 从手动端口监控器中移除
 	if as.manualPortMonitor != nil {
-		as.manualPortMonitor.RemovePort(internalPort)
+		as.manualPortMonitor.RemovePort(mapping.InternalPort)
 	}
 
-	as.logger.WithFields(logrus.Fields{
-		"internal_port": internalPort,
-		"external_port": externalPort,
-		"protocol":      protocol,
-	}).Info("成功删除手动映射")
+	as.logger.WithFields(mapping.logFields()).Info("成功删除手动映射")
+
+	return nil
+}
+
+// addManualUPnPMapping 为手动映射注册UPnP映射并记录尝试结果
+func (as *AutoUPnPService) addManualUPnPMapping(mapping *ManualMapping) error {
+	err := as.registerManualUPnPMapping(mapping)
+	as.recordManualMappingAttempt(mapping, err)
+	return err
+}
+
+// registerManualUPnPMapping 向路由器注册手动映射，主外部端口冲突时切换到备用端口
+// 主外部端口被优先级更高的手动映射占用时直接使用备用端口，没有备用端口时返回ErrExternalPortPreempted
+func (as *AutoUPnPService) registerManualUPnPMapping(mapping *ManualMapping) error {
+	if holder := as.manualPortHolder(mapping); holder != nil {
+		if mapping.BackupExternalPort == 0 {
+			return fmt.Errorf("%w: %d/%s (%d:%d)", ErrExternalPortPreempted, mapping.ExternalPort, mapping.Protocol, holder.InternalPort, holder.ExternalPort)
+		}
+		return as.registerOnBackupPort(mapping, fmt.Sprintf("主外部端口%d被优先级更高的映射占用", mapping.ExternalPort))
+	}
+
+	err := as.upnpManager.AddPortMapping(mapping.InternalPort, mapping.ExternalPort, mapping.Protocol, mapping.Description, mapping.RemoteHost)
+	if err == nil {
+		if mapping.LiveExternalPort != 0 && mapping.LiveExternalPort != mapping.ExternalPort {
+			as.updateLiveExternalPort(mapping, mapping.ExternalPort, "主外部端口恢复可用")
+		}
+		return nil
+	}
+
+	if mapping.BackupExternalPort == 0 || !upnp.IsConflictError(err) {
+		return err
+	}
+
+	as.logger.WithFields(mapping.logFields()).WithError(err).WithField("backup_port", mapping.BackupExternalPort).Warn("主外部端口冲突，切换到备用外部端口")
+
+	return as.registerOnBackupPort(mapping, fmt.Sprintf("主外部端口%d冲突", mapping.ExternalPort))
+}
+
+// registerOnBackupPort 在备用外部端口上注册手动映射
+func (as *AutoUPnPService) registerOnBackupPort(mapping *ManualMapping, reason string) error {
+	if err := as.upnpManager.AddPortMapping(mapping.InternalPort, mapping.BackupExternalPort, mapping.Protocol, mapping.Description, mapping.RemoteHost); err != nil {
+		return fmt.Errorf("%s且备用外部端口映射失败: %w", reason, err)
+	}
 
+	as.updateLiveExternalPort(mapping, mapping.BackupExternalPort, reason)
 	return nil
 }
 
+// failoverUnreachableMappings 按差异报告检查配置了备用端口的手动映射，主外部端口不可达时切换到备用端口
+// 路由器上的主端口映射丢失或被改为指向其他地址时先按本地记录重新写入，路由器拒绝（例如端口已被其他主机占用）时视为主端口不可达
+func (as *AutoUPnPService) failoverUnreachableMappings(report *DriftReport) {
+	for _, entry := range report.Entries {
+		if entry.Kind != DriftMissingOnRouter && entry.Kind != DriftParamMismatch {
+			continue
+		}
+		mapping, exists := as.manualManager.GetMapping(entry.InternalPort, entry.ExternalPort, entry.Protocol)
+		if !exists || mapping.Disabled || mapping.BackupExternalPort == 0 || mapping.CurrentExternalPort() != mapping.ExternalPort {
+			continue
+		}
+
+		err := as.upnpManager.RewritePortMapping(mapping.InternalPort, mapping.ExternalPort, mapping.Protocol)
+		if err == nil {
+			continue
+		}
+
+		reason := fmt.Sprintf("主外部端口%d不可达", mapping.ExternalPort)
+		as.logger.WithFields(mapping.logFields()).WithError(err).WithFields(logrus.Fields{
+			"detail":      entry.Detail,
+			"backup_port": mapping.BackupExternalPort,
+		}).Warn("主外部端口不可达，切换到备用外部端口")
+
+		// 主端口映射已不在本服务控制之下，不再续期
+		as.upnpManager.ForgetPortMapping(mapping.InternalPort, mapping.ExternalPort, mapping.Protocol)
+		if err := as.registerOnBackupPort(mapping, reason); err != nil {
+			as.logger.WithFields(mapping.logFields()).WithError(err).Error("切换到备用外部端口失败")
+		}
+	}
+}
+
+// updateLiveExternalPort 记录手动映射当前生效的外部端口
+func (as *AutoUPnPService) updateLiveExternalPort(mapping *ManualMapping, livePort int, reason string) {
+	if err := as.manualManager.UpdateLiveExternalPort(
+		mapping.InternalPort,
+		mapping.ExternalPort,
+		mapping.Protocol,
+		livePort,
+		reason,
+	); err != nil {
+		as.logger.WithError(err).Warn("更新手动映射生效端口失败")
+	}
+}
+
+// openPinhole 在双栈网络下为映射打开IPv6针孔
+// 限制了来源地址的映射不打开针孔：来源地址只支持IPv4，针孔无法做同样的限制，打开后任意IPv6地址都能访问
+func (as *AutoUPnPService) openPinhole(internalPort, externalPort int, protocol, remoteHost string) {
+	if !as.config.UPnP.EnableIPv6Pinhole {
+		return
+	}
+	if remoteHost != "" {
+		as.logger.WithFields(logrus.Fields{
+			"port":        internalPort,
+			"protocol":    protocol,
+			"remote_host": remoteHost,
+		}).Info("映射限制了来源地址，跳过打开IPv6针孔")
+		return
+	}
+	if as.upnpManager == nil || !as.upnpManager.IsPinholeAvailable() {
+		return
+	}
+
+	if _, err := as.upnpManager.AddPinhole(internalPort, externalPort, protocol); err != nil {
+		as.logger.WithError(err).WithFields(logrus.Fields{
+			"port":     internalPort,
+			"protocol": protocol,
+		}).Warn("打开IPv6针孔失败")
+	}
+}
+
+// closePinhole 映射不再使用IPv6针孔，共用内部端口的其他映射仍在使用时保留针孔
+func (as *AutoUPnPService) closePinhole(internalPort, externalPort int, protocol string) {
+	if as.upnpManager == nil {
+		return
+	}
+
+	if !as.upnpManager.HasPinhole(internalPort, externalPort, protocol) {
+		return
+	}
+
+	if err := as.upnpManager.RemovePinhole(internalPort, externalPort, protocol); err != nil {
+		as.logger.WithError(err).WithFields(logrus.Fields{
+			"port":     internalPort,
+			"protocol": protocol,
+		}).Warn("关闭IPv6针孔失败")
+	}
+}
+
 // GetPortMappings 获取所有端口映射
 func (as *AutoUPnPService) GetPortMappings() map[string]*upnp.PortMapping {
 	return as.upnpManager.GetPortMappings()
@@ -730,12 +1512,28 @@ func (as *AutoUPnPService) GetInactivePorts() []int {
 	return as.autoPortMonitor.GetInactivePorts()
 }
 
+// GetPortStates 获取所有被监控端口的详细状态，包括自动和手动监控的端口
+func (as *AutoUPnPService) GetPortStates() map[int]portmonitor.PortState {
+	states := make(map[int]portmonitor.PortState)
+	if as.autoPortMonitor != nil {
+		for port, status := range as.autoPortMonitor.GetAllPortStatus() {
+			states[port] = status.State
+		}
+	}
+	if as.manualPortMonitor != nil {
+		for port, status := range as.manualPortMonitor.GetAllPortStatus() {
+			states[port] = status.State
+		}
+	}
+	return states
+}
+
 // GetManualMappings 获取手动映射列表
 func (as *AutoUPnPService) GetManualMappings() []*ManualMapping {
 	if as.manualManager == nil {
 		return []*ManualMapping{}
 	}
-	return as.manualManager.GetMappings()
+	return as.withHealth(as.manualManager.GetMappings())
 }
 
 // GetActiveManualMappings 获取激活的手动映射列表
@@ -743,7 +1541,7 @@ func (as *AutoUPnPService) GetActiveManualMappings() []*ManualMapping {
 	if as.manualManager == nil {
 		return []*ManualMapping{}
 	}
-	return as.manualManager.GetActiveMappings()
+	return as.withHealth(as.manualManager.GetActiveMappings())
 }
 
 // GetInactiveManualMappings 获取非激活的手动映射列表
@@ -751,7 +1549,7 @@ func (as *AutoUPnPService) GetInactiveManualMappings() []*ManualMapping {
 	if as.manualManager == nil {
 		return []*ManualMapping{}
 	}
-	return as.manualManager.GetInactiveMappings()
+	return as.withHealth(as.manualManager.GetInactiveMappings())
 }
 
 // GetUPnPClientCount 获取UPnP客户端数量
@@ -762,6 +1560,14 @@ func (as *AutoUPnPService) GetUPnPClientCount() int {
 	return as.upnpManager.GetClientCount()
 }
 
+// GetUPnPClients 获取各UPnP客户端的状态
+func (as *AutoUPnPService) GetUPnPClients() []map[string]interface{} {
+	if as.upnpManager == nil {
+		return nil
+	}
+	return as.upnpManager.GetClientStatus()
+}
+
 // IsUPnPAvailable 检查UPnP服务是否可用
 func (as *AutoUPnPService) IsUPnPAvailable() bool {
 	return as.GetUPnPClientCount() > 0
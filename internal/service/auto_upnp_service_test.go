@@ -1,6 +1,8 @@
 package service
 
 import (
+	"io"
+	"net"
 	"testing"
 	"time"
 
@@ -59,6 +61,48 @@ func TestAutoUPnPService_StartStop(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 }
 
+func TestAutoUPnPService_ExcludeAutoPortBeforeStart(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听TCP端口失败: %v", err)
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	cfg := &config.Config{
+		PortRange: config.PortRangeConfig{Start: port, End: port, Step: 1},
+		Monitor: config.MonitorConfig{
+			CheckInterval:   100 * time.Millisecond,
+			CleanupInterval: 1 * time.Second,
+		},
+		UPnP: config.UPnPConfig{
+			DiscoveryTimeout:    1 * time.Second,
+			HealthCheckInterval: 1 * time.Second,
+		},
+		Admin: config.AdminConfig{DataDir: t.TempDir()},
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	service := NewAutoUPnPService(cfg, logger)
+
+	// 管理服务在服务启动前排除端口，第一次扫描就不应监控该端口
+	service.ExcludeAutoPort(port)
+	if err := service.Start(); err != nil {
+		t.Fatalf("启动服务失败: %v", err)
+	}
+	defer service.Stop()
+
+	if !service.autoPortMonitor.IsExcluded(port) {
+		t.Fatalf("启动前排除的端口 %d 未在自动端口监控中排除", port)
+	}
+	time.Sleep(300 * time.Millisecond)
+	for _, active := range service.GetActivePorts() {
+		if active == port {
+			t.Fatalf("被排除的端口 %d 不应出现在活跃端口中", port)
+		}
+	}
+}
+
 func TestAutoUPnPService_GetStatus(t *testing.T) {
 	cfg := &config.Config{}
 	logger := logrus.New()
@@ -87,7 +131,7 @@ func TestAutoUPnPService_AddRemoveManualMapping(t *testing.T) {
 	service := NewAutoUPnPService(cfg, logger)
 
 	// 添加手动映射
-	err := service.AddManualMapping(8080, 8080, "TCP", "test mapping")
+	_, err := service.AddManualMapping(8080, 8080, "TCP", "test mapping")
 	if err != nil {
 		t.Fatalf("添加手动映射失败: %v", err)
 	}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"auto-upnp/config"
+	"auto-upnp/internal/upnp"
+
+	"github.com/sirupsen/logrus"
+)
+
+// soapResponse WANIPConnection操作的SOAP应答
+const soapResponse = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body><u:%sResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">%s</u:%sResponse></s:Body></s:Envelope>`
+
+// soapErrorResponse 指定错误码的SOAP错误
+const soapErrorResponse = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body><s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring>
+<detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode>
+<errorDescription>Error</errorDescription></UPnPError></detail>
+</s:Fault></s:Body></s:Envelope>`
+
+// newTestRouter 只提供WANIPConnection控制地址的路由器，takenPort为true时拒绝添加外部端口9400的映射
+func newTestRouter(t *testing.T, takenPort *atomic.Bool) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		action := r.Header.Get("SOAPAction")
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		switch {
+		case strings.Contains(action, "GetExternalIPAddress"):
+			fmt.Fprintf(w, soapResponse, "GetExternalIPAddress", "<NewExternalIPAddress>203.0.113.7</NewExternalIPAddress>", "GetExternalIPAddress")
+		case strings.Contains(action, "AddPortMapping"):
+			if takenPort.Load() && strings.Contains(string(body), "<NewExternalPort>9400</NewExternalPort>") {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprintf(w, soapErrorResponse, 718)
+				return
+			}
+			fmt.Fprintf(w, soapResponse, "AddPortMapping", "", "AddPortMapping")
+		default:
+			// 路由器上没有可接管的映射
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, soapErrorResponse, 714)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFailoverUnreachableMappings_SwitchesToBackupPort(t *testing.T) {
+	var takenPort atomic.Bool
+	router := newTestRouter(t, &takenPort)
+
+	cfg := &config.Config{
+		Admin: config.AdminConfig{DataDir: t.TempDir()},
+	}
+	service := NewAutoUPnPService(cfg, logrus.New())
+	service.upnpManager = upnp.NewUPnPManager(&upnp.Config{
+		GatewayURLs:      []string{router.URL + "/ctl/IPConn"},
+		BindAddress:      "192.168.1.10",
+		DiscoveryTimeout: time.Second,
+		MaxMappings:      10,
+	}, logrus.New())
+	t.Cleanup(service.upnpManager.Close)
+
+	if err := service.manualManager.AddMappingWithOptions(9400, 9400, "TCP", "web", ManualMappingOptions{BackupExternalPort: 9401}); err != nil {
+		t.Fatalf("添加映射失败: %v", err)
+	}
+	mapping, _ := service.manualManager.GetMapping(9400, 9400, "TCP")
+	if err := service.addManualUPnPMapping(mapping); err != nil {
+		t.Fatalf("注册主外部端口失败: %v", err)
+	}
+
+	// 主外部端口被其他主机占用，路由器拒绝重新写入
+	takenPort.Store(true)
+	service.failoverUnreachableMappings(&DriftReport{Entries: []DriftEntry{{
+		Kind:         DriftParamMismatch,
+		InternalPort: 9400,
+		ExternalPort: 9400,
+		Protocol:     "TCP",
+		Detail:       "路由器上的映射指向其他地址",
+	}}})
+
+	mapping, _ = service.manualManager.GetMapping(9400, 9400, "TCP")
+	if mapping.CurrentExternalPort() != 9401 {
+		t.Fatalf("主外部端口不可达时应切换到备用端口, 当前为 %d", mapping.CurrentExternalPort())
+	}
+	if !strings.Contains(mapping.SwitchReason, "不可达") {
+		t.Errorf("应记录切换原因, 实际为 %q", mapping.SwitchReason)
+	}
+	if !service.upnpManager.HasPortMapping(9400, 9401, "TCP") || service.upnpManager.HasPortMapping(9400, 9400, "TCP") {
+		t.Error("切换后应只记录备用端口上的映射")
+	}
+
+	// 已经在备用端口上的映射不再处理
+	service.failoverUnreachableMappings(&DriftReport{Entries: []DriftEntry{{Kind: DriftMissingOnRouter, InternalPort: 9400, ExternalPort: 9400, Protocol: "TCP"}}})
+	if mapping, _ := service.manualManager.GetMapping(9400, 9400, "TCP"); mapping.CurrentExternalPort() != 9401 {
+		t.Errorf("备用端口生效后不应再切换, 当前为 %d", mapping.CurrentExternalPort())
+	}
+}
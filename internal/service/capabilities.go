@@ -0,0 +1,129 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"auto-upnp/internal/externalip"
+)
+
+// ErrProtocolNotSupported 映射提供方不支持请求的协议
+var ErrProtocolNotSupported = errors.New("协议不支持")
+
+// 映射提供方
+const (
+	ProviderUPnP        = "upnp"         // IGD WANIPConnection端口映射
+	ProviderIPv6Pinhole = "ipv6_pinhole" // IGD WANIPv6FirewallControl防火墙针孔
+)
+
+// 可选功能的名称，Capabilities.Features中为false表示功能未在配置中启用，未列出的功能本实例不支持
+const (
+	FeatureMappingGroups     = "mapping_groups"      // 按分组启用或停用（暂停）映射
+	FeatureReservedMappings  = "reserved_mappings"   // 本地端口未上线时预留外部端口
+	FeatureHealthChecks      = "health_checks"       // http和tcp健康检查
+	FeatureExecHealthChecks  = "exec_health_checks"  // exec健康检查，需要monitor.allow_exec_health_check
+	FeatureMDNS              = "mdns"                // 在局域网广播映射的服务
+	FeatureDDNS              = "ddns"                // 公网IP变化时更新动态域名
+	FeatureDriftCheck        = "drift_check"         // 定期比较本地记录与路由器映射表
+	FeatureRemoveGracePeriod = "remove_grace_period" // 端口下线后延迟删除映射
+	FeatureIdempotentAdd     = "idempotent_add"      // 默认按幂等处理重复添加
+	FeatureRemoteHost        = "remote_host"         // 手动映射限制来源地址
+)
+
+// ProviderCapability 映射提供方支持的协议和地址族
+type ProviderCapability struct {
+	Provider  string   `json:"provider"`
+	Protocols []string `json:"protocols"`
+	Families  []string `json:"families"`
+	Available bool     `json:"available"` // 当前是否发现了支持该功能的设备
+}
+
+// Capabilities 运行中的实例支持的映射能力，客户端和管理界面据此显示或隐藏功能
+type Capabilities struct {
+	Providers         []ProviderCapability `json:"providers"`
+	MaxMappings       int                  `json:"max_mappings"`
+	ExternalIPSources []string             `json:"external_ip_sources"` // 获取公网IP的来源，按优先级排列
+	Features          map[string]bool      `json:"features"`
+	// Unsupported 常见但无法通过端口映射转发的协议，以及替代方案
+	Unsupported map[string]string `json:"unsupported"`
+}
+
+// providerProtocols 各提供方支持的协议，IGD的端口映射和针孔都只接受TCP和UDP
+var providerProtocols = map[string][]string{
+	ProviderUPnP:        {"TCP", "UDP"},
+	ProviderIPv6Pinhole: {"TCP", "UDP"},
+}
+
+// unsupportedProtocolHints 没有端口概念的协议无法映射，需要在路由器上配置直通规则
+var unsupportedProtocolHints = map[string]string{
+	"GRE":  "在路由器上开启PPTP/GRE直通，或手动添加转发规则",
+	"ESP":  "在路由器上开启IPsec直通，或改用NAT-T（UDP 500/4500）",
+	"AH":   "AH无法穿越NAT，改用ESP配合NAT-T（UDP 500/4500）",
+	"SCTP": "在路由器上手动添加SCTP转发规则",
+	"ICMP": "ICMP没有端口，无法映射",
+}
+
+// NormalizeProtocol 规范化映射协议，为空时默认TCP
+// 协议不被UPnP支持时返回ErrProtocolNotSupported，错误信息中给出替代方案
+func NormalizeProtocol(protocol string) (string, error) {
+	protocol = strings.ToUpper(strings.TrimSpace(protocol))
+	if protocol == "" {
+		return "TCP", nil
+	}
+	for _, supported := range providerProtocols[ProviderUPnP] {
+		if protocol == supported {
+			return protocol, nil
+		}
+	}
+
+	hint, known := unsupportedProtocolHints[protocol]
+	if !known {
+		hint = "请在路由器上手动配置规则"
+	}
+	return "", fmt.Errorf("%w: UPnP不支持协议 %s，只支持 %s；%s",
+		ErrProtocolNotSupported, protocol, strings.Join(providerProtocols[ProviderUPnP], "、"), hint)
+}
+
+// GetCapabilities 获取各映射提供方支持的协议和当前可用性
+func (as *AutoUPnPService) GetCapabilities() *Capabilities {
+	upnpAvailable := as.upnpManager != nil && as.upnpManager.IsUPnPAvailable()
+	pinholeAvailable := as.upnpManager != nil && as.upnpManager.IsPinholeAvailable()
+
+	unsupported := make(map[string]string, len(unsupportedProtocolHints))
+	for protocol, hint := range unsupportedProtocolHints {
+		unsupported[protocol] = hint
+	}
+
+	features := map[string]bool{
+		FeatureMappingGroups:     true,
+		FeatureReservedMappings:  true,
+		FeatureHealthChecks:      true,
+		FeatureExecHealthChecks:  as.config.Monitor.AllowExecHealthCheck,
+		FeatureMDNS:              as.config.MDNS.Enabled,
+		FeatureDDNS:              as.ddnsUpdater != nil,
+		FeatureDriftCheck:        as.config.UPnP.DriftCheckInterval > 0,
+		FeatureRemoveGracePeriod: as.config.Monitor.RemoveGracePeriod > 0,
+		FeatureIdempotentAdd:     as.config.Admin.IdempotentAdd,
+		FeatureRemoteHost:        true,
+	}
+
+	sources := as.config.ExternalIP.Sources
+	if as.config.ExternalIP.PublicIPOverride != "" {
+		sources = []string{externalip.SourceStatic}
+	}
+	if sources == nil {
+		sources = []string{}
+	}
+
+	return &Capabilities{
+		Providers: []ProviderCapability{
+			{Provider: ProviderUPnP, Protocols: providerProtocols[ProviderUPnP], Families: []string{"IPv4"}, Available: upnpAvailable},
+			{Provider: ProviderIPv6Pinhole, Protocols: providerProtocols[ProviderIPv6Pinhole], Families: []string{"IPv6"}, Available: pinholeAvailable},
+		},
+		MaxMappings:       as.config.Monitor.MaxMappings,
+		ExternalIPSources: sources,
+		Features:          features,
+		Unsupported:       unsupported,
+	}
+}
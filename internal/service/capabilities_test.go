@@ -0,0 +1,71 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"auto-upnp/config"
+	"auto-upnp/internal/externalip"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestNormalizeProtocol(t *testing.T) {
+	for input, want := range map[string]string{"": "TCP", "tcp": "TCP", " udp ": "UDP"} {
+		got, err := NormalizeProtocol(input)
+		if err != nil || got != want {
+			t.Errorf("NormalizeProtocol(%q) = %q, %v, 期望 %q", input, got, err, want)
+		}
+	}
+
+	_, err := NormalizeProtocol("gre")
+	if !errors.Is(err, ErrProtocolNotSupported) {
+		t.Fatalf("GRE应返回ErrProtocolNotSupported, 实际为 %v", err)
+	}
+	if !strings.Contains(err.Error(), "GRE") || !strings.Contains(err.Error(), "直通") {
+		t.Errorf("错误信息应说明协议和替代方案: %v", err)
+	}
+}
+
+func TestGetCapabilities_ReflectsConfiguration(t *testing.T) {
+	cfg := &config.Config{
+		Admin:      config.AdminConfig{DataDir: t.TempDir(), IdempotentAdd: true},
+		Monitor:    config.MonitorConfig{MaxMappings: 20, RemoveGracePeriod: time.Minute},
+		ExternalIP: config.ExternalIPConfig{Sources: []string{externalip.SourceRouter, externalip.SourceSTUN}},
+	}
+	service := NewAutoUPnPService(cfg, logrus.New())
+
+	caps := service.GetCapabilities()
+	if caps.MaxMappings != 20 {
+		t.Errorf("max_mappings = %d, 期望 20", caps.MaxMappings)
+	}
+	if strings.Join(caps.ExternalIPSources, ",") != "router,stun" {
+		t.Errorf("公网IP来源 = %v, 期望按配置的优先级排列", caps.ExternalIPSources)
+	}
+	for feature, want := range map[string]bool{
+		FeatureRemoveGracePeriod: true,
+		FeatureIdempotentAdd:     true,
+		FeatureExecHealthChecks:  false,
+		FeatureMDNS:              false,
+		FeatureDDNS:              false,
+		FeatureDriftCheck:        false,
+		FeatureMappingGroups:     true,
+	} {
+		if got, listed := caps.Features[feature]; !listed || got != want {
+			t.Errorf("功能 %s = %v (列出=%v), 期望 %v", feature, got, listed, want)
+		}
+	}
+	for _, provider := range caps.Providers {
+		if provider.Available {
+			t.Errorf("未发现设备时提供方 %s 不应可用", provider.Provider)
+		}
+	}
+
+	// 配置了固定公网IP时只使用固定地址
+	cfg.ExternalIP.PublicIPOverride = "203.0.113.7"
+	if sources := service.GetCapabilities().ExternalIPSources; len(sources) != 1 || sources[0] != externalip.SourceStatic {
+		t.Errorf("固定公网IP时来源应为 %s, 实际为 %v", externalip.SourceStatic, sources)
+	}
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newCorrelationID 生成映射的关联ID（UUID v4），用于在日志中串联同一映射的完整生命周期
+func newCorrelationID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// logFields 手动映射的日志字段，包含关联ID
+func (m *ManualMapping) logFields() logrus.Fields {
+	return logrus.Fields{
+		"correlation_id": m.CorrelationID,
+		"internal_port":  m.InternalPort,
+		"external_port":  m.ExternalPort,
+		"protocol":       m.Protocol,
+	}
+}
+
+// autoCorrelationID 获取自动映射的关联ID，端口上线时生成，映射删除后失效（调用者需要持有mappingMutex）
+func (as *AutoUPnPService) autoCorrelationID(port int) string {
+	id, exists := as.autoCorrelationIDs[port]
+	if !exists {
+		id = newCorrelationID()
+		as.autoCorrelationIDs[port] = id
+	}
+	return id
+}
+
+// autoLogEntry 自动映射的日志条目，包含端口和关联ID（调用者需要持有mappingMutex）
+func (as *AutoUPnPService) autoLogEntry(port int) *logrus.Entry {
+	return as.logger.WithFields(logrus.Fields{
+		"port":           port,
+		"correlation_id": as.autoCorrelationID(port),
+	})
+}
+
+// GetMappingCorrelationIDs 获取路由器映射对应的关联ID，键与GetPortMappings相同
+func (as *AutoUPnPService) GetMappingCorrelationIDs() map[string]string {
+	ids := make(map[string]string)
+	for _, mapping := range as.manualManager.GetMappings() {
+		key := fmt.Sprintf("%d:%d:%s", mapping.InternalPort, mapping.CurrentExternalPort(), mapping.Protocol)
+		ids[key] = mapping.CorrelationID
+	}
+
+	as.mappingMutex.RLock()
+	defer as.mappingMutex.RUnlock()
+	for port, id := range as.autoCorrelationIDs {
+		ids[fmt.Sprintf("%d:%d:TCP", port, port)] = id
+	}
+	return ids
+}
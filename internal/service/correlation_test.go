@@ -0,0 +1,29 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestCorrelationID_PersistedAcrossReload(t *testing.T) {
+	dataDir := t.TempDir()
+	manager := NewManualMappingManager(dataDir, logrus.New())
+	if err := manager.AddMappingWithOptions(8080, 8080, "TCP", "web", ManualMappingOptions{}); err != nil {
+		t.Fatalf("添加映射失败: %v", err)
+	}
+
+	mapping, _ := manager.GetMapping(8080, 8080, "TCP")
+	if len(mapping.CorrelationID) != 36 {
+		t.Fatalf("关联ID格式不正确: %q", mapping.CorrelationID)
+	}
+
+	reloaded := NewManualMappingManager(dataDir, logrus.New())
+	if err := reloaded.LoadMappings(); err != nil {
+		t.Fatalf("加载映射失败: %v", err)
+	}
+	loaded, exists := reloaded.GetMapping(8080, 8080, "TCP")
+	if !exists || loaded.CorrelationID != mapping.CorrelationID {
+		t.Errorf("重新加载后关联ID应保持不变: %+v", loaded)
+	}
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"auto-upnp/internal/ddns"
+	"auto-upnp/internal/externalip"
+)
+
+// minDDNSInterval DDNS重试检查的最短间隔
+const minDDNSInterval = time.Minute
+
+// newDDNSUpdater 按配置创建DDNS更新器，未配置服务时返回nil
+func (as *AutoUPnPService) newDDNSUpdater() (*ddns.Updater, error) {
+	cfg := as.config.DDNS
+	if cfg.Provider == "" {
+		return nil, nil
+	}
+
+	client := externalip.NewHTTPClient(as.config.Network.BindIP())
+
+	var provider ddns.Provider
+	var err error
+	switch cfg.Provider {
+	case ddns.ProviderCloudflare:
+		provider, err = ddns.NewCloudflareProvider(cfg.Cloudflare.APIToken, cfg.Cloudflare.ZoneID, cfg.Cloudflare.RecordName, client)
+	case ddns.ProviderDuckDNS:
+		provider, err = ddns.NewDuckDNSProvider(cfg.DuckDNS.Token, cfg.DuckDNS.Domains, client)
+	case ddns.ProviderWebhook:
+		provider, err = ddns.NewWebhookProvider(cfg.Webhook.URL, client)
+	default:
+		return nil, fmt.Errorf("未知的DDNS服务: %s", cfg.Provider)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return ddns.NewUpdater(provider, cfg.MinInterval, cfg.Timeout, as.logger), nil
+}
+
+// ddnsInterval DDNS重试检查间隔，节流或失败后在下一个间隔重试
+func (as *AutoUPnPService) ddnsInterval() time.Duration {
+	if as.config.DDNS.MinInterval < minDDNSInterval {
+		return minDDNSInterval
+	}
+	return as.config.DDNS.MinInterval
+}
+
+// notifyDDNS 公网IP变化时唤醒DDNS协程，不阻塞公网IP解析
+func (as *AutoUPnPService) notifyDDNS(*externalip.Result) {
+	select {
+	case as.ddnsTrigger <- struct{}{}:
+	default:
+	}
+}
+
+// ddnsRoutine 公网IP变化时更新DDNS，并定期重试被节流或失败的更新
+func (as *AutoUPnPService) ddnsRoutine() {
+	defer as.wg.Done()
+	defer as.heartbeats.Recover(SubsystemDDNS)
+
+	ticker := time.NewTicker(as.ddnsInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-as.ctx.Done():
+			return
+		case <-as.ddnsTrigger:
+		case <-ticker.C:
+		}
+
+		as.syncDDNS()
+		as.heartbeats.Beat(SubsystemDDNS)
+	}
+}
+
+// syncDDNS 把DDNS解析同步为当前的公网IP
+func (as *AutoUPnPService) syncDDNS() {
+	current := as.externalIPStatus()
+	if current == nil {
+		return
+	}
+	if _, err := as.ddnsUpdater.Sync(as.ctx, current.IP); err != nil {
+		as.logger.WithError(err).Debug("DDNS同步未完成，将在下一个间隔重试")
+	}
+}
+
+// ddnsStatus 返回DDNS更新状态，未启用时返回nil
+func (as *AutoUPnPService) ddnsStatus() *ddns.Status {
+	if as.ddnsUpdater == nil {
+		return nil
+	}
+	status := as.ddnsUpdater.Status()
+	return &status
+}
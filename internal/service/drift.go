@@ -0,0 +1,258 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"auto-upnp/internal/upnp"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 本地记录与路由器映射表之间的差异类型
+const (
+	DriftMissingOnRouter = "missing_on_router" // 本地记录的映射在路由器上不存在
+	DriftExtraOnRouter   = "extra_on_router"   // 路由器上本实例创建的映射没有本地记录
+	DriftParamMismatch   = "param_mismatch"    // 映射存在但内部地址、内部端口或启用状态与记录不一致
+)
+
+// DriftEntry 一条差异
+type DriftEntry struct {
+	Kind         string `json:"kind"`
+	InternalPort int    `json:"internal_port"`
+	ExternalPort int    `json:"external_port"`
+	Protocol     string `json:"protocol"`
+	Expected     string `json:"expected,omitempty"` // 本地记录，形如 192.168.1.10:8080
+	Actual       string `json:"actual,omitempty"`   // 路由器上的实际值
+	Detail       string `json:"detail"`
+}
+
+// DriftReport 影子校验的结果，只报告差异不做修正
+type DriftReport struct {
+	CheckedAt  time.Time    `json:"checked_at"`
+	InSync     bool         `json:"in_sync"`
+	Records    int          `json:"records"`        // 本地记录的映射数量
+	RouterSize int          `json:"router_entries"` // 路由器映射表的条目数量
+	Missing    int          `json:"missing_on_router"`
+	Extra      int          `json:"extra_on_router"`
+	Mismatched int          `json:"param_mismatch"`
+	Entries    []DriftEntry `json:"entries"`
+}
+
+// DriftReconcileResult 按差异报告修正路由器映射的结果
+type DriftReconcileResult struct {
+	Corrected []DriftEntry   `json:"corrected"`
+	Failed    []DriftFailure `json:"failed"`
+}
+
+// DriftFailure 修正失败的差异
+type DriftFailure struct {
+	DriftEntry
+	Error string `json:"error"`
+}
+
+// diffRouterMappings 比较本地记录和路由器映射表
+// 只有本实例创建（Managed）的路由器条目才可能算作多余，其他程序的映射不参与比较
+func diffRouterMappings(records map[string]*upnp.PortMapping, entries []*upnp.RouterMapping) []DriftEntry {
+	routerKey := func(externalPort int, protocol string) string {
+		return fmt.Sprintf("%d-%s", externalPort, strings.ToUpper(protocol))
+	}
+
+	byPort := make(map[string]*upnp.RouterMapping, len(entries))
+	for _, entry := range entries {
+		byPort[routerKey(entry.ExternalPort, entry.Protocol)] = entry
+	}
+
+	drift := make([]DriftEntry, 0)
+	recorded := make(map[string]bool, len(records))
+	for _, record := range records {
+		key := routerKey(record.ExternalPort, record.Protocol)
+		recorded[key] = true
+		expected := fmt.Sprintf("%s:%d", record.InternalClient, record.InternalPort)
+
+		entry, exists := byPort[key]
+		if !exists {
+			drift = append(drift, DriftEntry{
+				Kind:         DriftMissingOnRouter,
+				InternalPort: record.InternalPort,
+				ExternalPort: record.ExternalPort,
+				Protocol:     record.Protocol,
+				Expected:     expected,
+				Detail:       "路由器上不存在该映射",
+			})
+			continue
+		}
+
+		actual := fmt.Sprintf("%s:%d", entry.InternalClient, entry.InternalPort)
+		switch {
+		case actual != expected:
+			drift = append(drift, DriftEntry{
+				Kind:         DriftParamMismatch,
+				InternalPort: record.InternalPort,
+				ExternalPort: record.ExternalPort,
+				Protocol:     record.Protocol,
+				Expected:     expected,
+				Actual:       actual,
+				Detail:       "路由器上的映射指向其他地址",
+			})
+		case !entry.Enabled:
+			drift = append(drift, DriftEntry{
+				Kind:         DriftParamMismatch,
+				InternalPort: record.InternalPort,
+				ExternalPort: record.ExternalPort,
+				Protocol:     record.Protocol,
+				Expected:     expected,
+				Actual:       actual,
+				Detail:       "路由器上的映射已被禁用",
+			})
+		}
+	}
+
+	for _, entry := range entries {
+		if !entry.Managed || recorded[routerKey(entry.ExternalPort, entry.Protocol)] {
+			continue
+		}
+		drift = append(drift, DriftEntry{
+			Kind:         DriftExtraOnRouter,
+			InternalPort: entry.InternalPort,
+			ExternalPort: entry.ExternalPort,
+			Protocol:     strings.ToUpper(entry.Protocol),
+			Actual:       fmt.Sprintf("%s:%d", entry.InternalClient, entry.InternalPort),
+			Detail:       fmt.Sprintf("本实例创建的映射没有本地记录: %s", entry.Description),
+		})
+	}
+
+	sort.Slice(drift, func(i, j int) bool {
+		if drift[i].ExternalPort != drift[j].ExternalPort {
+			return drift[i].ExternalPort < drift[j].ExternalPort
+		}
+		return drift[i].Protocol < drift[j].Protocol
+	})
+	return drift
+}
+
+// CheckDrift 枚举路由器映射表并与本地记录比较，记录并返回差异报告，不修改任何映射
+func (as *AutoUPnPService) CheckDrift() (*DriftReport, error) {
+	if as.upnpManager == nil {
+		return nil, fmt.Errorf("UPnP服务不可用")
+	}
+
+	records := as.upnpManager.GetPortMappings()
+	entries, err := as.upnpManager.ListRouterMappings()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DriftReport{
+		CheckedAt:  time.Now(),
+		Records:    len(records),
+		RouterSize: len(entries),
+		Entries:    diffRouterMappings(records, entries),
+	}
+	for _, entry := range report.Entries {
+		switch entry.Kind {
+		case DriftMissingOnRouter:
+			report.Missing++
+		case DriftExtraOnRouter:
+			report.Extra++
+		case DriftParamMismatch:
+			report.Mismatched++
+		}
+		as.logger.WithFields(logrus.Fields{
+			"kind":          entry.Kind,
+			"internal_port": entry.InternalPort,
+			"external_port": entry.ExternalPort,
+			"protocol":      entry.Protocol,
+			"expected":      entry.Expected,
+			"actual":        entry.Actual,
+			"detail":        entry.Detail,
+		}).Warn("本地映射记录与路由器不一致")
+	}
+	report.InSync = len(report.Entries) == 0
+
+	as.driftMutex.Lock()
+	as.lastDrift = report
+	as.driftMutex.Unlock()
+
+	return report, nil
+}
+
+// GetDriftReport 获取最近一次的差异报告，尚未校验过时立即校验
+func (as *AutoUPnPService) GetDriftReport() (*DriftReport, error) {
+	as.driftMutex.Lock()
+	report := as.lastDrift
+	as.driftMutex.Unlock()
+
+	if report != nil {
+		return report, nil
+	}
+	return as.CheckDrift()
+}
+
+// ReconcileDrift 重新校验并修正差异：重新写入丢失或被改动的映射，删除路由器上多余的映射
+func (as *AutoUPnPService) ReconcileDrift() (*DriftReconcileResult, error) {
+	report, err := as.CheckDrift()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DriftReconcileResult{
+		Corrected: make([]DriftEntry, 0),
+		Failed:    make([]DriftFailure, 0),
+	}
+	for _, entry := range report.Entries {
+		var err error
+		switch entry.Kind {
+		case DriftMissingOnRouter, DriftParamMismatch:
+			err = as.upnpManager.RewritePortMapping(entry.InternalPort, entry.ExternalPort, entry.Protocol)
+		case DriftExtraOnRouter:
+			err = as.upnpManager.RemoveRouterPortMapping(entry.ExternalPort, entry.Protocol)
+		}
+		if err != nil {
+			result.Failed = append(result.Failed, DriftFailure{DriftEntry: entry, Error: err.Error()})
+			continue
+		}
+		result.Corrected = append(result.Corrected, entry)
+	}
+
+	as.logger.WithFields(logrus.Fields{
+		"corrected": len(result.Corrected),
+		"failed":    len(result.Failed),
+	}).Info("已按差异报告修正路由器映射")
+
+	// 修正后重新校验，使缓存的报告反映当前状态
+	if len(result.Corrected) > 0 {
+		if _, err := as.CheckDrift(); err != nil {
+			as.logger.WithError(err).Warn("修正后重新校验映射失败")
+		}
+	}
+	return result, nil
+}
+
+// driftRoutine 定期校验本地记录与路由器映射表，只记录差异不自动修正
+// 配置了备用端口的手动映射例外：主外部端口不可达时切换到备用端口
+func (as *AutoUPnPService) driftRoutine() {
+	defer as.wg.Done()
+	defer as.heartbeats.Recover(SubsystemDrift)
+
+	ticker := time.NewTicker(as.config.UPnP.DriftCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-as.ctx.Done():
+			return
+		case <-ticker.C:
+			if as.upnpManager.IsUPnPAvailable() {
+				if report, err := as.CheckDrift(); err != nil {
+					as.logger.WithError(err).Warn("校验路由器映射失败")
+				} else {
+					as.failoverUnreachableMappings(report)
+				}
+			}
+			as.heartbeats.Beat(SubsystemDrift)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"testing"
+
+	"auto-upnp/internal/upnp"
+)
+
+func TestDiffRouterMappings(t *testing.T) {
+	records := map[string]*upnp.PortMapping{
+		"8080:8080:TCP": {InternalPort: 8080, ExternalPort: 8080, Protocol: "TCP", InternalClient: "192.168.1.10"},
+		"9000:9000:UDP": {InternalPort: 9000, ExternalPort: 9000, Protocol: "UDP", InternalClient: "192.168.1.10"},
+		"2222:2222:TCP": {InternalPort: 2222, ExternalPort: 2222, Protocol: "TCP", InternalClient: "192.168.1.10"},
+		"7000:7000:TCP": {InternalPort: 7000, ExternalPort: 7000, Protocol: "TCP", InternalClient: "192.168.1.10"},
+	}
+	entries := []*upnp.RouterMapping{
+		// 与记录一致
+		{ExternalPort: 8080, InternalPort: 8080, Protocol: "TCP", InternalClient: "192.168.1.10", Enabled: true, Managed: true},
+		// 被其他程序改为指向其他主机
+		{ExternalPort: 9000, InternalPort: 9000, Protocol: "UDP", InternalClient: "192.168.1.20", Enabled: true},
+		// 被禁用
+		{ExternalPort: 7000, InternalPort: 7000, Protocol: "tcp", InternalClient: "192.168.1.10", Enabled: false, Managed: true},
+		// 本实例创建但没有本地记录
+		{ExternalPort: 3000, InternalPort: 3000, Protocol: "TCP", InternalClient: "192.168.1.10", Enabled: true, Managed: true},
+		// 其他程序的映射不参与比较
+		{ExternalPort: 51413, InternalPort: 51413, Protocol: "TCP", InternalClient: "192.168.1.30", Enabled: true},
+	}
+
+	drift := diffRouterMappings(records, entries)
+
+	want := map[int]string{
+		2222: DriftMissingOnRouter,
+		3000: DriftExtraOnRouter,
+		7000: DriftParamMismatch,
+		9000: DriftParamMismatch,
+	}
+	if len(drift) != len(want) {
+		t.Fatalf("差异数量为 %d, 期望 %d: %+v", len(drift), len(want), drift)
+	}
+	for _, entry := range drift {
+		if kind, ok := want[entry.ExternalPort]; !ok || entry.Kind != kind {
+			t.Errorf("外部端口 %d 的差异类型为 %s, 期望 %s", entry.ExternalPort, entry.Kind, want[entry.ExternalPort])
+		}
+	}
+	if drift[0].ExternalPort != 2222 || drift[len(drift)-1].ExternalPort != 9000 {
+		t.Errorf("差异应按外部端口排序: %+v", drift)
+	}
+}
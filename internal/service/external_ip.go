@@ -0,0 +1,92 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"auto-upnp/internal/externalip"
+	"auto-upnp/internal/upnp"
+)
+
+// newExternalIPResolver 按配置的优先级创建公网IP解析器
+func (as *AutoUPnPService) newExternalIPResolver() *externalip.Resolver {
+	cfg := as.config.ExternalIP
+
+	// 配置了固定公网IP时不再自动获取，避免1:1 NAT之后获取到的地址覆盖配置
+	if cfg.PublicIPOverride != "" {
+		as.logger.WithField("ip", cfg.PublicIPOverride).Info("使用配置的固定公网IP")
+		source := externalip.NewStaticSource(net.ParseIP(cfg.PublicIPOverride))
+		return externalip.NewResolver([]externalip.Source{source}, cfg.CacheTTL, cfg.Timeout, as.logger)
+	}
+
+	sources := make([]externalip.Source, 0, len(cfg.Sources))
+	for _, name := range cfg.Sources {
+		switch name {
+		case externalip.SourceRouter:
+			sources = append(sources, externalip.NewRouterSource(as.upnpManager))
+		case externalip.SourceSTUN:
+			sources = append(sources, externalip.NewSTUNSource(cfg.STUNServers, as.config.Network.BindIP()))
+		case externalip.SourceHTTP:
+			sources = append(sources, externalip.NewHTTPSource(cfg.HTTPURLs, as.config.Network.BindIP()))
+		default:
+			as.logger.WithField("source", name).Warn("未知的公网IP来源，已忽略")
+		}
+	}
+
+	return externalip.NewResolver(sources, cfg.CacheTTL, cfg.Timeout, as.logger)
+}
+
+// externalIPRoutine 定期刷新公网IP
+func (as *AutoUPnPService) externalIPRoutine() {
+	defer as.wg.Done()
+	defer as.heartbeats.Recover(SubsystemExternalIP)
+
+	ticker := time.NewTicker(as.externalIPInterval())
+	defer ticker.Stop()
+
+	for {
+		if _, err := as.ipResolver.Resolve(as.ctx, true); err != nil {
+			as.logger.WithError(err).Warn("获取公网IP失败")
+		}
+		as.heartbeats.Beat(SubsystemExternalIP)
+
+		select {
+		case <-as.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// externalIPInterval 公网IP刷新间隔
+func (as *AutoUPnPService) externalIPInterval() time.Duration {
+	if as.config.ExternalIP.CacheTTL <= 0 {
+		return 5 * time.Minute
+	}
+	return as.config.ExternalIP.CacheTTL
+}
+
+// GetExternalIP 获取公网IP及其来源，force为true时忽略缓存重新查询
+func (as *AutoUPnPService) GetExternalIP(force bool) (*externalip.Result, error) {
+	if as.ipResolver == nil {
+		return nil, fmt.Errorf("公网IP解析器未初始化")
+	}
+	return as.ipResolver.Resolve(as.ctx, force)
+}
+
+// GetRouterExternalIP 获取路由器WAN口地址，使用UPnP健康检查缓存的值，不是每次都请求路由器
+func (as *AutoUPnPService) GetRouterExternalIP() (*upnp.ExternalIPInfo, error) {
+	if as.upnpManager == nil {
+		return nil, fmt.Errorf("UPnP服务不可用")
+	}
+	return as.upnpManager.GetExternalIPInfo()
+}
+
+// externalIPStatus 返回缓存的公网IP，尚未获取时返回nil
+func (as *AutoUPnPService) externalIPStatus() *externalip.Result {
+	if as.ipResolver == nil {
+		return nil
+	}
+	return as.ipResolver.Current()
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+
+	"auto-upnp/internal/liveness"
+)
+
+// 健康汇总的整体状态
+const (
+	HealthStatusOK       = "ok"       // 所有检查都通过
+	HealthStatusDegraded = "degraded" // UPnP可用，但部分映射或子系统异常
+	HealthStatusDown     = "down"     // UPnP不可用，无法注册任何映射
+)
+
+// flappingFailureThreshold 映射成功过但失败次数达到该值时视为反复失败
+// 映射持续稳定后失败计数会清零，所以计数反映的是近期的失败
+const flappingFailureThreshold = 3
+
+// HealthProblem 健康检查发现的问题
+type HealthProblem struct {
+	Component string `json:"component"` // upnp, mapping, subsystem
+	Message   string `json:"message"`
+}
+
+// HealthSummary 服务健康汇总，供监控面板和告警使用
+type HealthSummary struct {
+	Status             string                      `json:"status"`
+	UPnPAvailable      bool                        `json:"upnp_available"`
+	UPnPClients        int                         `json:"upnp_clients"`
+	HealthyUPnPClients int                         `json:"healthy_upnp_clients"`
+	FailedMappings     int                         `json:"failed_mappings"`   // 所有尝试都失败的映射数量
+	FlappingMappings   int                         `json:"flapping_mappings"` // 成功过但近期反复失败的映射数量
+	Subsystems         []*liveness.SubsystemStatus `json:"subsystems"`
+	Problems           []HealthProblem             `json:"problems"`
+}
+
+// mappingHealth 根据映射的尝试计数判断是否失败或反复失败
+func mappingHealth(stats MappingStats) (failed, flapping bool) {
+	if stats.FailureCount == 0 {
+		return false, false
+	}
+	if stats.FailureCount == stats.AttemptCount {
+		return true, false
+	}
+	return false, stats.FailureCount >= flappingFailureThreshold
+}
+
+// GetHealthSummary 汇总UPnP、映射和子系统心跳的状态
+func (as *AutoUPnPService) GetHealthSummary() *HealthSummary {
+	summary := &HealthSummary{
+		Status:     HealthStatusOK,
+		Subsystems: as.GetSubsystemStatus(),
+		Problems:   make([]HealthProblem, 0),
+	}
+	addProblem := func(component, format string, args ...interface{}) {
+		summary.Problems = append(summary.Problems, HealthProblem{
+			Component: component,
+			Message:   fmt.Sprintf(format, args...),
+		})
+	}
+
+	if as.upnpManager != nil {
+		summary.UPnPClients = as.upnpManager.GetClientCount()
+		summary.HealthyUPnPClients = as.upnpManager.GetHealthyClientCount()
+	}
+	summary.UPnPAvailable = summary.HealthyUPnPClients > 0
+	switch {
+	case as.upnpManager == nil:
+		addProblem("upnp", "服务尚未启动")
+	case !summary.UPnPAvailable:
+		addProblem("upnp", "没有可用的UPnP设备")
+	case summary.HealthyUPnPClients < summary.UPnPClients:
+		addProblem("upnp", "%d/%d 个UPnP设备不健康", summary.UPnPClients-summary.HealthyUPnPClients, summary.UPnPClients)
+	}
+
+	as.mappingMutex.RLock()
+	autoStats := as.autoMappingStatsSnapshot()
+	as.mappingMutex.RUnlock()
+
+	ports := make([]int, 0, len(autoStats))
+	for port := range autoStats {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	for _, port := range ports {
+		stats := autoStats[port]
+		failed, flapping := mappingHealth(stats)
+		if failed {
+			summary.FailedMappings++
+			addProblem("mapping", "自动映射 %d/TCP 失败: %s", port, stats.LastError)
+		} else if flapping {
+			summary.FlappingMappings++
+			addProblem("mapping", "自动映射 %d/TCP 反复失败 (%d/%d)", port, stats.FailureCount, stats.AttemptCount)
+		}
+	}
+
+	for _, mapping := range as.GetManualMappings() {
+		if mapping.Disabled {
+			continue
+		}
+		failed, flapping := mappingHealth(mapping.MappingStats)
+		if failed {
+			summary.FailedMappings++
+			addProblem("mapping", "手动映射 %d->%d/%s 失败: %s", mapping.InternalPort, mapping.ExternalPort, mapping.Protocol, mapping.LastError)
+		} else if flapping {
+			summary.FlappingMappings++
+			addProblem("mapping", "手动映射 %d->%d/%s 反复失败 (%d/%d)", mapping.InternalPort, mapping.ExternalPort, mapping.Protocol, mapping.FailureCount, mapping.AttemptCount)
+		}
+	}
+
+	for _, subsystem := range summary.Subsystems {
+		if !subsystem.Healthy {
+			addProblem("subsystem", "子系统 %s 心跳超时", subsystem.Name)
+		}
+	}
+
+	switch {
+	case !summary.UPnPAvailable:
+		summary.Status = HealthStatusDown
+	case len(summary.Problems) > 0:
+		summary.Status = HealthStatusDegraded
+	}
+	return summary
+}
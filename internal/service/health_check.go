@@ -0,0 +1,54 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"auto-upnp/internal/portmonitor"
+)
+
+// ErrInvalidHealthCheck 映射的健康检查配置无效
+var ErrInvalidHealthCheck = errors.New("健康检查配置无效")
+
+// validateHealthCheck 检查健康检查配置，exec检查需要开启monitor.allow_exec_health_check
+func (as *AutoUPnPService) validateHealthCheck(check *portmonitor.HealthCheck) error {
+	if check == nil {
+		return nil
+	}
+	if err := check.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidHealthCheck, err)
+	}
+	if check.Type == portmonitor.HealthCheckExec && !as.config.Monitor.AllowExecHealthCheck {
+		return fmt.Errorf("%w: exec健康检查未启用，需要配置monitor.allow_exec_health_check", ErrInvalidHealthCheck)
+	}
+	return nil
+}
+
+// applyHealthCheck 为映射的内部端口设置健康检查
+// 端口监控按内部端口检查，多个映射共用内部端口时以最后设置的健康检查为准
+func (as *AutoUPnPService) applyHealthCheck(mapping *ManualMapping) {
+	if as.manualPortMonitor == nil || mapping.HealthCheck == nil {
+		return
+	}
+	if err := as.validateHealthCheck(mapping.HealthCheck); err != nil {
+		as.logger.WithError(err).WithFields(mapping.logFields()).Warn("忽略映射的健康检查，只检查端口是否监听")
+		return
+	}
+	as.manualPortMonitor.SetHealthCheck(mapping.InternalPort, mapping.HealthCheck)
+}
+
+// withHealth 为映射填充最近一次健康检查的结果
+func (as *AutoUPnPService) withHealth(mappings []*ManualMapping) []*ManualMapping {
+	if as.manualPortMonitor == nil {
+		return mappings
+	}
+	for _, mapping := range mappings {
+		if mapping.HealthCheck == nil {
+			continue
+		}
+		if status, exists := as.manualPortMonitor.GetPortStatus(mapping.InternalPort); exists {
+			mapping.Health = status.Health
+		}
+	}
+	return mappings
+}
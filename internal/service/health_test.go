@@ -0,0 +1,27 @@
+package service
+
+import "testing"
+
+func TestMappingHealth(t *testing.T) {
+	tests := []struct {
+		name         string
+		stats        MappingStats
+		wantFailed   bool
+		wantFlapping bool
+	}{
+		{"never attempted", MappingStats{}, false, false},
+		{"all succeeded", MappingStats{AttemptCount: 5}, false, false},
+		{"never succeeded", MappingStats{AttemptCount: 2, FailureCount: 2}, true, false},
+		{"occasional failure", MappingStats{AttemptCount: 5, FailureCount: 1}, false, false},
+		{"repeated failures", MappingStats{AttemptCount: 8, FailureCount: 3}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failed, flapping := mappingHealth(tt.stats)
+			if failed != tt.wantFailed || flapping != tt.wantFlapping {
+				t.Errorf("mappingHealth() = (%v, %v), 期望 (%v, %v)", failed, flapping, tt.wantFailed, tt.wantFlapping)
+			}
+		})
+	}
+}
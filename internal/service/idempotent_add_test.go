@@ -0,0 +1,45 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"auto-upnp/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestAddManualMapping_DuplicateHandling(t *testing.T) {
+	cfg := &config.Config{
+		Admin: config.AdminConfig{DataDir: t.TempDir()},
+	}
+	service := NewAutoUPnPService(cfg, logrus.New())
+
+	first, err := service.AddManualMappingWithOptions(9100, 9100, "TCP", "web", ManualMappingOptions{})
+	if err != nil {
+		t.Fatalf("添加映射失败: %v", err)
+	}
+
+	// 默认不幂等，重复添加返回错误
+	if _, err := service.AddManualMappingWithOptions(9100, 9100, "TCP", "web", ManualMappingOptions{}); !errors.Is(err, ErrMappingExists) {
+		t.Errorf("重复添加应返回ErrMappingExists, 实际为 %v", err)
+	}
+
+	// 幂等添加相同映射返回已有映射，且不修改记录
+	result, err := service.AddManualMappingWithOptions(9100, 9100, "TCP", "web", ManualMappingOptions{Idempotent: true})
+	if err != nil {
+		t.Fatalf("幂等添加相同映射不应报错: %v", err)
+	}
+	if !result.Existing || result.Mapping.CorrelationID != first.Mapping.CorrelationID {
+		t.Errorf("幂等添加应返回已有映射: %+v", result)
+	}
+
+	// 参数不同的重复添加即使幂等也返回错误
+	_, err = service.AddManualMappingWithOptions(9100, 9100, "TCP", "other", ManualMappingOptions{Idempotent: true})
+	if !errors.Is(err, ErrMappingMismatch) {
+		t.Errorf("参数不同的重复添加应返回ErrMappingMismatch, 实际为 %v", err)
+	}
+	if mapping, _ := service.manualManager.GetMapping(9100, 9100, "TCP"); mapping.Description != "web" {
+		t.Errorf("参数不同的重复添加不应修改已有映射: %+v", mapping)
+	}
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"time"
+
+	"auto-upnp/internal/externalip"
+	"auto-upnp/internal/metrics"
+)
+
+// startInfluxExporter 启动InfluxDB指标推送协程
+func (as *AutoUPnPService) startInfluxExporter() {
+	cfg := as.config.Exporters.InfluxDB
+	as.influxPusher = metrics.NewInfluxPusher(metrics.InfluxConfig{
+		URL:        cfg.URL,
+		Token:      cfg.Token,
+		Timeout:    cfg.Timeout,
+		MaxPending: cfg.MaxPending,
+		Tags:       cfg.Tags,
+	}, externalip.NewHTTPClient(as.config.Network.BindIP()))
+
+	as.heartbeats.Register(SubsystemInfluxDB, cfg.Interval)
+	as.wg.Add(1)
+	go as.influxRoutine()
+}
+
+// influxRoutine 定期推送指标，推送在独立协程中进行，端点故障不会影响映射操作
+func (as *AutoUPnPService) influxRoutine() {
+	defer as.wg.Done()
+	defer as.heartbeats.Recover(SubsystemInfluxDB)
+
+	ticker := time.NewTicker(as.config.Exporters.InfluxDB.Interval)
+	defer ticker.Stop()
+
+	failing := false
+	for {
+		select {
+		case <-as.ctx.Done():
+			return
+		case now := <-ticker.C:
+			err := as.influxPusher.Push(as.ctx, as.Metrics(), now)
+			// 只在失败和恢复时记录日志，避免端点长时间不可用时刷屏
+			switch {
+			case err != nil && !failing:
+				as.logger.WithError(err).Warn("推送InfluxDB指标失败，将在下个周期重试")
+			case err == nil && failing:
+				as.logger.Info("InfluxDB指标推送已恢复")
+			}
+			failing = err != nil
+			as.heartbeats.Beat(SubsystemInfluxDB)
+		}
+	}
+}
+
+// influxStatus 返回InfluxDB推送状态，未启用时返回nil
+func (as *AutoUPnPService) influxStatus() *metrics.PushStatus {
+	if as.influxPusher == nil {
+		return nil
+	}
+	status := as.influxPusher.Status()
+	return &status
+}
@@ -2,23 +2,101 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
 	"time"
 
+	"auto-upnp/config"
+	"auto-upnp/internal/portmonitor"
+
 	"github.com/sirupsen/logrus"
 )
 
+// ErrMappingNotFound 手动映射不存在
+var ErrMappingNotFound = errors.New("手动映射不存在")
+
+// ErrMappingExists 手动映射已存在，非幂等添加时返回
+var ErrMappingExists = errors.New("手动映射已存在")
+
+// ErrMappingMismatch 手动映射已存在但参数不同，幂等添加时也返回
+var ErrMappingMismatch = errors.New("手动映射已存在且参数不同")
+
 // ManualMapping 手动端口映射记录
 type ManualMapping struct {
-	InternalPort int    `json:"internal_port"`
-	ExternalPort int    `json:"external_port"`
-	Protocol     string `json:"protocol"`
-	Description  string `json:"description"`
-	CreatedAt    string `json:"created_at"`
-	Active       bool   `json:"active"`
+	CorrelationID      string                    `json:"correlation_id"` // 创建映射时生成，用于在日志中串联映射的完整生命周期
+	InternalPort       int                       `json:"internal_port"`  // 本机服务监听的端口，端口监控检查该端口
+	ExternalPort       int                       `json:"external_port"`  // 路由器对外开放并转发到内部端口的端口
+	Protocol           string                    `json:"protocol"`
+	Description        string                    `json:"description"`
+	CreatedAt          string                    `json:"created_at"`
+	Active             bool                      `json:"active"`
+	BackupExternalPort int                       `json:"backup_external_port,omitempty"`
+	LiveExternalPort   int                       `json:"live_external_port,omitempty"`
+	SwitchReason       string                    `json:"switch_reason,omitempty"`
+	SwitchedAt         string                    `json:"switched_at,omitempty"`
+	Note               string                    `json:"note,omitempty"`        // 本地备注，不会同步到路由器
+	Scheme             string                    `json:"scheme,omitempty"`      // 访问协议（http、https、ssh等），用于生成访问链接，不会同步到路由器
+	RemoteHost         string                    `json:"remote_host,omitempty"` // 路由器只转发来自该地址的连接，为空时不限制
+	Group              string                    `json:"group,omitempty"`
+	Disabled           bool                      `json:"disabled,omitempty"`  // 被停用的映射不会注册到路由器
+	MDNSType           string                    `json:"mdns_type,omitempty"` // 在局域网广播的DNS-SD服务类型，为空时不广播
+	MDNSName           string                    `json:"mdns_name,omitempty"`
+	Priority           int                       `json:"priority"`               // 外部端口冲突时优先级高的映射抢占优先级低的映射
+	Reserved           bool                      `json:"reserved,omitempty"`     // 预留映射在本地端口未上线时也注册到路由器，占用外部端口
+	HealthCheck        *portmonitor.HealthCheck  `json:"health_check,omitempty"` // 应用层健康检查，检查通过时本地端口才视为上线
+	Health             *portmonitor.HealthResult `json:"health,omitempty"`       // 最近一次健康检查的结果，只在查询时填充，不保存
+	MappingStats
+}
+
+// ManualMappingOptions 手动映射的可选参数
+type ManualMappingOptions struct {
+	BackupExternalPort int                      // 备用外部端口，主端口冲突时使用
+	Replace            bool                     // 外部端口冲突时替换已有映射
+	Group              string                   // 所属分组，同组映射可以一起启用或停用
+	MDNSType           string                   // DNS-SD服务类型，例如 _http._tcp
+	MDNSName           string                   // DNS-SD服务实例名
+	Priority           int                      // 映射优先级，为0时使用DefaultManualPriority
+	Idempotent         bool                     // 映射已存在且参数相同时返回已有映射，而不是报错
+	Reserved           bool                     // 本地端口未上线时也注册到路由器，预留外部端口
+	HealthCheck        *portmonitor.HealthCheck // 应用层健康检查，为nil时只检查端口是否监听
+	Scheme             string                   // 访问协议，为空时按常见端口推断
+	RemoteHost         string                   // 只允许该IPv4地址访问，为空时不限制来源
+}
+
+// matches 检查已有映射与重复添加请求的参数是否一致
+func (m *ManualMapping) matches(description string, opts ManualMappingOptions) bool {
+	return m.Description == description &&
+		m.BackupExternalPort == opts.BackupExternalPort &&
+		m.Group == opts.Group &&
+		m.MDNSType == opts.MDNSType &&
+		m.MDNSName == opts.MDNSName &&
+		m.Priority == opts.Priority &&
+		m.Reserved == opts.Reserved &&
+		m.HealthCheck.Equal(opts.HealthCheck) &&
+		m.Scheme == opts.Scheme &&
+		m.RemoteHost == opts.RemoteHost
+}
+
+// holdsRouterMapping 检查映射是否应在路由器上注册：未停用，且本地端口在线或映射为预留映射
+func (m *ManualMapping) holdsRouterMapping() bool {
+	return !m.Disabled && (m.Active || m.Reserved)
+}
+
+// CurrentExternalPort 获取当前在路由器上生效的外部端口
+func (m *ManualMapping) CurrentExternalPort() int {
+	if m.LiveExternalPort != 0 {
+		return m.LiveExternalPort
+	}
+	return m.ExternalPort
+}
+
+// clone 复制映射，返回给调用方的映射与管理器内部状态互不影响
+func (m *ManualMapping) clone() *ManualMapping {
+	mapping := *m
+	return &mapping
 }
 
 // ManualMappingManager 手动映射管理器
@@ -27,6 +105,8 @@ type ManualMappingManager struct {
 	logger   *logrus.Logger
 	mutex    sync.RWMutex
 	mappings map[string]*ManualMapping // key: "internalPort:externalPort:protocol"
+	loaded   bool                      // 是否已读取映射文件，读取前保存会先合并文件中的映射，避免覆盖
+	onChange func()                    // 映射变化时调用，需要在使用前设置
 }
 
 // NewManualMappingManager 创建手动映射管理器
@@ -57,56 +137,93 @@ func NewManualMappingManager(dataDir string, logger *logrus.Logger) *ManualMappi
 
 // ensureDataDir 确保数据目录存在且有写权限
 func ensureDataDir(dataDir string, logger *logrus.Logger) error {
-	// 创建目录
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
-		return fmt.Errorf("创建目录失败: %w", err)
-	}
-
-	// 测试写权限
-	testFile := filepath.Join(dataDir, ".test_write")
-	if err := os.WriteFile(testFile, []byte("test"), 0644); err != nil {
-		return fmt.Errorf("目录无写权限: %w", err)
+	if err := config.EnsureDataDir(dataDir); err != nil {
+		return err
 	}
 
-	// 清理测试文件
-	os.Remove(testFile)
-
 	logger.Infof("使用数据目录: %s", dataDir)
 	return nil
 }
 
 // LoadMappings 从文件加载手动映射
+// 加载前已经添加的映射会与文件中的映射合并，键相同时保留内存中的映射
 func (mm *ManualMappingManager) LoadMappings() error {
 	mm.mutex.Lock()
 	defer mm.mutex.Unlock()
 
+	added := len(mm.mappings)
+	if mm.loaded {
+		added = 0
+	}
+
+	count, changed, err := mm.mergeFileUnsafe()
+	if err != nil {
+		return err
+	}
+
+	// 加载前添加的映射和补充的字段需要写回文件
+	if added > 0 || changed {
+		if err := mm.saveMappingsUnsafe(); err != nil {
+			mm.logger.WithError(err).Warn("保存手动映射失败")
+		}
+	}
+
+	mm.logger.Infof("成功加载 %d 个手动映射", count)
+	return nil
+}
+
+// mergeFileUnsafe 读取映射文件并合并到内存，内存中已有的映射优先
+// 返回文件中的映射数量，以及是否为旧版本的映射补充了字段（调用者需要持有写锁）
+func (mm *ManualMappingManager) mergeFileUnsafe() (int, bool, error) {
 	// 检查文件是否存在
 	if _, err := os.Stat(mm.filePath); os.IsNotExist(err) {
-		mm.logger.Info("手动映射文件不存在，将创建新文件")
-		return nil
+		if !mm.loaded {
+			mm.logger.Info("手动映射文件不存在，将创建新文件")
+		}
+		mm.loaded = true
+		return 0, false, nil
 	}
 
 	// 读取文件
 	data, err := os.ReadFile(mm.filePath)
 	if err != nil {
-		return fmt.Errorf("读取手动映射文件失败: %w", err)
+		return 0, false, fmt.Errorf("读取手动映射文件失败: %w", err)
 	}
 
 	// 解析JSON
 	var mappings []*ManualMapping
 	if err := json.Unmarshal(data, &mappings); err != nil {
-		return fmt.Errorf("解析手动映射文件失败: %w", err)
+		return 0, false, fmt.Errorf("解析手动映射文件失败: %w", err)
 	}
 
 	// 加载到内存
-	mm.mappings = make(map[string]*ManualMapping)
+	changed := false
 	for _, mapping := range mappings {
 		key := mm.getMappingKey(mapping.InternalPort, mapping.ExternalPort, mapping.Protocol)
+		if _, exists := mm.mappings[key]; exists {
+			continue
+		}
+		// 旧版本保存的映射没有优先级、访问协议和关联ID
+		if mapping.Priority == 0 {
+			mapping.Priority = DefaultManualPriority
+		}
+		if mapping.Scheme == "" {
+			mapping.Scheme = InferScheme(mapping.InternalPort, mapping.ExternalPort, mapping.Protocol)
+		}
+		if mapping.CorrelationID == "" {
+			mapping.CorrelationID = newCorrelationID()
+			changed = true
+		}
 		mm.mappings[key] = mapping
 	}
 
-	mm.logger.Infof("成功加载 %d 个手动映射", len(mappings))
-	return nil
+	mm.loaded = true
+	return len(mappings), changed, nil
+}
+
+// OnChange 设置映射变化时的回调，回调在持有管理器锁时执行，不能阻塞或访问管理器
+func (mm *ManualMappingManager) OnChange(fn func()) {
+	mm.onChange = fn
 }
 
 // SaveMappings 保存手动映射到文件
@@ -143,18 +260,38 @@ func (mm *ManualMappingManager) SaveMappings() error {
 
 // AddMapping 添加手动映射
 func (mm *ManualMappingManager) AddMapping(internalPort, externalPort int, protocol, description string) error {
+	return mm.AddMappingWithOptions(internalPort, externalPort, protocol, description, ManualMappingOptions{})
+}
+
+// AddMappingWithOptions 添加带可选参数的手动映射
+func (mm *ManualMappingManager) AddMappingWithOptions(internalPort, externalPort int, protocol, description string, opts ManualMappingOptions) error {
 	mm.mutex.Lock()
 	defer mm.mutex.Unlock()
 
 	key := mm.getMappingKey(internalPort, externalPort, protocol)
 
+	priority := opts.Priority
+	if priority == 0 {
+		priority = DefaultManualPriority
+	}
+
 	mapping := &ManualMapping{
-		InternalPort: internalPort,
-		ExternalPort: externalPort,
-		Protocol:     protocol,
-		Description:  description,
-		CreatedAt:    time.Now().Format(time.RFC3339),
-		Active:       true,
+		CorrelationID:      newCorrelationID(),
+		InternalPort:       internalPort,
+		ExternalPort:       externalPort,
+		Protocol:           protocol,
+		Description:        description,
+		CreatedAt:          time.Now().Format(time.RFC3339),
+		Active:             true,
+		BackupExternalPort: opts.BackupExternalPort,
+		Group:              opts.Group,
+		MDNSType:           opts.MDNSType,
+		MDNSName:           opts.MDNSName,
+		Priority:           priority,
+		Reserved:           opts.Reserved,
+		HealthCheck:        opts.HealthCheck,
+		Scheme:             opts.Scheme,
+		RemoteHost:         opts.RemoteHost,
 	}
 
 	mm.mappings[key] = mapping
@@ -171,7 +308,7 @@ func (mm *ManualMappingManager) RemoveMapping(internalPort, externalPort int, pr
 	key := mm.getMappingKey(internalPort, externalPort, protocol)
 
 	if _, exists := mm.mappings[key]; !exists {
-		return fmt.Errorf("手动映射不存在: %s", key)
+		return fmt.Errorf("%w: %s", ErrMappingNotFound, key)
 	}
 
 	delete(mm.mappings, key)
@@ -180,14 +317,32 @@ func (mm *ManualMappingManager) RemoveMapping(internalPort, externalPort int, pr
 	return mm.saveMappingsUnsafe()
 }
 
-// GetMappings 获取所有手动映射
+// RestoreMapping 按原有记录恢复被删除的映射，保留关联ID、分组、备注等全部字段
+func (mm *ManualMappingManager) RestoreMapping(mapping *ManualMapping) error {
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+
+	key := mm.getMappingKey(mapping.InternalPort, mapping.ExternalPort, mapping.Protocol)
+	if _, exists := mm.mappings[key]; exists {
+		return fmt.Errorf("%w: %s", ErrMappingExists, key)
+	}
+
+	restored := mapping.clone()
+	restored.Health = nil
+	mm.mappings[key] = restored
+
+	// 保存到文件
+	return mm.saveMappingsUnsafe()
+}
+
+// GetMappings 获取所有手动映射的副本
 func (mm *ManualMappingManager) GetMappings() []*ManualMapping {
 	mm.mutex.RLock()
 	defer mm.mutex.RUnlock()
 
 	mappings := make([]*ManualMapping, 0, len(mm.mappings))
 	for _, mapping := range mm.mappings {
-		mappings = append(mappings, mapping)
+		mappings = append(mappings, mapping.clone())
 	}
 	return mappings
 }
@@ -199,7 +354,10 @@ func (mm *ManualMappingManager) GetMapping(internalPort, externalPort int, proto
 
 	key := mm.getMappingKey(internalPort, externalPort, protocol)
 	mapping, exists := mm.mappings[key]
-	return mapping, exists
+	if !exists {
+		return nil, false
+	}
+	return mapping.clone(), true
 }
 
 // UpdateMappingActiveStatus 更新映射的激活状态
@@ -210,7 +368,7 @@ func (mm *ManualMappingManager) UpdateMappingActiveStatus(internalPort, external
 	key := mm.getMappingKey(internalPort, externalPort, protocol)
 	mapping, exists := mm.mappings[key]
 	if !exists {
-		return fmt.Errorf("手动映射不存在: %s", key)
+		return fmt.Errorf("%w: %s", ErrMappingNotFound, key)
 	}
 
 	// 只有当状态发生变化时才更新
@@ -230,6 +388,154 @@ func (mm *ManualMappingManager) UpdateMappingActiveStatus(internalPort, external
 	return nil
 }
 
+// UpdateLiveExternalPort 更新映射当前生效的外部端口及切换原因
+func (mm *ManualMappingManager) UpdateLiveExternalPort(internalPort, externalPort int, protocol string, livePort int, reason string) error {
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+
+	key := mm.getMappingKey(internalPort, externalPort, protocol)
+	mapping, exists := mm.mappings[key]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrMappingNotFound, key)
+	}
+
+	if mapping.CurrentExternalPort() == livePort {
+		return nil
+	}
+
+	mapping.LiveExternalPort = livePort
+	mapping.SwitchReason = reason
+	mapping.SwitchedAt = time.Now().Format(time.RFC3339)
+
+	mm.logger.WithFields(logrus.Fields{
+		"internal_port": internalPort,
+		"external_port": externalPort,
+		"protocol":      protocol,
+		"live_port":     livePort,
+		"reason":        reason,
+	}).Warn("手动映射外部端口已切换")
+
+	return mm.saveMappingsUnsafe()
+}
+
+// RecordMappingAttempt 记录一次向路由器注册映射的结果
+func (mm *ManualMappingManager) RecordMappingAttempt(internalPort, externalPort int, protocol string, attemptErr error) error {
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+
+	key := mm.getMappingKey(internalPort, externalPort, protocol)
+	mapping, exists := mm.mappings[key]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrMappingNotFound, key)
+	}
+
+	mapping.MappingStats.record(attemptErr, time.Now())
+	return mm.saveMappingsUnsafe()
+}
+
+// ResetSustainedStats 清零已持续稳定的映射的失败计数
+func (mm *ManualMappingManager) ResetSustainedStats(now time.Time) error {
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+
+	changed := false
+	for _, mapping := range mm.mappings {
+		if mapping.MappingStats.resetIfSustained(now) {
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+	return mm.saveMappingsUnsafe()
+}
+
+// UpdateMappingNote 更新映射的本地备注
+func (mm *ManualMappingManager) UpdateMappingNote(internalPort, externalPort int, protocol, note string) (*ManualMapping, error) {
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+
+	key := mm.getMappingKey(internalPort, externalPort, protocol)
+	mapping, exists := mm.mappings[key]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrMappingNotFound, key)
+	}
+
+	if mapping.Note == note {
+		return mapping.clone(), nil
+	}
+
+	mapping.Note = note
+	mm.logger.WithFields(logrus.Fields{
+		"internal_port": internalPort,
+		"external_port": externalPort,
+		"protocol":      protocol,
+	}).Info("更新手动映射备注")
+
+	if err := mm.saveMappingsUnsafe(); err != nil {
+		return nil, err
+	}
+	return mapping.clone(), nil
+}
+
+// UpdateMappingScheme 更新映射的访问协议
+func (mm *ManualMappingManager) UpdateMappingScheme(internalPort, externalPort int, protocol, scheme string) (*ManualMapping, error) {
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+
+	key := mm.getMappingKey(internalPort, externalPort, protocol)
+	mapping, exists := mm.mappings[key]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrMappingNotFound, key)
+	}
+
+	if mapping.Scheme == scheme {
+		return mapping.clone(), nil
+	}
+
+	mapping.Scheme = scheme
+	mm.logger.WithFields(mapping.logFields()).WithField("scheme", scheme).Info("更新手动映射访问协议")
+
+	if err := mm.saveMappingsUnsafe(); err != nil {
+		return nil, err
+	}
+	return mapping.clone(), nil
+}
+
+// SetMappingDisabled 设置映射是否被停用
+func (mm *ManualMappingManager) SetMappingDisabled(internalPort, externalPort int, protocol string, disabled bool) error {
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+
+	key := mm.getMappingKey(internalPort, externalPort, protocol)
+	mapping, exists := mm.mappings[key]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrMappingNotFound, key)
+	}
+
+	if mapping.Disabled == disabled {
+		return nil
+	}
+
+	mapping.Disabled = disabled
+	return mm.saveMappingsUnsafe()
+}
+
+// GetGroupMappings 获取分组内的所有映射
+func (mm *ManualMappingManager) GetGroupMappings(group string) []*ManualMapping {
+	mm.mutex.RLock()
+	defer mm.mutex.RUnlock()
+
+	mappings := make([]*ManualMapping, 0)
+	for _, mapping := range mm.mappings {
+		if mapping.Group == group {
+			mappings = append(mappings, mapping.clone())
+		}
+	}
+	return mappings
+}
+
 // GetActiveMappings 获取所有激活的手动映射
 func (mm *ManualMappingManager) GetActiveMappings() []*ManualMapping {
 	mm.mutex.RLock()
@@ -238,7 +544,7 @@ func (mm *ManualMappingManager) GetActiveMappings() []*ManualMapping {
 	mappings := make([]*ManualMapping, 0)
 	for _, mapping := range mm.mappings {
 		if mapping.Active {
-			mappings = append(mappings, mapping)
+			mappings = append(mappings, mapping.clone())
 		}
 	}
 	return mappings
@@ -252,7 +558,7 @@ func (mm *ManualMappingManager) GetInactiveMappings() []*ManualMapping {
 	mappings := make([]*ManualMapping, 0)
 	for _, mapping := range mm.mappings {
 		if !mapping.Active {
-			mappings = append(mappings, mapping)
+			mappings = append(mappings, mapping.clone())
 		}
 	}
 	return mappings
@@ -265,6 +571,13 @@ func (mm *ManualMappingManager) getMappingKey(internalPort, externalPort int, pr
 
 // saveMappingsUnsafe 不安全保存（调用者需要持有锁）
 func (mm *ManualMappingManager) saveMappingsUnsafe() error {
+	// 存储尚未加载时先合并文件中的映射，否则会用不完整的映射覆盖文件
+	if !mm.loaded {
+		if _, _, err := mm.mergeFileUnsafe(); err != nil {
+			return fmt.Errorf("合并手动映射文件失败: %w", err)
+		}
+	}
+
 	// 转换为切片
 	mappings := make([]*ManualMapping, 0, len(mm.mappings))
 	for _, mapping := range mm.mappings {
@@ -288,5 +601,10 @@ func (mm *ManualMappingManager) saveMappingsUnsafe() error {
 		return fmt.Errorf("写入手动映射文件失败: %w", err)
 	}
 
+	// 每次修改映射后都会保存，写入成功后在这里统一通知变化
+	if mm.onChange != nil {
+		mm.onChange()
+	}
+
 	return nil
 }
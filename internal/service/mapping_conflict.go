@@ -0,0 +1,193 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"auto-upnp/internal/upnp"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 冲突映射的来源
+const (
+	ConflictSourceManual = "manual" // 本服务的手动映射
+	ConflictSourceAuto   = "auto"   // 本服务的自动映射
+	ConflictSourceRouter = "router" // 路由器上其他客户端创建的映射
+)
+
+// MappingConflict 外部端口冲突详情
+type MappingConflict struct {
+	Source         string `json:"source"`
+	InternalPort   int    `json:"internal_port"`
+	ExternalPort   int    `json:"external_port"`
+	Protocol       string `json:"protocol"`
+	InternalClient string `json:"internal_client,omitempty"`
+	Description    string `json:"description,omitempty"`
+	Priority       int    `json:"priority"` // 路由器上其他客户端的映射优先级未知，固定为0且不会被自动抢占
+	Replaceable    bool   `json:"replaceable"`
+
+	manual *ManualMapping // 冲突的手动映射，仅Source为manual时有效
+}
+
+// MappingConflictError 外部端口已被占用
+type MappingConflictError struct {
+	Conflict *MappingConflict
+}
+
+// Error 实现error接口
+func (e *MappingConflictError) Error() string {
+	owner := fmt.Sprintf("本机:%d", e.Conflict.InternalPort)
+	if e.Conflict.InternalClient != "" {
+		owner = fmt.Sprintf("%s:%d", e.Conflict.InternalClient, e.Conflict.InternalPort)
+	}
+	return fmt.Sprintf("外部端口 %d/%s 已被映射 %s 占用", e.Conflict.ExternalPort, e.Conflict.Protocol, owner)
+}
+
+// findMappingConflict 查找占用外部端口的其他映射，没有冲突时返回nil
+func (as *AutoUPnPService) findMappingConflict(internalPort, externalPort int, protocol string) *MappingConflict {
+	// 本服务的手动映射，被抢占的映射可能与抢占者共用外部端口，取优先级最高的一条
+	var holder *ManualMapping
+	for _, mapping := range as.manualManager.GetMappings() {
+		if !strings.EqualFold(mapping.Protocol, protocol) || mapping.CurrentExternalPort() != externalPort {
+			continue
+		}
+		// 同一条映射重复添加不算冲突
+		if mapping.InternalPort == internalPort && mapping.ExternalPort == externalPort {
+			continue
+		}
+		if holder == nil || mapping.Priority > holder.Priority {
+			holder = mapping
+		}
+	}
+	if holder != nil {
+		return &MappingConflict{
+			Source:       ConflictSourceManual,
+			InternalPort: holder.InternalPort,
+			ExternalPort: externalPort,
+			Protocol:     holder.Protocol,
+			Description:  holder.Description,
+			Priority:     holder.Priority,
+			Replaceable:  true,
+			manual:       holder,
+		}
+	}
+
+	// 本服务的自动映射，由端口监控维护，不允许替换
+	if strings.EqualFold(protocol, "TCP") {
+		as.mappingMutex.RLock()
+		autoMapped := as.activeMappings[externalPort]
+		as.mappingMutex.RUnlock()
+
+		if autoMapped && externalPort != internalPort {
+			return &MappingConflict{
+				Source:       ConflictSourceAuto,
+				InternalPort: externalPort,
+				ExternalPort: externalPort,
+				Protocol:     "TCP",
+				Description:  fmt.Sprintf("AutoUPnP-%d", externalPort),
+				Priority:     AutoMappingPriority,
+				Replaceable:  false,
+			}
+		}
+	}
+
+	// 路由器上其他客户端的映射
+	if as.upnpManager == nil {
+		return nil
+	}
+	routerMapping, err := as.upnpManager.FindConflictingMapping(internalPort, externalPort, protocol)
+	if err != nil {
+		as.logger.WithError(err).Debug("查询路由器端口映射失败，跳过冲突检查")
+		return nil
+	}
+	if routerMapping == nil {
+		return nil
+	}
+
+	return &MappingConflict{
+		Source:         ConflictSourceRouter,
+		InternalPort:   routerMapping.InternalPort,
+		ExternalPort:   externalPort,
+		Protocol:       protocol,
+		InternalClient: routerMapping.InternalClient,
+		Description:    routerMapping.Description,
+		Replaceable:    true,
+	}
+}
+
+// resolveMappingConflict 删除冲突的映射，为替换让出外部端口
+func (as *AutoUPnPService) resolveMappingConflict(conflict *MappingConflict) error {
+	if !conflict.Replaceable {
+		return fmt.Errorf("冲突的映射不允许替换: %s", conflict.Source)
+	}
+
+	as.logger.WithFields(logrus.Fields{
+		"source":          conflict.Source,
+		"internal_port":   conflict.InternalPort,
+		"external_port":   conflict.ExternalPort,
+		"protocol":        conflict.Protocol,
+		"internal_client": conflict.InternalClient,
+	}).Warn("替换冲突的端口映射")
+
+	switch conflict.Source {
+	case ConflictSourceManual:
+		return as.removeManualMapping(conflict.manual.InternalPort, conflict.manual.ExternalPort, conflict.manual.Protocol)
+	case ConflictSourceRouter:
+		return as.upnpManager.RemoveRouterPortMapping(conflict.ExternalPort, conflict.Protocol)
+	default:
+		return fmt.Errorf("未知的冲突来源: %s", conflict.Source)
+	}
+}
+
+// restoreReplacedMapping 替换失败时恢复被删除的手动映射
+func (as *AutoUPnPService) restoreReplacedMapping(conflict *MappingConflict) {
+	if conflict.Source != ConflictSourceManual {
+		// 其他客户端的映射无法代为恢复，由其自行续期
+		as.logger.WithFields(logrus.Fields{
+			"external_port":   conflict.ExternalPort,
+			"protocol":        conflict.Protocol,
+			"internal_client": conflict.InternalClient,
+		}).Warn("替换失败，被删除的路由器映射无法自动恢复")
+		return
+	}
+
+	// 按原有记录恢复，而不是重新添加，保留关联ID、分组、预留、健康检查、停用状态和备注
+	old := conflict.manual
+	if err := as.manualManager.RestoreMapping(old); err != nil {
+		as.logger.WithError(err).WithFields(old.logFields()).Warn("恢复被替换的手动映射失败")
+		return
+	}
+	restored, _ := as.manualManager.GetMapping(old.InternalPort, old.ExternalPort, old.Protocol)
+	as.restoreManualMapping(restored)
+}
+
+// routerConflictFromError 将路由器返回的718冲突错误转换为带详情的冲突错误
+func (as *AutoUPnPService) routerConflictFromError(internalPort, externalPort int, protocol string, err error) error {
+	if !upnp.IsConflictError(err) {
+		return err
+	}
+
+	conflict := &MappingConflict{
+		Source:       ConflictSourceRouter,
+		ExternalPort: externalPort,
+		Protocol:     protocol,
+		Replaceable:  true,
+	}
+	if routerMapping, lookupErr := as.upnpManager.FindConflictingMapping(internalPort, externalPort, protocol); lookupErr == nil && routerMapping != nil {
+		conflict.InternalPort = routerMapping.InternalPort
+		conflict.InternalClient = routerMapping.InternalClient
+		conflict.Description = routerMapping.Description
+	}
+	return &MappingConflictError{Conflict: conflict}
+}
+
+// IsMappingConflict 检查错误是否为外部端口冲突，是则返回冲突详情
+func IsMappingConflict(err error) (*MappingConflict, bool) {
+	var conflictErr *MappingConflictError
+	if errors.As(err, &conflictErr) {
+		return conflictErr.Conflict, true
+	}
+	return nil, false
+}
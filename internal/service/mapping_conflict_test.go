@@ -0,0 +1,84 @@
+package service
+
+import (
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"auto-upnp/config"
+	"auto-upnp/internal/upnp"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newConflictTestService 创建连接到测试路由器的服务
+func newConflictTestService(t *testing.T, takenPort *atomic.Bool) *AutoUPnPService {
+	router := newTestRouter(t, takenPort)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	service := NewAutoUPnPService(&config.Config{
+		Admin: config.AdminConfig{DataDir: t.TempDir()},
+	}, logger)
+	service.upnpManager = upnp.NewUPnPManager(&upnp.Config{
+		GatewayURLs:      []string{router.URL + "/ctl/IPConn"},
+		BindAddress:      "192.168.1.10",
+		DiscoveryTimeout: time.Second,
+		MaxMappings:      10,
+	}, logger)
+	t.Cleanup(service.upnpManager.Close)
+	return service
+}
+
+func TestAddManualMapping_ReportsAndReplacesManualConflict(t *testing.T) {
+	var takenPort atomic.Bool
+	service := newConflictTestService(t, &takenPort)
+
+	if _, err := service.AddManualMappingWithOptions(9001, 9450, "TCP", "old", ManualMappingOptions{Reserved: true}); err != nil {
+		t.Fatalf("添加映射失败: %v", err)
+	}
+
+	// 相同优先级的映射不会抢占，返回冲突详情
+	_, err := service.AddManualMappingWithOptions(9002, 9450, "TCP", "new", ManualMappingOptions{Reserved: true})
+	conflict, ok := IsMappingConflict(err)
+	if !ok {
+		t.Fatalf("外部端口被占用时应返回冲突错误, 实际为 %v", err)
+	}
+	if conflict.Source != ConflictSourceManual || conflict.InternalPort != 9001 || !conflict.Replaceable {
+		t.Errorf("冲突详情不正确: %+v", conflict)
+	}
+	if _, exists := service.manualManager.GetMapping(9002, 9450, "TCP"); exists {
+		t.Error("冲突时不应保留新映射")
+	}
+
+	// 替换冲突的映射
+	if _, err := service.AddManualMappingWithOptions(9002, 9450, "TCP", "new", ManualMappingOptions{Reserved: true, Replace: true}); err != nil {
+		t.Fatalf("替换映射失败: %v", err)
+	}
+	if _, exists := service.manualManager.GetMapping(9001, 9450, "TCP"); exists {
+		t.Error("被替换的映射应被删除")
+	}
+	if !service.upnpManager.HasPortMapping(9002, 9450, "TCP") || service.upnpManager.HasPortMapping(9001, 9450, "TCP") {
+		t.Error("替换后路由器上应只有新映射")
+	}
+}
+
+func TestAddManualMapping_ConvertsRouterConflict(t *testing.T) {
+	var takenPort atomic.Bool
+	takenPort.Store(true)
+	service := newConflictTestService(t, &takenPort)
+
+	// 路由器上其他主机占用了外部端口，返回718
+	_, err := service.AddManualMappingWithOptions(9400, 9400, "TCP", "web", ManualMappingOptions{Reserved: true})
+	conflict, ok := IsMappingConflict(err)
+	if !ok {
+		t.Fatalf("路由器拒绝映射时应返回冲突错误, 实际为 %v", err)
+	}
+	if conflict.Source != ConflictSourceRouter || conflict.ExternalPort != 9400 || !conflict.Replaceable {
+		t.Errorf("冲突详情不正确: %+v", conflict)
+	}
+	if _, exists := service.manualManager.GetMapping(9400, 9400, "TCP"); exists {
+		t.Error("路由器拒绝映射时应撤销本地记录")
+	}
+}
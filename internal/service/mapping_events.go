@@ -0,0 +1,68 @@
+package service
+
+import (
+	"sync"
+
+	"auto-upnp/internal/notify"
+)
+
+// 映射事件，用于导出counter类型的累计次数
+const (
+	mappingEventCreated      = "created"
+	mappingEventRemoved      = "removed"
+	mappingEventAddFailed    = "add_failed"
+	mappingEventRemoveFailed = "remove_failed"
+)
+
+// mappingEventKey 按映射类型（auto、manual）和事件区分的计数键
+type mappingEventKey struct {
+	mappingType string
+	event       string
+}
+
+// mappingEvents 映射添加、删除和失败的累计次数，服务运行期间只增不减，与失败后会清零的MappingStats不同
+type mappingEvents struct {
+	mutex  sync.Mutex
+	counts map[mappingEventKey]uint64
+}
+
+// add 记录一次映射事件
+func (e *mappingEvents) add(mappingType, event string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.counts == nil {
+		e.counts = make(map[mappingEventKey]uint64)
+	}
+	e.counts[mappingEventKey{mappingType: mappingType, event: event}]++
+}
+
+// count 获取映射事件的累计次数
+func (e *mappingEvents) count(mappingType, event string) uint64 {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.counts[mappingEventKey{mappingType: mappingType, event: event}]
+}
+
+// recordMappingAdd 记录一次向路由器添加映射的结果，并发送映射事件通知
+func (as *AutoUPnPService) recordMappingAdd(mappingType string, internalPort, externalPort int, protocol string, err error) {
+	if err != nil {
+		as.mappingEvents.add(mappingType, mappingEventAddFailed)
+		as.notifyMappingEvent(notify.EventMappingFailed, mappingType, internalPort, externalPort, protocol, "add", err)
+		return
+	}
+	as.mappingEvents.add(mappingType, mappingEventCreated)
+	as.notifyMappingEvent(notify.EventMappingCreated, mappingType, internalPort, externalPort, protocol, "", nil)
+}
+
+// recordMappingRemoval 记录一次从路由器删除映射的结果，并发送映射事件通知
+func (as *AutoUPnPService) recordMappingRemoval(mappingType string, internalPort, externalPort int, protocol string, err error) {
+	if err != nil {
+		as.mappingEvents.add(mappingType, mappingEventRemoveFailed)
+		as.notifyMappingEvent(notify.EventMappingFailed, mappingType, internalPort, externalPort, protocol, "remove", err)
+		return
+	}
+	as.mappingEvents.add(mappingType, mappingEventRemoved)
+	as.notifyMappingEvent(notify.EventMappingRemoved, mappingType, internalPort, externalPort, protocol, "", nil)
+}
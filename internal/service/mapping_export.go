@@ -0,0 +1,157 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 映射导出格式
+const (
+	ExportFormatNftables = "nftables"
+	ExportFormatIptables = "iptables"
+)
+
+// ExportOptions 映射导出参数
+type ExportOptions struct {
+	Format       string // nftables 或 iptables
+	InternalIP   string // DNAT目标地址，为空时使用本机地址
+	WANInterface string // 只匹配该入口网卡，为空时不限制
+}
+
+// ExportMappings 将手动映射导出为路由器可执行的DNAT规则脚本，用于不支持UPnP的路由器
+func (as *AutoUPnPService) ExportMappings(opts ExportOptions) (string, error) {
+	if opts.Format != ExportFormatNftables && opts.Format != ExportFormatIptables {
+		return "", fmt.Errorf("不支持的导出格式: %s", opts.Format)
+	}
+
+	if opts.InternalIP == "" {
+		if as.upnpManager == nil {
+			return "", fmt.Errorf("无法确定本机地址，请指定internal_ip")
+		}
+		localIP, err := as.upnpManager.GetLocalIP()
+		if err != nil {
+			return "", fmt.Errorf("获取本机地址失败: %w", err)
+		}
+		opts.InternalIP = localIP
+	}
+
+	mappings := as.manualManager.GetMappings()
+	sort.Slice(mappings, func(i, j int) bool {
+		return mappings[i].ExternalPort < mappings[j].ExternalPort
+	})
+
+	if opts.Format == ExportFormatNftables {
+		return renderNftables(mappings, opts), nil
+	}
+	return renderIptables(mappings, opts), nil
+}
+
+// renderNftables 生成nftables规则集，规则放在独立的表中以便整体删除
+func renderNftables(mappings []*ManualMapping, opts ExportOptions) string {
+	var b strings.Builder
+
+	fmt.Fprintln(&b, "#!/usr/sbin/nft -f")
+	writeExportHeader(&b, len(mappings), opts)
+	fmt.Fprintln(&b, "# 删除规则: nft delete table ip auto_upnp")
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "table ip auto_upnp {")
+
+	fmt.Fprintln(&b, "\tchain prerouting {")
+	fmt.Fprintln(&b, "\t\ttype nat hook prerouting priority dstnat; policy accept;")
+	for _, mapping := range mappings {
+		iifMatch := ""
+		if opts.WANInterface != "" {
+			iifMatch = fmt.Sprintf("iifname %q ", opts.WANInterface)
+		}
+		saddrMatch := ""
+		if mapping.RemoteHost != "" {
+			saddrMatch = fmt.Sprintf("ip saddr %s ", mapping.RemoteHost)
+		}
+		fmt.Fprintf(&b, "\t\t%s%s%s dport %d dnat to %s:%d comment %q\n",
+			iifMatch, saddrMatch, exportProtocol(mapping.Protocol), mapping.ExternalPort,
+			opts.InternalIP, mapping.InternalPort, exportComment(mapping))
+	}
+	fmt.Fprintln(&b, "\t}")
+	fmt.Fprintln(&b)
+
+	fmt.Fprintln(&b, "\tchain forward {")
+	fmt.Fprintln(&b, "\t\ttype filter hook forward priority filter; policy accept;")
+	for _, mapping := range mappings {
+		fmt.Fprintf(&b, "\t\tip daddr %s %s dport %d ct state new accept\n",
+			opts.InternalIP, exportProtocol(mapping.Protocol), mapping.InternalPort)
+	}
+	fmt.Fprintln(&b, "\t}")
+	fmt.Fprintln(&b, "}")
+
+	return b.String()
+}
+
+// renderIptables 生成iptables命令脚本，末尾附带对应的删除命令
+func renderIptables(mappings []*ManualMapping, opts ExportOptions) string {
+	var b strings.Builder
+
+	fmt.Fprintln(&b, "#!/bin/sh")
+	writeExportHeader(&b, len(mappings), opts)
+	fmt.Fprintln(&b)
+
+	var addRules, deleteRules []string
+	for _, mapping := range mappings {
+		protocol := exportProtocol(mapping.Protocol)
+
+		iifMatch := ""
+		if opts.WANInterface != "" {
+			iifMatch = fmt.Sprintf(" -i %s", opts.WANInterface)
+		}
+		sourceMatch := ""
+		if mapping.RemoteHost != "" {
+			sourceMatch = " -s " + mapping.RemoteHost
+		}
+		dnatRule := fmt.Sprintf("PREROUTING%s%s -p %s --dport %d -m comment --comment %q -j DNAT --to-destination %s:%d",
+			iifMatch, sourceMatch, protocol, mapping.ExternalPort, exportComment(mapping), opts.InternalIP, mapping.InternalPort)
+		forwardRule := fmt.Sprintf("FORWARD -p %s -d %s --dport %d -m conntrack --ctstate NEW -j ACCEPT",
+			protocol, opts.InternalIP, mapping.InternalPort)
+
+		addRules = append(addRules, "iptables -t nat -A "+dnatRule, "iptables -A "+forwardRule)
+		deleteRules = append(deleteRules, "# iptables -t nat -D "+dnatRule, "# iptables -D "+forwardRule)
+	}
+
+	fmt.Fprintln(&b, "# 添加规则")
+	for _, rule := range addRules {
+		fmt.Fprintln(&b, rule)
+	}
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "# 删除规则（取消注释后执行）")
+	for _, rule := range deleteRules {
+		fmt.Fprintln(&b, rule)
+	}
+
+	return b.String()
+}
+
+// writeExportHeader 写入导出脚本的说明注释
+func writeExportHeader(b *strings.Builder, count int, opts ExportOptions) {
+	fmt.Fprintf(b, "# 由 auto-upnp 于 %s 生成，共 %d 个手动映射\n", time.Now().Format(time.RFC3339), count)
+	fmt.Fprintf(b, "# 目标地址: %s\n", opts.InternalIP)
+	if opts.WANInterface != "" {
+		fmt.Fprintf(b, "# 入口网卡: %s\n", opts.WANInterface)
+	}
+}
+
+// exportProtocol 获取规则中使用的协议名
+func exportProtocol(protocol string) string {
+	if strings.EqualFold(protocol, "UDP") {
+		return "udp"
+	}
+	return "tcp"
+}
+
+// exportComment 生成规则注释，去掉可能破坏脚本的字符
+func exportComment(mapping *ManualMapping) string {
+	comment := mapping.Description
+	if comment == "" {
+		comment = fmt.Sprintf("auto-upnp %d", mapping.InternalPort)
+	}
+	return strings.NewReplacer(`"`, "", `\`, "", "\n", " ", "$", "", "`", "").Replace(comment)
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderExportScripts(t *testing.T) {
+	mappings := []*ManualMapping{
+		{InternalPort: 443, ExternalPort: 8443, Protocol: "TCP", Description: `NAS "https"`},
+		{InternalPort: 51820, ExternalPort: 51820, Protocol: "UDP"},
+	}
+	opts := ExportOptions{InternalIP: "192.168.1.10", WANInterface: "eth0"}
+
+	nft := renderNftables(mappings, opts)
+	for _, want := range []string{
+		`iifname "eth0" tcp dport 8443 dnat to 192.168.1.10:443 comment "NAS https"`,
+		`udp dport 51820 dnat to 192.168.1.10:51820`,
+		`ip daddr 192.168.1.10 tcp dport 443 ct state new accept`,
+		`nft delete table ip auto_upnp`,
+	} {
+		if !strings.Contains(nft, want) {
+			t.Errorf("nftables规则缺少 %q:\n%s", want, nft)
+		}
+	}
+
+	ipt := renderIptables(mappings, opts)
+	for _, want := range []string{
+		`iptables -t nat -A PREROUTING -i eth0 -p tcp --dport 8443 -m comment --comment "NAS https" -j DNAT --to-destination 192.168.1.10:443`,
+		`# iptables -t nat -D PREROUTING -i eth0 -p tcp --dport 8443`,
+		`iptables -A FORWARD -p udp -d 192.168.1.10 --dport 51820 -m conntrack --ctstate NEW -j ACCEPT`,
+	} {
+		if !strings.Contains(ipt, want) {
+			t.Errorf("iptables规则缺少 %q:\n%s", want, ipt)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 分组状态
+const (
+	GroupStatusEnabled  = "enabled"
+	GroupStatusDisabled = "disabled"
+	GroupStatusPartial  = "partial"
+)
+
+// ErrGroupNotFound 映射分组不存在
+var ErrGroupNotFound = errors.New("映射分组不存在")
+
+// MappingGroup 映射分组及其汇总状态
+type MappingGroup struct {
+	Name     string           `json:"name"`
+	Status   string           `json:"status"` // enabled、disabled 或 partial
+	Total    int              `json:"total"`
+	Enabled  int              `json:"enabled"`
+	Active   int              `json:"active"` // 端口在线的映射数量
+	Mappings []*ManualMapping `json:"mappings"`
+}
+
+// GroupMemberResult 分组操作中单个映射的执行结果
+type GroupMemberResult struct {
+	InternalPort int    `json:"internal_port"`
+	ExternalPort int    `json:"external_port"`
+	Protocol     string `json:"protocol"`
+	Success      bool   `json:"success"`
+	Error        string `json:"error,omitempty"`
+}
+
+// GetMappingGroups 获取所有映射分组，按名称排序
+func (as *AutoUPnPService) GetMappingGroups() []*MappingGroup {
+	groups := make(map[string]*MappingGroup)
+	for _, mapping := range as.manualManager.GetMappings() {
+		if mapping.Group == "" {
+			continue
+		}
+
+		group, exists := groups[mapping.Group]
+		if !exists {
+			group = &MappingGroup{Name: mapping.Group}
+			groups[mapping.Group] = group
+		}
+
+		group.Total++
+		if !mapping.Disabled {
+			group.Enabled++
+		}
+		if mapping.Active {
+			group.Active++
+		}
+		group.Mappings = append(group.Mappings, mapping)
+	}
+
+	result := make([]*MappingGroup, 0, len(groups))
+	for _, group := range groups {
+		switch group.Enabled {
+		case group.Total:
+			group.Status = GroupStatusEnabled
+		case 0:
+			group.Status = GroupStatusDisabled
+		default:
+			group.Status = GroupStatusPartial
+		}
+		sort.Slice(group.Mappings, func(i, j int) bool {
+			return group.Mappings[i].InternalPort < group.Mappings[j].InternalPort
+		})
+		result = append(result, group)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// EnableMappingGroup 启用分组内的所有映射，端口在线的映射立即注册到路由器
+func (as *AutoUPnPService) EnableMappingGroup(name string) ([]*GroupMemberResult, error) {
+	return as.setMappingGroupEnabled(name, true)
+}
+
+// DisableMappingGroup 停用分组内的所有映射并从路由器删除，本地记录保留
+func (as *AutoUPnPService) DisableMappingGroup(name string) ([]*GroupMemberResult, error) {
+	return as.setMappingGroupEnabled(name, false)
+}
+
+// setMappingGroupEnabled 逐个启用或停用分组成员，单个成员失败不影响其他成员
+func (as *AutoUPnPService) setMappingGroupEnabled(name string, enabled bool) ([]*GroupMemberResult, error) {
+	// 与手动映射的添加删除串行执行，保证分组操作期间成员不变
+	as.manualMutex.Lock()
+	defer as.manualMutex.Unlock()
+
+	mappings := as.manualManager.GetGroupMappings(name)
+	if len(mappings) == 0 {
+		return nil, ErrGroupNotFound
+	}
+	sort.Slice(mappings, func(i, j int) bool { return mappings[i].InternalPort < mappings[j].InternalPort })
+
+	results := make([]*GroupMemberResult, 0, len(mappings))
+	failed := 0
+	for _, mapping := range mappings {
+		result := &GroupMemberResult{
+			InternalPort: mapping.InternalPort,
+			ExternalPort: mapping.ExternalPort,
+			Protocol:     mapping.Protocol,
+			Success:      true,
+		}
+
+		var err error
+		if enabled {
+			err = as.enableManualMapping(mapping)
+		} else {
+			err = as.disableManualMapping(mapping)
+		}
+		if err != nil {
+			result.Success = false
+			result.Error = err.Error()
+			failed++
+		}
+		results = append(results, result)
+	}
+
+	as.logger.WithFields(logrus.Fields{
+		"group":   name,
+		"enabled": enabled,
+		"total":   len(results),
+		"failed":  failed,
+	}).Info("映射分组状态已更新")
+
+	return results, nil
+}
+
+// enableManualMapping 启用单个手动映射
+func (as *AutoUPnPService) enableManualMapping(mapping *ManualMapping) error {
+	if err := as.manualManager.SetMappingDisabled(mapping.InternalPort, mapping.ExternalPort, mapping.Protocol, false); err != nil {
+		return err
+	}
+
+	// 端口不在线时等待端口上线后再注册，预留映射直接注册
+	if (!mapping.Active && !mapping.Reserved) || as.upnpManager == nil {
+		return nil
+	}
+	if as.upnpManager.HasPortMapping(mapping.InternalPort, mapping.CurrentExternalPort(), mapping.Protocol) {
+		return nil
+	}
+
+	if mapping.Active {
+		as.openPinhole(mapping.InternalPort, mapping.ExternalPort, mapping.Protocol, mapping.RemoteHost)
+	}
+	return as.addManualUPnPMapping(mapping)
+}
+
+// disableManualMapping 停用单个手动映射
+func (as *AutoUPnPService) disableManualMapping(mapping *ManualMapping) error {
+	if err := as.manualManager.SetMappingDisabled(mapping.InternalPort, mapping.ExternalPort, mapping.Protocol, true); err != nil {
+		return err
+	}
+
+	// 端口下线等待删除的映射直接在此删除
+	pending := as.cancelRemoval("manual", mapping.InternalPort, mapping.ExternalPort, mapping.Protocol)
+	if (!mapping.Active && !mapping.Reserved && !pending) || as.upnpManager == nil {
+		return nil
+	}
+
+	as.closePinhole(mapping.InternalPort, mapping.ExternalPort, mapping.Protocol)
+	if !as.upnpManager.HasPortMapping(mapping.InternalPort, mapping.CurrentExternalPort(), mapping.Protocol) {
+		return nil
+	}
+	return as.upnpManager.RemovePortMapping(mapping.InternalPort, mapping.CurrentExternalPort(), mapping.Protocol)
+}
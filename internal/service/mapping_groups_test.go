@@ -0,0 +1,57 @@
+package service
+
+import (
+	"testing"
+
+	"auto-upnp/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestMappingGroups_DisableEnable(t *testing.T) {
+	cfg := &config.Config{
+		Admin: config.AdminConfig{DataDir: t.TempDir()},
+	}
+	service := NewAutoUPnPService(cfg, logrus.New())
+
+	opts := ManualMappingOptions{Group: "game"}
+	for _, port := range []int{27015, 27016} {
+		if err := service.manualManager.AddMappingWithOptions(port, port, "UDP", "game", opts); err != nil {
+			t.Fatalf("添加映射失败: %v", err)
+		}
+		// 端口未在线，启用停用只修改本地记录
+		if err := service.manualManager.UpdateMappingActiveStatus(port, port, "UDP", false); err != nil {
+			t.Fatalf("更新映射状态失败: %v", err)
+		}
+	}
+	if err := service.manualManager.AddMapping(8080, 8080, "TCP", "web"); err != nil {
+		t.Fatalf("添加映射失败: %v", err)
+	}
+
+	results, err := service.DisableMappingGroup("game")
+	if err != nil {
+		t.Fatalf("停用分组失败: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("分组操作结果数量为 %d, 期望 2", len(results))
+	}
+
+	groups := service.GetMappingGroups()
+	if len(groups) != 1 || groups[0].Status != GroupStatusDisabled || groups[0].Total != 2 {
+		t.Fatalf("分组状态不正确: %+v", groups)
+	}
+	if web, _ := service.manualManager.GetMapping(8080, 8080, "TCP"); web.Disabled {
+		t.Error("未分组的映射不应被停用")
+	}
+
+	if _, err := service.EnableMappingGroup("game"); err != nil {
+		t.Fatalf("启用分组失败: %v", err)
+	}
+	if groups := service.GetMappingGroups(); groups[0].Status != GroupStatusEnabled {
+		t.Errorf("启用后分组状态为 %s, 期望 %s", groups[0].Status, GroupStatusEnabled)
+	}
+
+	if _, err := service.EnableMappingGroup("missing"); err != ErrGroupNotFound {
+		t.Errorf("不存在的分组应返回ErrGroupNotFound, 实际为 %v", err)
+	}
+}
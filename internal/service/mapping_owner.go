@@ -0,0 +1,31 @@
+package service
+
+import (
+	"fmt"
+
+	"auto-upnp/internal/portmonitor"
+)
+
+// MappingOwner 映射的内部端口当前所属的本地进程
+type MappingOwner struct {
+	InternalPort int                    `json:"internal_port"`
+	ExternalPort int                    `json:"external_port"`
+	Protocol     string                 `json:"protocol"`
+	Owner        *portmonitor.PortOwner `json:"owner"`
+}
+
+// GetMappingOwner 查询映射的内部端口由哪个进程（或容器）监听
+// 映射可以是手动映射，也可以是已注册到路由器的自动映射；无法确定进程时返回known为false的结果而不是错误
+func (as *AutoUPnPService) GetMappingOwner(internalPort, externalPort int, protocol string) (*MappingOwner, error) {
+	_, exists := as.manualManager.GetMapping(internalPort, externalPort, protocol)
+	if !exists && (as.upnpManager == nil || !as.upnpManager.HasPortMapping(internalPort, externalPort, protocol)) {
+		return nil, fmt.Errorf("%w: %d:%d:%s", ErrMappingNotFound, internalPort, externalPort, protocol)
+	}
+
+	return &MappingOwner{
+		InternalPort: internalPort,
+		ExternalPort: externalPort,
+		Protocol:     protocol,
+		Owner:        portmonitor.FindPortOwner(internalPort, protocol),
+	}, nil
+}
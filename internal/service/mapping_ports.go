@@ -0,0 +1,52 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidMappingPort 手动映射的端口无效或与自动映射的端口范围冲突
+var ErrInvalidMappingPort = errors.New("映射端口无效")
+
+// 端口号的有效范围
+const (
+	minMappingPort = 1
+	maxMappingPort = 65535
+)
+
+// validateMappingPorts 检查手动映射的端口
+//
+// 内部端口是本机服务监听的端口，也是端口监控检查的端口；外部端口是路由器对外开放的端口，
+// 路由器把外部端口的流量转发到本机的内部端口。自动映射总是 端口 -> 相同端口，
+// 所以自动监控范围内的端口不能再作为手动映射的内部端口或外部端口：
+// 前者会与自动映射重复，后者会占用范围内端口上线时自动映射要使用的外部端口
+func (as *AutoUPnPService) validateMappingPorts(internalPort, externalPort, backupExternalPort int) error {
+	if internalPort < minMappingPort || internalPort > maxMappingPort {
+		return fmt.Errorf("%w: 内部端口 %d 超出范围 %d-%d", ErrInvalidMappingPort, internalPort, minMappingPort, maxMappingPort)
+	}
+	if externalPort < minMappingPort || externalPort > maxMappingPort {
+		return fmt.Errorf("%w: 外部端口 %d 超出范围 %d-%d", ErrInvalidMappingPort, externalPort, minMappingPort, maxMappingPort)
+	}
+	if backupExternalPort != 0 {
+		if backupExternalPort < minMappingPort || backupExternalPort > maxMappingPort {
+			return fmt.Errorf("%w: 备用外部端口 %d 超出范围 %d-%d", ErrInvalidMappingPort, backupExternalPort, minMappingPort, maxMappingPort)
+		}
+		if backupExternalPort == externalPort {
+			return fmt.Errorf("%w: 备用外部端口不能与外部端口相同", ErrInvalidMappingPort)
+		}
+	}
+
+	if as.config.InPortRange(internalPort) {
+		return fmt.Errorf("%w: 内部端口 %d 在自动映射端口范围 %d-%d 内，由端口监控管理，请勿重复添加",
+			ErrInvalidMappingPort, internalPort, as.config.PortRange.Start, as.config.PortRange.End)
+	}
+	if as.config.InPortRange(externalPort) {
+		return fmt.Errorf("%w: 外部端口 %d 在自动映射端口范围 %d-%d 内，会与本机端口 %d 的自动映射冲突",
+			ErrInvalidMappingPort, externalPort, as.config.PortRange.Start, as.config.PortRange.End, externalPort)
+	}
+	if backupExternalPort != 0 && as.config.InPortRange(backupExternalPort) {
+		return fmt.Errorf("%w: 备用外部端口 %d 在自动映射端口范围 %d-%d 内，会与本机端口 %d 的自动映射冲突",
+			ErrInvalidMappingPort, backupExternalPort, as.config.PortRange.Start, as.config.PortRange.End, backupExternalPort)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"auto-upnp/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestValidateMappingPorts(t *testing.T) {
+	cfg := &config.Config{
+		PortRange: config.PortRangeConfig{Start: 8000, End: 9000, Step: 1},
+		Admin:     config.AdminConfig{DataDir: t.TempDir()},
+	}
+	service := NewAutoUPnPService(cfg, logrus.New())
+
+	tests := []struct {
+		name     string
+		internal int
+		external int
+		backup   int
+		valid    bool
+	}{
+		{"内外端口相同", 443, 443, 0, true},
+		{"内外端口不同", 443, 10443, 0, true},
+		{"外部端口低于内部端口", 10443, 443, 0, true},
+		{"最小端口", 1, 1, 0, true},
+		{"最大端口", 65535, 65535, 0, true},
+		{"范围下界前一个端口", 7999, 7999, 0, true},
+		{"范围上界后一个端口", 9001, 9001, 0, true},
+		{"内部端口为0", 0, 443, 0, false},
+		{"外部端口为0", 443, 0, 0, false},
+		{"内部端口超过65535", 65536, 443, 0, false},
+		{"外部端口超过65535", 443, 65536, 0, false},
+		{"负数端口", -1, 443, 0, false},
+		{"内部端口在范围起点", 8000, 10000, 0, false},
+		{"内部端口在范围终点", 9000, 10000, 0, false},
+		{"内外端口相同且在范围内", 8080, 8080, 0, false},
+		{"外部端口在范围起点", 443, 8000, 0, false},
+		{"外部端口在范围终点", 443, 9000, 0, false},
+		{"合法的备用端口", 443, 10443, 20443, true},
+		{"备用端口与外部端口相同", 443, 10443, 10443, false},
+		{"备用端口在范围内", 443, 10443, 8443, false},
+		{"备用端口超过65535", 443, 10443, 65536, false},
+		{"备用端口为负数", 443, 10443, -1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := service.validateMappingPorts(tt.internal, tt.external, tt.backup)
+			if tt.valid && err != nil {
+				t.Errorf("%d->%d(备用%d) 应合法: %v", tt.internal, tt.external, tt.backup, err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidMappingPort) {
+				t.Errorf("%d->%d(备用%d) 应返回ErrInvalidMappingPort, 实际为 %v", tt.internal, tt.external, tt.backup, err)
+			}
+		})
+	}
+}
+
+func TestAddManualMapping_PortSemantics(t *testing.T) {
+	cfg := &config.Config{
+		PortRange: config.PortRangeConfig{Start: 8000, End: 9000, Step: 1},
+		Admin:     config.AdminConfig{DataDir: t.TempDir()},
+	}
+	service := NewAutoUPnPService(cfg, logrus.New())
+
+	if _, err := service.AddManualMapping(8080, 10080, "TCP", "in range"); !errors.Is(err, ErrInvalidMappingPort) {
+		t.Fatalf("范围内的内部端口应被拒绝, 实际为 %v", err)
+	}
+	if _, err := service.AddManualMapping(80, 8080, "TCP", "shadow auto"); !errors.Is(err, ErrInvalidMappingPort) {
+		t.Fatalf("范围内的外部端口应被拒绝, 实际为 %v", err)
+	}
+
+	result, err := service.AddManualMapping(22, 10022, "TCP", "ssh")
+	if err != nil {
+		t.Fatalf("添加映射失败: %v", err)
+	}
+	if result.InternalPort != 22 || result.ExternalPort != 10022 {
+		t.Errorf("结果端口为 %d->%d, 期望 22->10022", result.InternalPort, result.ExternalPort)
+	}
+
+	// 内部端口和外部端口共同确定映射，交换后是另一条映射
+	if _, exists := service.manualManager.GetMapping(10022, 22, "TCP"); exists {
+		t.Error("不应存在内外端口交换后的映射")
+	}
+	if _, exists := service.manualManager.GetMapping(22, 10022, "TCP"); !exists {
+		t.Error("映射应按请求的内部端口和外部端口保存")
+	}
+}
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 映射优先级，外部端口冲突时优先级高的映射抢占优先级低的映射
+const (
+	AutoMappingPriority   = 0    // 自动映射的优先级，低于所有手动映射
+	DefaultManualPriority = 100  // 手动映射的默认优先级
+	MaxMappingPriority    = 1000 // 手动映射允许的最大优先级
+)
+
+// ErrExternalPortPreempted 外部端口被优先级更高的映射占用
+var ErrExternalPortPreempted = errors.New("外部端口被优先级更高的映射占用")
+
+// evictableBy 检查冲突的映射能否被指定优先级的映射抢占
+// 路由器上其他客户端的映射无法代为恢复，只能通过replace替换
+func (c *MappingConflict) evictableBy(priority int) bool {
+	return c.Source != ConflictSourceRouter && c.Priority < priority
+}
+
+// manualPortHolder 查找占用映射主外部端口且优先级更高的手动映射
+// 被停用的映射不占用端口；优先级更高的映射即使端口未上线也保留外部端口
+func (as *AutoUPnPService) manualPortHolder(mapping *ManualMapping) *ManualMapping {
+	for _, other := range as.manualManager.GetMappings() {
+		if other.Disabled || other.Priority <= mapping.Priority {
+			continue
+		}
+		if other.InternalPort == mapping.InternalPort && other.ExternalPort == mapping.ExternalPort {
+			continue
+		}
+		if strings.EqualFold(other.Protocol, mapping.Protocol) && other.CurrentExternalPort() == mapping.ExternalPort {
+			return other
+		}
+	}
+	return nil
+}
+
+// manualHoldsExternalPort 检查外部端口是否被手动映射占用，自动映射不会注册被手动映射占用的端口
+func (as *AutoUPnPService) manualHoldsExternalPort(externalPort int, protocol string) bool {
+	for _, mapping := range as.manualManager.GetMappings() {
+		if !mapping.Disabled && strings.EqualFold(mapping.Protocol, protocol) && mapping.CurrentExternalPort() == externalPort {
+			return true
+		}
+	}
+	return false
+}
+
+// evictMapping 从路由器删除被抢占的低优先级映射
+// 手动映射的本地记录保留，之后由rehomeEvictedMapping切换到备用端口或等待外部端口释放
+func (as *AutoUPnPService) evictMapping(conflict *MappingConflict, priority int) error {
+	fields := logrus.Fields{
+		"source":           conflict.Source,
+		"internal_port":    conflict.InternalPort,
+		"external_port":    conflict.ExternalPort,
+		"protocol":         conflict.Protocol,
+		"priority":         conflict.Priority,
+		"preempt_priority": priority,
+	}
+	if conflict.manual != nil {
+		fields["correlation_id"] = conflict.manual.CorrelationID
+	}
+	as.logger.WithFields(fields).Warn("抢占低优先级的端口映射")
+
+	switch conflict.Source {
+	case ConflictSourceManual:
+		mapping := conflict.manual
+		as.cancelRemoval("manual", mapping.InternalPort, mapping.ExternalPort, mapping.Protocol)
+		if as.upnpManager == nil || !as.upnpManager.HasPortMapping(mapping.InternalPort, conflict.ExternalPort, mapping.Protocol) {
+			return nil
+		}
+		return as.upnpManager.RemovePortMapping(mapping.InternalPort, conflict.ExternalPort, mapping.Protocol)
+	case ConflictSourceAuto:
+		as.mappingMutex.Lock()
+		defer as.mappingMutex.Unlock()
+
+		as.cancelRemoval("auto", conflict.ExternalPort, conflict.ExternalPort, "TCP")
+		if !as.activeMappings[conflict.ExternalPort] {
+			return nil
+		}
+		if err := as.upnpManager.RemovePortMapping(conflict.ExternalPort, conflict.ExternalPort, "TCP"); err != nil {
+			return err
+		}
+		delete(as.activeMappings, conflict.ExternalPort)
+		delete(as.autoCorrelationIDs, conflict.ExternalPort)
+		return nil
+	default:
+		return fmt.Errorf("冲突的映射不允许抢占: %s", conflict.Source)
+	}
+}
+
+// rehomeEvictedMapping 为被抢占的手动映射重新注册，有备用端口时切换到备用端口
+// 自动映射在外部端口被手动映射占用期间不会重新注册
+func (as *AutoUPnPService) rehomeEvictedMapping(conflict *MappingConflict) {
+	if conflict.Source != ConflictSourceManual || as.upnpManager == nil {
+		return
+	}
+
+	old := conflict.manual
+	mapping, exists := as.manualManager.GetMapping(old.InternalPort, old.ExternalPort, old.Protocol)
+	if !exists || !mapping.holdsRouterMapping() {
+		return
+	}
+
+	if err := as.addManualUPnPMapping(mapping); err != nil {
+		as.logger.WithError(err).WithFields(mapping.logFields()).Warn("被抢占的映射没有可用的备用端口，等待外部端口释放")
+	}
+}
+
+// reclaimExternalPort 外部端口释放后，由等待该端口的优先级最高的映射重新占用
+func (as *AutoUPnPService) reclaimExternalPort(externalPort int, protocol string) {
+	if as.upnpManager == nil {
+		return
+	}
+
+	candidates := make([]*ManualMapping, 0)
+	for _, mapping := range as.manualManager.GetMappings() {
+		if !mapping.holdsRouterMapping() || mapping.ExternalPort != externalPort || !strings.EqualFold(mapping.Protocol, protocol) {
+			continue
+		}
+		if mapping.CurrentExternalPort() == externalPort && as.upnpManager.HasPortMapping(mapping.InternalPort, externalPort, mapping.Protocol) {
+			return
+		}
+		candidates = append(candidates, mapping)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Priority > candidates[j].Priority })
+
+	for _, mapping := range candidates {
+		if as.manualPortHolder(mapping) != nil {
+			continue
+		}
+
+		// 正在使用备用端口的映射先释放备用端口，再注册回主外部端口
+		if livePort := mapping.CurrentExternalPort(); livePort != externalPort && as.upnpManager.HasPortMapping(mapping.InternalPort, livePort, mapping.Protocol) {
+			if err := as.upnpManager.RemovePortMapping(mapping.InternalPort, livePort, mapping.Protocol); err != nil {
+				as.logger.WithError(err).Warn("释放备用外部端口失败")
+				return
+			}
+		}
+
+		if err := as.addManualUPnPMapping(mapping); err != nil {
+			as.logger.WithFields(mapping.logFields()).WithError(err).Warn("重新占用外部端口失败")
+		} else {
+			as.logger.WithFields(mapping.logFields()).WithField("priority", mapping.Priority).Info("外部端口释放，被抢占的映射重新占用")
+		}
+		return
+	}
+
+	// 没有手动映射等待时交还给自动映射
+	if !strings.EqualFold(protocol, "TCP") || as.autoPortMonitor == nil || as.manualHoldsExternalPort(externalPort, protocol) {
+		return
+	}
+	if status, exists := as.autoPortMonitor.GetPortStatus(externalPort); exists && status.IsActive {
+		as.onAutoPortStatusChanged(externalPort, true)
+	}
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"testing"
+
+	"auto-upnp/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestMappingPriority_HigherPriorityPreemptsExternalPort(t *testing.T) {
+	cfg := &config.Config{
+		Admin: config.AdminConfig{DataDir: t.TempDir()},
+	}
+	service := NewAutoUPnPService(cfg, logrus.New())
+
+	// 端口未在线，抢占只修改本地记录
+	low := ManualMappingOptions{Priority: 10}
+	if err := service.manualManager.AddMappingWithOptions(9000, 9000, "TCP", "low", low); err != nil {
+		t.Fatalf("添加映射失败: %v", err)
+	}
+	if err := service.manualManager.UpdateMappingActiveStatus(9000, 9000, "TCP", false); err != nil {
+		t.Fatalf("更新映射状态失败: %v", err)
+	}
+
+	result, err := service.AddManualMappingWithOptions(9001, 9000, "TCP", "high", ManualMappingOptions{Priority: 200})
+	if err != nil {
+		t.Fatalf("高优先级映射应抢占外部端口: %v", err)
+	}
+	if result.Mapping.Priority != 200 {
+		t.Errorf("映射优先级为 %d, 期望 200", result.Mapping.Priority)
+	}
+
+	evicted, exists := service.manualManager.GetMapping(9000, 9000, "TCP")
+	if !exists {
+		t.Fatal("被抢占的映射应保留本地记录")
+	}
+	if holder := service.manualPortHolder(evicted); holder == nil || holder.InternalPort != 9001 {
+		t.Fatalf("被抢占映射的外部端口应由高优先级映射占用: %+v", holder)
+	}
+
+	// 默认优先级低于占用者，返回冲突且冲突方为优先级最高的映射
+	_, err = service.AddManualMappingWithOptions(9002, 9000, "TCP", "default", ManualMappingOptions{})
+	conflict, ok := IsMappingConflict(err)
+	if !ok {
+		t.Fatalf("低优先级映射应返回冲突, 实际为 %v", err)
+	}
+	if conflict.InternalPort != 9001 || conflict.Priority != 200 {
+		t.Errorf("冲突详情不正确: %+v", conflict)
+	}
+	if _, exists := service.manualManager.GetMapping(9002, 9000, "TCP"); exists {
+		t.Error("冲突时不应保存映射")
+	}
+}
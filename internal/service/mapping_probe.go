@@ -0,0 +1,158 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"auto-upnp/internal/portmonitor"
+)
+
+// 映射测试步骤
+const (
+	ProbeStepLocalService  = "local_service"
+	ProbeStepRouterMapping = "router_mapping"
+	ProbeStepExternal      = "external_reachability"
+)
+
+// 映射测试失败原因
+const (
+	ProbeFailureLocalServiceDown    = "local_service_down"
+	ProbeFailureRouterMappingBroken = "router_mapping_broken"
+	ProbeFailureExternalUnreachable = "external_unreachable"
+)
+
+// probeTimeout 映射测试中每个网络探测的超时时间
+const probeTimeout = 3 * time.Second
+
+// MappingProbeStep 映射测试的单个步骤
+type MappingProbeStep struct {
+	Name       string  `json:"name"`
+	Passed     bool    `json:"passed"`
+	Skipped    bool    `json:"skipped,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+	Detail     string  `json:"detail,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// MappingProbeResult 映射端到端测试结果
+type MappingProbeResult struct {
+	InternalPort  int                 `json:"internal_port"`
+	ExternalPort  int                 `json:"external_port"`
+	Protocol      string              `json:"protocol"`
+	Passed        bool                `json:"passed"`
+	FailureReason string              `json:"failure_reason,omitempty"`
+	DurationMs    float64             `json:"duration_ms"`
+	Steps         []*MappingProbeStep `json:"steps"`
+}
+
+// ProbeManualMapping 依次检查本地服务、路由器映射和外部可达性，定位"显示活跃但无法访问"的原因
+func (as *AutoUPnPService) ProbeManualMapping(internalPort, externalPort int, protocol string) (*MappingProbeResult, error) {
+	mapping, exists := as.manualManager.GetMapping(internalPort, externalPort, protocol)
+	if !exists {
+		return nil, fmt.Errorf("%w: %d:%d:%s", ErrMappingNotFound, internalPort, externalPort, protocol)
+	}
+
+	start := time.Now()
+	result := &MappingProbeResult{
+		InternalPort: internalPort,
+		ExternalPort: mapping.CurrentExternalPort(),
+		Protocol:     protocol,
+	}
+
+	steps := []struct {
+		name    string
+		failure string
+		run     func() (string, error)
+	}{
+		{ProbeStepLocalService, ProbeFailureLocalServiceDown, func() (string, error) {
+			return as.probeLocalService(mapping)
+		}},
+		{ProbeStepRouterMapping, ProbeFailureRouterMappingBroken, func() (string, error) {
+			return as.probeRouterMapping(mapping)
+		}},
+		{ProbeStepExternal, ProbeFailureExternalUnreachable, func() (string, error) {
+			return as.probeExternalReachability(mapping)
+		}},
+	}
+
+	for _, step := range steps {
+		probeStep := &MappingProbeStep{Name: step.name}
+		result.Steps = append(result.Steps, probeStep)
+
+		// 前一步失败后不再继续，后续步骤的结果没有意义
+		if result.FailureReason != "" {
+			probeStep.Skipped = true
+			continue
+		}
+
+		stepStart := time.Now()
+		detail, err := step.run()
+		probeStep.DurationMs = elapsedMs(stepStart)
+		probeStep.Detail = detail
+		if err != nil {
+			probeStep.Error = err.Error()
+			result.FailureReason = step.failure
+			continue
+		}
+		probeStep.Passed = true
+	}
+
+	result.Passed = result.FailureReason == ""
+	result.DurationMs = elapsedMs(start)
+	return result, nil
+}
+
+// probeLocalService 检查本机服务是否在监听
+func (as *AutoUPnPService) probeLocalService(mapping *ManualMapping) (string, error) {
+	state := portmonitor.ProbePortState(mapping.InternalPort, mapping.Protocol, probeTimeout)
+	if !state.IsListening() {
+		return string(state), fmt.Errorf("本地端口 %d/%s 没有服务在监听", mapping.InternalPort, mapping.Protocol)
+	}
+	return string(state), nil
+}
+
+// probeRouterMapping 检查路由器上的映射是否存在且指向本机
+func (as *AutoUPnPService) probeRouterMapping(mapping *ManualMapping) (string, error) {
+	if as.upnpManager == nil {
+		return "", fmt.Errorf("UPnP管理器未初始化")
+	}
+	if mapping.Disabled {
+		return "", fmt.Errorf("映射已被停用")
+	}
+	if err := as.upnpManager.VerifyRouterMapping(mapping.InternalPort, mapping.CurrentExternalPort(), mapping.Protocol); err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+// probeExternalReachability 通过公网地址连接映射端口，依赖路由器支持NAT回环
+func (as *AutoUPnPService) probeExternalReachability(mapping *ManualMapping) (string, error) {
+	if mapping.Protocol == "UDP" {
+		// UDP无连接，无法可靠判断外部可达性
+		return "UDP映射不支持外部可达性检查", nil
+	}
+
+	ip, err := as.GetExternalIP(false)
+	if err != nil {
+		return "", fmt.Errorf("获取公网IP失败: %w", err)
+	}
+
+	addr := net.JoinHostPort(ip.IP, strconv.Itoa(mapping.CurrentExternalPort()))
+	dialer := &net.Dialer{Timeout: probeTimeout}
+	if bindIP := as.config.Network.BindIP(); bindIP != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: bindIP}
+	}
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return addr, fmt.Errorf("连接 %s 失败（路由器可能不支持NAT回环，或上级网络存在NAT）: %w", addr, err)
+	}
+	conn.Close()
+	return addr, nil
+}
+
+// elapsedMs 计算经过的毫秒数
+func elapsedMs(start time.Time) float64 {
+	return float64(time.Since(start).Microseconds()) / 1000
+}
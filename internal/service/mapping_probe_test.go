@@ -0,0 +1,154 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"auto-upnp/config"
+	"auto-upnp/internal/upnp"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newProbeTestService 创建连接到测试路由器的服务，路由器对GetSpecificPortMappingEntry返回entry的结果，结果为空时返回714
+func newProbeTestService(t *testing.T, entry func() string) *AutoUPnPService {
+	router := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action := r.Header.Get("SOAPAction")
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		switch {
+		case strings.Contains(action, "GetExternalIPAddress"):
+			fmt.Fprintf(w, soapResponse, "GetExternalIPAddress", "<NewExternalIPAddress>203.0.113.7</NewExternalIPAddress>", "GetExternalIPAddress")
+			return
+		case strings.Contains(action, "GetSpecificPortMappingEntry"):
+			if fields := entry(); fields != "" {
+				fmt.Fprintf(w, soapResponse, "GetSpecificPortMappingEntry", fields, "GetSpecificPortMappingEntry")
+				return
+			}
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, soapErrorResponse, 714)
+	}))
+	t.Cleanup(router.Close)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	service := NewAutoUPnPService(&config.Config{
+		Admin: config.AdminConfig{DataDir: t.TempDir()},
+	}, logger)
+	service.upnpManager = upnp.NewUPnPManager(&upnp.Config{
+		GatewayURLs:      []string{router.URL + "/ctl/IPConn"},
+		BindAddress:      "192.168.1.10",
+		DiscoveryTimeout: time.Second,
+		MaxMappings:      10,
+	}, logger)
+	t.Cleanup(service.upnpManager.Close)
+	if err := service.upnpManager.Discover(); err != nil {
+		t.Fatalf("连接测试路由器失败: %v", err)
+	}
+	return service
+}
+
+// probeStepNames 按步骤名称索引测试结果
+func probeStepNames(result *MappingProbeResult) map[string]*MappingProbeStep {
+	steps := make(map[string]*MappingProbeStep, len(result.Steps))
+	for _, step := range result.Steps {
+		steps[step.Name] = step
+	}
+	return steps
+}
+
+func TestProbeManualMapping_Reachable(t *testing.T) {
+	conn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		t.Fatalf("监听UDP端口失败: %v", err)
+	}
+	defer conn.Close()
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+
+	service := newProbeTestService(t, func() string {
+		return fmt.Sprintf("<NewInternalPort>%d</NewInternalPort><NewInternalClient>192.168.1.10</NewInternalClient>"+
+			"<NewEnabled>1</NewEnabled><NewPortMappingDescription>test</NewPortMappingDescription><NewLeaseDuration>0</NewLeaseDuration>", port)
+	})
+	if err := service.manualManager.AddMapping(port, port, "UDP", "test"); err != nil {
+		t.Fatalf("添加映射失败: %v", err)
+	}
+
+	result, err := service.ProbeManualMapping(port, port, "UDP")
+	if err != nil {
+		t.Fatalf("映射测试失败: %v", err)
+	}
+	if !result.Passed || result.FailureReason != "" {
+		t.Fatalf("本地服务在监听且路由器映射指向本机时应通过, 失败原因 %q", result.FailureReason)
+	}
+	for _, step := range result.Steps {
+		if !step.Passed || step.Skipped {
+			t.Errorf("步骤 %s 应通过: %+v", step.Name, step)
+		}
+	}
+}
+
+func TestProbeManualMapping_Unreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听TCP端口失败: %v", err)
+	}
+	listeningPort := listener.Addr().(*net.TCPAddr).Port
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听TCP端口失败: %v", err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+	defer listener.Close()
+
+	// 路由器上的映射都不存在
+	service := newProbeTestService(t, func() string { return "" })
+
+	tests := []struct {
+		name    string
+		port    int
+		failure string
+		failed  string
+		detail  string
+		skipped []string
+	}{
+		{"本地服务未监听", closedPort, ProbeFailureLocalServiceDown, ProbeStepLocalService, "没有服务在监听",
+			[]string{ProbeStepRouterMapping, ProbeStepExternal}},
+		{"路由器映射不存在", listeningPort, ProbeFailureRouterMappingBroken, ProbeStepRouterMapping, "路由器上不存在",
+			[]string{ProbeStepExternal}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := service.manualManager.AddMapping(tt.port, tt.port, "TCP", "test"); err != nil {
+				t.Fatalf("添加映射失败: %v", err)
+			}
+
+			result, err := service.ProbeManualMapping(tt.port, tt.port, "TCP")
+			if err != nil {
+				t.Fatalf("映射测试失败: %v", err)
+			}
+			if result.Passed || result.FailureReason != tt.failure {
+				t.Fatalf("失败原因 = %q, 期望 %q", result.FailureReason, tt.failure)
+			}
+			steps := probeStepNames(result)
+			if step := steps[tt.failed]; step.Passed || !strings.Contains(step.Error, tt.detail) {
+				t.Errorf("步骤 %s 应失败并记录包含 %q 的错误: %+v", tt.failed, tt.detail, step)
+			}
+			for _, name := range tt.skipped {
+				if !steps[name].Skipped {
+					t.Errorf("步骤 %s 应在前一步失败后跳过", name)
+				}
+			}
+		})
+	}
+
+	if _, err := service.ProbeManualMapping(9999, 9999, "TCP"); err == nil {
+		t.Error("不存在的映射应返回错误")
+	}
+}
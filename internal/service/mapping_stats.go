@@ -0,0 +1,98 @@
+package service
+
+import (
+	"time"
+)
+
+// mappingStatsResetAfter 映射成功后持续该时长没有再失败，则清零失败计数
+const mappingStatsResetAfter = 10 * time.Minute
+
+// MappingStats 映射的累计尝试和失败计数，用于区分"从未成功"和"反复失败"
+type MappingStats struct {
+	AttemptCount  int    `json:"attempt_count"`
+	FailureCount  int    `json:"failure_count"`
+	LastError     string `json:"last_error,omitempty"`
+	LastErrorAt   string `json:"last_error_at,omitempty"`
+	LastSuccessAt string `json:"last_success_at,omitempty"`
+}
+
+// record 记录一次映射尝试的结果
+func (s *MappingStats) record(err error, now time.Time) {
+	s.AttemptCount++
+	if err != nil {
+		s.FailureCount++
+		s.LastError = err.Error()
+		s.LastErrorAt = now.Format(time.RFC3339)
+		return
+	}
+	s.LastSuccessAt = now.Format(time.RFC3339)
+}
+
+// resetIfSustained 最近一次成功之后持续稳定时清零计数，返回是否发生了清零
+func (s *MappingStats) resetIfSustained(now time.Time) bool {
+	if s.FailureCount == 0 || s.LastSuccessAt == "" {
+		return false
+	}
+
+	lastSuccess, err := time.Parse(time.RFC3339, s.LastSuccessAt)
+	if err != nil || now.Sub(lastSuccess) < mappingStatsResetAfter {
+		return false
+	}
+	if lastError, err := time.Parse(time.RFC3339, s.LastErrorAt); err == nil && lastError.After(lastSuccess) {
+		return false
+	}
+
+	*s = MappingStats{LastSuccessAt: s.LastSuccessAt}
+	return true
+}
+
+// recordAutoMappingAttempt 记录自动映射的尝试结果（调用者需要持有mappingMutex）
+func (as *AutoUPnPService) recordAutoMappingAttempt(port int, err error) {
+	stats, exists := as.autoMappingStats[port]
+	if !exists {
+		stats = &MappingStats{}
+		as.autoMappingStats[port] = stats
+	}
+	stats.record(err, time.Now())
+	as.recordMappingAdd("auto", port, port, "TCP", err)
+}
+
+// recordManualMappingAttempt 记录手动映射的尝试结果
+func (as *AutoUPnPService) recordManualMappingAttempt(mapping *ManualMapping, err error) {
+	as.recordMappingAdd("manual", mapping.InternalPort, mapping.CurrentExternalPort(), mapping.Protocol, err)
+	if recordErr := as.manualManager.RecordMappingAttempt(
+		mapping.InternalPort,
+		mapping.ExternalPort,
+		mapping.Protocol,
+		err,
+	); recordErr != nil {
+		as.logger.WithError(recordErr).Debug("记录手动映射尝试结果失败")
+	}
+}
+
+// resetSustainedMappingStats 清零已持续稳定的映射的失败计数（调用者需要持有mappingMutex）
+func (as *AutoUPnPService) resetSustainedMappingStats() {
+	now := time.Now()
+	for port, stats := range as.autoMappingStats {
+		stats.resetIfSustained(now)
+		// 端口已不再映射且没有失败记录时不再保留
+		if !as.activeMappings[port] && stats.FailureCount == 0 {
+			delete(as.autoMappingStats, port)
+		}
+	}
+
+	if as.manualManager != nil {
+		if err := as.manualManager.ResetSustainedStats(now); err != nil {
+			as.logger.WithError(err).Warn("清零手动映射失败计数失败")
+		}
+	}
+}
+
+// autoMappingStatsSnapshot 复制自动映射的计数（调用者需要持有mappingMutex）
+func (as *AutoUPnPService) autoMappingStatsSnapshot() map[int]MappingStats {
+	snapshot := make(map[int]MappingStats, len(as.autoMappingStats))
+	for port, stats := range as.autoMappingStats {
+		snapshot[port] = *stats
+	}
+	return snapshot
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMappingStats_ResetAfterSustainedSuccess(t *testing.T) {
+	start := time.Now()
+	stats := &MappingStats{}
+
+	stats.record(errors.New("路由器拒绝"), start)
+	stats.record(errors.New("路由器拒绝"), start.Add(time.Minute))
+	stats.record(nil, start.Add(2*time.Minute))
+
+	if stats.AttemptCount != 3 || stats.FailureCount != 2 {
+		t.Fatalf("计数错误: %+v", stats)
+	}
+
+	// 成功时间不够长，不清零
+	if stats.resetIfSustained(start.Add(5 * time.Minute)) {
+		t.Fatal("成功时间不足时不应清零")
+	}
+
+	if !stats.resetIfSustained(start.Add(2*time.Minute + mappingStatsResetAfter)) {
+		t.Fatal("持续成功后应当清零")
+	}
+	if stats.AttemptCount != 0 || stats.FailureCount != 0 || stats.LastError != "" {
+		t.Errorf("清零后计数错误: %+v", stats)
+	}
+}
+
+func TestMappingStats_NoResetAfterLaterFailure(t *testing.T) {
+	start := time.Now()
+	stats := &MappingStats{}
+
+	stats.record(nil, start)
+	stats.record(errors.New("路由器拒绝"), start.Add(time.Minute))
+
+	if stats.resetIfSustained(start.Add(time.Hour)) {
+		t.Fatal("最近一次尝试失败时不应清零")
+	}
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"auto-upnp/internal/mdns"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MDNSStatus mDNS广播状态
+type MDNSStatus struct {
+	HostName string         `json:"host_name"`
+	Services []mdns.Service `json:"services"`
+}
+
+// startMDNS 启动mDNS应答器，在局域网广播配置了服务类型的映射
+func (as *AutoUPnPService) startMDNS() error {
+	cfg := as.config.MDNS
+
+	var iface *net.Interface
+	if cfg.Interface != "" {
+		var err error
+		if iface, err = net.InterfaceByName(cfg.Interface); err != nil {
+			return fmt.Errorf("查找mDNS网卡失败: %w", err)
+		}
+	}
+
+	responder := mdns.NewResponder(mdnsHostName(cfg.HostName), as.logger)
+	if err := responder.Start(iface); err != nil {
+		return err
+	}
+	as.mdnsResponder = responder
+
+	as.heartbeats.Register(SubsystemMDNS, as.config.Monitor.CheckInterval)
+	as.wg.Add(1)
+	go as.mdnsRoutine()
+	return nil
+}
+
+// mdnsHostName 生成合法的主机名标签，未配置时使用本机主机名
+func mdnsHostName(configured string) string {
+	name := configured
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+	name = strings.TrimSuffix(name, ".local")
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[:i]
+	}
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' {
+			return r
+		}
+		return '-'
+	}, name)
+	if name == "" {
+		return "auto-upnp"
+	}
+	return name
+}
+
+// notifyMDNS 映射变化时唤醒mDNS协程
+func (as *AutoUPnPService) notifyMDNS() {
+	if as.mdnsResponder == nil {
+		return
+	}
+	select {
+	case as.mdnsTrigger <- struct{}{}:
+	default:
+	}
+}
+
+// mdnsRoutine 按映射状态同步广播的服务，退出时注销所有服务
+func (as *AutoUPnPService) mdnsRoutine() {
+	defer as.wg.Done()
+	defer as.heartbeats.Recover(SubsystemMDNS)
+	defer as.mdnsResponder.Close()
+
+	ticker := time.NewTicker(as.config.Monitor.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		as.syncMDNS()
+		as.heartbeats.Beat(SubsystemMDNS)
+
+		select {
+		case <-as.ctx.Done():
+			return
+		case <-as.mdnsTrigger:
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncMDNS 广播活跃的映射，注销已删除或下线的映射
+func (as *AutoUPnPService) syncMDNS() {
+	if as.upnpManager != nil {
+		if localIP, err := as.upnpManager.GetLocalIP(); err == nil {
+			as.mdnsResponder.SetIP(net.ParseIP(localIP))
+		}
+	}
+
+	services := as.mdnsServices()
+	added, removed := as.mdnsResponder.Sync(services)
+	if added > 0 || removed > 0 {
+		as.logger.WithFields(logrus.Fields{
+			"added":    added,
+			"removed":  removed,
+			"services": len(services),
+		}).Info("更新mDNS服务广播")
+	}
+}
+
+// mdnsServices 收集需要广播的服务：已映射的自动端口和已激活的手动映射
+func (as *AutoUPnPService) mdnsServices() []mdns.Service {
+	hostName := as.mdnsResponder.HostName()
+	var services []mdns.Service
+
+	as.mappingMutex.RLock()
+	for _, svc := range as.config.MDNS.Services {
+		if !as.activeMappings[svc.Port] {
+			continue
+		}
+		name := svc.Name
+		if name == "" {
+			name = fmt.Sprintf("%s-%d", strings.TrimSuffix(hostName, ".local"), svc.Port)
+		}
+		services = append(services, mdns.Service{Instance: name, Type: svc.Type, Port: svc.Port})
+	}
+	as.mappingMutex.RUnlock()
+
+	for _, mapping := range as.manualManager.GetMappings() {
+		if mapping.MDNSType == "" || !mapping.Active || mapping.Disabled {
+			continue
+		}
+		name := mapping.MDNSName
+		if name == "" {
+			name = mapping.Description
+		}
+		services = append(services, mdns.Service{Instance: name, Type: mapping.MDNSType, Port: mapping.InternalPort})
+	}
+	return services
+}
+
+// mdnsStatus 返回mDNS广播状态，未启用时返回nil
+func (as *AutoUPnPService) mdnsStatus() *MDNSStatus {
+	if as.mdnsResponder == nil {
+		return nil
+	}
+	return &MDNSStatus{
+		HostName: as.mdnsResponder.HostName(),
+		Services: as.mdnsResponder.Services(),
+	}
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"auto-upnp/internal/metrics"
+)
+
+// Metrics 采集服务指标，供各指标导出方式共用
+func (as *AutoUPnPService) Metrics() []metrics.Sample {
+	var samples []metrics.Sample
+
+	as.mappingMutex.RLock()
+	autoMappings := len(as.activeMappings)
+	var autoAttempts, autoFailures int
+	for _, stats := range as.autoMappingStats {
+		autoAttempts += stats.AttemptCount
+		autoFailures += stats.FailureCount
+	}
+	as.mappingMutex.RUnlock()
+
+	var manualActive, manualInactive, manualDisabled int
+	var manualAttempts, manualFailures int
+	for _, mapping := range as.GetManualMappings() {
+		switch {
+		case mapping.Disabled:
+			manualDisabled++
+		case mapping.Active:
+			manualActive++
+		default:
+			manualInactive++
+		}
+		manualAttempts += mapping.AttemptCount
+		manualFailures += mapping.FailureCount
+	}
+
+	samples = append(samples,
+		metrics.Gauge("auto_upnp_auto_mappings", "已注册的自动映射数量", float64(autoMappings), nil),
+		metrics.Gauge("auto_upnp_manual_mappings", "手动映射数量", float64(manualActive), map[string]string{"state": "active"}),
+		metrics.Gauge("auto_upnp_manual_mappings", "手动映射数量", float64(manualInactive), map[string]string{"state": "inactive"}),
+		metrics.Gauge("auto_upnp_manual_mappings", "手动映射数量", float64(manualDisabled), map[string]string{"state": "disabled"}),
+		metrics.Gauge("auto_upnp_pending_removals", "等待宽限期结束后删除的映射数量", float64(len(as.GetPendingRemovals())), nil),
+		metrics.Gauge("auto_upnp_mapping_attempts", "映射尝试次数，映射持续稳定后清零", float64(autoAttempts), map[string]string{"type": "auto"}),
+		metrics.Gauge("auto_upnp_mapping_attempts", "映射尝试次数，映射持续稳定后清零", float64(manualAttempts), map[string]string{"type": "manual"}),
+		metrics.Gauge("auto_upnp_mapping_failures", "映射失败次数，映射持续稳定后清零", float64(autoFailures), map[string]string{"type": "auto"}),
+		metrics.Gauge("auto_upnp_mapping_failures", "映射失败次数，映射持续稳定后清零", float64(manualFailures), map[string]string{"type": "manual"}),
+		metrics.Gauge("auto_upnp_upnp_clients", "可用的UPnP客户端数量", float64(as.GetUPnPClientCount()), nil),
+		metrics.Gauge("auto_upnp_upnp_healthy_clients", "健康的UPnP客户端数量", float64(as.getHealthyUPnPClientCount()), nil),
+		metrics.Gauge("auto_upnp_upnp_available", "UPnP是否可用", metrics.Bool(as.IsUPnPAvailable()), nil),
+		metrics.Gauge("auto_upnp_external_ip_known", "是否已获取到公网IP", metrics.Bool(as.externalIPStatus() != nil), nil),
+	)
+
+	for _, mappingType := range []string{"auto", "manual"} {
+		labels := map[string]string{"type": mappingType}
+		samples = append(samples,
+			metrics.Counter("auto_upnp_mappings_created_total", "成功添加到路由器的映射次数", float64(as.mappingEvents.count(mappingType, mappingEventCreated)), labels),
+			metrics.Counter("auto_upnp_mappings_removed_total", "成功从路由器删除的映射次数", float64(as.mappingEvents.count(mappingType, mappingEventRemoved)), labels),
+			metrics.Counter("auto_upnp_mapping_operation_failures_total", "添加或删除映射失败的次数", float64(as.mappingEvents.count(mappingType, mappingEventAddFailed)),
+				map[string]string{"type": mappingType, "operation": "add"}),
+			metrics.Counter("auto_upnp_mapping_operation_failures_total", "添加或删除映射失败的次数", float64(as.mappingEvents.count(mappingType, mappingEventRemoveFailed)),
+				map[string]string{"type": mappingType, "operation": "remove"}),
+		)
+	}
+
+	for _, subsystem := range as.GetSubsystemStatus() {
+		samples = append(samples, metrics.Gauge(
+			"auto_upnp_subsystem_healthy", "子系统是否按时心跳",
+			metrics.Bool(subsystem.Healthy), map[string]string{"subsystem": subsystem.Name},
+		))
+	}
+
+	return samples
+}
+
+// getHealthyUPnPClientCount 获取健康的UPnP客户端数量
+func (as *AutoUPnPService) getHealthyUPnPClientCount() int {
+	if as.upnpManager == nil {
+		return 0
+	}
+	return as.upnpManager.GetHealthyClientCount()
+}
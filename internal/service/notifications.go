@@ -0,0 +1,60 @@
+package service
+
+import (
+	"time"
+
+	"auto-upnp/internal/externalip"
+	"auto-upnp/internal/notify"
+)
+
+// mappingProvider 映射事件中的映射提供方，本服务只通过UPnP添加映射
+const mappingProvider = "upnp"
+
+// startNotifications 启动映射事件的Webhook推送协程
+func (as *AutoUPnPService) startNotifications() {
+	cfg := as.config.Notifications
+	as.notifier = notify.NewDispatcher(notify.WebhookConfig{
+		URL:         cfg.WebhookURL,
+		Timeout:     cfg.Timeout,
+		QueueSize:   cfg.QueueSize,
+		MaxAttempts: cfg.MaxAttempts,
+		RetryDelay:  cfg.RetryDelay,
+	}, externalip.NewHTTPClient(as.config.Network.BindIP()), as.logger)
+
+	as.wg.Add(1)
+	go func() {
+		defer as.wg.Done()
+		as.notifier.Run(as.ctx)
+	}()
+}
+
+// notifyMappingEvent 将映射事件加入推送队列，未配置Webhook时忽略；不会阻塞调用者
+func (as *AutoUPnPService) notifyMappingEvent(event, mappingType string, internalPort, externalPort int, protocol, operation string, err error) {
+	if as.notifier == nil {
+		return
+	}
+
+	e := notify.Event{
+		Event:        event,
+		Type:         mappingType,
+		InternalPort: internalPort,
+		ExternalPort: externalPort,
+		Protocol:     protocol,
+		Provider:     mappingProvider,
+		Operation:    operation,
+		Timestamp:    time.Now(),
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	as.notifier.Notify(e)
+}
+
+// notificationStatus 返回Webhook推送状态，未启用时返回nil
+func (as *AutoUPnPService) notificationStatus() *notify.Status {
+	if as.notifier == nil {
+		return nil
+	}
+	status := as.notifier.Status()
+	return &status
+}
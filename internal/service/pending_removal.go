@@ -0,0 +1,147 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PendingRemoval 等待宽限期结束后删除的映射
+type PendingRemoval struct {
+	Type         string    `json:"type"` // auto 或 manual
+	InternalPort int       `json:"internal_port"`
+	ExternalPort int       `json:"external_port"`
+	Protocol     string    `json:"protocol"`
+	ScheduledAt  time.Time `json:"scheduled_at"`
+	RemoveAt     time.Time `json:"remove_at"`
+
+	timer *time.Timer
+}
+
+// pendingRemovalKey 生成待删除映射的键
+func pendingRemovalKey(mappingType string, internalPort, externalPort int, protocol string) string {
+	return fmt.Sprintf("%s:%d:%d:%s", mappingType, internalPort, externalPort, protocol)
+}
+
+// scheduleRemoval 安排在宽限期结束后执行删除，未配置宽限期时返回false，由调用方立即删除
+// 定时器计入服务的wg，Stop会取消尚未触发的删除并等待正在执行的删除完成
+func (as *AutoUPnPService) scheduleRemoval(removal *PendingRemoval, remove func()) bool {
+	gracePeriod := as.config.Monitor.RemoveGracePeriod
+	if gracePeriod <= 0 {
+		return false
+	}
+
+	key := pendingRemovalKey(removal.Type, removal.InternalPort, removal.ExternalPort, removal.Protocol)
+
+	as.pendingMutex.Lock()
+	defer as.pendingMutex.Unlock()
+
+	if _, exists := as.pendingRemovals[key]; exists {
+		return true
+	}
+	// 服务正在停止，映射由关闭流程统一删除
+	if as.ctx.Err() != nil {
+		return true
+	}
+
+	removal.ScheduledAt = time.Now()
+	removal.RemoveAt = removal.ScheduledAt.Add(gracePeriod)
+	as.wg.Add(1)
+	removal.timer = time.AfterFunc(gracePeriod, func() {
+		defer as.wg.Done()
+
+		as.pendingMutex.Lock()
+		current, exists := as.pendingRemovals[key]
+		if !exists || current != removal {
+			// 已被取消
+			as.pendingMutex.Unlock()
+			return
+		}
+		delete(as.pendingRemovals, key)
+		as.pendingMutex.Unlock()
+
+		remove()
+		as.notifyStatusChanged()
+	})
+	as.pendingRemovals[key] = removal
+	as.notifyStatusChanged()
+
+	as.logger.WithFields(logrus.Fields{
+		"type":          removal.Type,
+		"internal_port": removal.InternalPort,
+		"external_port": removal.ExternalPort,
+		"protocol":      removal.Protocol,
+		"grace_period":  gracePeriod.String(),
+	}).Info("端口下线，映射将在宽限期结束后删除")
+	return true
+}
+
+// cancelRemoval 取消待删除的映射，返回映射是否处于待删除状态
+func (as *AutoUPnPService) cancelRemoval(mappingType string, internalPort, externalPort int, protocol string) bool {
+	if !as.dropRemoval(mappingType, internalPort, externalPort, protocol) {
+		return false
+	}
+
+	as.logger.WithFields(logrus.Fields{
+		"type":          mappingType,
+		"internal_port": internalPort,
+		"external_port": externalPort,
+		"protocol":      protocol,
+	}).Info("端口在宽限期内恢复，保留映射")
+	return true
+}
+
+// dropRemoval 移除待删除的映射并停止其定时器，返回映射是否处于待删除状态
+func (as *AutoUPnPService) dropRemoval(mappingType string, internalPort, externalPort int, protocol string) bool {
+	key := pendingRemovalKey(mappingType, internalPort, externalPort, protocol)
+
+	as.pendingMutex.Lock()
+	defer as.pendingMutex.Unlock()
+
+	removal, exists := as.pendingRemovals[key]
+	if !exists {
+		return false
+	}
+
+	as.stopRemovalTimer(removal)
+	delete(as.pendingRemovals, key)
+	as.notifyStatusChanged()
+	return true
+}
+
+// cancelAllRemovals 取消所有待删除的映射
+func (as *AutoUPnPService) cancelAllRemovals() {
+	as.pendingMutex.Lock()
+	defer as.pendingMutex.Unlock()
+
+	for key, removal := range as.pendingRemovals {
+		as.stopRemovalTimer(removal)
+		delete(as.pendingRemovals, key)
+	}
+}
+
+// stopRemovalTimer 停止删除定时器（调用者需要持有pendingMutex）
+// 定时器已经触发时由回调释放wg，回调发现记录已被删除后直接返回
+func (as *AutoUPnPService) stopRemovalTimer(removal *PendingRemoval) {
+	if removal.timer.Stop() {
+		as.wg.Done()
+	}
+}
+
+// GetPendingRemovals 获取等待删除的映射列表
+func (as *AutoUPnPService) GetPendingRemovals() []*PendingRemoval {
+	as.pendingMutex.Lock()
+	defer as.pendingMutex.Unlock()
+
+	removals := make([]*PendingRemoval, 0, len(as.pendingRemovals))
+	for _, removal := range as.pendingRemovals {
+		removals = append(removals, removal)
+	}
+
+	sort.Slice(removals, func(i, j int) bool {
+		return removals[i].RemoveAt.Before(removals[j].RemoveAt)
+	})
+	return removals
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"auto-upnp/config"
+	"auto-upnp/internal/upnp"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestScheduleRemoval_CancelWithinGracePeriod(t *testing.T) {
+	cfg := &config.Config{
+		Admin:   config.AdminConfig{DataDir: t.TempDir()},
+		Monitor: config.MonitorConfig{RemoveGracePeriod: 50 * time.Millisecond},
+	}
+	service := NewAutoUPnPService(cfg, logrus.New())
+
+	var removed int32
+	removal := &PendingRemoval{Type: "auto", InternalPort: 8080, ExternalPort: 8080, Protocol: "TCP"}
+	if !service.scheduleRemoval(removal, func() { atomic.AddInt32(&removed, 1) }) {
+		t.Fatal("配置宽限期时应当延迟删除")
+	}
+
+	if pending := service.GetPendingRemovals(); len(pending) != 1 {
+		t.Fatalf("待删除映射数量为 %d, 期望 1", len(pending))
+	}
+
+	// 宽限期内端口恢复
+	if !service.cancelRemoval("auto", 8080, 8080, "TCP") {
+		t.Fatal("取消待删除映射失败")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt32(&removed) != 0 {
+		t.Error("宽限期内恢复的映射不应被删除")
+	}
+	if pending := service.GetPendingRemovals(); len(pending) != 0 {
+		t.Errorf("取消后仍有 %d 个待删除映射", len(pending))
+	}
+}
+
+func TestScheduleRemoval_RemoveAfterGracePeriod(t *testing.T) {
+	cfg := &config.Config{
+		Admin:   config.AdminConfig{DataDir: t.TempDir()},
+		Monitor: config.MonitorConfig{RemoveGracePeriod: 20 * time.Millisecond},
+	}
+	service := NewAutoUPnPService(cfg, logrus.New())
+
+	done := make(chan struct{})
+	removal := &PendingRemoval{Type: "manual", InternalPort: 8080, ExternalPort: 80, Protocol: "TCP"}
+	service.scheduleRemoval(removal, func() { close(done) })
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("宽限期结束后映射未被删除")
+	}
+
+	if pending := service.GetPendingRemovals(); len(pending) != 0 {
+		t.Errorf("删除后仍有 %d 个待删除映射", len(pending))
+	}
+}
+
+func TestStop_CancelsAndWaitsForPendingRemovals(t *testing.T) {
+	cfg := &config.Config{
+		Admin:   config.AdminConfig{DataDir: t.TempDir()},
+		Monitor: config.MonitorConfig{RemoveGracePeriod: time.Millisecond},
+	}
+	service := NewAutoUPnPService(cfg, logrus.New())
+
+	// 第一个删除在Stop时正在执行，第二个删除尚未到期
+	started := make(chan struct{})
+	var finished int32
+	service.scheduleRemoval(&PendingRemoval{Type: "auto", InternalPort: 8080, ExternalPort: 8080, Protocol: "TCP"}, func() {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		atomic.StoreInt32(&finished, 1)
+	})
+	<-started
+
+	service.config.Monitor.RemoveGracePeriod = 20 * time.Millisecond
+	var removed int32
+	service.scheduleRemoval(&PendingRemoval{Type: "auto", InternalPort: 8081, ExternalPort: 8081, Protocol: "TCP"}, func() {
+		atomic.AddInt32(&removed, 1)
+	})
+
+	service.Stop()
+	if atomic.LoadInt32(&finished) != 1 {
+		t.Error("Stop应等待正在执行的删除完成后再返回")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt32(&removed) != 0 {
+		t.Error("Stop之后不应再执行延迟删除")
+	}
+	service.scheduleRemoval(&PendingRemoval{Type: "auto", InternalPort: 8082, ExternalPort: 8082, Protocol: "TCP"}, func() {
+		atomic.AddInt32(&removed, 1)
+	})
+	if pending := service.GetPendingRemovals(); len(pending) != 0 {
+		t.Errorf("服务停止后仍安排了 %d 个延迟删除", len(pending))
+	}
+}
+
+func TestRemoveOfflineManualMapping_RechecksMappingState(t *testing.T) {
+	var takenPort atomic.Bool
+	router := newTestRouter(t, &takenPort)
+
+	cfg := &config.Config{
+		Admin: config.AdminConfig{DataDir: t.TempDir()},
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	service := NewAutoUPnPService(cfg, logger)
+	service.upnpManager = upnp.NewUPnPManager(&upnp.Config{
+		GatewayURLs:      []string{router.URL + "/ctl/IPConn"},
+		BindAddress:      "192.168.1.10",
+		DiscoveryTimeout: time.Second,
+		MaxMappings:      10,
+	}, logger)
+	t.Cleanup(service.upnpManager.Close)
+
+	// add 添加指定激活状态的手动映射，返回映射快照
+	add := func(active bool) *ManualMapping {
+		t.Helper()
+		if err := service.manualManager.AddMapping(9500, 9500, "TCP", "web"); err != nil {
+			t.Fatalf("添加映射失败: %v", err)
+		}
+		if err := service.manualManager.UpdateMappingActiveStatus(9500, 9500, "TCP", active); err != nil {
+			t.Fatalf("更新映射状态失败: %v", err)
+		}
+		mapping, _ := service.manualManager.GetMapping(9500, 9500, "TCP")
+		return mapping
+	}
+
+	scheduled := add(true)
+	if err := service.addManualUPnPMapping(scheduled); err != nil {
+		t.Fatalf("注册映射失败: %v", err)
+	}
+
+	// 宽限期内端口恢复
+	service.removeOfflineManualMapping(scheduled)
+	if !service.upnpManager.HasPortMapping(9500, 9500, "TCP") {
+		t.Error("端口已经恢复的映射不应从路由器删除")
+	}
+
+	// 宽限期内映射被删除后重新添加
+	if err := service.manualManager.RemoveMapping(9500, 9500, "TCP"); err != nil {
+		t.Fatalf("删除映射失败: %v", err)
+	}
+	add(false)
+	service.removeOfflineManualMapping(scheduled)
+	if !service.upnpManager.HasPortMapping(9500, 9500, "TCP") {
+		t.Error("重新添加的映射不应被之前安排的删除取消注册")
+	}
+
+	// 宽限期结束时映射仍处于下线状态
+	current, _ := service.manualManager.GetMapping(9500, 9500, "TCP")
+	service.removeOfflineManualMapping(current)
+	if service.upnpManager.HasPortMapping(9500, 9500, "TCP") {
+		t.Error("宽限期结束后仍下线的映射应从路由器删除")
+	}
+	if _, exists := service.manualManager.GetMapping(9500, 9500, "TCP"); !exists {
+		t.Error("取消路由器注册时应保留手动映射的本地记录")
+	}
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"auto-upnp/internal/upnp"
+)
+
+// ErrMappingNotRegistered 映射当前没有注册到路由器，例如手动映射的本地端口未上线
+var ErrMappingNotRegistered = errors.New("映射当前没有注册到路由器")
+
+// RefreshMapping 按本地记录重新向路由器写入单个映射并重置租期
+// 路由器丢失了某个映射时使用，不需要先删除再添加；手动映射使用当前生效的外部端口（备用端口生效时为备用端口）
+func (as *AutoUPnPService) RefreshMapping(internalPort, externalPort int, protocol string) error {
+	protocol, err := NormalizeProtocol(protocol)
+	if err != nil {
+		return err
+	}
+	if as.upnpManager == nil {
+		return fmt.Errorf("UPnP管理器未初始化")
+	}
+
+	liveExternalPort := as.liveExternalPort(internalPort, externalPort, protocol)
+	if err := as.upnpManager.RewritePortMapping(internalPort, liveExternalPort, protocol); err != nil {
+		if errors.Is(err, upnp.ErrMappingNotTracked) {
+			return fmt.Errorf("%w: %d:%d/%s", ErrMappingNotRegistered, internalPort, externalPort, protocol)
+		}
+		return fmt.Errorf("重新写入映射失败: %w", err)
+	}
+	return nil
+}
+
+// GetPortMapping 获取路由器上单个映射的本地记录，手动映射按当前生效的外部端口查找
+func (as *AutoUPnPService) GetPortMapping(internalPort, externalPort int, protocol string) (*upnp.PortMapping, bool) {
+	if as.upnpManager == nil {
+		return nil, false
+	}
+	return as.upnpManager.GetPortMapping(internalPort, as.liveExternalPort(internalPort, externalPort, protocol), protocol)
+}
+
+// liveExternalPort 映射在路由器上实际使用的外部端口，备用端口生效的手动映射返回备用端口
+func (as *AutoUPnPService) liveExternalPort(internalPort, externalPort int, protocol string) int {
+	if mapping, exists := as.manualManager.GetMapping(internalPort, externalPort, protocol); exists {
+		return mapping.CurrentExternalPort()
+	}
+	return externalPort
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"auto-upnp/config"
+	"auto-upnp/internal/upnp"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestRefreshMapping_RewritesLiveExternalPort(t *testing.T) {
+	// 记录路由器收到的AddPortMapping的外部端口
+	var mutex sync.Mutex
+	var written []string
+	externalPort := regexp.MustCompile(`<NewExternalPort>(\d+)</NewExternalPort>`)
+	router := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		action := r.Header.Get("SOAPAction")
+		switch {
+		case strings.Contains(action, "GetExternalIPAddress"):
+			fmt.Fprintf(w, soapResponse, "GetExternalIPAddress", "<NewExternalIPAddress>203.0.113.7</NewExternalIPAddress>", "GetExternalIPAddress")
+			return
+		case strings.Contains(action, "AddPortMapping"):
+			if match := externalPort.FindSubmatch(body); match != nil {
+				mutex.Lock()
+				written = append(written, string(match[1]))
+				mutex.Unlock()
+			}
+			fmt.Fprintf(w, soapResponse, "AddPortMapping", "", "AddPortMapping")
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, soapErrorResponse, 714)
+	}))
+	t.Cleanup(router.Close)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	service := NewAutoUPnPService(&config.Config{
+		Admin: config.AdminConfig{DataDir: t.TempDir()},
+	}, logger)
+	service.upnpManager = upnp.NewUPnPManager(&upnp.Config{
+		GatewayURLs:      []string{router.URL + "/ctl/IPConn"},
+		BindAddress:      "192.168.1.10",
+		DiscoveryTimeout: time.Second,
+		MaxMappings:      10,
+	}, logger)
+	t.Cleanup(service.upnpManager.Close)
+
+	// 本地端口未上线的映射没有注册到路由器
+	if err := service.manualManager.AddMappingWithOptions(9400, 9400, "TCP", "web", ManualMappingOptions{BackupExternalPort: 9401}); err != nil {
+		t.Fatalf("添加映射失败: %v", err)
+	}
+	if err := service.RefreshMapping(9400, 9400, "tcp"); !errors.Is(err, ErrMappingNotRegistered) {
+		t.Fatalf("未注册的映射应返回ErrMappingNotRegistered, 实际为 %v", err)
+	}
+
+	// 备用端口生效时重新写入备用端口
+	mapping, _ := service.manualManager.GetMapping(9400, 9400, "TCP")
+	if err := service.registerOnBackupPort(mapping, "主外部端口9400冲突"); err != nil {
+		t.Fatalf("注册备用端口映射失败: %v", err)
+	}
+	mutex.Lock()
+	written = nil
+	mutex.Unlock()
+
+	if err := service.RefreshMapping(9400, 9400, "tcp"); err != nil {
+		t.Fatalf("重新写入映射失败: %v", err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(written) != 1 || written[0] != "9401" {
+		t.Errorf("应按当前生效的外部端口重新写入一次, 实际写入 %v", written)
+	}
+	if _, exists := service.GetPortMapping(9400, 9400, "TCP"); !exists {
+		t.Error("应能按配置的外部端口查到备用端口上的映射")
+	}
+}
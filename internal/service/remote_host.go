@@ -0,0 +1,25 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ErrInvalidRemoteHost 映射限制的来源地址格式错误
+var ErrInvalidRemoteHost = errors.New("来源地址格式错误")
+
+// NormalizeRemoteHost 规范化映射限制的来源地址，为空时返回空字符串（不限制来源）
+// WANIPConnection的NewRemoteHost只支持单个IPv4地址，不支持域名和网段
+func NormalizeRemoteHost(remoteHost string) (string, error) {
+	remoteHost = strings.TrimSpace(remoteHost)
+	if remoteHost == "" {
+		return "", nil
+	}
+	ip := net.ParseIP(remoteHost).To4()
+	if ip == nil || ip.IsUnspecified() {
+		return "", fmt.Errorf("%w: %q，应为单个IPv4地址", ErrInvalidRemoteHost, remoteHost)
+	}
+	return ip.String(), nil
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"auto-upnp/config"
+
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestNormalizeRemoteHost(t *testing.T) {
+	for input, want := range map[string]string{"": "", " 198.51.100.20 ": "198.51.100.20", "::ffff:198.51.100.20": "198.51.100.20"} {
+		got, err := NormalizeRemoteHost(input)
+		if err != nil || got != want {
+			t.Errorf("NormalizeRemoteHost(%q) = %q, %v, 期望 %q", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"0.0.0.0", "2001:db8::1", "peer.example.com", "198.51.100.0/24"} {
+		if _, err := NormalizeRemoteHost(input); !errors.Is(err, ErrInvalidRemoteHost) {
+			t.Errorf("NormalizeRemoteHost(%q) 应返回ErrInvalidRemoteHost, 实际为 %v", input, err)
+		}
+	}
+}
+
+func TestOpenPinhole_SkipsRestrictedMappings(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	cfg := &config.Config{
+		Admin: config.AdminConfig{DataDir: t.TempDir()},
+		UPnP:  config.UPnPConfig{EnableIPv6Pinhole: true},
+	}
+	service := NewAutoUPnPService(cfg, logger)
+
+	// 针孔无法限制IPv4来源地址，限制了来源地址的映射不应打开针孔
+	service.openPinhole(8080, 8080, "TCP", "198.51.100.20")
+	entry := hook.LastEntry()
+	if entry == nil || entry.Message != "映射限制了来源地址，跳过打开IPv6针孔" {
+		t.Fatalf("限制了来源地址的映射应跳过针孔, 日志: %v", entry)
+	}
+
+	hook.Reset()
+	service.openPinhole(8080, 8080, "TCP", "")
+	if entry := hook.LastEntry(); entry != nil && entry.Message == "映射限制了来源地址，跳过打开IPv6针孔" {
+		t.Error("没有限制来源地址的映射不应跳过针孔")
+	}
+}
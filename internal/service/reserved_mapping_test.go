@@ -0,0 +1,92 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"auto-upnp/config"
+	"auto-upnp/internal/portmonitor"
+	"auto-upnp/internal/upnp"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestReservedMapping_HoldsRouterMappingWhileInactive(t *testing.T) {
+	cfg := &config.Config{
+		Admin: config.AdminConfig{DataDir: t.TempDir()},
+	}
+	service := NewAutoUPnPService(cfg, logrus.New())
+
+	if err := service.manualManager.AddMappingWithOptions(9200, 9200, "TCP", "planned", ManualMappingOptions{Reserved: true}); err != nil {
+		t.Fatalf("添加映射失败: %v", err)
+	}
+	if err := service.manualManager.UpdateMappingActiveStatus(9200, 9200, "TCP", false); err != nil {
+		t.Fatalf("更新映射状态失败: %v", err)
+	}
+
+	result, err := service.manualMappingResult(9200, 9200, "TCP")
+	if err != nil {
+		t.Fatalf("获取映射结果失败: %v", err)
+	}
+	if result.Status != ManualMappingStatusReserved {
+		t.Errorf("未上线的预留映射状态为 %s, 期望 %s", result.Status, ManualMappingStatusReserved)
+	}
+	if !result.Mapping.holdsRouterMapping() {
+		t.Error("未上线的预留映射应保留在路由器上")
+	}
+
+	result.Mapping.Disabled = true
+	if result.Mapping.holdsRouterMapping() {
+		t.Error("被停用的预留映射不应保留在路由器上")
+	}
+}
+
+func TestReplaceMapping_FailedReplaceRestoresMapping(t *testing.T) {
+	cfg := &config.Config{
+		Admin: config.AdminConfig{DataDir: t.TempDir()},
+	}
+	service := NewAutoUPnPService(cfg, logrus.New())
+
+	// 网关无法连接，替换后的映射注册失败
+	gateway := httptest.NewServer(http.NotFoundHandler())
+	gateway.Close()
+	service.upnpManager = upnp.NewUPnPManager(&upnp.Config{GatewayURLs: []string{gateway.URL + "/rootDesc.xml"}, DiscoveryTimeout: time.Second}, logrus.New())
+
+	healthCheck := &portmonitor.HealthCheck{Type: portmonitor.HealthCheckTCP}
+	if err := service.manualManager.AddMappingWithOptions(9300, 9300, "TCP", "old", ManualMappingOptions{Reserved: true, HealthCheck: healthCheck}); err != nil {
+		t.Fatalf("添加映射失败: %v", err)
+	}
+	if err := service.manualManager.UpdateMappingActiveStatus(9300, 9300, "TCP", false); err != nil {
+		t.Fatalf("更新映射状态失败: %v", err)
+	}
+	if err := service.manualManager.SetMappingDisabled(9300, 9300, "TCP", true); err != nil {
+		t.Fatalf("停用映射失败: %v", err)
+	}
+	old, _ := service.manualManager.GetMapping(9300, 9300, "TCP")
+
+	if _, err := service.AddManualMappingWithOptions(9301, 9300, "TCP", "new", ManualMappingOptions{Replace: true, Reserved: true}); err == nil {
+		t.Fatal("网关不可用时替换应失败")
+	}
+
+	if _, exists := service.manualManager.GetMapping(9301, 9300, "TCP"); exists {
+		t.Error("替换失败时应撤销新映射")
+	}
+	restored, exists := service.manualManager.GetMapping(9300, 9300, "TCP")
+	if !exists {
+		t.Fatal("替换失败时应恢复被替换的映射")
+	}
+	if !restored.Reserved {
+		t.Error("恢复的映射应保持预留")
+	}
+	if restored.CorrelationID != old.CorrelationID {
+		t.Errorf("恢复的映射关联ID为 %s, 期望 %s", restored.CorrelationID, old.CorrelationID)
+	}
+	if !restored.Disabled {
+		t.Error("恢复的映射应保持停用")
+	}
+	if !restored.HealthCheck.Equal(healthCheck) {
+		t.Errorf("恢复的映射健康检查为 %+v, 期望 %+v", restored.HealthCheck, healthCheck)
+	}
+}
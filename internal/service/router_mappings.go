@@ -0,0 +1,135 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"auto-upnp/internal/upnp"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 接管路由器映射失败的原因
+var (
+	ErrRouterMappingNotFound = errors.New("路由器上不存在该映射")
+	ErrRouterMappingManaged  = errors.New("映射已由本服务管理")
+	ErrRouterMappingForeign  = errors.New("映射指向其他主机，无法接管")
+	ErrRouterMappingInRange  = errors.New("内部端口在自动映射端口范围内，由端口监控管理")
+)
+
+// adoptedDescription 接管没有描述的映射时使用的描述
+const adoptedDescription = "adopted"
+
+// ListRouterMappings 列出路由器上的全部端口映射，并标记哪些由本服务管理
+func (as *AutoUPnPService) ListRouterMappings() ([]*upnp.RouterMapping, error) {
+	if as.upnpManager == nil {
+		return nil, fmt.Errorf("UPnP服务不可用")
+	}
+
+	entries, err := as.upnpManager.ListRouterMappings()
+	if err != nil {
+		return nil, err
+	}
+
+	// 手动映射可能尚未被路由器改写描述（例如刚接管），按端口补充标记
+	for _, entry := range entries {
+		if entry.Managed || !entry.Local {
+			continue
+		}
+		if _, exists := as.manualManager.GetMapping(entry.InternalPort, entry.ExternalPort, entry.Protocol); exists {
+			entry.Managed = true
+		}
+	}
+	return entries, nil
+}
+
+// AdoptRouterMapping 将路由器上其他程序创建的映射接管为手动映射
+// 手动映射总是指向本机，所以只能接管内部地址为本机的映射
+func (as *AutoUPnPService) AdoptRouterMapping(externalPort int, protocol string) (*ManualMappingResult, error) {
+	entries, err := as.ListRouterMappings()
+	if err != nil {
+		return nil, err
+	}
+
+	var entry *upnp.RouterMapping
+	for _, candidate := range entries {
+		if candidate.ExternalPort == externalPort && strings.EqualFold(candidate.Protocol, protocol) {
+			entry = candidate
+			break
+		}
+	}
+	if entry == nil {
+		return nil, fmt.Errorf("%w: %d/%s", ErrRouterMappingNotFound, externalPort, protocol)
+	}
+	if entry.Managed {
+		return nil, fmt.Errorf("%w: %d/%s", ErrRouterMappingManaged, externalPort, protocol)
+	}
+	if !entry.Local {
+		return nil, fmt.Errorf("%w: %s:%d", ErrRouterMappingForeign, entry.InternalClient, entry.InternalPort)
+	}
+	if as.config.InPortRange(entry.InternalPort) {
+		return nil, fmt.Errorf("%w: %d", ErrRouterMappingInRange, entry.InternalPort)
+	}
+
+	description := entry.Description
+	if description == "" {
+		description = adoptedDescription
+	}
+
+	// 指向本机相同内部端口的路由器映射不算冲突，添加时会覆盖为本实例的描述
+	result, err := as.AddManualMappingWithOptions(entry.InternalPort, entry.ExternalPort, entry.Protocol, description, ManualMappingOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	as.logger.WithFields(logrus.Fields{
+		"internal_port": entry.InternalPort,
+		"external_port": entry.ExternalPort,
+		"protocol":      entry.Protocol,
+		"description":   entry.Description,
+	}).Info("已接管路由器端口映射")
+
+	return result, nil
+}
+
+// leftoverDescriptionPrefixes 启动时接管的映射描述前缀，分别对应自动映射和手动映射
+var leftoverDescriptionPrefixes = []string{"AutoUPnP-", "Manual-"}
+
+// adoptLeftoverMappings 启动时接管路由器上本实例上次运行留下的映射
+// 服务崩溃或remove_on_shutdown为false时映射会留在路由器上，接管后重新添加不会因映射已存在而失败，
+// 端口不再需要的映射在releaseLeftoverMappings中删除
+func (as *AutoUPnPService) adoptLeftoverMappings() {
+	if !as.upnpManager.IsUPnPAvailable() {
+		return
+	}
+
+	adopted, err := as.upnpManager.AdoptRouterMappings(leftoverDescriptionPrefixes...)
+	if err != nil {
+		as.logger.WithError(err).Warn("接管路由器上遗留的端口映射失败")
+		return
+	}
+	if adopted > 0 {
+		as.logger.WithField("adopted", adopted).Info("已接管路由器上遗留的端口映射")
+	}
+}
+
+// isManualRouterMapping 判断路由器映射是否属于手动映射，remove_manual_on_shutdown为false时关闭服务保留这些映射
+// 保留的映射在下次启动恢复手动映射时直接接管
+func (as *AutoUPnPService) isManualRouterMapping(mapping *upnp.PortMapping) bool {
+	for _, manual := range as.manualManager.GetMappings() {
+		if !manual.Disabled && manual.InternalPort == mapping.InternalPort &&
+			manual.CurrentExternalPort() == mapping.ExternalPort && strings.EqualFold(manual.Protocol, mapping.Protocol) {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseLeftoverMappings 删除接管后一直没有对应端口上线的遗留映射
+// 等待两个端口检查周期，确保端口监控已经完成首轮扫描
+func (as *AutoUPnPService) releaseLeftoverMappings() {
+	if released := as.upnpManager.ReleaseUnclaimedMappings(2 * as.config.Monitor.CheckInterval); released > 0 {
+		as.logger.WithField("released", released).Info("已删除路由器上遗留且不再使用的端口映射")
+	}
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalidScheme 映射的访问协议格式错误
+var ErrInvalidScheme = errors.New("访问协议格式错误")
+
+// 常用的访问协议，管理界面据此生成访问链接，其他符合URI格式的协议名按自定义协议处理
+const (
+	SchemeHTTP  = "http"
+	SchemeHTTPS = "https"
+	SchemeSSH   = "ssh"
+	SchemeRDP   = "rdp"
+)
+
+// schemePattern URI协议名格式（RFC 3986）
+var schemePattern = regexp.MustCompile(`^[a-z][a-z0-9+.-]{0,31}$`)
+
+// wellKnownSchemes 常见TCP端口对应的访问协议，用于未指定访问协议时推断
+var wellKnownSchemes = map[int]string{
+	22:   SchemeSSH,
+	80:   SchemeHTTP,
+	443:  SchemeHTTPS,
+	3389: SchemeRDP,
+	8080: SchemeHTTP,
+	8443: SchemeHTTPS,
+}
+
+// NormalizeScheme 规范化映射的访问协议，为空时返回空字符串
+func NormalizeScheme(scheme string) (string, error) {
+	scheme = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(scheme)), "://")
+	if scheme == "" {
+		return "", nil
+	}
+	if !schemePattern.MatchString(scheme) {
+		return "", fmt.Errorf("%w: %q，应为 http、https、ssh、rdp 或其他URI协议名", ErrInvalidScheme, scheme)
+	}
+	return scheme, nil
+}
+
+// InferScheme 按常见端口推断访问协议，先看内部端口（本机服务），再看外部端口，无法推断时返回空字符串
+// 访问协议只用于展示，UDP端口不做推断
+func InferScheme(internalPort, externalPort int, protocol string) string {
+	if !strings.EqualFold(protocol, "TCP") {
+		return ""
+	}
+	if scheme, ok := wellKnownSchemes[internalPort]; ok {
+		return scheme
+	}
+	return wellKnownSchemes[externalPort]
+}
+
+// GetMappingSchemes 获取路由器映射的访问协议，键与GetPortMappings相同，无法确定访问协议的映射不包含在内
+func (as *AutoUPnPService) GetMappingSchemes() map[string]string {
+	schemes := make(map[string]string)
+	for key, mapping := range as.GetPortMappings() {
+		if scheme := InferScheme(mapping.InternalPort, mapping.ExternalPort, mapping.Protocol); scheme != "" {
+			schemes[key] = scheme
+		}
+	}
+	// 手动映射指定的访问协议优先于推断结果
+	for _, mapping := range as.manualManager.GetMappings() {
+		if mapping.Scheme != "" {
+			schemes[fmt.Sprintf("%d:%d:%s", mapping.InternalPort, mapping.CurrentExternalPort(), mapping.Protocol)] = mapping.Scheme
+		}
+	}
+	return schemes
+}
+
+// UpdateManualMappingScheme 更新手动映射的访问协议，为空时按端口重新推断，不会修改路由器上的映射
+func (as *AutoUPnPService) UpdateManualMappingScheme(internalPort, externalPort int, protocol, scheme string) (*ManualMapping, error) {
+	scheme, err := NormalizeScheme(scheme)
+	if err != nil {
+		return nil, err
+	}
+	if scheme == "" {
+		scheme = InferScheme(internalPort, externalPort, protocol)
+	}
+	return as.manualManager.UpdateMappingScheme(internalPort, externalPort, protocol, scheme)
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"auto-upnp/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestNormalizeScheme(t *testing.T) {
+	for input, want := range map[string]string{"": "", "HTTPS": "https", " ssh ": "ssh", "rdp://": "rdp", "svn+ssh": "svn+ssh"} {
+		got, err := NormalizeScheme(input)
+		if err != nil || got != want {
+			t.Errorf("NormalizeScheme(%q) = %q, %v, 期望 %q", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"1http", "ht tp", "http:", "<script>"} {
+		if _, err := NormalizeScheme(input); !errors.Is(err, ErrInvalidScheme) {
+			t.Errorf("NormalizeScheme(%q) 应返回ErrInvalidScheme, 实际为 %v", input, err)
+		}
+	}
+}
+
+func TestInferScheme(t *testing.T) {
+	tests := []struct {
+		internal, external int
+		protocol           string
+		want               string
+	}{
+		{443, 10443, "TCP", "https"},
+		{10022, 22, "TCP", "ssh"},
+		{80, 443, "TCP", "http"}, // 内部端口优先
+		{3389, 3389, "UDP", ""},
+		{5000, 5000, "TCP", ""},
+	}
+	for _, tt := range tests {
+		if got := InferScheme(tt.internal, tt.external, tt.protocol); got != tt.want {
+			t.Errorf("InferScheme(%d, %d, %s) = %q, 期望 %q", tt.internal, tt.external, tt.protocol, got, tt.want)
+		}
+	}
+}
+
+func TestAddManualMapping_Scheme(t *testing.T) {
+	cfg := &config.Config{
+		Admin: config.AdminConfig{DataDir: t.TempDir()},
+	}
+	service := NewAutoUPnPService(cfg, logrus.New())
+
+	result, err := service.AddManualMappingWithOptions(443, 10443, "TCP", "web", ManualMappingOptions{})
+	if err != nil {
+		t.Fatalf("添加映射失败: %v", err)
+	}
+	if result.Mapping.Scheme != SchemeHTTPS {
+		t.Errorf("访问协议为 %q, 期望按内部端口推断为 https", result.Mapping.Scheme)
+	}
+
+	// 推断的访问协议与未指定时相同，重复添加按幂等处理
+	if _, err := service.AddManualMappingWithOptions(443, 10443, "TCP", "web", ManualMappingOptions{Idempotent: true}); err != nil {
+		t.Errorf("幂等添加失败: %v", err)
+	}
+	if _, err := service.AddManualMappingWithOptions(443, 10443, "TCP", "web", ManualMappingOptions{Idempotent: true, Scheme: "http"}); !errors.Is(err, ErrMappingMismatch) {
+		t.Errorf("访问协议不同应返回ErrMappingMismatch, 实际为 %v", err)
+	}
+
+	mapping, err := service.UpdateManualMappingScheme(443, 10443, "TCP", "HTTP")
+	if err != nil || mapping.Scheme != SchemeHTTP {
+		t.Fatalf("更新访问协议失败: %v, %+v", err, mapping)
+	}
+	mapping, err = service.UpdateManualMappingScheme(443, 10443, "TCP", "")
+	if err != nil || mapping.Scheme != SchemeHTTPS {
+		t.Errorf("清空访问协议后应重新推断为 https: %v, %+v", err, mapping)
+	}
+
+	if _, err := service.AddManualMappingWithOptions(5000, 15000, "TCP", "app", ManualMappingOptions{Scheme: "bad scheme"}); !errors.Is(err, ErrInvalidScheme) {
+		t.Errorf("格式错误的访问协议应返回ErrInvalidScheme, 实际为 %v", err)
+	}
+}
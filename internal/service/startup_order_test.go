@@ -0,0 +1,44 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestManualMappings_AddedBeforeLoadArePersisted(t *testing.T) {
+	dataDir := t.TempDir()
+
+	// 上次运行保存的映射
+	previous := NewManualMappingManager(dataDir, logrus.New())
+	if err := previous.LoadMappings(); err != nil {
+		t.Fatalf("加载映射失败: %v", err)
+	}
+	if err := previous.AddMapping(8080, 8080, "TCP", "existing"); err != nil {
+		t.Fatalf("添加映射失败: %v", err)
+	}
+
+	// 存储加载完成前添加的映射既不能覆盖已保存的映射，也不能在加载时丢失
+	manager := NewManualMappingManager(dataDir, logrus.New())
+	if err := manager.AddMapping(9090, 9090, "TCP", "early"); err != nil {
+		t.Fatalf("添加映射失败: %v", err)
+	}
+	if err := manager.LoadMappings(); err != nil {
+		t.Fatalf("加载映射失败: %v", err)
+	}
+	for _, port := range []int{8080, 9090} {
+		if _, exists := manager.GetMapping(port, port, "TCP"); !exists {
+			t.Errorf("加载后映射 %d 丢失", port)
+		}
+	}
+
+	reloaded := NewManualMappingManager(dataDir, logrus.New())
+	if err := reloaded.LoadMappings(); err != nil {
+		t.Fatalf("加载映射失败: %v", err)
+	}
+	for _, port := range []int{8080, 9090} {
+		if _, exists := reloaded.GetMapping(port, port, "TCP"); !exists {
+			t.Errorf("映射 %d 未被持久化", port)
+		}
+	}
+}
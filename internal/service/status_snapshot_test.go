@@ -0,0 +1,92 @@
+package service
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"auto-upnp/config"
+	"auto-upnp/internal/upnp"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestGetStatus_ConsistentUnderConcurrentUpdates(t *testing.T) {
+	var takenPort atomic.Bool
+	router := newTestRouter(t, &takenPort)
+
+	cfg := &config.Config{
+		Admin: config.AdminConfig{DataDir: t.TempDir()},
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	service := NewAutoUPnPService(cfg, logger)
+	service.upnpManager = upnp.NewUPnPManager(&upnp.Config{
+		GatewayURLs:      []string{router.URL + "/ctl/IPConn"},
+		BindAddress:      "192.168.1.10",
+		DiscoveryTimeout: time.Second,
+		MaxMappings:      10,
+	}, logger)
+	t.Cleanup(service.upnpManager.Close)
+
+	// 通过服务层的添加和删除接口并发修改映射，预留映射在端口未上线时也会注册到路由器
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	var added atomic.Int32
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func(port int) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if _, err := service.AddManualMappingWithOptions(port, port, "TCP", "test", ManualMappingOptions{Reserved: true}); err != nil {
+					t.Errorf("添加映射失败: %v", err)
+					return
+				}
+				added.Add(1)
+				if err := service.RemoveManualMappingWithOptions(port, port, "TCP", RemoveMappingOptions{}); err != nil {
+					t.Errorf("删除映射失败: %v", err)
+					return
+				}
+			}
+		}(20000 + worker)
+	}
+
+	for i := 0; i < 200; i++ {
+		status := service.GetStatus()
+		if _, err := json.Marshal(status); err != nil {
+			t.Fatalf("序列化状态失败: %v", err)
+		}
+
+		manual := status["manual_mappings"].(map[string]interface{})
+		total := manual["total_mappings"].(int)
+		active := manual["active_mappings"].(int)
+		inactive := manual["inactive_mappings"].(int)
+		if total != len(manual["mappings"].([]*ManualMapping)) || active+inactive != total ||
+			active != len(manual["active_mappings_list"].([]*ManualMapping)) ||
+			inactive != len(manual["inactive_mappings_list"].([]*ManualMapping)) {
+			t.Fatalf("手动映射状态快照不一致: total=%d active=%d inactive=%d", total, active, inactive)
+		}
+
+		mappings := status["upnp_mappings"].(map[string]interface{})
+		if mappings["total_mappings"].(int) != len(mappings["mappings"].(map[string]*upnp.PortMapping)) {
+			t.Fatalf("UPnP映射状态快照不一致: total=%d", mappings["total_mappings"].(int))
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+	if added.Load() == 0 {
+		t.Fatal("并发期间没有成功添加映射")
+	}
+	if status := service.GetStatus(); status["manual_mappings"].(map[string]interface{})["total_mappings"].(int) != 0 {
+		t.Error("全部删除后不应保留手动映射")
+	}
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statusNotifier 状态变化通知，每次变化递增版本号并唤醒所有等待者
+type statusNotifier struct {
+	mutex   sync.Mutex
+	epoch   int64 // 创建时间，区分服务重启前后的版本号
+	version uint64
+	changed chan struct{} // 状态变化时关闭并替换为新的通道
+}
+
+// newStatusNotifier 创建状态变化通知
+func newStatusNotifier() *statusNotifier {
+	return &statusNotifier{
+		epoch:   time.Now().UnixNano(),
+		changed: make(chan struct{}),
+	}
+}
+
+// notify 记录一次状态变化，不会阻塞
+func (n *statusNotifier) notify() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.version++
+	close(n.changed)
+	n.changed = make(chan struct{})
+}
+
+// current 获取当前版本的ETag，以及下一次变化时会被关闭的通道
+func (n *statusNotifier) current() (string, <-chan struct{}) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	return fmt.Sprintf(`W/"%x-%d"`, n.epoch, n.version), n.changed
+}
+
+// normalizeETag 去掉ETag的弱校验前缀和引号，客户端传入的 since 参数可以带或不带这些修饰
+func normalizeETag(etag string) string {
+	etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+	return strings.Trim(etag, `"`)
+}
+
+// notifyStatusChanged 通知等待中的状态长轮询请求
+// 端口上下线、映射增删改、待删除映射、公网IP和UPnP设备的变化都会调用
+func (as *AutoUPnPService) notifyStatusChanged() {
+	as.statusChanges.notify()
+}
+
+// StatusETag 获取当前状态版本的ETag
+func (as *AutoUPnPService) StatusETag() string {
+	etag, _ := as.statusChanges.current()
+	return etag
+}
+
+// WaitStatusChange 在状态版本仍为since时等待，直到状态变化、超时或ctx取消
+// 返回当前版本的ETag，以及与since相比是否已经变化
+func (as *AutoUPnPService) WaitStatusChange(ctx context.Context, since string, timeout time.Duration) (string, bool) {
+	etag, changed := as.statusChanges.current()
+	if normalizeETag(etag) != normalizeETag(since) {
+		return etag, true
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-changed:
+		etag, _ = as.statusChanges.current()
+		return etag, true
+	case <-timer.C:
+	case <-ctx.Done():
+	case <-as.ctx.Done():
+	}
+	return etag, false
+}
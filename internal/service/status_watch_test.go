@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"auto-upnp/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestWaitStatusChange(t *testing.T) {
+	cfg := &config.Config{
+		Admin: config.AdminConfig{DataDir: t.TempDir()},
+	}
+	service := NewAutoUPnPService(cfg, logrus.New())
+	etag := service.StatusETag()
+
+	// 版本不同时立即返回
+	if current, changed := service.WaitStatusChange(context.Background(), `W/"stale"`, time.Minute); !changed || current != etag {
+		t.Errorf("旧版本应立即返回当前版本: %s, %v", current, changed)
+	}
+
+	// 没有变化时等待到超时，since可以不带弱校验前缀和引号
+	start := time.Now()
+	if _, changed := service.WaitStatusChange(context.Background(), normalizeETag(etag), 50*time.Millisecond); changed {
+		t.Error("状态没有变化时不应返回changed")
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Error("状态没有变化时应等待到超时")
+	}
+
+	// 映射变化时唤醒等待者
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		time.Sleep(20 * time.Millisecond)
+		if _, err := service.AddManualMapping(9200, 9200, "TCP", "watch"); err != nil {
+			t.Errorf("添加映射失败: %v", err)
+		}
+	}()
+	current, changed := service.WaitStatusChange(context.Background(), etag, 5*time.Second)
+	// 等待添加完成，避免goroutine在测试结束、临时目录删除后才写入文件
+	<-done
+	if !changed || current == etag {
+		t.Errorf("添加映射后应返回新版本: %s, %v", current, changed)
+	}
+}
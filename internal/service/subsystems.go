@@ -0,0 +1,29 @@
+package service
+
+import (
+	"auto-upnp/internal/liveness"
+)
+
+// 需要跟踪心跳的子系统名称
+const (
+	SubsystemAutoPortMonitor   = "auto_port_monitor"
+	SubsystemManualPortMonitor = "manual_port_monitor"
+	SubsystemUPnPHealthCheck   = "upnp_health_check"
+	SubsystemUPnPRetry         = "upnp_retry"
+	SubsystemCleanup           = "cleanup"
+	SubsystemExternalIP        = "external_ip"
+	SubsystemDDNS              = "ddns"
+	SubsystemMDNS              = "mdns"
+	SubsystemInfluxDB          = "influxdb_exporter"
+	SubsystemDrift             = "drift_check"
+)
+
+// GetSubsystemStatus 获取各子系统的存活状态
+func (as *AutoUPnPService) GetSubsystemStatus() []*liveness.SubsystemStatus {
+	return as.heartbeats.Snapshot()
+}
+
+// IsReady 检查服务是否已启动且所有子系统都在按时心跳
+func (as *AutoUPnPService) IsReady() bool {
+	return as.upnpManager != nil && as.heartbeats.Healthy()
+}
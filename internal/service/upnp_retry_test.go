@@ -0,0 +1,64 @@
+package service
+
+import (
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"auto-upnp/config"
+	"auto-upnp/internal/upnp"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestDiscoveryBackoff(t *testing.T) {
+	minDelay, maxDelay := 5*time.Second, time.Minute
+	want := []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute, time.Minute}
+	for i, expected := range want {
+		if got := discoveryBackoff(i+1, minDelay, maxDelay); got != expected {
+			t.Errorf("第%d次失败后的间隔为 %s, 期望 %s", i+1, got, expected)
+		}
+	}
+	if got := discoveryBackoff(1000, minDelay, maxDelay); got != maxDelay {
+		t.Errorf("多次失败后的间隔应不超过最大间隔, 实际为 %s", got)
+	}
+}
+
+func TestRediscoverUPnP_RetriesPendingManualMappings(t *testing.T) {
+	var takenPort atomic.Bool
+	router := newTestRouter(t, &takenPort)
+
+	cfg := &config.Config{
+		Admin: config.AdminConfig{DataDir: t.TempDir()},
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	service := NewAutoUPnPService(cfg, logger)
+	service.upnpManager = upnp.NewUPnPManager(&upnp.Config{
+		GatewayURLs:      []string{router.URL + "/ctl/IPConn"},
+		BindAddress:      "192.168.1.10",
+		DiscoveryTimeout: time.Second,
+		MaxMappings:      10,
+	}, logger)
+	t.Cleanup(service.upnpManager.Close)
+
+	// 端口已经上线，但之前没有发现路由器，映射尚未注册
+	if err := service.manualManager.AddMapping(9600, 9600, "TCP", "web"); err != nil {
+		t.Fatalf("添加映射失败: %v", err)
+	}
+	if err := service.manualManager.UpdateMappingActiveStatus(9600, 9600, "TCP", true); err != nil {
+		t.Fatalf("更新映射状态失败: %v", err)
+	}
+
+	count, err := service.RediscoverUPnP()
+	if err != nil {
+		t.Fatalf("重新发现失败: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("重新发现后的客户端数量为 %d, 期望 1", count)
+	}
+	if !service.upnpManager.HasPortMapping(9600, 9600, "TCP") {
+		t.Error("重新发现后应重试注册活跃的手动映射")
+	}
+}
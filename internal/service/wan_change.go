@@ -0,0 +1,108 @@
+package service
+
+import (
+	"time"
+
+	"auto-upnp/internal/upnp"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WANReassertResult WAN地址变化后重新写入映射的结果
+type WANReassertResult struct {
+	Previous   string    `json:"previous"`
+	Current    string    `json:"current"`
+	At         time.Time `json:"at"`
+	Reasserted int       `json:"reasserted"`
+	Failed     int       `json:"failed"`
+	Skipped    string    `json:"skipped,omitempty"` // 未重新写入的原因
+}
+
+// WANChangeStatus 路由器WAN地址及最近一次变化后的处理结果
+type WANChangeStatus struct {
+	upnp.WANStatus
+	ReassertEnabled bool               `json:"reassert_enabled"`
+	LastReassert    *WANReassertResult `json:"last_reassert,omitempty"`
+}
+
+// onWANChange 路由器WAN地址变化时重新写入所有映射
+// 部分路由器在WAN口重新拨号后会清空映射表，主动重新写入可以避免等到下一次健康检查失败才恢复
+func (as *AutoUPnPService) onWANChange(previous, current string) {
+	defer as.notifyStatusChanged()
+
+	result := &WANReassertResult{Previous: previous, Current: current, At: time.Now()}
+	logger := as.logger.WithFields(logrus.Fields{
+		"previous": previous,
+		"current":  current,
+	})
+
+	switch {
+	case !as.config.UPnP.ReassertOnWANChange:
+		result.Skipped = "未启用upnp.reassert_on_wan_change"
+	case current == "":
+		// WAN口没有地址时写入大概率失败，等地址恢复时再次触发
+		result.Skipped = "WAN口暂时没有地址，等待恢复后重新写入"
+	case as.ctx.Err() != nil:
+		result.Skipped = "服务正在停止"
+	}
+	if result.Skipped != "" {
+		logger.WithField("reason", result.Skipped).Info("WAN地址变化，暂不重新写入映射")
+		as.recordWANReassert(result)
+		return
+	}
+
+	for _, mapping := range as.upnpManager.GetPortMappings() {
+		if as.ctx.Err() != nil {
+			break
+		}
+		err := as.upnpManager.RewritePortMapping(mapping.InternalPort, mapping.ExternalPort, mapping.Protocol)
+		if err != nil {
+			result.Failed++
+			logger.WithFields(logrus.Fields{
+				"internal_port": mapping.InternalPort,
+				"external_port": mapping.ExternalPort,
+				"protocol":      mapping.Protocol,
+				"error":         err,
+			}).Warn("WAN地址变化后重新写入映射失败")
+			continue
+		}
+		result.Reasserted++
+	}
+
+	logger.WithFields(logrus.Fields{
+		"reasserted": result.Reasserted,
+		"failed":     result.Failed,
+	}).Info("WAN地址变化，已重新写入映射")
+	as.recordWANReassert(result)
+
+	// 公网IP很可能随之变化，立即刷新以便DDNS尽快更新
+	if as.ipResolver != nil {
+		if _, err := as.ipResolver.Resolve(as.ctx, true); err != nil {
+			logger.WithError(err).Warn("WAN地址变化后刷新公网IP失败")
+		}
+	}
+}
+
+// recordWANReassert 记录最近一次WAN地址变化的处理结果
+func (as *AutoUPnPService) recordWANReassert(result *WANReassertResult) {
+	as.wanMutex.Lock()
+	as.lastWANReassert = result
+	as.wanMutex.Unlock()
+}
+
+// wanStatus 返回WAN地址状态，UPnP管理器未初始化时返回nil
+func (as *AutoUPnPService) wanStatus() *WANChangeStatus {
+	if as.upnpManager == nil {
+		return nil
+	}
+
+	as.wanMutex.Lock()
+	last := as.lastWANReassert
+	as.wanMutex.Unlock()
+
+	return &WANChangeStatus{
+		WANStatus:       as.upnpManager.GetWANStatus(),
+		ReassertEnabled: as.config.UPnP.ReassertOnWANChange,
+		LastReassert:    last,
+	}
+}
@@ -0,0 +1,198 @@
+package upnp
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// unclaimedMapping 启动时接管、尚未被AddPortMapping认领的映射，受UPnPManager.mutex保护
+type unclaimedMapping struct {
+	mapping   *PortMapping
+	adoptedAt time.Time
+}
+
+// adoptedMapping 根据路由器上的映射条目创建接管的本地记录
+func (um *UPnPManager) adoptedMapping(clientInfo *UPnPClientInfo, remoteHost string, internalPort, externalPort int, protocol, localIP, description string, lease uint32) *PortMapping {
+	// 按路由器剩余租期推算创建时间，使本地过期时间与路由器一致
+	createdAt := time.Now()
+	if lease > 0 && um.config.MappingDuration > 0 {
+		elapsed := um.config.MappingDuration - time.Duration(lease)*time.Second
+		if elapsed > 0 {
+			createdAt = createdAt.Add(-elapsed)
+		}
+	}
+
+	return &PortMapping{
+		InternalPort:   internalPort,
+		ExternalPort:   externalPort,
+		Protocol:       protocol,
+		InternalClient: localIP,
+		Description:    um.stripInstance(description),
+		LeaseDuration:  lease,
+		CreatedAt:      createdAt,
+		Adopted:        true,
+		Gateway:        clientInfo.URL,
+		RemoteHost:     remoteHost,
+	}
+}
+
+// AdoptRouterMappings 枚举路由器映射表，接管本实例上次运行留下、指向本机且描述以指定前缀开头的映射
+// 接管后再添加相同映射时直接认领，不再请求路由器；一直没有被认领的映射由ReleaseUnclaimedMappings删除
+func (um *UPnPManager) AdoptRouterMappings(prefixes ...string) (int, error) {
+	um.mutex.RLock()
+	clients := make([]clientSnapshot, 0, len(um.clients))
+	for _, clientInfo := range um.clients {
+		if clientInfo.IsHealthy {
+			clients = append(clients, clientSnapshot{info: clientInfo, client: clientInfo.Client})
+		}
+	}
+	um.mutex.RUnlock()
+
+	if len(clients) == 0 {
+		return 0, fmt.Errorf("没有可用的UPnP客户端")
+	}
+
+	localIP, err := um.getLocalIP()
+	if err != nil {
+		return 0, fmt.Errorf("获取本地IP地址失败: %w", err)
+	}
+
+	adopted := 0
+	var lastErr error
+	for _, snapshot := range clients {
+		entries, err := um.listClientMappings(snapshot, localIP)
+		if err != nil {
+			lastErr = err
+			um.logger.WithFields(logrus.Fields{
+				"device": snapshot.info.DeviceName,
+				"error":  err,
+			}).Warn("枚举路由器端口映射失败，跳过该网关")
+			continue
+		}
+		for _, entry := range entries {
+			if !entry.Local || !entry.Enabled || !um.ownsDescription(entry.Description) {
+				continue
+			}
+			if !hasAnyPrefix(um.stripInstance(entry.Description), prefixes) {
+				continue
+			}
+			if um.adoptEntry(snapshot.info, entry, localIP) {
+				adopted++
+			}
+		}
+	}
+
+	if adopted == 0 && lastErr != nil {
+		return 0, fmt.Errorf("枚举路由器端口映射失败: %w", lastErr)
+	}
+	return adopted, nil
+}
+
+// adoptEntry 将一条路由器映射加入本地记录，已有记录或达到映射数量上限时跳过
+func (um *UPnPManager) adoptEntry(clientInfo *UPnPClientInfo, entry *RouterMapping, localIP string) bool {
+	mappingKey := um.getMappingKey(entry.InternalPort, entry.ExternalPort, entry.Protocol)
+
+	um.mutex.Lock()
+	defer um.mutex.Unlock()
+
+	if _, exists := um.mappings[mappingKey]; exists || um.inflight[mappingKey] {
+		return false
+	}
+	if len(um.mappings)+len(um.inflight) >= um.config.MaxMappings {
+		um.logger.WithFields(logrus.Fields{
+			"external_port": entry.ExternalPort,
+			"protocol":      entry.Protocol,
+			"max_mappings":  um.config.MaxMappings,
+		}).Warn("端口映射数量已达到上限，不再接管路由器上的映射")
+		return false
+	}
+
+	mapping := um.adoptedMapping(clientInfo, entry.RemoteHost, entry.InternalPort, entry.ExternalPort, entry.Protocol, localIP, entry.Description, entry.LeaseDuration)
+	um.mappings[mappingKey] = mapping
+	if um.unclaimed == nil {
+		um.unclaimed = make(map[string]unclaimedMapping)
+	}
+	um.unclaimed[mappingKey] = unclaimedMapping{mapping: mapping, adoptedAt: time.Now()}
+
+	um.logger.WithFields(logrus.Fields{
+		"internal_port": entry.InternalPort,
+		"external_port": entry.ExternalPort,
+		"protocol":      entry.Protocol,
+		"description":   entry.Description,
+		"lease":         entry.LeaseDuration,
+		"device":        clientInfo.DeviceName,
+	}).Info("启动时接管路由器上已存在的端口映射")
+	return true
+}
+
+// claimAdopted 认领启动时接管的映射，认领成功时调用者不需要再请求路由器
+// 接管的映射限制的来源地址与本次添加不同时不认领，返回该映射由调用者删除后重新添加
+func (um *UPnPManager) claimAdopted(mappingKey, remoteHost string) (bool, *PortMapping) {
+	um.mutex.Lock()
+	defer um.mutex.Unlock()
+
+	entry, ok := um.unclaimed[mappingKey]
+	if !ok {
+		return false, nil
+	}
+	delete(um.unclaimed, mappingKey)
+	if um.mappings[mappingKey] != entry.mapping {
+		return false, nil
+	}
+	if entry.mapping.RemoteHost != remoteHost {
+		return false, entry.mapping
+	}
+	return true, nil
+}
+
+// ReleaseUnclaimedMappings 删除接管超过grace仍未被认领的映射，即对应端口已经不再需要映射，返回删除的数量
+func (um *UPnPManager) ReleaseUnclaimedMappings(grace time.Duration) int {
+	now := time.Now()
+
+	um.mutex.Lock()
+	var expired []*PortMapping
+	for key, entry := range um.unclaimed {
+		if now.Sub(entry.adoptedAt) < grace {
+			continue
+		}
+		delete(um.unclaimed, key)
+		// 接管后已被删除或重新添加的映射不再处理
+		if um.mappings[key] == entry.mapping {
+			expired = append(expired, entry.mapping)
+		}
+	}
+	um.mutex.Unlock()
+
+	released := 0
+	for _, mapping := range expired {
+		fields := logrus.Fields{
+			"internal_port": mapping.InternalPort,
+			"external_port": mapping.ExternalPort,
+			"protocol":      mapping.Protocol,
+			"description":   mapping.Description,
+		}
+		if err := um.RemovePortMapping(mapping.InternalPort, mapping.ExternalPort, mapping.Protocol); err != nil {
+			um.logger.WithFields(fields).WithError(err).Warn("删除上次运行遗留的端口映射失败")
+			continue
+		}
+		um.logger.WithFields(fields).Info("已删除上次运行遗留且不再使用的端口映射")
+		released++
+	}
+	return released
+}
+
+// hasAnyPrefix 判断字符串是否以任一前缀开头，没有前缀时总是成立
+func hasAnyPrefix(s string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package upnp
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// soapDeletePortMappingResponse DeletePortMapping的SOAP应答
+const soapDeletePortMappingResponse = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body><u:DeletePortMappingResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1"></u:DeletePortMappingResponse></s:Body></s:Envelope>`
+
+func TestAdoptRouterMappings_ClaimsAndReleasesLeftovers(t *testing.T) {
+	entries := []struct {
+		external, internal int
+		protocol, client   string
+		description        string
+	}{
+		{8080, 8080, "TCP", "192.168.1.10", "node1/AutoUPnP-8080"},
+		{9000, 9000, "TCP", "192.168.1.10", "node1/Manual-9000"},
+		{8081, 8081, "TCP", "192.168.1.10", "node2/AutoUPnP-8081"}, // 其他实例
+		{7000, 7000, "TCP", "192.168.1.20", "node1/AutoUPnP-7000"}, // 指向其他主机
+		{6881, 6881, "UDP", "192.168.1.10", "node1/Transmission"},  // 不是本服务的描述
+	}
+	indexPattern := regexp.MustCompile(`<NewPortMappingIndex>(\d+)</NewPortMappingIndex>`)
+
+	var mu sync.Mutex
+	var actions []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		action := r.Header.Get("SOAPAction")
+		switch {
+		case strings.Contains(action, "GetGenericPortMappingEntry"):
+			index, _ := strconv.Atoi(string(indexPattern.FindSubmatch(body)[1]))
+			if index >= len(entries) {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(w, soapArrayIndexInvalid)
+				return
+			}
+			e := entries[index]
+			fmt.Fprintf(w, soapGenericEntryResponse, e.external, e.protocol, e.internal, e.client, e.description)
+		case strings.Contains(action, "DeletePortMapping"):
+			mu.Lock()
+			actions = append(actions, "delete")
+			mu.Unlock()
+			fmt.Fprint(w, soapDeletePortMappingResponse)
+		default:
+			mu.Lock()
+			actions = append(actions, action)
+			mu.Unlock()
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, soapFault, 501)
+		}
+	}))
+	defer server.Close()
+
+	um := &UPnPManager{
+		logger:     logrus.New(),
+		config:     &Config{InstanceID: "node1", BindAddress: "192.168.1.10", MaxMappings: 10, MaxFailCount: 3},
+		clients:    []*UPnPClientInfo{renewalClient(server.URL + "/ctl/IPConn")},
+		mappings:   make(map[string]*PortMapping),
+		inflight:   make(map[string]bool),
+		discovered: true,
+	}
+
+	adopted, err := um.AdoptRouterMappings("AutoUPnP-", "Manual-")
+	if err != nil || adopted != 2 {
+		t.Fatalf("接管结果 = %d, %v，期望接管2个映射", adopted, err)
+	}
+
+	// 端口上线时直接认领，不再请求路由器
+	if err := um.AddPortMapping(8080, 8080, "TCP", "AutoUPnP-8080", ""); err != nil {
+		t.Fatalf("认领接管的映射失败: %v", err)
+	}
+
+	// 一直没有认领的映射从路由器删除
+	if released := um.ReleaseUnclaimedMappings(0); released != 1 {
+		t.Errorf("删除的遗留映射数量 = %d，期望1", released)
+	}
+	if !um.HasPortMapping(8080, 8080, "TCP") || um.HasPortMapping(9000, 9000, "TCP") {
+		t.Error("应保留已认领的映射并删除未认领的映射")
+	}
+	if len(actions) != 1 || actions[0] != "delete" {
+		t.Errorf("路由器收到的请求 = %v，期望只删除未认领的映射", actions)
+	}
+}
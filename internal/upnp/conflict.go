@@ -0,0 +1,104 @@
+package upnp
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FindConflictingMapping 查询路由器上占用外部端口的其他映射，没有冲突或UPnP不可用时返回nil
+func (um *UPnPManager) FindConflictingMapping(internalPort, externalPort int, protocol string) (*PortMapping, error) {
+	um.mutex.RLock()
+	clients := make([]*UPnPClientInfo, 0, len(um.clients))
+	for _, clientInfo := range um.clients {
+		if clientInfo.IsHealthy {
+			clients = append(clients, clientInfo)
+		}
+	}
+	um.mutex.RUnlock()
+
+	if len(clients) == 0 {
+		return nil, nil
+	}
+
+	localIP, err := um.getLocalIP()
+	if err != nil {
+		return nil, fmt.Errorf("获取本地IP地址失败: %w", err)
+	}
+
+	var lastErr error
+	for _, clientInfo := range clients {
+		entryPort, entryClient, _, entryDescription, entryLease, err := clientInfo.Client.GetSpecificPortMappingEntry(
+			"",                   // NewRemoteHost
+			uint16(externalPort), // NewExternalPort
+			protocol,             // NewProtocol
+		)
+		if err != nil {
+			if UPnPErrorCode(err) == ErrCodeNoSuchEntry {
+				continue
+			}
+			lastErr = err
+			continue
+		}
+
+		// 本机相同内部端口的映射不算冲突
+		if entryClient == localIP && int(entryPort) == internalPort {
+			return nil, nil
+		}
+
+		return &PortMapping{
+			InternalPort:   int(entryPort),
+			ExternalPort:   externalPort,
+			Protocol:       protocol,
+			InternalClient: entryClient,
+			Description:    entryDescription,
+			LeaseDuration:  entryLease,
+		}, nil
+	}
+
+	if lastErr != nil {
+		return nil, fmt.Errorf("查询路由器端口映射失败: %w", lastErr)
+	}
+	return nil, nil
+}
+
+// RemoveRouterPortMapping 删除路由器上指定外部端口的映射，包括非本服务创建的映射
+func (um *UPnPManager) RemoveRouterPortMapping(externalPort int, protocol string) error {
+	um.mutex.Lock()
+	defer um.mutex.Unlock()
+
+	var lastErr error
+	removed := false
+	for _, clientInfo := range um.clients {
+		if !clientInfo.IsHealthy {
+			continue
+		}
+
+		if err := um.removePortMappingFromClient(clientInfo.Client, "", externalPort, protocol); err != nil {
+			lastErr = err
+			continue
+		}
+		removed = true
+	}
+
+	if !removed {
+		if lastErr != nil {
+			return fmt.Errorf("删除路由器端口映射失败: %w", lastErr)
+		}
+		return fmt.Errorf("没有可用的UPnP客户端")
+	}
+
+	// 同步清理本地记录
+	for key, mapping := range um.mappings {
+		if mapping.ExternalPort == externalPort && mapping.Protocol == protocol {
+			delete(um.mappings, key)
+		}
+	}
+
+	um.logger.WithFields(logrus.Fields{
+		"external_port": externalPort,
+		"protocol":      protocol,
+	}).Info("路由器端口映射删除成功")
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package upnp
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// discoverByControlURL 跳过SSDP，直接使用配置的URL创建WAN IP连接客户端（调用者需要持有discoverMutex）
+func (um *UPnPManager) discoverByControlURL() error {
+	return um.addClientByURL(um.config.ControlURL)
+}
+
+// discoverByGatewayURLs 只使用配置的网关地址列表创建客户端，不进行SSDP发现（调用者需要持有discoverMutex）
+// 部分地址不可用时使用其余地址，全部不可用时返回错误
+func (um *UPnPManager) discoverByGatewayURLs() error {
+	added := 0
+	var lastErr error
+	for _, gatewayURL := range um.config.GatewayURLs {
+		if err := um.addClientByURL(gatewayURL); err != nil {
+			lastErr = err
+			um.logger.WithFields(logrus.Fields{
+				"gateway_url": gatewayURL,
+				"error":       err,
+			}).Warn("无法连接配置的网关")
+			continue
+		}
+		added++
+	}
+	if added == 0 {
+		return fmt.Errorf("配置的网关都不可用: %w", lastErr)
+	}
+	return nil
+}
+
+// addClientByURL 以设备描述地址或控制地址创建WAN IP连接客户端并加入客户端列表
+// URL可以是设备描述地址（如 http://192.168.1.1:5000/rootDesc.xml），也可以是WANIPConnection服务的控制地址
+func (um *UPnPManager) addClientByURL(rawURL string) error {
+	loc, err := url.Parse(rawURL)
+	if err != nil || loc.Scheme == "" || loc.Host == "" {
+		return fmt.Errorf("控制URL格式错误: %s", rawURL)
+	}
+
+	ctx, cancel := context.WithTimeout(um.ctx, um.config.DiscoveryTimeout)
+	defer cancel()
+
+	client, deviceName, version, descErr := um.clientFromDescription(ctx, loc)
+	if descErr != nil {
+		client, version, err = um.clientFromControlURL(ctx, loc)
+		if err != nil {
+			return fmt.Errorf("读取设备描述失败(%v)，直接调用控制URL也失败: %w", descErr, err)
+		}
+		deviceName = loc.Host
+	}
+
+	um.mutex.Lock()
+	defer um.mutex.Unlock()
+
+	um.addClient(&UPnPClientInfo{
+		Client:     client,
+		DeviceName: deviceName,
+		URL:        loc.String(),
+		IGDVersion: version,
+		LastSeen:   time.Now(),
+		IsHealthy:  true,
+	})
+	um.discovered = true
+
+	um.logger.WithFields(logrus.Fields{
+		"device":      deviceName,
+		"control_url": loc.String(),
+		"description": descErr == nil,
+		"igd_version": version,
+	}).Info("通过配置的地址添加UPnP客户端")
+	return nil
+}
+
+// clientFromDescription 读取设备描述并创建WAN IP连接客户端，返回客户端、设备名和IGD版本
+func (um *UPnPManager) clientFromDescription(ctx context.Context, loc *url.URL) (WANConnectionClient, string, int, error) {
+	client, version, err := um.wanClientByURL(ctx, loc)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	return client, client.GetServiceClient().RootDevice.Device.FriendlyName, version, nil
+}
+
+// clientFromControlURL 直接以控制地址创建WAN IP连接客户端，并调用GetExternalIPAddress确认SOAP可用
+// 依次按WANIPConnection:1、WANPPPConnection:1和WANIPConnection:2调用，返回路由器接受的客户端和IGD版本
+func (um *UPnPManager) clientFromControlURL(ctx context.Context, loc *url.URL) (WANConnectionClient, int, error) {
+	var lastErr error
+	for _, service := range wanServices {
+		client := newWANClientForControlURL(loc, service)
+		um.applyUserAgent(client.GetServiceClient())
+
+		if _, err := client.GetExternalIPAddressCtx(ctx); err != nil {
+			lastErr = err
+			continue
+		}
+		return client, service.version, nil
+	}
+	return nil, 0, fmt.Errorf("调用GetExternalIPAddress失败: %w", lastErr)
+}
@@ -0,0 +1,303 @@
+package upnp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// soapExternalIPResponse GetExternalIPAddress的SOAP应答
+const soapExternalIPResponse = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body><u:GetExternalIPAddressResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">
+<NewExternalIPAddress>203.0.113.7</NewExternalIPAddress>
+</u:GetExternalIPAddressResponse></s:Body></s:Envelope>`
+
+func TestDiscoverByControlURL_UsesSOAPEndpointWithoutDescription(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			// 控制地址不提供设备描述
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		fmt.Fprint(w, soapExternalIPResponse)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	um := &UPnPManager{
+		logger: logrus.New(),
+		ctx:    ctx,
+		config: &Config{ControlURL: server.URL + "/ctl/IPConn", DiscoveryTimeout: 5 * time.Second},
+	}
+
+	if err := um.discoverByControlURL(); err != nil {
+		t.Fatalf("通过控制URL发现失败: %v", err)
+	}
+	if len(um.clients) != 1 || !um.discovered {
+		t.Fatalf("应添加一个客户端: %+v", um.clients)
+	}
+
+	ip, err := um.clients[0].Client.GetExternalIPAddress()
+	if err != nil || ip != "203.0.113.7" {
+		t.Fatalf("客户端调用失败: %q, %v", ip, err)
+	}
+}
+
+func TestDiscoverByControlURL_FailsWhenEndpointUnusable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	um := &UPnPManager{
+		logger: logrus.New(),
+		ctx:    context.Background(),
+		config: &Config{ControlURL: server.URL + "/ctl/IPConn", DiscoveryTimeout: 5 * time.Second},
+	}
+	if err := um.discoverByControlURL(); err == nil {
+		t.Fatal("不可用的控制URL应返回错误，由调用方回退到SSDP发现")
+	}
+	if len(um.clients) != 0 {
+		t.Fatalf("失败时不应添加客户端: %+v", um.clients)
+	}
+}
+
+func TestDiscover_GatewayURLsSkipsSSDP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		fmt.Fprint(w, soapExternalIPResponse)
+	}))
+	defer server.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	defer dead.Close()
+
+	um := &UPnPManager{
+		logger: logrus.New(),
+		ctx:    context.Background(),
+		config: &Config{
+			GatewayURLs:      []string{dead.URL + "/ctl/IPConn", server.URL + "/ctl/IPConn"},
+			DiscoveryTimeout: 5 * time.Second,
+		},
+	}
+	if err := um.discover(); err != nil {
+		t.Fatalf("部分网关可用时发现失败: %v", err)
+	}
+	if len(um.clients) != 1 || um.clients[0].URL != server.URL+"/ctl/IPConn" {
+		t.Fatalf("应只添加可用的网关: %+v", um.clients)
+	}
+
+	// 全部不可用时直接返回错误，不回退到SSDP发现
+	um = &UPnPManager{
+		logger: logrus.New(),
+		ctx:    context.Background(),
+		config: &Config{GatewayURLs: []string{dead.URL + "/ctl/IPConn"}, DiscoveryTimeout: 5 * time.Second},
+	}
+	if err := um.discover(); err == nil || len(um.clients) != 0 {
+		t.Fatalf("网关都不可用时应返回错误: %v, %+v", err, um.clients)
+	}
+}
+
+// igd2RootDesc 只提供WANIPConnection:2的IGDv2设备描述
+const igd2RootDesc = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+<specVersion><major>1</major><minor>0</minor></specVersion>
+<device>
+<deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:2</deviceType>
+<friendlyName>FRITZ!Box 7590</friendlyName>
+<deviceList><device>
+<deviceType>urn:schemas-upnp-org:device:WANDevice:2</deviceType>
+<friendlyName>WANDevice</friendlyName>
+<deviceList><device>
+<deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:2</deviceType>
+<friendlyName>WANConnectionDevice</friendlyName>
+<serviceList><service>
+<serviceType>urn:schemas-upnp-org:service:WANIPConnection:2</serviceType>
+<serviceId>urn:upnp-org:serviceId:WANIPConn1</serviceId>
+<controlURL>/ctl/IPConn</controlURL>
+<eventSubURL>/evt/IPConn</eventSubURL>
+<SCPDURL>/WANIPCn.xml</SCPDURL>
+</service></serviceList>
+</device></deviceList>
+</device></deviceList>
+</device>
+</root>`
+
+// newIGD2Server 模拟只接受WANIPConnection:2调用的路由器
+func newIGD2Server(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		if r.Method == http.MethodGet && r.URL.Path == "/rootDesc.xml" {
+			fmt.Fprint(w, igd2RootDesc)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		if !strings.Contains(r.Header.Get("SOAPAction"), "WANIPConnection:2") {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, soapFault, 401) // Invalid Action
+			return
+		}
+		fmt.Fprint(w, strings.ReplaceAll(soapExternalIPResponse, "WANIPConnection:1", "WANIPConnection:2"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDiscoverByControlURL_IGDv2Only(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		deviceName string
+	}{
+		{name: "设备描述", path: "/rootDesc.xml", deviceName: "FRITZ!Box 7590"},
+		{name: "控制地址", path: "/ctl/IPConn"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newIGD2Server(t)
+			um := &UPnPManager{
+				logger: logrus.New(),
+				ctx:    context.Background(),
+				config: &Config{ControlURL: server.URL + tt.path, DiscoveryTimeout: 5 * time.Second},
+			}
+
+			if err := um.discoverByControlURL(); err != nil {
+				t.Fatalf("只提供IGDv2的路由器应能发现: %v", err)
+			}
+			if len(um.clients) != 1 || um.clients[0].IGDVersion != 2 {
+				t.Fatalf("应添加一个IGDv2客户端: %+v", um.clients)
+			}
+			if tt.deviceName != "" && um.clients[0].DeviceName != tt.deviceName {
+				t.Errorf("设备名 = %q，期望 %q", um.clients[0].DeviceName, tt.deviceName)
+			}
+
+			ip, err := um.clients[0].Client.GetExternalIPAddress()
+			if err != nil || ip != "203.0.113.7" {
+				t.Fatalf("IGDv2客户端调用失败: %q, %v", ip, err)
+			}
+			if status := um.GetClientStatus(); len(status) != 1 || status[0]["igd_version"] != 2 {
+				t.Errorf("客户端状态中应显示IGD版本: %v", status)
+			}
+		})
+	}
+}
+
+// pppRootDesc 同时列出WANIPConnection:1和WANPPPConnection:1的DSL路由器设备描述，只有PPP连接已拨号
+const pppRootDesc = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+<specVersion><major>1</major><minor>0</minor></specVersion>
+<device>
+<deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+<friendlyName>DSL Router</friendlyName>
+<deviceList><device>
+<deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+<friendlyName>WANDevice</friendlyName>
+<deviceList><device>
+<deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+<friendlyName>WANConnectionDevice</friendlyName>
+<serviceList>
+<service>
+<serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+<serviceId>urn:upnp-org:serviceId:WANIPConn1</serviceId>
+<controlURL>/ctl/IPConn</controlURL>
+<eventSubURL>/evt/IPConn</eventSubURL>
+<SCPDURL>/WANIPCn.xml</SCPDURL>
+</service>
+<service>
+<serviceType>urn:schemas-upnp-org:service:WANPPPConnection:1</serviceType>
+<serviceId>urn:upnp-org:serviceId:WANPPPConn1</serviceId>
+<controlURL>/ctl/PPPConn</controlURL>
+<eventSubURL>/evt/PPPConn</eventSubURL>
+<SCPDURL>/WANPPPCn.xml</SCPDURL>
+</service>
+</serviceList>
+</device></deviceList>
+</device></deviceList>
+</device>
+</root>`
+
+func TestDiscoverByControlURL_PrefersConnectedPPPService(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		switch r.URL.Path {
+		case "/rootDesc.xml":
+			fmt.Fprint(w, pppRootDesc)
+		case "/ctl/IPConn":
+			// 未连接的IP连接返回空地址
+			fmt.Fprint(w, strings.ReplaceAll(soapExternalIPResponse, "203.0.113.7", ""))
+		case "/ctl/PPPConn":
+			fmt.Fprint(w, strings.ReplaceAll(soapExternalIPResponse, "WANIPConnection:1", "WANPPPConnection:1"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	um := &UPnPManager{
+		logger: logrus.New(),
+		ctx:    context.Background(),
+		config: &Config{ControlURL: server.URL + "/rootDesc.xml", DiscoveryTimeout: 5 * time.Second},
+	}
+	if err := um.discoverByControlURL(); err != nil {
+		t.Fatalf("通过设备描述发现失败: %v", err)
+	}
+	if len(um.clients) != 1 {
+		t.Fatalf("应添加一个客户端: %+v", um.clients)
+	}
+	if service := wanServiceName(um.clients[0].Client); service != "WANPPPConnection:1" {
+		t.Errorf("应选择已连接的WANPPPConnection:1，实际 %q", service)
+	}
+	if um.GetClientCount() != 1 {
+		t.Errorf("PPP客户端应计入客户端数量")
+	}
+}
+
+func TestRediscover_ReturnsImmediatelyWhileDiscoveryRuns(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		fmt.Fprint(w, soapExternalIPResponse)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	um := &UPnPManager{
+		logger: logrus.New(),
+		ctx:    ctx,
+		config: &Config{ControlURL: server.URL + "/ctl/IPConn", DiscoveryTimeout: 5 * time.Second},
+	}
+
+	// 已有发现正在进行时不排队等待
+	um.discoverMutex.Lock()
+	if _, err := um.Rediscover(); !errors.Is(err, ErrDiscoveryInProgress) {
+		t.Errorf("发现进行中时应返回ErrDiscoveryInProgress, 实际为 %v", err)
+	}
+	um.discoverMutex.Unlock()
+
+	count, err := um.Rediscover()
+	if err != nil {
+		t.Fatalf("重新发现失败: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("重新发现后的客户端数量为 %d, 期望 1", count)
+	}
+}
@@ -0,0 +1,29 @@
+package upnp
+
+import (
+	"errors"
+
+	"github.com/huin/goupnp/soap"
+)
+
+// IGD规范中的UPnP错误码
+const (
+	ErrCodeActionFailed  = 501 // 操作失败（通常是路由器繁忙等临时错误）
+	ErrCodeNoSuchEntry   = 714 // 映射条目不存在
+	ErrCodeConflict      = 718 // 外部端口已被其他映射占用
+	ErrCodeSamePortValue = 724 // 路由器只支持内外端口相同的映射
+)
+
+// UPnPErrorCode 从错误链中提取UPnP错误码，不是SOAP错误时返回0
+func UPnPErrorCode(err error) int {
+	var faultErr *soap.SOAPFaultError
+	if errors.As(err, &faultErr) {
+		return faultErr.Detail.UPnPError.Errorcode
+	}
+	return 0
+}
+
+// IsConflictError 检查错误是否为外部端口冲突
+func IsConflictError(err error) bool {
+	return UPnPErrorCode(err) == ErrCodeConflict
+}
@@ -0,0 +1,71 @@
+package upnp
+
+import (
+	"fmt"
+	"time"
+)
+
+// ExternalIPInfo 路由器WAN口地址及其来源
+type ExternalIPInfo struct {
+	IP        string    `json:"ip"`
+	Device    string    `json:"device"`
+	Gateway   string    `json:"gateway"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// GetExternalIP 从路由器获取WAN口地址，优先使用健康检查缓存的地址
+func (um *UPnPManager) GetExternalIP() (string, error) {
+	info, err := um.GetExternalIPInfo()
+	if err != nil {
+		return "", err
+	}
+	return info.IP, nil
+}
+
+// GetExternalIPInfo 返回最近一次健康检查成功的客户端缓存的WAN口地址
+// 尚未缓存时（启动后第一次健康检查之前，或路由器返回了空地址）向健康的客户端查询一次并缓存
+func (um *UPnPManager) GetExternalIPInfo() (*ExternalIPInfo, error) {
+	um.mutex.RLock()
+	var cached, fallback *UPnPClientInfo
+	var client WANConnectionClient
+	for _, clientInfo := range um.clients {
+		if !clientInfo.IsHealthy {
+			continue
+		}
+		if clientInfo.WANAddress != "" && (cached == nil || clientInfo.WANAddressAt.After(cached.WANAddressAt)) {
+			cached = clientInfo
+		}
+		if fallback == nil {
+			fallback, client = clientInfo, clientInfo.Client
+		}
+	}
+	var info *ExternalIPInfo
+	if cached != nil {
+		info = &ExternalIPInfo{IP: cached.WANAddress, Device: cached.DeviceName, Gateway: cached.URL, UpdatedAt: cached.WANAddressAt}
+	}
+	um.mutex.RUnlock()
+
+	if info != nil {
+		return info, nil
+	}
+	if fallback == nil {
+		return nil, fmt.Errorf("没有可用的健康UPnP客户端")
+	}
+
+	address, err := client.GetExternalIPAddress()
+	if err != nil {
+		return nil, fmt.Errorf("获取路由器外部IP失败: %w", err)
+	}
+	address = normalizeWANAddress(address)
+	if address == "" {
+		return nil, fmt.Errorf("路由器WAN口暂时没有地址")
+	}
+
+	now := time.Now()
+	um.mutex.Lock()
+	fallback.WANAddress = address
+	fallback.WANAddressAt = now
+	um.mutex.Unlock()
+
+	return &ExternalIPInfo{IP: address, Device: fallback.DeviceName, Gateway: fallback.URL, UpdatedAt: now}, nil
+}
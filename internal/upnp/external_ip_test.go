@@ -0,0 +1,79 @@
+package upnp
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/huin/goupnp"
+	"github.com/huin/goupnp/dcps/internetgateway1"
+	"github.com/huin/goupnp/soap"
+	"github.com/sirupsen/logrus"
+)
+
+func TestGetExternalIPInfo_UsesCachedAddress(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		fmt.Fprint(w, soapExternalIPResponse)
+	}))
+	defer server.Close()
+
+	loc, _ := url.Parse(server.URL + "/ctl/IPConn")
+	clientInfo := &UPnPClientInfo{
+		Client: &internetgateway1.WANIPConnection1{
+			ServiceClient: goupnp.ServiceClient{
+				SOAPClient: soap.NewSOAPClient(*loc),
+				Location:   loc,
+				Service:    &goupnp.Service{ServiceType: internetgateway1.URN_WANIPConnection_1},
+			},
+		},
+		DeviceName: "router",
+		URL:        server.URL + "/",
+		IsHealthy:  true,
+	}
+	um := &UPnPManager{
+		logger:  logrus.New(),
+		config:  &Config{MaxFailCount: 3},
+		clients: []*UPnPClientInfo{clientInfo},
+	}
+
+	// 第一次健康检查之前查询一次路由器，之后使用缓存
+	for i := 0; i < 3; i++ {
+		ip, err := um.GetExternalIP()
+		if err != nil || ip != "203.0.113.7" {
+			t.Fatalf("第%d次获取外部IP: %q, %v", i, ip, err)
+		}
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("路由器请求次数 = %d，缓存后不应再请求路由器", got)
+	}
+
+	// 健康检查刷新缓存
+	if !um.checkClientHealth(clientInfo) {
+		t.Fatal("健康检查应成功")
+	}
+	info, err := um.GetExternalIPInfo()
+	if err != nil || info.IP != "203.0.113.7" || info.Device != "router" || !info.UpdatedAt.Equal(clientInfo.LastSeen) {
+		t.Errorf("健康检查后的缓存 = %+v, %v", info, err)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("路由器请求次数 = %d，只有健康检查应请求路由器", got)
+	}
+}
+
+func TestGetLocalIP_UsesBindAddress(t *testing.T) {
+	um := &UPnPManager{config: &Config{BindAddress: "192.168.1.10"}}
+	if ip, err := um.getLocalIP(); err != nil || ip != "192.168.1.10" {
+		t.Errorf("配置IPv4绑定地址时映射应指向该地址, 实际为 %q, %v", ip, err)
+	}
+
+	um = &UPnPManager{config: &Config{BindAddress: "2001:db8::10"}}
+	if ip, err := um.getLocalIPv6(); err != nil || ip != "2001:db8::10" {
+		t.Errorf("配置IPv6绑定地址时针孔应指向该地址, 实际为 %q, %v", ip, err)
+	}
+}
@@ -0,0 +1,170 @@
+package upnp
+
+import (
+	"net/url"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 存在多个健康网关时选择映射所用网关的策略，取值与config包中的GatewayPolicy常量一致
+const (
+	GatewayPolicyFirstHealthy = "first_healthy" // 总是使用第一个健康的网关
+	GatewayPolicyRoundRobin   = "round_robin"   // 依次轮流使用各网关
+	GatewayPolicyWeighted     = "weighted"      // 按权重分配，未配置权重的网关权重为1
+)
+
+// ValidGatewayPolicy 判断网关选择策略是否有效，空字符串等同于first_healthy
+func ValidGatewayPolicy(policy string) bool {
+	switch policy {
+	case "", GatewayPolicyFirstHealthy, GatewayPolicyRoundRobin, GatewayPolicyWeighted:
+		return true
+	}
+	return false
+}
+
+// gatewaySelector 网关选择的状态，受UPnPManager.mutex保护
+type gatewaySelector struct {
+	next    int            // round_robin下一次优先使用的网关序号
+	current map[string]int // weighted每个网关的当前权重（平滑加权轮询）
+}
+
+// GatewayUsage 一个网关上的映射数量
+type GatewayUsage struct {
+	Gateway    string `json:"gateway"`
+	DeviceName string `json:"device_name,omitempty"`
+	Available  bool   `json:"available"` // 当前是否为健康的UPnP客户端
+	Weight     int    `json:"weight"`
+	Mappings   int    `json:"mappings"`
+}
+
+// gatewayHost 网关URL中的主机名，路由器重启后端口可能变化，主机名用于匹配同一网关
+func gatewayHost(gateway string) string {
+	u, err := url.Parse(gateway)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// gatewayWeight 网关的权重，依次按URL、主机名和设备名查找配置，未配置时为1
+func (um *UPnPManager) gatewayWeight(clientInfo *UPnPClientInfo) int {
+	for _, key := range []string{clientInfo.URL, gatewayHost(clientInfo.URL), clientInfo.DeviceName} {
+		if weight, ok := um.config.GatewayWeights[key]; ok && key != "" {
+			return weight
+		}
+	}
+	return 1
+}
+
+// orderClients 按网关选择策略排列候选客户端，首个客户端添加失败时依次尝试其余客户端
+// 调用者需要持有mutex
+func (um *UPnPManager) orderClients(clients []clientSnapshot) []clientSnapshot {
+	if len(clients) < 2 {
+		return clients
+	}
+
+	ordered := make([]clientSnapshot, 0, len(clients))
+	switch um.config.GatewayPolicy {
+	case GatewayPolicyRoundRobin:
+		first := um.gateways.next % len(clients)
+		um.gateways.next = first + 1
+		ordered = append(ordered, clients[first:]...)
+		ordered = append(ordered, clients[:first]...)
+	case GatewayPolicyWeighted:
+		// 其余客户端保持原有顺序，只把选中的客户端放在最前面
+		first := um.pickWeighted(clients)
+		ordered = append(ordered, clients[first])
+		ordered = append(ordered, clients[:first]...)
+		ordered = append(ordered, clients[first+1:]...)
+	default:
+		return clients
+	}
+	return ordered
+}
+
+// pickWeighted 平滑加权轮询：每个网关的当前权重加上其权重，选出当前权重最大的网关后减去总权重
+func (um *UPnPManager) pickWeighted(clients []clientSnapshot) int {
+	if um.gateways.current == nil {
+		um.gateways.current = make(map[string]int)
+	}
+
+	best, total := -1, 0
+	for i, snapshot := range clients {
+		weight := um.gatewayWeight(snapshot.info)
+		if weight <= 0 {
+			continue
+		}
+		total += weight
+		um.gateways.current[snapshot.info.URL] += weight
+		if best < 0 || um.gateways.current[snapshot.info.URL] > um.gateways.current[clients[best].info.URL] {
+			best = i
+		}
+	}
+	if best < 0 {
+		return 0
+	}
+	um.gateways.current[clients[best].info.URL] -= total
+	return best
+}
+
+// mappingClients 映射所在网关的客户端，URL完全匹配的客户端在前，其后是同一主机上的其他客户端
+// （路由器重启后端口变化，重新发现的客户端与旧客户端同时存在）；映射没有记录网关时返回所有客户端，
+// 记录的网关不在当前客户端中时也返回所有客户端，调用者需要持有mutex
+func (um *UPnPManager) mappingClients(mapping *PortMapping) []*UPnPClientInfo {
+	if mapping.Gateway == "" {
+		return um.clients
+	}
+
+	var exact, byHost []*UPnPClientInfo
+	host := gatewayHost(mapping.Gateway)
+	for _, clientInfo := range um.clients {
+		switch {
+		case clientInfo.URL == mapping.Gateway:
+			exact = append(exact, clientInfo)
+		case host != "" && gatewayHost(clientInfo.URL) == host:
+			byHost = append(byHost, clientInfo)
+		}
+	}
+	if matched := append(exact, byHost...); len(matched) > 0 {
+		return matched
+	}
+
+	um.logger.WithFields(logrus.Fields{
+		"external_port": mapping.ExternalPort,
+		"protocol":      mapping.Protocol,
+		"gateway":       mapping.Gateway,
+	}).Warn("映射所在的网关当前不可用，改为尝试所有网关")
+	return um.clients
+}
+
+// GetGatewayUsage 获取各网关上的映射数量，包括映射所在但当前不可用的网关
+func (um *UPnPManager) GetGatewayUsage() []GatewayUsage {
+	um.mutex.RLock()
+	defer um.mutex.RUnlock()
+
+	usage := make(map[string]*GatewayUsage, len(um.clients))
+	for _, clientInfo := range um.clients {
+		usage[clientInfo.URL] = &GatewayUsage{
+			Gateway:    clientInfo.URL,
+			DeviceName: clientInfo.DeviceName,
+			Available:  clientInfo.IsHealthy,
+			Weight:     um.gatewayWeight(clientInfo),
+		}
+	}
+	for _, mapping := range um.mappings {
+		gateway, ok := usage[mapping.Gateway]
+		if !ok {
+			gateway = &GatewayUsage{Gateway: mapping.Gateway, Weight: 1}
+			usage[mapping.Gateway] = gateway
+		}
+		gateway.Mappings++
+	}
+
+	result := make([]GatewayUsage, 0, len(usage))
+	for _, gateway := range usage {
+		result = append(result, *gateway)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Gateway < result[j].Gateway })
+	return result
+}
@@ -0,0 +1,84 @@
+package upnp
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// gatewayClients 两个网关的客户端快照
+func gatewayClients() []clientSnapshot {
+	return []clientSnapshot{
+		{info: &UPnPClientInfo{URL: "http://192.168.1.1:5000/", DeviceName: "wan1", IsHealthy: true}},
+		{info: &UPnPClientInfo{URL: "http://192.168.2.1:1900/", DeviceName: "wan2", IsHealthy: true}},
+	}
+}
+
+func TestOrderClients_Distribution(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		picks   int
+		wantWAN map[string]int
+	}{
+		{name: "first_healthy", config: Config{GatewayPolicy: GatewayPolicyFirstHealthy}, picks: 4,
+			wantWAN: map[string]int{"wan1": 4}},
+		{name: "round_robin", config: Config{GatewayPolicy: GatewayPolicyRoundRobin}, picks: 4,
+			wantWAN: map[string]int{"wan1": 2, "wan2": 2}},
+		{name: "weighted by host", config: Config{GatewayPolicy: GatewayPolicyWeighted, GatewayWeights: map[string]int{"192.168.1.1": 3}}, picks: 8,
+			wantWAN: map[string]int{"wan1": 6, "wan2": 2}},
+		{name: "weighted zero is fallback only", config: Config{GatewayPolicy: GatewayPolicyWeighted, GatewayWeights: map[string]int{"wan1": 0}}, picks: 3,
+			wantWAN: map[string]int{"wan2": 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			um := &UPnPManager{logger: logrus.New(), config: &config}
+			clients := gatewayClients()
+
+			got := make(map[string]int)
+			for i := 0; i < tt.picks; i++ {
+				ordered := um.orderClients(clients)
+				if len(ordered) != len(clients) {
+					t.Fatalf("排列后客户端数量 = %d，期望 %d", len(ordered), len(clients))
+				}
+				got[ordered[0].info.DeviceName]++
+			}
+			for name, want := range tt.wantWAN {
+				if got[name] != want {
+					t.Errorf("%s 被选中 %d 次，期望 %d（全部: %v）", name, got[name], want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestMappingClients_TargetsRecordedGateway(t *testing.T) {
+	clients := gatewayClients()
+	um := &UPnPManager{
+		logger:  logrus.New(),
+		config:  &Config{},
+		clients: []*UPnPClientInfo{clients[0].info, clients[1].info},
+	}
+
+	tests := []struct {
+		gateway string
+		want    []string
+	}{
+		{gateway: "http://192.168.2.1:1900/", want: []string{"wan2"}},
+		{gateway: "http://192.168.2.1:2189/", want: []string{"wan2"}}, // 路由器重启后端口变化
+		{gateway: "", want: []string{"wan1", "wan2"}},                 // 升级前的映射没有记录网关
+		{gateway: "http://192.168.3.1:5000/", want: []string{"wan1", "wan2"}},
+	}
+	for _, tt := range tests {
+		got := um.mappingClients(&PortMapping{ExternalPort: 8080, Protocol: "TCP", Gateway: tt.gateway})
+		names := make([]string, 0, len(got))
+		for _, clientInfo := range got {
+			names = append(names, clientInfo.DeviceName)
+		}
+		if len(names) != len(tt.want) || names[0] != tt.want[0] {
+			t.Errorf("网关 %q 对应的客户端 = %v，期望 %v", tt.gateway, names, tt.want)
+		}
+	}
+}
@@ -0,0 +1,504 @@
+package upnp
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/huin/goupnp"
+	"github.com/huin/goupnp/dcps/internetgateway2"
+	"github.com/sirupsen/logrus"
+)
+
+// maxPinholeLease WANIPv6FirewallControl规范允许的最大租期（秒）
+const maxPinholeLease = 86400
+
+// Pinhole IPv6防火墙针孔信息
+type Pinhole struct {
+	InternalPort   int       `json:"internal_port"`
+	Protocol       string    `json:"protocol"`
+	InternalClient string    `json:"internal_client"`
+	UniqueID       uint16    `json:"unique_id"`
+	LeaseTime      uint32    `json:"lease_time"`
+	Device         string    `json:"device"`
+	CreatedAt      time.Time `json:"created_at"`
+	Simulated      bool      `json:"simulated,omitempty"` // 模拟模式下只记录在本地，没有写入路由器
+
+	owners map[string]bool // 使用针孔的映射键，最后一个使用者删除后才关闭针孔
+}
+
+// PinholeClientInfo IPv6防火墙控制客户端信息
+type PinholeClientInfo struct {
+	Client     *internetgateway2.WANIPv6FirewallControl1
+	DeviceName string
+	URL        string
+}
+
+// discoverPinholeClients 发现支持WANIPv6FirewallControl的IGD设备
+func (um *UPnPManager) discoverPinholeClients() {
+	devices, err := goupnp.DiscoverDevices(internetgateway2.URN_WANIPv6FirewallControl_1)
+	if err != nil {
+		um.logger.WithError(err).Debug("发现IPv6防火墙控制服务失败")
+		return
+	}
+
+	var clients []*PinholeClientInfo
+	for _, device := range devices {
+		if device.Root == nil {
+			continue
+		}
+
+		fwClients, err := internetgateway2.NewWANIPv6FirewallControl1ClientsFromRootDevice(device.Root, &device.Root.URLBase)
+		if err != nil || len(fwClients) == 0 {
+			continue
+		}
+
+		um.applyUserAgent(&fwClients[0].ServiceClient)
+
+		// 检查防火墙是否允许入站针孔
+		_, inboundAllowed, err := fwClients[0].GetFirewallStatus()
+		if err != nil || !inboundAllowed {
+			um.logger.WithField("device", device.Root.Device.FriendlyName).Debug("IPv6防火墙不允许入站针孔")
+			continue
+		}
+
+		clients = append(clients, &PinholeClientInfo{
+			Client:     fwClients[0],
+			DeviceName: device.Root.Device.FriendlyName,
+			URL:        device.Root.URLBase.String(),
+		})
+	}
+
+	um.pinholeMutex.Lock()
+	um.pinholeClients = clients
+	um.pinholeMutex.Unlock()
+
+	if len(clients) > 0 {
+		um.logger.WithField("client_count", len(clients)).Info("发现IPv6防火墙控制服务")
+	}
+}
+
+// IsPinholeAvailable 检查IPv6针孔服务是否可用
+func (um *UPnPManager) IsPinholeAvailable() bool {
+	um.pinholeMutex.RLock()
+	defer um.pinholeMutex.RUnlock()
+	return len(um.pinholeClients) > 0
+}
+
+// AddPinhole 为映射打开本机IPv6地址的入站针孔
+// 针孔只与内部端口和协议有关，共用内部端口的映射共用一个针孔，每个映射登记为针孔的使用者
+func (um *UPnPManager) AddPinhole(internalPort, externalPort int, protocol string) (*Pinhole, error) {
+	pinholeKey := um.getPinholeKey(internalPort, protocol)
+	owner := um.getMappingKey(internalPort, externalPort, protocol)
+
+	var clients []*PinholeClientInfo
+	var done chan struct{}
+	owners := map[string]bool{owner: true}
+	for done == nil {
+		um.pinholeMutex.Lock()
+		// 同一针孔正在打开时等待打开结束，避免在路由器上重复打开
+		if opening, exists := um.pinholeOpening[pinholeKey]; exists {
+			um.pinholeMutex.Unlock()
+			<-opening
+			continue
+		}
+		if pinhole, exists := um.pinholes[pinholeKey]; exists {
+			if !pinhole.expired(time.Now()) {
+				pinhole.owners[owner] = true
+				pinholeCopy := pinhole.clone()
+				um.pinholeMutex.Unlock()
+				return pinholeCopy, nil
+			}
+			// 租期已过，路由器上的针孔已经关闭，重新打开
+			um.logger.WithFields(logrus.Fields{
+				"internal_port": internalPort,
+				"protocol":      protocol,
+				"unique_id":     pinhole.UniqueID,
+			}).Info("IPv6针孔租期已过，重新打开")
+			for existing := range pinhole.owners {
+				owners[existing] = true
+			}
+			delete(um.pinholes, pinholeKey)
+		}
+		if len(um.pinholeClients) == 0 {
+			um.pinholeMutex.Unlock()
+			return nil, fmt.Errorf("没有可用的IPv6防火墙控制服务")
+		}
+		clients = append([]*PinholeClientInfo(nil), um.pinholeClients...)
+		done = make(chan struct{})
+		um.pinholeOpening[pinholeKey] = done
+		um.pinholeMutex.Unlock()
+	}
+	defer um.finishPinholeOpening(pinholeKey, done)
+
+	// 路由器请求在锁外进行
+	pinhole, err := um.openPinholeOnClients(clients, internalPort, protocol)
+	if err != nil {
+		return nil, err
+	}
+
+	um.pinholeMutex.Lock()
+	defer um.pinholeMutex.Unlock()
+	pinhole.owners = owners
+	um.pinholes[pinholeKey] = pinhole
+	return pinhole.clone(), nil
+}
+
+// finishPinholeOpening 结束针孔的打开过程，唤醒等待同一针孔的调用者
+func (um *UPnPManager) finishPinholeOpening(pinholeKey string, done chan struct{}) {
+	um.pinholeMutex.Lock()
+	delete(um.pinholeOpening, pinholeKey)
+	um.pinholeMutex.Unlock()
+	close(done)
+}
+
+// openPinholeOnClients 依次通过防火墙控制服务打开针孔，返回新的针孔记录（调用者不能持有pinholeMutex）
+func (um *UPnPManager) openPinholeOnClients(clients []*PinholeClientInfo, internalPort int, protocol string) (*Pinhole, error) {
+	protocolNumber, err := pinholeProtocolNumber(protocol)
+	if err != nil {
+		return nil, err
+	}
+
+	localIPv6, err := um.getLocalIPv6()
+	if err != nil {
+		return nil, fmt.Errorf("获取本地IPv6地址失败: %w", err)
+	}
+
+	leaseTime := um.pinholeLeaseTime()
+
+	var lastErr error
+	for _, clientInfo := range clients {
+		uniqueID, err := um.addPinholeToClient(clientInfo, internalPort, localIPv6, protocolNumber, leaseTime)
+		if err != nil {
+			lastErr = err
+			um.logger.WithFields(logrus.Fields{
+				"device":        clientInfo.DeviceName,
+				"internal_port": internalPort,
+				"protocol":      protocol,
+				"error":         err,
+			}).Warn("添加IPv6针孔失败")
+			continue
+		}
+
+		um.logger.WithFields(logrus.Fields{
+			"internal_port": internalPort,
+			"protocol":      protocol,
+			"local_ipv6":    localIPv6,
+			"unique_id":     uniqueID,
+			"lease_time":    leaseTime,
+			"device":        clientInfo.DeviceName,
+		}).Info("IPv6针孔添加成功")
+
+		return &Pinhole{
+			InternalPort:   internalPort,
+			Protocol:       protocol,
+			InternalClient: localIPv6,
+			UniqueID:       uniqueID,
+			LeaseTime:      leaseTime,
+			Device:         clientInfo.DeviceName,
+			CreatedAt:      time.Now(),
+			Simulated:      um.config.DryRun,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("所有IPv6防火墙控制服务都添加针孔失败: %w", lastErr)
+}
+
+// pinholeLeaseTime 针孔租期（秒），映射租期为0（永久）或超过规范上限时使用最大租期，由保活协程续期
+func (um *UPnPManager) pinholeLeaseTime() uint32 {
+	leaseTime := uint32(um.config.MappingDuration.Seconds())
+	if leaseTime == 0 || leaseTime > maxPinholeLease {
+		leaseTime = maxPinholeLease
+	}
+	return leaseTime
+}
+
+// expired 检查针孔的租期是否已过
+func (p *Pinhole) expired(now time.Time) bool {
+	return !now.Before(p.expiresAt())
+}
+
+// expiresAt 针孔在路由器上的到期时间，续期后重新计算
+func (p *Pinhole) expiresAt() time.Time {
+	return p.CreatedAt.Add(time.Duration(p.LeaseTime) * time.Second)
+}
+
+// clone 复制针孔记录，使用者集合一并复制
+func (p *Pinhole) clone() *Pinhole {
+	pinholeCopy := *p
+	pinholeCopy.owners = make(map[string]bool, len(p.owners))
+	for owner := range p.owners {
+		pinholeCopy.owners[owner] = true
+	}
+	return &pinholeCopy
+}
+
+// RenewPinholes 为即将到期的IPv6针孔续期，返回续期成功和失败的数量
+// 路由器拒绝续期时（例如路由器重启后针孔已丢失）重新打开针孔
+func (um *UPnPManager) RenewPinholes() (renewed, failed int) {
+	for _, pinhole := range um.pinholesDueForRenewal(time.Now()) {
+		fields := logrus.Fields{
+			"internal_port": pinhole.InternalPort,
+			"protocol":      pinhole.Protocol,
+			"unique_id":     pinhole.UniqueID,
+		}
+		err := um.renewPinhole(pinhole)
+		if err == nil {
+			um.logger.WithFields(fields).Debug("IPv6针孔续期成功")
+			renewed++
+			continue
+		}
+
+		um.logger.WithFields(fields).WithError(err).Warn("IPv6针孔续期失败，重新打开针孔")
+		if err := um.reopenPinhole(pinhole); err != nil {
+			// 保留原记录，下一轮继续尝试
+			um.logger.WithFields(fields).WithError(err).Warn("重新打开IPv6针孔失败")
+			failed++
+			continue
+		}
+		renewed++
+	}
+
+	if renewed > 0 || failed > 0 {
+		um.logger.WithFields(logrus.Fields{
+			"renewed": renewed,
+			"failed":  failed,
+		}).Info("IPv6针孔续期完成")
+	}
+	return renewed, failed
+}
+
+// pinholesDueForRenewal 剩余租期进入续期窗口的针孔副本
+func (um *UPnPManager) pinholesDueForRenewal(now time.Time) []*Pinhole {
+	window := renewalWindow * um.keepAliveInterval()
+
+	um.pinholeMutex.RLock()
+	defer um.pinholeMutex.RUnlock()
+
+	var due []*Pinhole
+	for _, pinhole := range um.pinholes {
+		if pinhole.expiresAt().Sub(now) <= window {
+			due = append(due, pinhole.clone())
+		}
+	}
+	return due
+}
+
+// pinholeClient 查找打开针孔的防火墙控制服务
+func (um *UPnPManager) pinholeClient(device string) *PinholeClientInfo {
+	um.pinholeMutex.RLock()
+	defer um.pinholeMutex.RUnlock()
+
+	for _, clientInfo := range um.pinholeClients {
+		if clientInfo.DeviceName == device {
+			return clientInfo
+		}
+	}
+	return nil
+}
+
+// renewPinhole 通过打开针孔的防火墙控制服务延长针孔租期
+func (um *UPnPManager) renewPinhole(pinhole *Pinhole) error {
+	clientInfo := um.pinholeClient(pinhole.Device)
+	if clientInfo == nil {
+		return fmt.Errorf("打开针孔的设备已不可用: %s", pinhole.Device)
+	}
+	if err := um.updatePinholeOnClient(clientInfo, pinhole); err != nil {
+		return err
+	}
+
+	um.pinholeMutex.Lock()
+	defer um.pinholeMutex.Unlock()
+	if current, exists := um.pinholes[um.getPinholeKey(pinhole.InternalPort, pinhole.Protocol)]; exists && current.UniqueID == pinhole.UniqueID {
+		current.CreatedAt = time.Now()
+	}
+	return nil
+}
+
+// reopenPinhole 续期失败后重新打开针孔，打开成功前保留原记录，使用者不变
+func (um *UPnPManager) reopenPinhole(pinhole *Pinhole) error {
+	pinholeKey := um.getPinholeKey(pinhole.InternalPort, pinhole.Protocol)
+
+	um.pinholeMutex.Lock()
+	current, exists := um.pinholes[pinholeKey]
+	_, opening := um.pinholeOpening[pinholeKey]
+	if !exists || current.UniqueID != pinhole.UniqueID || opening {
+		// 续期期间针孔已被删除或正在重新打开
+		um.pinholeMutex.Unlock()
+		return nil
+	}
+	clients := append([]*PinholeClientInfo(nil), um.pinholeClients...)
+	done := make(chan struct{})
+	um.pinholeOpening[pinholeKey] = done
+	um.pinholeMutex.Unlock()
+	defer um.finishPinholeOpening(pinholeKey, done)
+
+	reopened, err := um.openPinholeOnClients(clients, pinhole.InternalPort, pinhole.Protocol)
+	if err != nil {
+		return err
+	}
+
+	um.pinholeMutex.Lock()
+	current, exists = um.pinholes[pinholeKey]
+	if exists && current.UniqueID == pinhole.UniqueID {
+		reopened.owners = current.owners
+		um.pinholes[pinholeKey] = reopened
+		um.pinholeMutex.Unlock()
+		return nil
+	}
+	um.pinholeMutex.Unlock()
+
+	// 重新打开期间最后一个使用者已删除针孔，关闭新打开的针孔
+	if clientInfo := um.pinholeClient(reopened.Device); clientInfo != nil {
+		if err := um.deletePinholeFromClient(clientInfo, reopened); err != nil {
+			um.logger.WithError(err).WithField("unique_id", reopened.UniqueID).Warn("关闭已不再使用的IPv6针孔失败")
+		}
+	}
+	return nil
+}
+
+// RemovePinhole 映射不再使用针孔，没有其他映射使用时从路由器删除针孔
+func (um *UPnPManager) RemovePinhole(internalPort, externalPort int, protocol string) error {
+	pinholeKey := um.getPinholeKey(internalPort, protocol)
+	owner := um.getMappingKey(internalPort, externalPort, protocol)
+
+	um.pinholeMutex.Lock()
+	pinhole, exists := um.pinholes[pinholeKey]
+	if !exists {
+		um.pinholeMutex.Unlock()
+		return fmt.Errorf("IPv6针孔不存在: %s", pinholeKey)
+	}
+	delete(pinhole.owners, owner)
+	if len(pinhole.owners) > 0 {
+		um.pinholeMutex.Unlock()
+		um.logger.WithFields(logrus.Fields{
+			"internal_port": internalPort,
+			"protocol":      protocol,
+			"owners":        len(pinhole.owners),
+		}).Debug("其他映射仍在使用IPv6针孔，保留针孔")
+		return nil
+	}
+	// 先删除本地记录，路由器删除失败时针孔会随租期到期自动失效
+	delete(um.pinholes, pinholeKey)
+	um.pinholeMutex.Unlock()
+
+	clientInfo := um.pinholeClient(pinhole.Device)
+	if clientInfo == nil {
+		return nil
+	}
+	if err := um.deletePinholeFromClient(clientInfo, pinhole); err != nil {
+		return fmt.Errorf("删除IPv6针孔失败: %w", err)
+	}
+
+	um.logger.WithFields(logrus.Fields{
+		"internal_port": internalPort,
+		"protocol":      protocol,
+		"unique_id":     pinhole.UniqueID,
+	}).Info("IPv6针孔删除成功")
+	return nil
+}
+
+// addPinholeToClient 通过指定的防火墙控制服务打开针孔，模拟模式下只记录日志
+func (um *UPnPManager) addPinholeToClient(clientInfo *PinholeClientInfo, internalPort int, localIPv6 string, protocolNumber uint16, leaseTime uint32) (uint16, error) {
+	if um.config.DryRun {
+		um.logger.WithFields(logrus.Fields{
+			"internal_port": internalPort,
+			"protocol":      protocolNumber,
+			"local_ipv6":    localIPv6,
+			"device":        clientInfo.DeviceName,
+		}).Info("模拟模式，跳过打开IPv6针孔")
+		return 0, nil
+	}
+	return clientInfo.Client.AddPinhole(
+		"",                   // RemoteHost，空表示任意来源
+		0,                    // RemotePort，0表示任意端口
+		localIPv6,            // InternalClient
+		uint16(internalPort), // InternalPort
+		protocolNumber,       // Protocol
+		leaseTime,            // LeaseTime
+	)
+}
+
+// deletePinholeFromClient 通过指定的防火墙控制服务关闭针孔，模拟模式下只记录日志
+func (um *UPnPManager) deletePinholeFromClient(clientInfo *PinholeClientInfo, pinhole *Pinhole) error {
+	if um.config.DryRun {
+		um.logger.WithFields(logrus.Fields{
+			"internal_port": pinhole.InternalPort,
+			"protocol":      pinhole.Protocol,
+			"device":        clientInfo.DeviceName,
+		}).Info("模拟模式，跳过关闭IPv6针孔")
+		return nil
+	}
+	return clientInfo.Client.DeletePinhole(pinhole.UniqueID)
+}
+
+// updatePinholeOnClient 通过指定的防火墙控制服务延长针孔租期，模拟模式下只记录日志
+func (um *UPnPManager) updatePinholeOnClient(clientInfo *PinholeClientInfo, pinhole *Pinhole) error {
+	if um.config.DryRun {
+		um.logger.WithFields(logrus.Fields{
+			"internal_port": pinhole.InternalPort,
+			"protocol":      pinhole.Protocol,
+			"device":        clientInfo.DeviceName,
+		}).Info("模拟模式，跳过IPv6针孔续期")
+		return nil
+	}
+	return clientInfo.Client.UpdatePinhole(pinhole.UniqueID, pinhole.LeaseTime)
+}
+
+// HasPinhole 检查映射是否在使用IPv6针孔
+func (um *UPnPManager) HasPinhole(internalPort, externalPort int, protocol string) bool {
+	um.pinholeMutex.RLock()
+	defer um.pinholeMutex.RUnlock()
+
+	pinhole, exists := um.pinholes[um.getPinholeKey(internalPort, protocol)]
+	return exists && pinhole.owners[um.getMappingKey(internalPort, externalPort, protocol)]
+}
+
+// GetPinholes 获取所有IPv6针孔
+func (um *UPnPManager) GetPinholes() map[string]*Pinhole {
+	um.pinholeMutex.RLock()
+	defer um.pinholeMutex.RUnlock()
+
+	pinholes := make(map[string]*Pinhole)
+	for key, pinhole := range um.pinholes {
+		pinholes[key] = pinhole.clone()
+	}
+	return pinholes
+}
+
+// getPinholeKey 获取针孔键，IPv6没有地址转换，针孔只与内部端口和协议有关
+func (um *UPnPManager) getPinholeKey(internalPort int, protocol string) string {
+	return fmt.Sprintf("%d:%s", internalPort, protocol)
+}
+
+// getLocalIPv6 获取本地全局IPv6地址，配置了IPv6绑定地址时直接使用
+func (um *UPnPManager) getLocalIPv6() (string, error) {
+	if ip := net.ParseIP(um.config.BindAddress); ip != nil && ip.To4() == nil {
+		return ip.String(), nil
+	}
+
+	conn, err := net.Dial("udp6", "[2001:4860:4860::8888]:80")
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	localAddr := conn.LocalAddr().(*net.UDPAddr)
+	if !localAddr.IP.IsGlobalUnicast() || localAddr.IP.To4() != nil {
+		return "", fmt.Errorf("没有可用的全局IPv6地址")
+	}
+	return localAddr.IP.String(), nil
+}
+
+// pinholeProtocolNumber 将协议名转换为IANA协议号
+func pinholeProtocolNumber(protocol string) (uint16, error) {
+	switch strings.ToUpper(protocol) {
+	case "TCP":
+		return 6, nil
+	case "UDP":
+		return 17, nil
+	default:
+		return 0, fmt.Errorf("IPv6针孔不支持的协议: %s", protocol)
+	}
+}
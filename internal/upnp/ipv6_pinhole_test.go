@@ -0,0 +1,241 @@
+package upnp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/huin/goupnp"
+	"github.com/huin/goupnp/dcps/internetgateway2"
+	"github.com/huin/goupnp/soap"
+	"github.com/sirupsen/logrus"
+)
+
+// soapPinholeResponse WANIPv6FirewallControl操作的SOAP应答
+const soapPinholeResponse = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body><u:%sResponse xmlns:u="urn:schemas-upnp-org:service:WANIPv6FirewallControl:1">%s</u:%sResponse></s:Body></s:Envelope>`
+
+// pinholeRouter 记录收到的针孔操作次数，updateFault不为0时UpdatePinhole返回该错误码
+type pinholeRouter struct {
+	adds, updates, deletes atomic.Int32
+	updateFault            int
+}
+
+// newPinholeManager 创建连接到模拟防火墙控制服务的管理器
+func newPinholeManager(t *testing.T, router *pinholeRouter) *UPnPManager {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		action := r.Header.Get("SOAPAction")
+		switch {
+		case strings.Contains(action, "AddPinhole"):
+			id := router.adds.Add(1)
+			fmt.Fprintf(w, soapPinholeResponse, "AddPinhole", fmt.Sprintf("<UniqueID>%d</UniqueID>", 100+id), "AddPinhole")
+		case strings.Contains(action, "UpdatePinhole"):
+			router.updates.Add(1)
+			if router.updateFault != 0 {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprintf(w, soapFault, router.updateFault)
+				return
+			}
+			fmt.Fprintf(w, soapPinholeResponse, "UpdatePinhole", "", "UpdatePinhole")
+		case strings.Contains(action, "DeletePinhole"):
+			router.deletes.Add(1)
+			fmt.Fprintf(w, soapPinholeResponse, "DeletePinhole", "", "DeletePinhole")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	loc, _ := url.Parse(server.URL + "/ctl/IPv6FC")
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return &UPnPManager{
+		logger: logrus.New(),
+		ctx:    ctx,
+		cancel: cancel,
+		config: &Config{BindAddress: "2001:db8::10", KeepAliveInterval: 5 * time.Minute},
+		pinholeClients: []*PinholeClientInfo{{
+			Client: &internetgateway2.WANIPv6FirewallControl1{
+				ServiceClient: goupnp.ServiceClient{
+					SOAPClient: soap.NewSOAPClient(*loc),
+					Location:   loc,
+					Service:    &goupnp.Service{ServiceType: internetgateway2.URN_WANIPv6FirewallControl_1},
+				},
+			},
+			DeviceName: "router",
+			URL:        server.URL,
+		}},
+		pinholes:       make(map[string]*Pinhole),
+		pinholeOpening: make(map[string]chan struct{}),
+	}
+}
+
+func TestAddPinhole_ReopensExpiredPinhole(t *testing.T) {
+	router := &pinholeRouter{}
+	um := newPinholeManager(t, router)
+
+	pinhole, err := um.AddPinhole(8080, 8080, "TCP")
+	if err != nil {
+		t.Fatalf("打开针孔失败: %v", err)
+	}
+	if pinhole.LeaseTime != maxPinholeLease {
+		t.Errorf("永久映射的针孔租期 = %d，期望 %d", pinhole.LeaseTime, maxPinholeLease)
+	}
+
+	// 返回副本，修改不影响管理器的记录
+	pinhole.UniqueID = 0
+	if _, err := um.AddPinhole(8080, 8080, "TCP"); err != nil {
+		t.Fatalf("重复打开针孔失败: %v", err)
+	}
+	if got := router.adds.Load(); got != 1 {
+		t.Errorf("未到期的针孔不应重新打开，AddPinhole次数 = %d", got)
+	}
+	if um.pinholes["8080:TCP"].UniqueID != 101 {
+		t.Error("AddPinhole应返回针孔记录的副本")
+	}
+
+	// 租期已过的针孔在路由器上已经关闭，需要重新打开
+	um.pinholes["8080:TCP"].CreatedAt = time.Now().Add(-25 * time.Hour)
+	reopened, err := um.AddPinhole(8080, 8080, "TCP")
+	if err != nil {
+		t.Fatalf("重新打开针孔失败: %v", err)
+	}
+	if got := router.adds.Load(); got != 2 || reopened.UniqueID != 102 {
+		t.Errorf("到期的针孔应重新打开，AddPinhole次数 = %d，UniqueID = %d", got, reopened.UniqueID)
+	}
+}
+
+func TestRenewPinholes_RenewsOnlyPinholesNearExpiry(t *testing.T) {
+	router := &pinholeRouter{}
+	um := newPinholeManager(t, router)
+
+	expiring := time.Now().Add(-(maxPinholeLease*time.Second - 5*time.Minute))
+	fresh := time.Now().Add(-time.Hour)
+	um.pinholes["8080:TCP"] = &Pinhole{InternalPort: 8080, Protocol: "TCP", UniqueID: 1, LeaseTime: maxPinholeLease, Device: "router", CreatedAt: expiring, owners: map[string]bool{"8080:8080:TCP": true}}
+	um.pinholes["9090:TCP"] = &Pinhole{InternalPort: 9090, Protocol: "TCP", UniqueID: 2, LeaseTime: maxPinholeLease, Device: "router", CreatedAt: fresh, owners: map[string]bool{"9090:9090:TCP": true}}
+
+	renewed, failed := um.RenewPinholes()
+	if renewed != 1 || failed != 0 {
+		t.Fatalf("续期结果 = %d成功 %d失败，期望1成功0失败", renewed, failed)
+	}
+	if got := router.updates.Load(); got != 1 {
+		t.Errorf("UpdatePinhole次数 = %d，只应为即将到期的针孔续期", got)
+	}
+	if !um.pinholes["8080:TCP"].CreatedAt.After(expiring) {
+		t.Error("续期后应重置针孔租期")
+	}
+	if !um.pinholes["9090:TCP"].CreatedAt.Equal(fresh) {
+		t.Error("未进入续期窗口的针孔不应续期")
+	}
+}
+
+func TestRenewPinholes_ReopensWhenUpdateFails(t *testing.T) {
+	// 路由器重启后针孔丢失，UpdatePinhole返回704（NoSuchEntry）
+	router := &pinholeRouter{updateFault: 704}
+	um := newPinholeManager(t, router)
+	um.pinholes["8080:TCP"] = &Pinhole{InternalPort: 8080, Protocol: "TCP", UniqueID: 1, LeaseTime: maxPinholeLease, Device: "router", CreatedAt: time.Now().Add(-maxPinholeLease * time.Second), owners: map[string]bool{"8080:8080:TCP": true}}
+
+	renewed, failed := um.RenewPinholes()
+	if renewed != 1 || failed != 0 {
+		t.Fatalf("续期结果 = %d成功 %d失败，期望重新打开针孔", renewed, failed)
+	}
+	if got := router.adds.Load(); got != 1 {
+		t.Errorf("续期失败后应重新打开针孔，AddPinhole次数 = %d", got)
+	}
+	if pinhole := um.pinholes["8080:TCP"]; pinhole == nil || pinhole.UniqueID != 101 || !pinhole.owners["8080:8080:TCP"] {
+		t.Errorf("应记录重新打开的针孔并保留使用者: %+v", pinhole)
+	}
+}
+
+func TestClose_DeletesPinholes(t *testing.T) {
+	router := &pinholeRouter{}
+	um := newPinholeManager(t, router)
+	um.mappings = make(map[string]*PortMapping)
+	if _, err := um.AddPinhole(8080, 8080, "TCP"); err != nil {
+		t.Fatalf("打开针孔失败: %v", err)
+	}
+
+	// 不删除端口映射时也要关闭针孔
+	um.Close()
+
+	if got := router.deletes.Load(); got != 1 {
+		t.Errorf("关闭时DeletePinhole次数 = %d，期望1", got)
+	}
+	if len(um.GetPinholes()) != 0 {
+		t.Error("关闭后不应保留针孔记录")
+	}
+}
+
+func TestRemovePinhole_KeepsPinholeSharedByOtherMapping(t *testing.T) {
+	router := &pinholeRouter{}
+	um := newPinholeManager(t, router)
+
+	// 外部端口80和8080都映射到内部端口8080，共用一个针孔
+	if _, err := um.AddPinhole(8080, 80, "TCP"); err != nil {
+		t.Fatalf("打开针孔失败: %v", err)
+	}
+	if _, err := um.AddPinhole(8080, 8080, "TCP"); err != nil {
+		t.Fatalf("打开针孔失败: %v", err)
+	}
+	if got := router.adds.Load(); got != 1 {
+		t.Errorf("共用内部端口的映射应共用针孔，AddPinhole次数 = %d", got)
+	}
+
+	if err := um.RemovePinhole(8080, 80, "TCP"); err != nil {
+		t.Fatalf("删除针孔失败: %v", err)
+	}
+	if got := router.deletes.Load(); got != 0 {
+		t.Errorf("其他映射仍在使用针孔时不应关闭，DeletePinhole次数 = %d", got)
+	}
+	if um.HasPinhole(8080, 80, "TCP") || !um.HasPinhole(8080, 8080, "TCP") {
+		t.Error("只应删除外部端口80的使用者")
+	}
+
+	if err := um.RemovePinhole(8080, 8080, "TCP"); err != nil {
+		t.Fatalf("删除针孔失败: %v", err)
+	}
+	if got := router.deletes.Load(); got != 1 {
+		t.Errorf("最后一个使用者删除后应关闭针孔，DeletePinhole次数 = %d", got)
+	}
+}
+
+func TestAddPinhole_DoesNotHoldMappingLock(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		fmt.Fprintf(w, soapPinholeResponse, "AddPinhole", "<UniqueID>1</UniqueID>", "AddPinhole")
+	}))
+	defer server.Close()
+	defer close(release)
+
+	um := newPinholeManager(t, &pinholeRouter{})
+	loc, _ := url.Parse(server.URL + "/ctl/IPv6FC")
+	um.pinholeClients[0].Client.SOAPClient = soap.NewSOAPClient(*loc)
+	um.pinholeClients[0].Client.Location = loc
+
+	go um.AddPinhole(8080, 8080, "TCP")
+
+	// 路由器还没有应答时，端口映射的锁和针孔状态查询都不应被阻塞
+	time.Sleep(50 * time.Millisecond)
+	locked := make(chan struct{})
+	go func() {
+		um.mutex.Lock()
+		um.mutex.Unlock()
+		um.GetPinholes()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("打开针孔期间不应持有锁")
+	}
+}
@@ -0,0 +1,38 @@
+package upnp
+
+import (
+	"os"
+	"strings"
+)
+
+// instanceSeparator 路由器上映射描述中实例标识与原描述之间的分隔符
+const instanceSeparator = "/"
+
+// DefaultInstanceID 获取默认的实例标识，使用主机名
+func DefaultInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "auto-upnp"
+	}
+	return hostname
+}
+
+// instanceDescription 在映射描述前加上实例标识，路由器上的描述形如 "nas/AutoUPnP-8080"
+func (um *UPnPManager) instanceDescription(description string) string {
+	return um.config.InstanceID + instanceSeparator + description
+}
+
+// ownsDescription 判断路由器上的映射描述是否由本实例创建
+func (um *UPnPManager) ownsDescription(description string) bool {
+	return strings.HasPrefix(description, um.config.InstanceID+instanceSeparator)
+}
+
+// stripInstance 去掉路由器映射描述中的实例标识
+func (um *UPnPManager) stripInstance(description string) string {
+	return strings.TrimPrefix(description, um.config.InstanceID+instanceSeparator)
+}
+
+// InstanceID 获取本实例的标识
+func (um *UPnPManager) InstanceID() string {
+	return um.config.InstanceID
+}
@@ -0,0 +1,119 @@
+package upnp
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestOwnsDescription_IgnoresForeignInstances(t *testing.T) {
+	nas := &UPnPManager{config: &Config{InstanceID: "nas"}}
+	nas2 := &UPnPManager{config: &Config{InstanceID: "nas2"}}
+
+	description := nas.instanceDescription("AutoUPnP-8080")
+	if description != "nas/AutoUPnP-8080" {
+		t.Fatalf("实例描述格式错误: %s", description)
+	}
+
+	tests := []struct {
+		description string
+		nas         bool
+		nas2        bool
+	}{
+		{"nas/AutoUPnP-8080", true, false},
+		{"nas2/AutoUPnP-8080", false, true},
+		{"AutoUPnP-8080", false, false}, // 未加前缀的旧映射或其他程序创建的映射
+		{"nas", false, false},
+	}
+
+	for _, tt := range tests {
+		if got := nas.ownsDescription(tt.description); got != tt.nas {
+			t.Errorf("nas.ownsDescription(%q) = %v, 期望 %v", tt.description, got, tt.nas)
+		}
+		if got := nas2.ownsDescription(tt.description); got != tt.nas2 {
+			t.Errorf("nas2.ownsDescription(%q) = %v, 期望 %v", tt.description, got, tt.nas2)
+		}
+	}
+
+	if got := nas.stripInstance(description); got != "AutoUPnP-8080" {
+		t.Errorf("去掉实例标识后的描述错误: %s", got)
+	}
+}
+
+func TestReleaseUnclaimedMappings_LeavesForeignInstancesAlone(t *testing.T) {
+	// 所有映射都指向本机，只有描述前缀区分所属实例
+	entries := []struct {
+		port        int
+		description string
+	}{
+		{8080, "nas/AutoUPnP-8080"},
+		{8081, "nas2/AutoUPnP-8081"}, // 同一主机上的其他实例
+		{8082, "AutoUPnP-8082"},      // 未加前缀的旧映射或其他程序创建的映射
+		{8083, "nasx/AutoUPnP-8083"}, // 实例标识以nas开头但不是nas
+	}
+	indexPattern := regexp.MustCompile(`<NewPortMappingIndex>(\d+)</NewPortMappingIndex>`)
+	portPattern := regexp.MustCompile(`<NewExternalPort>(\d+)</NewExternalPort>`)
+
+	var mu sync.Mutex
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		action := r.Header.Get("SOAPAction")
+		switch {
+		case strings.Contains(action, "GetGenericPortMappingEntry"):
+			index, _ := strconv.Atoi(string(indexPattern.FindSubmatch(body)[1]))
+			if index >= len(entries) {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(w, soapArrayIndexInvalid)
+				return
+			}
+			e := entries[index]
+			fmt.Fprintf(w, soapGenericEntryResponse, e.port, "TCP", e.port, "192.168.1.10", e.description)
+		case strings.Contains(action, "DeletePortMapping"):
+			mu.Lock()
+			deleted = append(deleted, string(portPattern.FindSubmatch(body)[1]))
+			mu.Unlock()
+			fmt.Fprint(w, soapDeletePortMappingResponse)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, soapFault, 501)
+		}
+	}))
+	defer server.Close()
+
+	um := &UPnPManager{
+		logger:     logrus.New(),
+		config:     &Config{InstanceID: "nas", BindAddress: "192.168.1.10", MaxMappings: 10, MaxFailCount: 3},
+		clients:    []*UPnPClientInfo{renewalClient(server.URL + "/ctl/IPConn")},
+		mappings:   make(map[string]*PortMapping),
+		inflight:   make(map[string]bool),
+		discovered: true,
+	}
+
+	adopted, err := um.AdoptRouterMappings("AutoUPnP-")
+	if err != nil || adopted != 1 {
+		t.Fatalf("接管结果 = %d, %v，只应接管本实例的映射", adopted, err)
+	}
+	for _, port := range []int{8081, 8082, 8083} {
+		if um.HasPortMapping(port, port, "TCP") {
+			t.Errorf("不应接管其他实例的映射: %d", port)
+		}
+	}
+
+	// 清理遗留映射时只删除本实例的映射
+	if released := um.ReleaseUnclaimedMappings(0); released != 1 {
+		t.Errorf("删除的遗留映射数量 = %d，期望1", released)
+	}
+	if len(deleted) != 1 || deleted[0] != "8080" {
+		t.Errorf("路由器上删除的外部端口 = %v，期望只删除8080", deleted)
+	}
+}
@@ -0,0 +1,80 @@
+package upnp
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	removalRetryAttempts = 3           // 路由器删除映射失败后的重试次数
+	removalRetryDelay    = time.Second // 删除重试的初始间隔，每次翻倍
+)
+
+// ErrMappingNotTracked 本服务没有记录该端口映射，路由器上也不会有本服务创建的映射
+var ErrMappingNotTracked = errors.New("端口映射不存在")
+
+// RemovalError 路由器删除端口映射失败，映射仍然保留在路由器上和本地记录中
+type RemovalError struct {
+	Key string
+	Err error
+}
+
+// Error 实现error接口
+func (e *RemovalError) Error() string {
+	return fmt.Sprintf("路由器上的端口映射 %s 删除失败: %v", e.Key, e.Err)
+}
+
+// Unwrap 返回底层错误，便于提取UPnP错误码
+func (e *RemovalError) Unwrap() error {
+	return e.Err
+}
+
+// IsRemovalError 检查错误是否表示路由器仍然保留着映射
+func IsRemovalError(err error) bool {
+	var removalErr *RemovalError
+	return errors.As(err, &removalErr)
+}
+
+// RemovePortMappingWithRetry 删除端口映射，路由器删除失败时按指数退避重试
+// 重试期间不持有管理器的锁，适合需要确认路由器已删除映射后再删除本地记录的调用方
+func (um *UPnPManager) RemovePortMappingWithRetry(internalPort, externalPort int, protocol string) error {
+	delay := removalRetryDelay
+	err := um.RemovePortMapping(internalPort, externalPort, protocol)
+	for attempt := 1; attempt <= removalRetryAttempts && IsRemovalError(err); attempt++ {
+		um.logger.WithFields(logrus.Fields{
+			"internal_port": internalPort,
+			"external_port": externalPort,
+			"protocol":      protocol,
+			"attempt":       attempt,
+			"error":         err,
+		}).Warn("删除端口映射失败，稍后重试")
+
+		time.Sleep(delay)
+		delay *= 2
+		err = um.RemovePortMapping(internalPort, externalPort, protocol)
+	}
+	return err
+}
+
+// ForgetPortMapping 只删除本地的映射记录，不请求路由器，返回映射是否存在
+// 路由器已不可用时强制删除使用，不再续期后路由器上的映射会随租期到期失效
+func (um *UPnPManager) ForgetPortMapping(internalPort, externalPort int, protocol string) bool {
+	um.mutex.Lock()
+	defer um.mutex.Unlock()
+
+	mappingKey := um.getMappingKey(internalPort, externalPort, protocol)
+	if _, exists := um.mappings[mappingKey]; !exists {
+		return false
+	}
+	delete(um.mappings, mappingKey)
+
+	um.logger.WithFields(logrus.Fields{
+		"internal_port": internalPort,
+		"external_port": externalPort,
+		"protocol":      protocol,
+	}).Warn("未确认路由器删除，只删除本地映射记录")
+	return true
+}
@@ -0,0 +1,96 @@
+package upnp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/huin/goupnp"
+	"github.com/huin/goupnp/dcps/internetgateway1"
+	"github.com/huin/goupnp/soap"
+	"github.com/sirupsen/logrus"
+)
+
+// soapFault 指定错误码的SOAP错误
+const soapFault = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body><s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring>
+<detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode>
+<errorDescription>Error</errorDescription></UPnPError></detail>
+</s:Fault></s:Body></s:Envelope>`
+
+// newFaultingManager 创建DeletePortMapping总是返回指定错误码的管理器，并预先记录一条映射
+func newFaultingManager(t *testing.T, code int) *UPnPManager {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, soapFault, code)
+	}))
+	t.Cleanup(server.Close)
+
+	loc, _ := url.Parse(server.URL + "/ctl/IPConn")
+	client := &internetgateway1.WANIPConnection1{
+		ServiceClient: goupnp.ServiceClient{
+			SOAPClient: soap.NewSOAPClient(*loc),
+			Location:   loc,
+			Service:    &goupnp.Service{ServiceType: internetgateway1.URN_WANIPConnection_1},
+		},
+	}
+	return &UPnPManager{
+		logger:     logrus.New(),
+		config:     &Config{MaxFailCount: 3},
+		clients:    []*UPnPClientInfo{{Client: client, DeviceName: "router", IsHealthy: true}},
+		mappings:   map[string]*PortMapping{"8080:8080:TCP": {InternalPort: 8080, ExternalPort: 8080, Protocol: "TCP"}},
+		discovered: true,
+	}
+}
+
+func TestRemovePortMapping_NoSuchEntryIsConfirmedAbsent(t *testing.T) {
+	um := newFaultingManager(t, ErrCodeNoSuchEntry)
+
+	if err := um.RemovePortMapping(8080, 8080, "TCP"); err != nil {
+		t.Fatalf("路由器上已不存在的映射应视为删除成功: %v", err)
+	}
+	if um.HasPortMapping(8080, 8080, "TCP") {
+		t.Error("确认删除后应删除本地记录")
+	}
+}
+
+func TestRemovePortMapping_FailureKeepsRecord(t *testing.T) {
+	um := newFaultingManager(t, 606)
+
+	err := um.RemovePortMapping(8080, 8080, "TCP")
+	if !IsRemovalError(err) {
+		t.Fatalf("路由器删除失败应返回RemovalError, 实际为 %v", err)
+	}
+	if UPnPErrorCode(err) != 606 {
+		t.Errorf("RemovalError应保留UPnP错误码, 实际为 %d", UPnPErrorCode(err))
+	}
+	if !um.HasPortMapping(8080, 8080, "TCP") {
+		t.Error("路由器删除失败时应保留本地记录")
+	}
+
+	if err := um.RemovePortMapping(9090, 9090, "TCP"); !errors.Is(err, ErrMappingNotTracked) {
+		t.Errorf("未记录的映射应返回ErrMappingNotTracked, 实际为 %v", err)
+	}
+}
+
+func TestForgetPortMapping_DropsRecordWithoutRouter(t *testing.T) {
+	um := newFaultingManager(t, 501)
+
+	if err := um.RemovePortMapping(8080, 8080, "TCP"); !IsRemovalError(err) {
+		t.Fatalf("路由器删除失败应返回RemovalError, 实际为 %v", err)
+	}
+	if !um.ForgetPortMapping(8080, 8080, "TCP") {
+		t.Fatal("已记录的映射应返回true")
+	}
+	if um.HasPortMapping(8080, 8080, "TCP") {
+		t.Error("强制删除后不应保留本地记录，否则会继续续期")
+	}
+	if um.ForgetPortMapping(8080, 8080, "TCP") {
+		t.Error("未记录的映射应返回false")
+	}
+}
@@ -0,0 +1,115 @@
+package upnp
+
+import (
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// renewalWindow 剩余租期不超过该数量的保活间隔时续期，续期失败时在租期结束前还有一次重试机会
+const renewalWindow = 2
+
+// keepAliveInterval 检查映射租期的间隔，不超过映射租期的一半
+func (um *UPnPManager) keepAliveInterval() time.Duration {
+	interval := um.config.KeepAliveInterval
+	if half := um.config.MappingDuration / 2; half > 0 && half < interval {
+		interval = half
+	}
+	return interval
+}
+
+// keepAliveRoutine 映射续期协程，在路由器上的租期结束前重新添加仍在使用的映射，并为IPv6针孔续期
+func (um *UPnPManager) keepAliveRoutine() {
+	// 租期为0时路由器上的映射不会过期，但IPv6针孔的租期最长为一天，启用针孔时仍需续期
+	if um.config.MappingDuration <= 0 && !um.config.EnableIPv6Pinhole {
+		return
+	}
+
+	ticker := time.NewTicker(um.keepAliveInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-um.ctx.Done():
+			return
+		case <-ticker.C:
+			um.RenewMappings()
+			um.RenewPinholes()
+		}
+	}
+}
+
+// dueForRenewal 剩余租期进入续期窗口的映射
+func (um *UPnPManager) dueForRenewal(now time.Time) []*PortMapping {
+	window := renewalWindow * um.keepAliveInterval()
+
+	um.mutex.RLock()
+	defer um.mutex.RUnlock()
+
+	var due []*PortMapping
+	for _, mapping := range um.mappings {
+		if mapping.CreatedAt.Add(um.config.MappingDuration).Sub(now) <= window {
+			due = append(due, &PortMapping{
+				InternalPort: mapping.InternalPort,
+				ExternalPort: mapping.ExternalPort,
+				Protocol:     mapping.Protocol,
+			})
+		}
+	}
+	return due
+}
+
+// RenewMappings 为即将到期的映射续期，返回续期成功和失败的数量
+// 有映射续期失败时（通常是路由器重启后控制地址变化）重新发现设备后再重试一次
+func (um *UPnPManager) RenewMappings() (renewed, failed int) {
+	if um.config.MappingDuration <= 0 {
+		return 0, 0
+	}
+
+	due := um.dueForRenewal(time.Now())
+	if len(due) == 0 {
+		return 0, 0
+	}
+
+	renewed, pending := um.renewMappings(due)
+	if len(pending) > 0 && um.ctx.Err() == nil {
+		um.logger.WithField("failed", len(pending)).Warn("端口映射续期失败，重新发现UPnP设备后重试")
+		um.rediscoverDevices()
+		var retried int
+		retried, pending = um.renewMappings(pending)
+		renewed += retried
+	}
+
+	failed = len(pending)
+	um.logger.WithFields(logrus.Fields{
+		"renewed": renewed,
+		"failed":  failed,
+	}).Info("端口映射续期完成")
+	return renewed, failed
+}
+
+// renewMappings 依次为映射续期，返回续期成功的数量和续期失败的映射
+// 续期期间被删除的映射既不算成功也不重试
+func (um *UPnPManager) renewMappings(mappings []*PortMapping) (renewed int, failed []*PortMapping) {
+	for _, mapping := range mappings {
+		clientInfo, _, err := um.reassertMapping(mapping.InternalPort, mapping.ExternalPort, mapping.Protocol)
+		fields := logrus.Fields{
+			"internal_port": mapping.InternalPort,
+			"external_port": mapping.ExternalPort,
+			"protocol":      mapping.Protocol,
+		}
+		switch {
+		case errors.Is(err, ErrMappingNotTracked):
+			um.logger.WithFields(fields).Debug("端口映射已删除，跳过续期")
+		case err != nil:
+			um.logger.WithFields(fields).WithError(err).Warn("端口映射续期失败")
+			failed = append(failed, mapping)
+		default:
+			fields["device"] = clientInfo.DeviceName
+			um.logger.WithFields(fields).Debug("端口映射续期成功")
+			renewed++
+		}
+	}
+	return renewed, failed
+}
@@ -0,0 +1,129 @@
+package upnp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/huin/goupnp"
+	"github.com/huin/goupnp/dcps/internetgateway1"
+	"github.com/huin/goupnp/soap"
+	"github.com/sirupsen/logrus"
+)
+
+// soapAddPortMappingResponse AddPortMapping的SOAP应答
+const soapAddPortMappingResponse = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body><u:AddPortMappingResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1"></u:AddPortMappingResponse></s:Body></s:Envelope>`
+
+// newRenewalRouter 只提供SOAP控制地址的路由器，记录收到的AddPortMapping次数
+func newRenewalRouter(t *testing.T, adds *atomic.Int32) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		if strings.Contains(r.Header.Get("SOAPAction"), "AddPortMapping") {
+			adds.Add(1)
+			fmt.Fprint(w, soapAddPortMappingResponse)
+			return
+		}
+		fmt.Fprint(w, soapExternalIPResponse)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// renewalClient 指向控制地址的WANIPConnection:1客户端
+func renewalClient(controlURL string) *UPnPClientInfo {
+	loc, _ := url.Parse(controlURL)
+	return &UPnPClientInfo{
+		Client: &internetgateway1.WANIPConnection1{
+			ServiceClient: goupnp.ServiceClient{
+				SOAPClient: soap.NewSOAPClient(*loc),
+				Location:   loc,
+				Service:    &goupnp.Service{ServiceType: internetgateway1.URN_WANIPConnection_1},
+			},
+		},
+		DeviceName: "router",
+		URL:        controlURL,
+		IsHealthy:  true,
+	}
+}
+
+func TestRenewMappings_RenewsOnlyMappingsNearExpiry(t *testing.T) {
+	var adds atomic.Int32
+	server := newRenewalRouter(t, &adds)
+
+	expiring := time.Now().Add(-58 * time.Minute)
+	fresh := time.Now().Add(-10 * time.Minute)
+	um := &UPnPManager{
+		logger:  logrus.New(),
+		ctx:     context.Background(),
+		config:  &Config{BindAddress: "192.168.1.10", MappingDuration: time.Hour, KeepAliveInterval: 2 * time.Minute, MaxFailCount: 3},
+		clients: []*UPnPClientInfo{renewalClient(server.URL + "/ctl/IPConn")},
+		mappings: map[string]*PortMapping{
+			"8080:8080:TCP": {InternalPort: 8080, ExternalPort: 8080, Protocol: "TCP", CreatedAt: expiring},
+			"9090:9090:TCP": {InternalPort: 9090, ExternalPort: 9090, Protocol: "TCP", CreatedAt: fresh},
+		},
+	}
+
+	renewed, failed := um.RenewMappings()
+	if renewed != 1 || failed != 0 {
+		t.Fatalf("续期结果 = %d成功 %d失败，期望1成功0失败", renewed, failed)
+	}
+	if got := adds.Load(); got != 1 {
+		t.Errorf("AddPortMapping次数 = %d，只应为即将到期的映射续期", got)
+	}
+	if !um.mappings["8080:8080:TCP"].CreatedAt.After(expiring) {
+		t.Error("续期后应重置映射租期")
+	}
+	if !um.mappings["9090:9090:TCP"].CreatedAt.Equal(fresh) {
+		t.Error("未进入续期窗口的映射不应续期")
+	}
+}
+
+func TestRenewMappings_RediscoversAfterRouterReboot(t *testing.T) {
+	var adds atomic.Int32
+	server := newRenewalRouter(t, &adds)
+
+	// 路由器重启后控制地址的端口变化，旧地址已无法连接
+	stale := httptest.NewServer(http.NotFoundHandler())
+	staleURL := stale.URL + "/ctl/IPConn"
+	stale.Close()
+
+	um := &UPnPManager{
+		logger: logrus.New(),
+		ctx:    context.Background(),
+		config: &Config{
+			BindAddress:       "192.168.1.10",
+			ControlURL:        server.URL + "/ctl/IPConn",
+			DiscoveryTimeout:  5 * time.Second,
+			MappingDuration:   time.Hour,
+			KeepAliveInterval: 2 * time.Minute,
+			MaxFailCount:      3,
+		},
+		clients: []*UPnPClientInfo{renewalClient(staleURL)},
+		mappings: map[string]*PortMapping{
+			"8080:8080:TCP": {InternalPort: 8080, ExternalPort: 8080, Protocol: "TCP", Gateway: staleURL, CreatedAt: time.Now().Add(-58 * time.Minute)},
+		},
+	}
+
+	renewed, failed := um.RenewMappings()
+	if renewed != 1 || failed != 0 {
+		t.Fatalf("重新发现后续期结果 = %d成功 %d失败，期望1成功0失败", renewed, failed)
+	}
+	for _, c := range um.clients {
+		t.Logf("%s %v %d", c.URL, c.IsHealthy, c.FailCount)
+	}
+	if got := um.mappings["8080:8080:TCP"].Gateway; got != server.URL+"/ctl/IPConn" {
+		t.Errorf("续期后映射所在网关 = %q，期望重新发现的控制地址", got)
+	}
+}
@@ -0,0 +1,44 @@
+package upnp
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestReserveMapping_CountsInflightMappings(t *testing.T) {
+	um := &UPnPManager{
+		logger:   logrus.New(),
+		config:   &Config{MaxMappings: 2},
+		mappings: make(map[string]*PortMapping),
+		inflight: make(map[string]bool),
+		clients: []*UPnPClientInfo{
+			{DeviceName: "healthy", IsHealthy: true},
+			{DeviceName: "broken", IsHealthy: false},
+		},
+	}
+
+	clients, err := um.reserveMapping("8080:8080:TCP")
+	if err != nil {
+		t.Fatalf("占用映射失败: %v", err)
+	}
+	if len(clients) != 1 || clients[0].info.DeviceName != "healthy" {
+		t.Fatalf("只应返回健康的客户端: %+v", clients)
+	}
+
+	if _, err := um.reserveMapping("8080:8080:TCP"); err == nil {
+		t.Fatal("正在添加的映射不应被重复占用")
+	}
+
+	if _, err := um.reserveMapping("8081:8081:TCP"); err != nil {
+		t.Fatalf("不同端口应可以并发占用: %v", err)
+	}
+	if _, err := um.reserveMapping("8082:8082:TCP"); err == nil {
+		t.Fatal("正在添加的映射应计入数量上限")
+	}
+
+	um.releaseReservation("8080:8080:TCP")
+	if _, err := um.reserveMapping("8080:8080:TCP"); err != nil {
+		t.Fatalf("释放后应可以再次占用: %v", err)
+	}
+}
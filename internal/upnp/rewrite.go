@@ -0,0 +1,87 @@
+package upnp
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RewritePortMapping 按本地记录重新写入路由器上的映射，用于修正路由器上丢失或被改动的映射
+// 路由器对相同外部端口的AddPortMapping会覆盖原有条目
+func (um *UPnPManager) RewritePortMapping(internalPort, externalPort int, protocol string) error {
+	clientInfo, localIP, err := um.reassertMapping(internalPort, externalPort, protocol)
+	if err != nil {
+		return err
+	}
+
+	um.logger.WithFields(logrus.Fields{
+		"internal_port": internalPort,
+		"external_port": externalPort,
+		"protocol":      protocol,
+		"local_ip":      localIP,
+		"device":        clientInfo.DeviceName,
+	}).Info("已按本地记录重新写入端口映射")
+	return nil
+}
+
+// reassertMapping 向映射所在网关重新发送AddPortMapping并重置租期，返回写入成功的客户端和本地IP
+func (um *UPnPManager) reassertMapping(internalPort, externalPort int, protocol string) (*UPnPClientInfo, string, error) {
+	mappingKey := um.getMappingKey(internalPort, externalPort, protocol)
+
+	um.mutex.RLock()
+	mapping, exists := um.mappings[mappingKey]
+	// 只重新写入映射所在的网关
+	var description, remoteHost string
+	var candidates []*UPnPClientInfo
+	if exists {
+		description, remoteHost = mapping.Description, mapping.RemoteHost
+		candidates = um.mappingClients(mapping)
+	}
+	clients := make([]clientSnapshot, 0, len(candidates))
+	for _, clientInfo := range candidates {
+		if clientInfo.IsHealthy {
+			clients = append(clients, clientSnapshot{info: clientInfo, client: clientInfo.Client})
+		}
+	}
+	um.mutex.RUnlock()
+
+	if !exists {
+		return nil, "", fmt.Errorf("%w: %s", ErrMappingNotTracked, mappingKey)
+	}
+	if len(clients) == 0 {
+		return nil, "", fmt.Errorf("没有可用的UPnP客户端")
+	}
+
+	localIP, err := um.getLocalIP()
+	if err != nil {
+		return nil, "", fmt.Errorf("获取本地IP地址失败: %w", err)
+	}
+
+	var lastErr error
+	for _, snapshot := range clients {
+		err := um.retryTransient(snapshot.info, "AddPortMapping", func() error {
+			return um.addPortMappingToClient(snapshot.client, remoteHost, internalPort, externalPort, protocol, localIP, description)
+		})
+		if err != nil {
+			lastErr = err
+			um.mutex.Lock()
+			um.recordClientFailure(snapshot.info, err)
+			um.mutex.Unlock()
+			continue
+		}
+
+		um.mutex.Lock()
+		um.recordClientSuccess(snapshot.info)
+		if current, ok := um.mappings[mappingKey]; ok {
+			current.Gateway = snapshot.info.URL
+			current.InternalClient = localIP
+			current.LeaseDuration = uint32(um.config.MappingDuration.Seconds())
+			current.CreatedAt = time.Now()
+		}
+		um.mutex.Unlock()
+		return snapshot.info, localIP, nil
+	}
+
+	return nil, "", fmt.Errorf("所有UPnP客户端都重新写入端口映射失败: %w", lastErr)
+}
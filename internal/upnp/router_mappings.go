@@ -0,0 +1,108 @@
+package upnp
+
+import (
+	"fmt"
+)
+
+// ErrCodeArrayIndexInvalid 映射表索引越界，表示已枚举到末尾
+const ErrCodeArrayIndexInvalid = 713
+
+// maxRouterEntries 枚举路由器映射表的条目上限，防止异常路由器无限返回
+const maxRouterEntries = 1024
+
+// RouterMapping 路由器映射表中的一条映射
+type RouterMapping struct {
+	Index          int    `json:"index"`
+	RemoteHost     string `json:"remote_host,omitempty"`
+	ExternalPort   int    `json:"external_port"`
+	InternalPort   int    `json:"internal_port"`
+	Protocol       string `json:"protocol"`
+	InternalClient string `json:"internal_client"`
+	Enabled        bool   `json:"enabled"`
+	Description    string `json:"description"`
+	LeaseDuration  uint32 `json:"lease_duration"`
+	Device         string `json:"device"`
+	Local          bool   `json:"local"`   // 映射指向本机
+	Managed        bool   `json:"managed"` // 映射由本实例创建
+}
+
+// ListRouterMappings 通过GetGenericPortMappingEntry枚举路由器上的全部端口映射，包括其他程序创建的映射
+func (um *UPnPManager) ListRouterMappings() ([]*RouterMapping, error) {
+	um.mutex.RLock()
+	clients := make([]clientSnapshot, 0, len(um.clients))
+	for _, clientInfo := range um.clients {
+		if clientInfo.IsHealthy {
+			clients = append(clients, clientSnapshot{info: clientInfo, client: clientInfo.Client})
+		}
+	}
+	um.mutex.RUnlock()
+
+	if len(clients) == 0 {
+		return nil, fmt.Errorf("没有可用的UPnP客户端")
+	}
+
+	localIP, err := um.getLocalIP()
+	if err != nil {
+		return nil, fmt.Errorf("获取本地IP地址失败: %w", err)
+	}
+
+	var lastErr error
+	for _, snapshot := range clients {
+		entries, err := um.listClientMappings(snapshot, localIP)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return entries, nil
+	}
+
+	return nil, fmt.Errorf("枚举路由器端口映射失败: %w", lastErr)
+}
+
+// listClientMappings 枚举单个客户端的映射表，遇到索引越界时结束
+func (um *UPnPManager) listClientMappings(snapshot clientSnapshot, localIP string) ([]*RouterMapping, error) {
+	um.mutex.RLock()
+	ownedKeys := make(map[string]bool, len(um.mappings))
+	for _, mapping := range um.mappings {
+		ownedKeys[fmt.Sprintf("%d-%s", mapping.ExternalPort, mapping.Protocol)] = true
+	}
+	um.mutex.RUnlock()
+
+	entries := make([]*RouterMapping, 0)
+	for i := 0; i < maxRouterEntries; i++ {
+		remoteHost, externalPort, protocol, internalPort, internalClient, enabled, description, lease, err :=
+			snapshot.client.GetGenericPortMappingEntry(uint16(i))
+		if err != nil {
+			code := UPnPErrorCode(err)
+			if code == ErrCodeArrayIndexInvalid || code == ErrCodeNoSuchEntry {
+				break
+			}
+			// 部分路由器到达末尾时只返回通用错误，已读到条目时按末尾处理
+			if i > 0 && code != 0 {
+				break
+			}
+			return nil, err
+		}
+
+		local := internalClient == localIP
+		managed := um.ownsDescription(description) ||
+			(local && ownedKeys[fmt.Sprintf("%d-%s", externalPort, protocol)])
+
+		entries = append(entries, &RouterMapping{
+			Index:          i,
+			RemoteHost:     remoteHost,
+			ExternalPort:   int(externalPort),
+			InternalPort:   int(internalPort),
+			Protocol:       protocol,
+			InternalClient: internalClient,
+			Enabled:        enabled,
+			Description:    description,
+			LeaseDuration:  lease,
+			Device:         snapshot.info.DeviceName,
+			Local:          local,
+			Managed:        managed,
+		})
+	}
+
+	return entries, nil
+}
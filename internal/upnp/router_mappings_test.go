@@ -0,0 +1,98 @@
+package upnp
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/huin/goupnp"
+	"github.com/huin/goupnp/dcps/internetgateway1"
+	"github.com/huin/goupnp/soap"
+	"github.com/sirupsen/logrus"
+)
+
+// soapGenericEntryResponse GetGenericPortMappingEntry的SOAP应答模板
+const soapGenericEntryResponse = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body><u:GetGenericPortMappingEntryResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">
+<NewRemoteHost></NewRemoteHost><NewExternalPort>%d</NewExternalPort><NewProtocol>%s</NewProtocol>
+<NewInternalPort>%d</NewInternalPort><NewInternalClient>%s</NewInternalClient><NewEnabled>1</NewEnabled>
+<NewPortMappingDescription>%s</NewPortMappingDescription><NewLeaseDuration>0</NewLeaseDuration>
+</u:GetGenericPortMappingEntryResponse></s:Body></s:Envelope>`
+
+// soapArrayIndexInvalid 映射表索引越界的SOAP错误
+const soapArrayIndexInvalid = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body><s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring>
+<detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>713</errorCode>
+<errorDescription>SpecifiedArrayIndexInvalid</errorDescription></UPnPError></detail>
+</s:Fault></s:Body></s:Envelope>`
+
+func TestListRouterMappings_EnumeratesUntilIndexInvalid(t *testing.T) {
+	entries := []struct {
+		external, internal int
+		protocol, client   string
+		description        string
+	}{
+		{8080, 8080, "TCP", "192.168.1.10", "node1/web"},
+		{3074, 3074, "UDP", "192.168.1.20", "Xbox"},
+		{9000, 9000, "TCP", "192.168.1.10", "Transmission"},
+	}
+	indexPattern := regexp.MustCompile(`<NewPortMappingIndex>(\d+)</NewPortMappingIndex>`)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		match := indexPattern.FindSubmatch(body)
+		index, _ := strconv.Atoi(string(match[1]))
+		if index >= len(entries) {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, soapArrayIndexInvalid)
+			return
+		}
+		e := entries[index]
+		fmt.Fprintf(w, soapGenericEntryResponse, e.external, e.protocol, e.internal, e.client, e.description)
+	}))
+	defer server.Close()
+
+	loc, _ := url.Parse(server.URL + "/ctl/IPConn")
+	client := &internetgateway1.WANIPConnection1{
+		ServiceClient: goupnp.ServiceClient{
+			SOAPClient: soap.NewSOAPClient(*loc),
+			Location:   loc,
+			Service:    &goupnp.Service{ServiceType: internetgateway1.URN_WANIPConnection_1},
+		},
+	}
+	um := &UPnPManager{
+		logger:   logrus.New(),
+		config:   &Config{InstanceID: "node1", BindAddress: "192.168.1.10"},
+		clients:  []*UPnPClientInfo{{Client: client, DeviceName: "router", IsHealthy: true}},
+		mappings: make(map[string]*PortMapping),
+	}
+
+	got, err := um.ListRouterMappings()
+	if err != nil {
+		t.Fatalf("枚举路由器映射失败: %v", err)
+	}
+	if len(got) != len(entries) {
+		t.Fatalf("应返回%d条映射，实际%d条", len(entries), len(got))
+	}
+
+	if !got[0].Managed || !got[0].Local {
+		t.Errorf("本实例创建的映射应标记为已管理: %+v", got[0])
+	}
+	if got[1].Managed || got[1].Local || got[1].InternalClient != "192.168.1.20" {
+		t.Errorf("其他主机的映射不应标记为本机或已管理: %+v", got[1])
+	}
+	if got[2].Managed || !got[2].Local || got[2].Description != "Transmission" {
+		t.Errorf("本机其他程序的映射应标记为本机但未管理: %+v", got[2])
+	}
+	if got[2].Index != 2 || got[2].Device != "router" {
+		t.Errorf("映射索引或设备名称错误: %+v", got[2])
+	}
+}
@@ -0,0 +1,139 @@
+package upnp
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// removeAllMappings 从路由器删除本服务创建的映射，SetKeepOnShutdown指定的映射保留并在下次启动时接管
+// 在锁内取出映射和客户端快照，路由器请求在锁外进行，关闭期间其他调用者不会被逐个删除请求阻塞
+func (um *UPnPManager) removeAllMappings() {
+	kept := um.mappingsKeptOnShutdown()
+
+	type pendingRemoval struct {
+		mapping *PortMapping
+		clients []clientSnapshot
+	}
+
+	um.mutex.Lock()
+	var removals []pendingRemoval
+	for key, mapping := range um.mappings {
+		if kept[key] {
+			continue
+		}
+		removal := pendingRemoval{mapping: mapping}
+		for _, clientInfo := range um.mappingClients(mapping) {
+			if clientInfo.IsHealthy {
+				removal.clients = append(removal.clients, clientSnapshot{info: clientInfo, client: clientInfo.Client})
+			}
+		}
+		removals = append(removals, removal)
+		delete(um.mappings, key)
+	}
+	um.mutex.Unlock()
+
+	for _, removal := range removals {
+		mapping := removal.mapping
+		for _, snapshot := range removal.clients {
+			if err := um.removePortMappingFromClient(snapshot.client, mapping.RemoteHost, mapping.ExternalPort, mapping.Protocol); err != nil {
+				um.logger.WithFields(logrus.Fields{
+					"external_port": mapping.ExternalPort,
+					"protocol":      mapping.Protocol,
+					"device":        snapshot.info.DeviceName,
+					"error":         err,
+				}).Warn("关闭时删除端口映射失败")
+			}
+		}
+	}
+
+	if len(kept) > 0 {
+		um.logger.WithField("kept", len(kept)).Info("已删除路由器上的端口映射，保留的映射下次启动时接管")
+		return
+	}
+	um.logger.Info("已删除路由器上的所有端口映射")
+}
+
+// removeAllPinholes 关闭本服务打开的IPv6针孔
+// 针孔无法在下次启动时接管，无论是否保留端口映射都在关闭时删除，避免防火墙上留下无人管理的入站规则
+func (um *UPnPManager) removeAllPinholes() {
+	um.pinholeMutex.Lock()
+	pinholes := um.pinholes
+	clients := um.pinholeClients
+	um.pinholes = make(map[string]*Pinhole)
+	um.pinholeMutex.Unlock()
+
+	if len(pinholes) == 0 {
+		return
+	}
+
+	// 路由器请求在锁外进行
+	for _, pinhole := range pinholes {
+		for _, clientInfo := range clients {
+			if clientInfo.DeviceName != pinhole.Device {
+				continue
+			}
+			if err := um.deletePinholeFromClient(clientInfo, pinhole); err != nil {
+				um.logger.WithFields(logrus.Fields{
+					"internal_port": pinhole.InternalPort,
+					"protocol":      pinhole.Protocol,
+					"device":        clientInfo.DeviceName,
+					"error":         err,
+				}).Warn("关闭时删除IPv6针孔失败")
+			}
+		}
+	}
+	um.logger.Info("已删除所有IPv6针孔")
+}
+
+// mappingsKeptOnShutdown 关闭时需要保留的映射键，在锁外调用判断函数
+func (um *UPnPManager) mappingsKeptOnShutdown() map[string]bool {
+	um.mutex.RLock()
+	keep := um.keepOnShutdown
+	um.mutex.RUnlock()
+	if keep == nil {
+		return nil
+	}
+
+	kept := make(map[string]bool)
+	for key, mapping := range um.GetPortMappings() {
+		if keep(mapping) {
+			kept[key] = true
+		}
+	}
+	return kept
+}
+
+// adoptExistingMapping 查询路由器上是否已有本实例创建、指向本机相同端口的映射，有则接管
+func (um *UPnPManager) adoptExistingMapping(clients []clientSnapshot, internalPort, externalPort int, protocol, remoteHost, localIP string) *PortMapping {
+	for _, snapshot := range clients {
+		clientInfo := snapshot.info
+		entryPort, entryClient, enabled, description, lease, err := snapshot.client.GetSpecificPortMappingEntry(
+			remoteHost,           // NewRemoteHost
+			uint16(externalPort), // NewExternalPort
+			protocol,             // NewProtocol
+		)
+		if err != nil || !enabled || entryClient != localIP || int(entryPort) != internalPort {
+			continue
+		}
+		// 其他实例创建的映射即使指向本机也不接管
+		if !um.ownsDescription(description) {
+			um.logger.WithFields(logrus.Fields{
+				"external_port": externalPort,
+				"protocol":      protocol,
+				"description":   description,
+			}).Debug("路由器上的映射不属于本实例，不接管")
+			continue
+		}
+
+		um.logger.WithFields(logrus.Fields{
+			"internal_port": internalPort,
+			"external_port": externalPort,
+			"protocol":      protocol,
+			"description":   description,
+			"lease":         lease,
+			"device":        clientInfo.DeviceName,
+		}).Info("接管路由器上已存在的端口映射")
+
+		return um.adoptedMapping(clientInfo, remoteHost, internalPort, externalPort, protocol, localIP, description, lease)
+	}
+	return nil
+}
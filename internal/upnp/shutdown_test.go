@@ -0,0 +1,159 @@
+package upnp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// soapSpecificEntryResponse GetSpecificPortMappingEntry的SOAP应答模板
+const soapSpecificEntryResponse = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body><u:GetSpecificPortMappingEntryResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">
+<NewInternalPort>%d</NewInternalPort><NewInternalClient>%s</NewInternalClient><NewEnabled>1</NewEnabled>
+<NewPortMappingDescription>%s</NewPortMappingDescription><NewLeaseDuration>0</NewLeaseDuration>
+</u:GetSpecificPortMappingEntryResponse></s:Body></s:Envelope>`
+
+// actionRouter 记录收到的SOAP操作，conflict为true时AddPortMapping返回718
+type actionRouter struct {
+	mutex    sync.Mutex
+	actions  []string
+	conflict bool
+}
+
+func (r *actionRouter) record(action string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.actions = append(r.actions, action)
+}
+
+func (r *actionRouter) recorded() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string(nil), r.actions...)
+}
+
+// newActionManager 创建连接到模拟路由器的管理器，路由器上已有本实例指向本机8080端口的映射
+func newActionManager(t *testing.T, router *actionRouter) *UPnPManager {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		action := r.Header.Get("SOAPAction")
+		switch {
+		case strings.Contains(action, "GetSpecificPortMappingEntry"):
+			router.record("GetSpecificPortMappingEntry")
+			fmt.Fprintf(w, soapSpecificEntryResponse, 8080, "192.168.1.10", "node1/AutoUPnP-8080")
+		case strings.Contains(action, "AddPortMapping"):
+			router.record("AddPortMapping")
+			if router.conflict {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprintf(w, soapFault, ErrCodeConflict)
+				return
+			}
+			fmt.Fprint(w, soapAddPortMappingResponse)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, soapFault, 501)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return &UPnPManager{
+		logger:     logrus.New(),
+		config:     &Config{InstanceID: "node1", BindAddress: "192.168.1.10", MaxMappings: 10, MaxFailCount: 3},
+		clients:    []*UPnPClientInfo{renewalClient(server.URL + "/ctl/IPConn")},
+		mappings:   make(map[string]*PortMapping),
+		inflight:   make(map[string]bool),
+		discovered: true,
+	}
+}
+
+func TestAddPortMapping_QueriesExistingMappingOnlyOnConflict(t *testing.T) {
+	router := &actionRouter{}
+	um := newActionManager(t, router)
+
+	if err := um.AddPortMapping(8080, 8080, "TCP", "node1/AutoUPnP-8080", ""); err != nil {
+		t.Fatalf("添加映射失败: %v", err)
+	}
+	if got := router.recorded(); len(got) != 1 || got[0] != "AddPortMapping" {
+		t.Errorf("正常添加时路由器收到的请求 = %v，不应查询已有映射", got)
+	}
+
+	// 路由器上保留着上次运行的映射，添加时返回718，查询后直接接管
+	router = &actionRouter{conflict: true}
+	um = newActionManager(t, router)
+	if err := um.AddPortMapping(8080, 8080, "TCP", "node1/AutoUPnP-8080", ""); err != nil {
+		t.Fatalf("冲突时应接管本实例保留的映射: %v", err)
+	}
+	if got := router.recorded(); len(got) != 2 || got[1] != "GetSpecificPortMappingEntry" {
+		t.Errorf("冲突时路由器收到的请求 = %v，期望添加后查询已有映射", got)
+	}
+	mapping, exists := um.GetPortMapping(8080, 8080, "TCP")
+	if !exists || !mapping.Adopted {
+		t.Errorf("应记录接管的映射: %+v", mapping)
+	}
+	if um.clients[0].FailCount != 0 {
+		t.Errorf("接管成功不应计入客户端失败, FailCount = %d", um.clients[0].FailCount)
+	}
+}
+
+func TestRemoveAllMappings_DeletesOutsideLock(t *testing.T) {
+	release := make(chan struct{})
+	var deletes sync.WaitGroup
+	deletes.Add(2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deletes.Done()
+		<-release
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		fmt.Fprint(w, soapDeletePortMappingResponse)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	um := &UPnPManager{
+		logger:  logrus.New(),
+		ctx:     ctx,
+		cancel:  cancel,
+		config:  &Config{RemoveOnShutdown: true},
+		clients: []*UPnPClientInfo{renewalClient(server.URL + "/ctl/IPConn")},
+		mappings: map[string]*PortMapping{
+			"8080:8080:TCP": {InternalPort: 8080, ExternalPort: 8080, Protocol: "TCP"},
+			"9090:9090:TCP": {InternalPort: 9090, ExternalPort: 9090, Protocol: "TCP"},
+		},
+		pinholes: make(map[string]*Pinhole),
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		um.Close()
+		close(closed)
+	}()
+
+	// 路由器还没有应答第一个删除请求时，读取映射不应被阻塞
+	time.Sleep(20 * time.Millisecond)
+	read := make(chan int)
+	go func() { read <- len(um.GetPortMappings()) }()
+	select {
+	case count := <-read:
+		if count != 0 {
+			t.Errorf("开始删除后本地记录数 = %d，期望0", count)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("关闭时删除映射期间不应持有锁")
+	}
+
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("关闭超时")
+	}
+	// 两个映射都应从路由器删除
+	deletes.Wait()
+}
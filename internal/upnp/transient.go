@@ -0,0 +1,64 @@
+package upnp
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	transientRetryAttempts = 2                      // 临时错误的额外重试次数
+	transientRetryDelay    = 300 * time.Millisecond // 临时错误的重试间隔，每次翻倍
+	transientFailWeight    = 3                      // 连续多少次临时错误计为一次失败
+)
+
+// IsTransientError 检查错误是否为路由器繁忙等临时错误
+// 部分路由器在负载较高时会对AddPortMapping返回501 Action Failed，稍后重试即可成功
+func IsTransientError(err error) bool {
+	return UPnPErrorCode(err) == ErrCodeActionFailed
+}
+
+// retryTransient 执行客户端操作，遇到临时错误时短暂等待后重试
+func (um *UPnPManager) retryTransient(clientInfo *UPnPClientInfo, action string, fn func() error) error {
+	delay := transientRetryDelay
+	err := fn()
+	for attempt := 1; attempt <= transientRetryAttempts && IsTransientError(err); attempt++ {
+		um.logger.WithFields(logrus.Fields{
+			"device":  clientInfo.DeviceName,
+			"action":  action,
+			"attempt": attempt,
+			"error":   err,
+		}).Debug("路由器返回临时错误，稍后重试")
+
+		time.Sleep(delay)
+		delay *= 2
+		err = fn()
+	}
+	return err
+}
+
+// recordClientFailure 记录客户端操作失败
+// 临时错误需要连续出现多次才计为一次失败，避免繁忙的路由器被误判为不可用而触发重新发现
+func (um *UPnPManager) recordClientFailure(clientInfo *UPnPClientInfo, err error) {
+	if IsTransientError(err) {
+		clientInfo.TransientErrors++
+		clientInfo.TransientFailCount++
+		if clientInfo.TransientFailCount < transientFailWeight {
+			return
+		}
+		clientInfo.TransientFailCount = 0
+	}
+
+	clientInfo.FailCount++
+	if clientInfo.FailCount >= um.config.MaxFailCount {
+		clientInfo.IsHealthy = false
+	}
+}
+
+// recordClientSuccess 记录客户端操作成功，重置失败计数
+func (um *UPnPManager) recordClientSuccess(clientInfo *UPnPClientInfo) {
+	clientInfo.FailCount = 0
+	clientInfo.TransientFailCount = 0
+	clientInfo.IsHealthy = true
+	clientInfo.LastSeen = time.Now()
+}
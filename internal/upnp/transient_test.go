@@ -0,0 +1,152 @@
+package upnp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/huin/goupnp"
+	"github.com/huin/goupnp/dcps/internetgateway1"
+	"github.com/huin/goupnp/soap"
+	"github.com/sirupsen/logrus"
+)
+
+// upnpFault 指定错误码的SOAP错误
+func upnpFault(code int) error {
+	fault := &soap.SOAPFaultError{}
+	fault.Detail.UPnPError.Errorcode = code
+	return fault
+}
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"501路由器繁忙", upnpFault(ErrCodeActionFailed), true},
+		{"包装后的501", fmt.Errorf("添加端口映射失败: %w", upnpFault(ErrCodeActionFailed)), true},
+		{"714映射不存在", upnpFault(ErrCodeNoSuchEntry), false},
+		{"718外部端口冲突", upnpFault(ErrCodeConflict), false},
+		{"724只支持相同端口", upnpFault(ErrCodeSamePortValue), false},
+		{"非SOAP错误", errors.New("connection refused"), false},
+		{"没有错误", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTransientError(tt.err); got != tt.want {
+				t.Errorf("IsTransientError() = %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordClientFailure_WeighsTransientErrors(t *testing.T) {
+	transient := upnpFault(ErrCodeActionFailed)
+	permanent := upnpFault(606)
+
+	tests := []struct {
+		name           string
+		errs           []error
+		wantFailCount  int
+		wantTransient  int
+		wantHealthy    bool
+		resetAfterLast bool
+	}{
+		{"一次临时错误不计失败", []error{transient}, 0, 1, true, false},
+		{"连续3次临时错误计为一次失败", []error{transient, transient, transient}, 1, 3, true, false},
+		{"6次临时错误计为两次失败", []error{transient, transient, transient, transient, transient, transient}, 2, 6, true, false},
+		{"9次临时错误达到失败上限", []error{transient, transient, transient, transient, transient, transient, transient, transient, transient}, 3, 9, false, false},
+		{"其他错误每次都计为失败", []error{permanent, permanent}, 2, 0, true, false},
+		{"其他错误达到失败上限", []error{permanent, permanent, permanent}, 3, 0, false, false},
+		{"混合错误", []error{transient, permanent, transient, transient}, 2, 3, true, false},
+		{"成功后重置计数", []error{permanent, permanent, transient, transient}, 0, 2, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			um := &UPnPManager{config: &Config{MaxFailCount: 3}}
+			clientInfo := &UPnPClientInfo{IsHealthy: true}
+			for _, err := range tt.errs {
+				um.recordClientFailure(clientInfo, err)
+			}
+			if tt.resetAfterLast {
+				um.recordClientSuccess(clientInfo)
+			}
+
+			if clientInfo.FailCount != tt.wantFailCount {
+				t.Errorf("FailCount = %d，期望 %d", clientInfo.FailCount, tt.wantFailCount)
+			}
+			if clientInfo.TransientErrors != tt.wantTransient {
+				t.Errorf("TransientErrors = %d，期望 %d", clientInfo.TransientErrors, tt.wantTransient)
+			}
+			if clientInfo.IsHealthy != tt.wantHealthy {
+				t.Errorf("IsHealthy = %v，期望 %v", clientInfo.IsHealthy, tt.wantHealthy)
+			}
+		})
+	}
+}
+
+func TestRemovePortMapping_RetriesTransientErrorsOutsideLock(t *testing.T) {
+	// 前两次删除返回501，第三次成功
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		if calls.Add(1) <= 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, soapFault, ErrCodeActionFailed)
+			return
+		}
+		fmt.Fprint(w, soapDeletePortMappingResponse)
+	}))
+	defer server.Close()
+
+	loc, _ := url.Parse(server.URL + "/ctl/IPConn")
+	client := &internetgateway1.WANIPConnection1{
+		ServiceClient: goupnp.ServiceClient{
+			SOAPClient: soap.NewSOAPClient(*loc),
+			Location:   loc,
+			Service:    &goupnp.Service{ServiceType: internetgateway1.URN_WANIPConnection_1},
+		},
+	}
+	clientInfo := &UPnPClientInfo{Client: client, DeviceName: "router", IsHealthy: true}
+	um := &UPnPManager{
+		logger:     logrus.New(),
+		config:     &Config{MaxFailCount: 3},
+		clients:    []*UPnPClientInfo{clientInfo},
+		mappings:   map[string]*PortMapping{"8080:8080:TCP": {InternalPort: 8080, ExternalPort: 8080, Protocol: "TCP"}},
+		discovered: true,
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- um.RemovePortMapping(8080, 8080, "TCP") }()
+
+	// 退避等待期间其他映射操作和状态读取不应被阻塞
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	start := time.Now()
+	if !um.HasPortMapping(8080, 8080, "TCP") {
+		t.Error("路由器确认删除前应保留本地记录")
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("退避重试期间读取映射等待了%v，不应持有锁", elapsed)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("临时错误重试后应删除成功: %v", err)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("DeletePortMapping次数 = %d，期望3", got)
+	}
+	if um.HasPortMapping(8080, 8080, "TCP") {
+		t.Error("删除成功后应删除本地记录")
+	}
+	if clientInfo.FailCount != 0 || !clientInfo.IsHealthy {
+		t.Errorf("重试成功后不应计入失败: FailCount = %d", clientInfo.FailCount)
+	}
+}
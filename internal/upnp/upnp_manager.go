@@ -2,36 +2,50 @@ package upnp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"sync"
 	"time"
 
 	"github.com/huin/goupnp"
-	"github.com/huin/goupnp/dcps/internetgateway1"
 	"github.com/sirupsen/logrus"
 )
 
+// ErrDiscoveryInProgress 已有设备发现正在进行
+var ErrDiscoveryInProgress = errors.New("UPnP设备发现正在进行中")
+
 // PortMapping 端口映射信息
 type PortMapping struct {
-	InternalPort   int
-	ExternalPort   int
-	Protocol       string
-	InternalClient string
-	Description    string
-	LeaseDuration  uint32
-	CreatedAt      time.Time
+	InternalPort   int       `json:"internal_port"`
+	ExternalPort   int       `json:"external_port"`
+	Protocol       string    `json:"protocol"`
+	InternalClient string    `json:"internal_client"`
+	Description    string    `json:"description"`
+	LeaseDuration  uint32    `json:"lease_duration"`
+	CreatedAt      time.Time `json:"created_at"`
+	Adopted        bool      `json:"adopted,omitempty"`     // 启动时接管的路由器上已存在的映射
+	Gateway        string    `json:"gateway,omitempty"`     // 映射所在网关的URL，删除和重新写入时只针对该网关
+	RemoteHost     string    `json:"remote_host,omitempty"` // 只允许该来源地址访问，为空时不限制
+	Simulated      bool      `json:"simulated,omitempty"`   // 模拟模式下只记录在本地，没有写入路由器
 }
 
 // UPnPClientInfo UPnP客户端信息
 type UPnPClientInfo struct {
-	Client     *internetgateway1.WANIPConnection1
+	Client     WANConnectionClient
 	DeviceName string
 	URL        string
+	IGDVersion int // 1为WANIPConnection:1，2为IGDv2的WANIPConnection:2
 	LastSeen   time.Time
 	IsHealthy  bool
 	FailCount  int
 	LastUsed   time.Time // 添加最后使用时间用于LRU缓存
+
+	TransientFailCount int // 连续的临时错误次数，达到阈值后计为一次失败
+	TransientErrors    int // 累计的临时错误次数
+
+	WANAddress   string    // 最近一次健康检查得到的外部IP，路由器返回空地址时为空
+	WANAddressAt time.Time // WANAddress的更新时间
 }
 
 // UPnPManager UPnP管理器
@@ -42,9 +56,30 @@ type UPnPManager struct {
 	ctx          context.Context
 	cancel       context.CancelFunc
 	mappings     map[string]*PortMapping
+	inflight     map[string]bool             // 正在向路由器添加的映射，路由器请求在锁外进行
+	unclaimed    map[string]unclaimedMapping // 启动时接管、尚未被认领的映射
 	config       *Config
 	discovered   bool
 	healthTicker *time.Ticker
+	heartbeat    func()
+
+	// 关闭时保留在路由器上的映射，为nil时全部删除
+	keepOnShutdown func(mapping *PortMapping) bool
+
+	// WAN地址变化检测
+	wan wanState
+
+	// 多个网关时的选择状态
+	gateways gatewaySelector
+
+	// 串行化设备发现，避免并发发现风暴
+	discoverMutex sync.Mutex
+
+	// IPv6针孔，使用独立的锁，针孔的路由器请求不阻塞端口映射操作
+	pinholeMutex   sync.RWMutex
+	pinholeClients []*PinholeClientInfo
+	pinholes       map[string]*Pinhole
+	pinholeOpening map[string]chan struct{} // 正在打开的针孔，关闭通道表示打开结束
 
 	// 添加缓存和连接池
 	clientCache  map[string]*UPnPClientInfo // 客户端缓存
@@ -60,11 +95,21 @@ type Config struct {
 	RetryAttempts       int
 	RetryDelay          time.Duration
 	MaxMappings         int
-	HealthCheckInterval time.Duration // 健康检查间隔
-	MaxFailCount        int           // 最大失败次数
-	KeepAliveInterval   time.Duration // 保活间隔
-	MaxCacheSize        int           // 最大缓存大小
-	CacheTTL            time.Duration // 缓存TTL
+	HealthCheckInterval time.Duration  // 健康检查间隔
+	MaxFailCount        int            // 最大失败次数
+	KeepAliveInterval   time.Duration  // 检查映射租期并续期的间隔
+	MaxCacheSize        int            // 最大缓存大小
+	CacheTTL            time.Duration  // 缓存TTL
+	EnableIPv6Pinhole   bool           // 是否启用IPv6针孔
+	UserAgent           string         // UPnP HTTP/SOAP请求的User-Agent，为空时使用默认值
+	RemoveOnShutdown    bool           // 关闭时是否删除路由器上的映射
+	InstanceID          string         // 实例标识，作为映射描述的前缀，多个实例共用一个路由器时只管理自己的映射
+	BindAddress         string         // 本机地址，不为空时映射指向该地址而不是自动选择的出口地址
+	ControlURL          string         // 路由器的设备描述或WANIPConnection控制地址，不为空时跳过SSDP发现
+	GatewayURLs         []string       // 网关的设备描述或控制地址列表，不为空时只使用这些网关，不进行SSDP发现
+	GatewayPolicy       string         // 多个健康网关时添加映射使用的网关，见GatewayPolicy*常量
+	GatewayWeights      map[string]int // weighted策略下各网关的权重，键为网关URL、主机名或设备名
+	DryRun              bool           // 模拟模式，照常发现设备和记录映射，但不向路由器添加或删除映射
 }
 
 // NewUPnPManager 创建新的UPnP管理器
@@ -72,6 +117,9 @@ func NewUPnPManager(config *Config, logger *logrus.Logger) *UPnPManager {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	// 设置默认值
+	if config.DiscoveryTimeout == 0 {
+		config.DiscoveryTimeout = 10 * time.Second
+	}
 	if config.HealthCheckInterval == 0 {
 		config.HealthCheckInterval = 2 * time.Minute
 	}
@@ -87,32 +135,44 @@ func NewUPnPManager(config *Config, logger *logrus.Logger) *UPnPManager {
 	if config.CacheTTL == 0 {
 		config.CacheTTL = 10 * time.Minute
 	}
+	if config.InstanceID == "" {
+		config.InstanceID = DefaultInstanceID()
+	}
 
 	um := &UPnPManager{
-		logger:       logger,
-		clients:      make([]*UPnPClientInfo, 0),
-		ctx:          ctx,
-		cancel:       cancel,
-		mappings:     make(map[string]*PortMapping),
-		config:       config,
-		discovered:   false,
-		clientCache:  make(map[string]*UPnPClientInfo),
-		maxCacheSize: config.MaxCacheSize,
-		cacheTTL:     config.CacheTTL,
-	}
-
-	// 启动健康检查协程
+		logger:         logger,
+		clients:        make([]*UPnPClientInfo, 0),
+		ctx:            ctx,
+		cancel:         cancel,
+		mappings:       make(map[string]*PortMapping),
+		inflight:       make(map[string]bool),
+		unclaimed:      make(map[string]unclaimedMapping),
+		pinholes:       make(map[string]*Pinhole),
+		pinholeOpening: make(map[string]chan struct{}),
+		config:         config,
+		discovered:     false,
+		clientCache:    make(map[string]*UPnPClientInfo),
+		maxCacheSize:   config.MaxCacheSize,
+		cacheTTL:       config.CacheTTL,
+	}
+
+	um.setupUserAgent()
+
+	// 启动健康检查协程，计时器在启动协程前创建，Close可以在任何时候安全地停止它
+	um.healthTicker = time.NewTicker(config.HealthCheckInterval)
 	go um.healthCheckRoutine()
 
 	// 启动缓存清理协程
 	go um.cacheCleanupRoutine()
 
+	// 启动映射续期协程
+	go um.keepAliveRoutine()
+
 	return um
 }
 
 // healthCheckRoutine 健康检查协程
 func (um *UPnPManager) healthCheckRoutine() {
-	um.healthTicker = time.NewTicker(um.config.HealthCheckInterval)
 	defer um.healthTicker.Stop()
 
 	for {
@@ -121,10 +181,32 @@ func (um *UPnPManager) healthCheckRoutine() {
 			return
 		case <-um.healthTicker.C:
 			um.performHealthCheck()
+
+			um.mutex.RLock()
+			heartbeat := um.heartbeat
+			um.mutex.RUnlock()
+			if heartbeat != nil {
+				heartbeat()
+			}
 		}
 	}
 }
 
+// SetKeepOnShutdown 设置关闭时保留在路由器上的映射，只在RemoveOnShutdown为true时生效
+// 判断函数在锁外调用，可以访问调用者自己的状态
+func (um *UPnPManager) SetKeepOnShutdown(keep func(mapping *PortMapping) bool) {
+	um.mutex.Lock()
+	defer um.mutex.Unlock()
+	um.keepOnShutdown = keep
+}
+
+// SetHeartbeat 设置每轮健康检查完成后调用的心跳函数
+func (um *UPnPManager) SetHeartbeat(heartbeat func()) {
+	um.mutex.Lock()
+	defer um.mutex.Unlock()
+	um.heartbeat = heartbeat
+}
+
 // performHealthCheck 执行健康检查
 func (um *UPnPManager) performHealthCheck() {
 	um.mutex.Lock()
@@ -156,6 +238,11 @@ func (um *UPnPManager) performHealthCheck() {
 	// 更新客户端列表
 	um.clients = healthyClients
 
+	// 以第一个健康客户端的外部IP为准检测WAN地址变化
+	if len(healthyClients) > 0 {
+		um.observeWANAddress(healthyClients[0].WANAddress)
+	}
+
 	// 如果没有健康的客户端，尝试重新发现
 	if len(um.clients) == 0 {
 		um.logger.Warn("所有UPnP客户端都不健康，尝试重新发现")
@@ -172,7 +259,7 @@ func (um *UPnPManager) performHealthCheck() {
 // checkClientHealth 检查单个客户端健康状态
 func (um *UPnPManager) checkClientHealth(clientInfo *UPnPClientInfo) bool {
 	// 尝试获取外部IP地址作为健康检查
-	_, err := clientInfo.Client.GetExternalIPAddress()
+	address, err := clientInfo.Client.GetExternalIPAddress()
 	if err != nil {
 		clientInfo.FailCount++
 		clientInfo.IsHealthy = false
@@ -199,6 +286,8 @@ func (um *UPnPManager) checkClientHealth(clientInfo *UPnPClientInfo) bool {
 	clientInfo.FailCount = 0
 	clientInfo.IsHealthy = true
 	clientInfo.LastSeen = time.Now()
+	clientInfo.WANAddress = normalizeWANAddress(address)
+	clientInfo.WANAddressAt = clientInfo.LastSeen
 	return true
 }
 
@@ -216,12 +305,75 @@ func (um *UPnPManager) rediscoverDevices() {
 
 // Discover 发现UPnP设备
 func (um *UPnPManager) Discover() error {
+	um.discoverMutex.Lock()
+	defer um.discoverMutex.Unlock()
+
+	return um.discover()
+}
+
+// Rediscover 立即重新发现UPnP设备，返回发现后的客户端数量
+//
+// 已有发现正在进行时直接返回ErrDiscoveryInProgress，不会排队等待。
+func (um *UPnPManager) Rediscover() (int, error) {
+	if !um.discoverMutex.TryLock() {
+		return um.GetClientCount(), ErrDiscoveryInProgress
+	}
+	defer um.discoverMutex.Unlock()
+
+	err := um.discover()
+	return um.GetClientCount(), err
+}
+
+// ensureDiscovered 未发现UPnP设备时先尝试重新发现（调用者不能持有锁）
+func (um *UPnPManager) ensureDiscovered() error {
+	um.mutex.RLock()
+	needDiscover := !um.discovered || len(um.clients) == 0
+	um.mutex.RUnlock()
+
+	if !needDiscover {
+		return nil
+	}
+
+	um.logger.Info("尝试重新发现UPnP设备")
+	return um.Discover()
+}
+
+// discover 执行UPnP设备发现（调用者需要持有discoverMutex）
+func (um *UPnPManager) discover() error {
 	um.logger.Info("开始发现UPnP设备")
 
-	// 发现所有UPnP设备
-	devices, err := goupnp.DiscoverDevices("urn:schemas-upnp-org:device:InternetGatewayDevice:1")
-	if err != nil {
-		return fmt.Errorf("发现UPnP设备失败: %w", err)
+	// 发现IPv6防火墙控制服务（与IGD设备独立）
+	if um.config.EnableIPv6Pinhole {
+		um.discoverPinholeClients()
+	}
+
+	// 配置了网关地址列表时只使用这些网关，避免发现多个路由器时映射到没有外网出口的设备
+	if len(um.config.GatewayURLs) > 0 {
+		return um.discoverByGatewayURLs()
+	}
+
+	// 配置了控制URL时跳过SSDP，适用于不响应组播发现但支持SOAP的路由器
+	if um.config.ControlURL != "" {
+		err := um.discoverByControlURL()
+		if err == nil {
+			return nil
+		}
+		um.logger.WithError(err).Warn("无法通过配置的控制URL连接路由器，改用SSDP发现")
+	}
+
+	// 分别搜索IGDv1和IGDv2设备，同时响应两种搜索的设备只添加一次
+	var devices []goupnp.MaybeRootDevice
+	var searchErr error
+	for _, target := range []string{urnInternetGatewayDevice1, urnInternetGatewayDevice2} {
+		found, err := goupnp.DiscoverDevices(target)
+		if err != nil {
+			searchErr = err
+			continue
+		}
+		devices = append(devices, found...)
+	}
+	if len(devices) == 0 && searchErr != nil {
+		return fmt.Errorf("发现UPnP设备失败: %w", searchErr)
 	}
 
 	if len(devices) == 0 {
@@ -230,54 +382,55 @@ func (um *UPnPManager) Discover() error {
 
 	um.logger.WithField("device_count", len(devices)).Info("发现UPnP设备")
 
-	um.mutex.Lock()
-	defer um.mutex.Unlock()
-
-	// 获取WAN IP连接客户端
+	// 在锁外创建客户端，设备同时提供多个WAN连接服务时需要请求路由器确认使用哪个
+	seen := make(map[string]bool, len(devices))
+	found := make([]*UPnPClientInfo, 0, len(devices))
 	for _, device := range devices {
-		clients, err := internetgateway1.NewWANIPConnection1ClientsFromRootDevice(device.Root, &device.Root.URLBase)
+		if device.Err != nil || device.Root == nil {
+			continue
+		}
+		location := device.Root.URLBase.String()
+		if seen[location] {
+			continue
+		}
+		seen[location] = true
+
+		client, version, err := um.wanClientFromRootDevice(device.Root, &device.Root.URLBase)
 		if err != nil {
-			um.logger.WithField("device", device.Root.Device.FriendlyName).Warn("无法创建WAN IP连接客户端")
+			um.logger.WithFields(logrus.Fields{
+				"device": device.Root.Device.FriendlyName,
+				"error":  err,
+			}).Warn("无法创建WAN连接客户端")
 			continue
 		}
 
-		if len(clients) > 0 {
-			clientInfo := &UPnPClientInfo{
-				Client:     clients[0],
-				DeviceName: device.Root.Device.FriendlyName,
-				URL:        device.Root.URLBase.String(),
-				LastSeen:   time.Now(),
-				IsHealthy:  true,
-				FailCount:  0,
-			}
+		found = append(found, &UPnPClientInfo{
+			Client:     client,
+			DeviceName: device.Root.Device.FriendlyName,
+			URL:        location,
+			IGDVersion: version,
+			LastSeen:   time.Now(),
+			IsHealthy:  true,
+			FailCount:  0,
+		})
 
-			// 检查是否已存在相同的客户端
-			exists := false
-			for _, existingClient := range um.clients {
-				if existingClient.URL == clientInfo.URL {
-					exists = true
-					// 更新现有客户端信息
-					existingClient.Client = clientInfo.Client
-					existingClient.LastSeen = time.Now()
-					existingClient.IsHealthy = true
-					existingClient.FailCount = 0
-					break
-				}
-			}
+		um.logger.WithFields(logrus.Fields{
+			"device":      device.Root.Device.FriendlyName,
+			"url":         location,
+			"service":     wanServiceName(client),
+			"igd_version": version,
+		}).Info("添加UPnP客户端")
+	}
 
-			if !exists {
-				um.clients = append(um.clients, clientInfo)
-			}
+	um.mutex.Lock()
+	defer um.mutex.Unlock()
 
-			um.logger.WithFields(logrus.Fields{
-				"device": device.Root.Device.FriendlyName,
-				"url":    device.Root.URLBase,
-			}).Info("添加UPnP客户端")
-		}
+	for _, clientInfo := range found {
+		um.addClient(clientInfo)
 	}
 
 	if len(um.clients) == 0 {
-		return fmt.Errorf("未找到可用的WAN IP连接")
+		return fmt.Errorf("未找到可用的WAN连接（WANIPConnection或WANPPPConnection）")
 	}
 
 	um.logger.WithField("client_count", len(um.clients)).Info("UPnP设备发现完成")
@@ -285,30 +438,53 @@ func (um *UPnPManager) Discover() error {
 	return nil
 }
 
-// AddPortMapping 添加端口映射
-func (um *UPnPManager) AddPortMapping(internalPort, externalPort int, protocol string, description string) error {
-	um.mutex.Lock()
-	defer um.mutex.Unlock()
+// addClient 添加发现的客户端，相同URL的客户端只更新连接信息（调用者需要持有锁）
+func (um *UPnPManager) addClient(clientInfo *UPnPClientInfo) {
+	for _, existingClient := range um.clients {
+		if existingClient.URL == clientInfo.URL {
+			// 更新现有客户端信息
+			existingClient.Client = clientInfo.Client
+			existingClient.IGDVersion = clientInfo.IGDVersion
+			existingClient.LastSeen = time.Now()
+			existingClient.IsHealthy = true
+			existingClient.FailCount = 0
+			return
+		}
+	}
+	um.clients = append(um.clients, clientInfo)
+}
 
-	// 检查映射数量限制
-	if len(um.mappings) >= um.config.MaxMappings {
-		return fmt.Errorf("端口映射数量已达到上限: %d", um.config.MaxMappings)
+// AddPortMapping 添加端口映射，remoteHost不为空时路由器只转发来自该地址的连接
+func (um *UPnPManager) AddPortMapping(internalPort, externalPort int, protocol, description, remoteHost string) error {
+	// 如果没有发现UPnP设备，先尝试重新发现
+	if err := um.ensureDiscovered(); err != nil {
+		return fmt.Errorf("无法发现UPnP设备，无法添加端口映射: %w", err)
 	}
 
-	// 检查是否已存在映射
+	// 启动时已从路由器接管的映射直接认领
 	mappingKey := um.getMappingKey(internalPort, externalPort, protocol)
-	if _, exists := um.mappings[mappingKey]; exists {
-		return fmt.Errorf("端口映射已存在: %s", mappingKey)
+	claimed, stale := um.claimAdopted(mappingKey, remoteHost)
+	if claimed {
+		um.logger.WithFields(logrus.Fields{
+			"internal_port": internalPort,
+			"external_port": externalPort,
+			"protocol":      protocol,
+		}).Debug("使用启动时接管的端口映射")
+		return nil
 	}
-
-	// 如果没有发现UPnP设备，先尝试重新发现
-	if !um.discovered || len(um.clients) == 0 {
-		um.logger.Info("尝试重新发现UPnP设备")
-		if err := um.Discover(); err != nil {
-			return fmt.Errorf("无法发现UPnP设备，无法添加端口映射: %w", err)
+	if stale != nil {
+		if err := um.RemovePortMapping(internalPort, externalPort, protocol); err != nil {
+			return fmt.Errorf("删除来源地址不同的已接管映射失败: %w", err)
 		}
 	}
 
+	// 占用映射键后在锁外请求路由器，不同端口的映射可以并发添加
+	clients, err := um.reserveMapping(mappingKey)
+	if err != nil {
+		return err
+	}
+	defer um.releaseReservation(mappingKey)
+
 	// 获取本地IP地址
 	localIP, err := um.getLocalIP()
 	if err != nil {
@@ -317,23 +493,28 @@ func (um *UPnPManager) AddPortMapping(internalPort, externalPort int, protocol s
 
 	// 尝试添加映射到所有可用的客户端
 	var lastErr error
-	for i, clientInfo := range um.clients {
-		if !clientInfo.IsHealthy {
-			um.logger.WithFields(logrus.Fields{
-				"client_index": i,
-				"device":       clientInfo.DeviceName,
-			}).Debug("跳过不健康的UPnP客户端")
-			continue
+	for i, snapshot := range clients {
+		clientInfo := snapshot.info
+		err := um.retryTransient(clientInfo, "AddPortMapping", func() error {
+			return um.addPortMappingToClient(snapshot.client, remoteHost, internalPort, externalPort, protocol, localIP, description)
+		})
+		// 外部端口冲突时检查是否为上次运行保留在路由器上的映射，是则直接接管
+		// 只在冲突时查询路由器，正常添加不增加额外的请求
+		if IsConflictError(err) {
+			if mapping := um.adoptExistingMapping([]clientSnapshot{snapshot}, internalPort, externalPort, protocol, remoteHost, localIP); mapping != nil {
+				um.mutex.Lock()
+				um.recordClientSuccess(clientInfo)
+				um.mappings[mappingKey] = mapping
+				um.mutex.Unlock()
+				return nil
+			}
 		}
-
-		err := um.addPortMappingToClient(clientInfo.Client, internalPort, externalPort, protocol, localIP, description)
 		if err != nil {
 			lastErr = err
 			// 增加失败计数
-			clientInfo.FailCount++
-			if clientInfo.FailCount >= um.config.MaxFailCount {
-				clientInfo.IsHealthy = false
-			}
+			um.mutex.Lock()
+			um.recordClientFailure(clientInfo, err)
+			um.mutex.Unlock()
 
 			um.logger.WithFields(logrus.Fields{
 				"client_index":  i,
@@ -341,16 +522,12 @@ func (um *UPnPManager) AddPortMapping(internalPort, externalPort int, protocol s
 				"internal_port": internalPort,
 				"external_port": externalPort,
 				"protocol":      protocol,
+				"transient":     IsTransientError(err),
 				"error":         err,
 			}).Warn("添加端口映射失败")
 			continue
 		}
 
-		// 映射成功，重置失败计数
-		clientInfo.FailCount = 0
-		clientInfo.IsHealthy = true
-		clientInfo.LastSeen = time.Now()
-
 		// 记录映射信息
 		mapping := &PortMapping{
 			InternalPort:   internalPort,
@@ -360,9 +537,16 @@ func (um *UPnPManager) AddPortMapping(internalPort, externalPort int, protocol s
 			Description:    description,
 			LeaseDuration:  uint32(um.config.MappingDuration.Seconds()),
 			CreatedAt:      time.Now(),
+			Gateway:        clientInfo.URL,
+			RemoteHost:     remoteHost,
+			Simulated:      um.config.DryRun,
 		}
 
+		// 映射成功，重置失败计数
+		um.mutex.Lock()
+		um.recordClientSuccess(clientInfo)
 		um.mappings[mappingKey] = mapping
+		um.mutex.Unlock()
 
 		um.logger.WithFields(logrus.Fields{
 			"internal_port": internalPort,
@@ -370,6 +554,7 @@ func (um *UPnPManager) AddPortMapping(internalPort, externalPort int, protocol s
 			"protocol":      protocol,
 			"local_ip":      localIP,
 			"description":   description,
+			"remote_host":   remoteHost,
 			"device":        clientInfo.DeviceName,
 		}).Info("端口映射添加成功")
 
@@ -379,28 +564,75 @@ func (um *UPnPManager) AddPortMapping(internalPort, externalPort int, protocol s
 	return fmt.Errorf("所有UPnP客户端都添加端口映射失败: %w", lastErr)
 }
 
-// RemovePortMapping 删除端口映射
-func (um *UPnPManager) RemovePortMapping(internalPort, externalPort int, protocol string) error {
+// clientSnapshot 在锁内获取的客户端，重新发现会替换UPnPClientInfo.Client，锁外只使用快照中的客户端
+type clientSnapshot struct {
+	info   *UPnPClientInfo
+	client WANConnectionClient
+}
+
+// reserveMapping 检查映射数量上限并占用映射键，返回按网关选择策略排列的健康客户端
+func (um *UPnPManager) reserveMapping(mappingKey string) ([]clientSnapshot, error) {
 	um.mutex.Lock()
 	defer um.mutex.Unlock()
 
+	// 检查映射数量限制，正在添加的映射也计入
+	if len(um.mappings)+len(um.inflight) >= um.config.MaxMappings {
+		return nil, fmt.Errorf("端口映射数量已达到上限: %d", um.config.MaxMappings)
+	}
+
+	// 检查是否已存在映射
+	if _, exists := um.mappings[mappingKey]; exists {
+		return nil, fmt.Errorf("端口映射已存在: %s", mappingKey)
+	}
+	if um.inflight[mappingKey] {
+		return nil, fmt.Errorf("端口映射正在添加: %s", mappingKey)
+	}
+
+	clients := make([]clientSnapshot, 0, len(um.clients))
+	for i, clientInfo := range um.clients {
+		if !clientInfo.IsHealthy {
+			um.logger.WithFields(logrus.Fields{
+				"client_index": i,
+				"device":       clientInfo.DeviceName,
+			}).Debug("跳过不健康的UPnP客户端")
+			continue
+		}
+		clients = append(clients, clientSnapshot{info: clientInfo, client: clientInfo.Client})
+	}
+
+	um.inflight[mappingKey] = true
+	return um.orderClients(clients), nil
+}
+
+// releaseReservation 释放映射键的占用
+func (um *UPnPManager) releaseReservation(mappingKey string) {
+	um.mutex.Lock()
+	defer um.mutex.Unlock()
+	delete(um.inflight, mappingKey)
+}
+
+// RemovePortMapping 删除端口映射，路由器确认删除（或确认映射已不存在）后才删除本地记录
+// 本服务没有记录该映射时返回ErrMappingNotTracked，路由器删除失败时返回*RemovalError
+func (um *UPnPManager) RemovePortMapping(internalPort, externalPort int, protocol string) error {
 	mappingKey := um.getMappingKey(internalPort, externalPort, protocol)
-	mapping, exists := um.mappings[mappingKey]
-	if !exists {
-		return fmt.Errorf("端口映射不存在: %s", mappingKey)
+	if !um.HasPortMapping(internalPort, externalPort, protocol) {
+		return fmt.Errorf("%w: %s", ErrMappingNotTracked, mappingKey)
 	}
 
 	// 如果没有发现UPnP设备，先尝试重新发现
-	if !um.discovered || len(um.clients) == 0 {
-		um.logger.Info("尝试重新发现UPnP设备")
-		if err := um.Discover(); err != nil {
-			return fmt.Errorf("无法发现UPnP设备，无法删除端口映射: %w", err)
-		}
+	if err := um.ensureDiscovered(); err != nil {
+		return &RemovalError{Key: mappingKey, Err: fmt.Errorf("无法发现UPnP设备: %w", err)}
 	}
 
-	// 尝试从所有客户端删除映射
-	var lastErr error
-	for i, clientInfo := range um.clients {
+	// 在锁内取得映射和客户端快照，路由器请求和临时错误的退避重试在锁外进行
+	um.mutex.Lock()
+	mapping, exists := um.mappings[mappingKey]
+	if !exists {
+		um.mutex.Unlock()
+		return fmt.Errorf("%w: %s", ErrMappingNotTracked, mappingKey)
+	}
+	var clients []clientSnapshot
+	for i, clientInfo := range um.mappingClients(mapping) {
 		if !clientInfo.IsHealthy {
 			um.logger.WithFields(logrus.Fields{
 				"client_index": i,
@@ -408,45 +640,68 @@ func (um *UPnPManager) RemovePortMapping(internalPort, externalPort int, protoco
 			}).Debug("跳过不健康的UPnP客户端")
 			continue
 		}
+		clients = append(clients, clientSnapshot{info: clientInfo, client: clientInfo.Client})
+	}
+	remoteHost := mapping.RemoteHost
+	um.mutex.Unlock()
 
-		err := um.removePortMappingFromClient(clientInfo.Client, externalPort, protocol)
+	// 从映射所在的网关删除映射，没有记录网关时尝试所有客户端
+	var lastErr error
+	for i, snapshot := range clients {
+		clientInfo := snapshot.info
+		err := um.retryTransient(clientInfo, "DeletePortMapping", func() error {
+			return um.removePortMappingFromClient(snapshot.client, remoteHost, externalPort, protocol)
+		})
+		// 路由器上已不存在该映射（例如路由器重启后丢失），与删除成功等同
+		if UPnPErrorCode(err) == ErrCodeNoSuchEntry {
+			um.logger.WithFields(logrus.Fields{
+				"internal_port": internalPort,
+				"external_port": externalPort,
+				"protocol":      protocol,
+				"device":        clientInfo.DeviceName,
+			}).Info("路由器上已不存在该端口映射，删除本地记录")
+			err = nil
+		}
 		if err != nil {
 			lastErr = err
 			// 增加失败计数
-			clientInfo.FailCount++
-			if clientInfo.FailCount >= um.config.MaxFailCount {
-				clientInfo.IsHealthy = false
-			}
+			um.mutex.Lock()
+			um.recordClientFailure(clientInfo, err)
+			um.mutex.Unlock()
 
 			um.logger.WithFields(logrus.Fields{
 				"client_index":  i,
 				"device":        clientInfo.DeviceName,
 				"external_port": externalPort,
 				"protocol":      protocol,
+				"transient":     IsTransientError(err),
 				"error":         err,
 			}).Warn("删除端口映射失败")
 			continue
 		}
 
-		// 删除成功，重置失败计数
-		clientInfo.FailCount = 0
-		clientInfo.IsHealthy = true
-		clientInfo.LastSeen = time.Now()
-
-		// 移除映射记录
-		delete(um.mappings, mappingKey)
+		// 删除成功，重置失败计数并移除映射记录；删除期间映射已被替换时保留新的记录
+		um.mutex.Lock()
+		um.recordClientSuccess(clientInfo)
+		if um.mappings[mappingKey] == mapping {
+			delete(um.mappings, mappingKey)
+		}
+		um.mutex.Unlock()
 
 		um.logger.WithFields(logrus.Fields{
-			"internal_port": mapping.InternalPort,
-			"external_port": mapping.ExternalPort,
-			"protocol":      mapping.Protocol,
+			"internal_port": internalPort,
+			"external_port": externalPort,
+			"protocol":      protocol,
 			"device":        clientInfo.DeviceName,
 		}).Info("端口映射删除成功")
 
 		return nil
 	}
 
-	return fmt.Errorf("所有UPnP客户端都删除端口映射失败: %w", lastErr)
+	if lastErr == nil {
+		lastErr = errors.New("没有健康的UPnP客户端")
+	}
+	return &RemovalError{Key: mappingKey, Err: fmt.Errorf("所有UPnP客户端都删除端口映射失败: %w", lastErr)}
 }
 
 // GetPortMappings 获取所有端口映射
@@ -456,11 +711,34 @@ func (um *UPnPManager) GetPortMappings() map[string]*PortMapping {
 
 	mappings := make(map[string]*PortMapping)
 	for key, mapping := range um.mappings {
-		mappings[key] = mapping
+		mappingCopy := *mapping
+		mappings[key] = &mappingCopy
 	}
 	return mappings
 }
 
+// HasPortMapping 检查端口映射是否存在
+func (um *UPnPManager) HasPortMapping(internalPort, externalPort int, protocol string) bool {
+	um.mutex.RLock()
+	defer um.mutex.RUnlock()
+
+	_, exists := um.mappings[um.getMappingKey(internalPort, externalPort, protocol)]
+	return exists
+}
+
+// GetPortMapping 获取单个端口映射的副本
+func (um *UPnPManager) GetPortMapping(internalPort, externalPort int, protocol string) (*PortMapping, bool) {
+	um.mutex.RLock()
+	defer um.mutex.RUnlock()
+
+	mapping, exists := um.mappings[um.getMappingKey(internalPort, externalPort, protocol)]
+	if !exists {
+		return nil, false
+	}
+	mappingCopy := *mapping
+	return &mappingCopy, true
+}
+
 // GetClientCount 获取UPnP客户端数量
 func (um *UPnPManager) GetClientCount() int {
 	um.mutex.RLock()
@@ -495,11 +773,15 @@ func (um *UPnPManager) GetClientStatus() []map[string]interface{} {
 	var status []map[string]interface{}
 	for _, client := range um.clients {
 		status = append(status, map[string]interface{}{
-			"device_name": client.DeviceName,
-			"url":         client.URL,
-			"is_healthy":  client.IsHealthy,
-			"fail_count":  client.FailCount,
-			"last_seen":   client.LastSeen,
+			"device_name":          client.DeviceName,
+			"url":                  client.URL,
+			"igd_version":          client.IGDVersion,
+			"service":              wanServiceName(client.Client),
+			"is_healthy":           client.IsHealthy,
+			"fail_count":           client.FailCount,
+			"last_seen":            client.LastSeen,
+			"transient_fail_count": client.TransientFailCount,
+			"transient_errors":     client.TransientErrors,
 		})
 	}
 	return status
@@ -533,7 +815,7 @@ func (um *UPnPManager) CleanupExpiredMappings() {
 		// 从所有健康的客户端删除映射
 		for _, clientInfo := range um.clients {
 			if clientInfo.IsHealthy {
-				um.removePortMappingFromClient(clientInfo.Client, mapping.ExternalPort, mapping.Protocol)
+				um.removePortMappingFromClient(clientInfo.Client, mapping.RemoteHost, mapping.ExternalPort, mapping.Protocol)
 			}
 		}
 
@@ -541,24 +823,43 @@ func (um *UPnPManager) CleanupExpiredMappings() {
 	}
 }
 
-// addPortMappingToClient 向指定客户端添加端口映射
-func (um *UPnPManager) addPortMappingToClient(client *internetgateway1.WANIPConnection1, internalPort, externalPort int, protocol, internalClient, description string) error {
+// addPortMappingToClient 向指定客户端添加端口映射，模拟模式下只记录日志
+func (um *UPnPManager) addPortMappingToClient(client WANConnectionClient, remoteHost string, internalPort, externalPort int, protocol, internalClient, description string) error {
+	if um.config.DryRun {
+		um.logger.WithFields(logrus.Fields{
+			"internal_port":   internalPort,
+			"external_port":   externalPort,
+			"protocol":        protocol,
+			"internal_client": internalClient,
+			"description":     um.instanceDescription(description),
+			"remote_host":     remoteHost,
+		}).Info("模拟模式，跳过向路由器添加端口映射")
+		return nil
+	}
 	return client.AddPortMapping(
-		"",                   // NewRemoteHost
-		uint16(externalPort), // NewExternalPort
-		protocol,             // NewProtocol
-		uint16(internalPort), // NewInternalPort
-		internalClient,       // NewInternalClient
-		true,                 // NewEnabled
-		description,          // NewPortMappingDescription
+		remoteHost,                          // NewRemoteHost
+		uint16(externalPort),                // NewExternalPort
+		protocol,                            // NewProtocol
+		uint16(internalPort),                // NewInternalPort
+		internalClient,                      // NewInternalClient
+		true,                                // NewEnabled
+		um.instanceDescription(description), // NewPortMappingDescription
 		uint32(um.config.MappingDuration.Seconds()), // NewLeaseDuration
 	)
 }
 
-// removePortMappingFromClient 从指定客户端删除端口映射
-func (um *UPnPManager) removePortMappingFromClient(client *internetgateway1.WANIPConnection1, externalPort int, protocol string) error {
+// removePortMappingFromClient 从指定客户端删除端口映射，remoteHost需要与添加映射时相同，模拟模式下只记录日志
+func (um *UPnPManager) removePortMappingFromClient(client WANConnectionClient, remoteHost string, externalPort int, protocol string) error {
+	if um.config.DryRun {
+		um.logger.WithFields(logrus.Fields{
+			"external_port": externalPort,
+			"protocol":      protocol,
+			"remote_host":   remoteHost,
+		}).Info("模拟模式，跳过从路由器删除端口映射")
+		return nil
+	}
 	return client.DeletePortMapping(
-		"",                   // NewRemoteHost
+		remoteHost,           // NewRemoteHost
 		uint16(externalPort), // NewExternalPort
 		protocol,             // NewProtocol
 	)
@@ -569,8 +870,17 @@ func (um *UPnPManager) getMappingKey(internalPort, externalPort int, protocol st
 	return fmt.Sprintf("%d:%d:%s", internalPort, externalPort, protocol)
 }
 
-// getLocalIP 获取本地IP地址
+// GetLocalIP 获取端口映射指向的本地IP地址
+func (um *UPnPManager) GetLocalIP() (string, error) {
+	return um.getLocalIP()
+}
+
+// getLocalIP 获取本地IP地址，配置了IPv4绑定地址时直接使用
 func (um *UPnPManager) getLocalIP() (string, error) {
+	if ip := net.ParseIP(um.config.BindAddress); ip != nil && ip.To4() != nil {
+		return ip.String(), nil
+	}
+
 	conn, err := net.Dial("udp", "8.8.8.8:80")
 	if err != nil {
 		return "", err
@@ -584,6 +894,17 @@ func (um *UPnPManager) getLocalIP() (string, error) {
 // Close 关闭UPnP管理器
 func (um *UPnPManager) Close() {
 	um.logger.Info("关闭UPnP管理器")
+
+	if um.config.RemoveOnShutdown {
+		um.removeAllMappings()
+	} else {
+		um.mutex.RLock()
+		count := len(um.mappings)
+		um.mutex.RUnlock()
+		um.logger.WithField("mappings", count).Info("保留路由器上的端口映射，下次启动时接管")
+	}
+	um.removeAllPinholes()
+
 	um.cancel()
 	if um.healthTicker != nil {
 		um.healthTicker.Stop()
@@ -0,0 +1,137 @@
+package upnp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestAddPortMapping_PassesRemoteHost(t *testing.T) {
+	remoteHostPattern := regexp.MustCompile(`<NewRemoteHost>([^<]*)</NewRemoteHost>`)
+
+	var mu sync.Mutex
+	var remoteHosts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		remoteHosts = append(remoteHosts, string(remoteHostPattern.FindSubmatch(body)[1]))
+		mu.Unlock()
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		switch action := r.Header.Get("SOAPAction"); {
+		case strings.Contains(action, "GetSpecificPortMappingEntry"):
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, soapFault, ErrCodeNoSuchEntry)
+		case strings.Contains(action, "DeletePortMapping"):
+			fmt.Fprint(w, soapDeletePortMappingResponse)
+		default:
+			fmt.Fprint(w, soapAddPortMappingResponse)
+		}
+	}))
+	defer server.Close()
+
+	um := &UPnPManager{
+		logger:     logrus.New(),
+		config:     &Config{BindAddress: "192.168.1.10", MaxMappings: 10, MaxFailCount: 3},
+		clients:    []*UPnPClientInfo{renewalClient(server.URL + "/ctl/IPConn")},
+		mappings:   make(map[string]*PortMapping),
+		inflight:   make(map[string]bool),
+		discovered: true,
+	}
+
+	if err := um.AddPortMapping(22, 2222, "TCP", "ssh", "198.51.100.20"); err != nil {
+		t.Fatalf("添加映射失败: %v", err)
+	}
+	if got := um.GetPortMappings()["22:2222:TCP"].RemoteHost; got != "198.51.100.20" {
+		t.Errorf("映射记录的来源地址 = %q", got)
+	}
+	// 路由器按来源地址区分映射，删除时需要传入相同的来源地址
+	if err := um.RemovePortMapping(22, 2222, "TCP"); err != nil {
+		t.Fatalf("删除映射失败: %v", err)
+	}
+	for i, remoteHost := range remoteHosts {
+		if remoteHost != "198.51.100.20" {
+			t.Errorf("第%d个请求的NewRemoteHost = %q，期望 198.51.100.20", i, remoteHost)
+		}
+	}
+}
+
+func TestAddPortMapping_DryRunSkipsRouter(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action := r.Header.Get("SOAPAction")
+		requests = append(requests, action)
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		// 模拟模式下只允许读取路由器映射表
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, soapFault, ErrCodeNoSuchEntry)
+	}))
+	defer server.Close()
+
+	um := &UPnPManager{
+		logger:     logrus.New(),
+		config:     &Config{BindAddress: "192.168.1.10", MaxMappings: 10, MaxFailCount: 3, DryRun: true},
+		clients:    []*UPnPClientInfo{renewalClient(server.URL + "/ctl/IPConn")},
+		mappings:   make(map[string]*PortMapping),
+		inflight:   make(map[string]bool),
+		discovered: true,
+	}
+
+	if err := um.AddPortMapping(8080, 8080, "TCP", "AutoUPnP-8080", ""); err != nil {
+		t.Fatalf("模拟添加映射失败: %v", err)
+	}
+	if mapping := um.GetPortMappings()["8080:8080:TCP"]; mapping == nil || !mapping.Simulated {
+		t.Fatalf("模拟添加的映射应记录在本地并标记为模拟: %+v", mapping)
+	}
+	if err := um.RemovePortMapping(8080, 8080, "TCP"); err != nil {
+		t.Fatalf("模拟删除映射失败: %v", err)
+	}
+	for _, action := range requests {
+		if strings.Contains(action, "AddPortMapping") || strings.Contains(action, "DeletePortMapping") {
+			t.Errorf("模拟模式下不应修改路由器，收到请求 %s", action)
+		}
+	}
+}
+
+func TestClose_KeepsSelectedMappings(t *testing.T) {
+	var mu sync.Mutex
+	var deleted []string
+	portPattern := regexp.MustCompile(`<NewExternalPort>(\d+)</NewExternalPort>`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		deleted = append(deleted, string(portPattern.FindSubmatch(body)[1]))
+		mu.Unlock()
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		fmt.Fprint(w, soapDeletePortMappingResponse)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	um := &UPnPManager{
+		logger:  logrus.New(),
+		ctx:     ctx,
+		cancel:  cancel,
+		config:  &Config{RemoveOnShutdown: true},
+		clients: []*UPnPClientInfo{renewalClient(server.URL + "/ctl/IPConn")},
+		mappings: map[string]*PortMapping{
+			"8080:8080:TCP": {InternalPort: 8080, ExternalPort: 8080, Protocol: "TCP", Description: "AutoUPnP-8080"},
+			"22:2222:TCP":   {InternalPort: 22, ExternalPort: 2222, Protocol: "TCP", Description: "ssh"},
+		},
+	}
+	// 只保留手动映射
+	um.SetKeepOnShutdown(func(mapping *PortMapping) bool { return mapping.ExternalPort == 2222 })
+
+	um.Close()
+
+	if len(deleted) != 1 || deleted[0] != "8080" {
+		t.Errorf("关闭时删除的映射 = %v，期望只删除8080", deleted)
+	}
+}
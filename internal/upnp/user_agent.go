@@ -0,0 +1,48 @@
+package upnp
+
+import (
+	"net/http"
+
+	"github.com/huin/goupnp"
+)
+
+// userAgentTransport 为UPnP HTTP请求设置自定义User-Agent
+type userAgentTransport struct {
+	userAgent string
+	base      http.RoundTripper
+}
+
+// RoundTrip 实现http.RoundTripper接口
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTripper不应修改原请求，复制后再设置请求头
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.base.RoundTrip(req)
+}
+
+// setupUserAgent 配置设备描述获取使用的User-Agent，未配置时保持goupnp默认行为
+func (um *UPnPManager) setupUserAgent() {
+	if um.config.UserAgent == "" {
+		return
+	}
+
+	// 设备描述XML通过goupnp的全局HTTP客户端获取
+	goupnp.HTTPClientDefault = &http.Client{Transport: um.newUserAgentTransport()}
+	um.logger.WithField("user_agent", um.config.UserAgent).Info("使用自定义UPnP User-Agent")
+}
+
+// applyUserAgent 为SOAP客户端设置User-Agent
+func (um *UPnPManager) applyUserAgent(serviceClient *goupnp.ServiceClient) {
+	if um.config.UserAgent == "" || serviceClient == nil || serviceClient.SOAPClient == nil {
+		return
+	}
+	serviceClient.SOAPClient.HTTPClient.Transport = um.newUserAgentTransport()
+}
+
+// newUserAgentTransport 创建带User-Agent的传输层
+func (um *UPnPManager) newUserAgentTransport() http.RoundTripper {
+	return &userAgentTransport{
+		userAgent: um.config.UserAgent,
+		base:      http.DefaultTransport,
+	}
+}
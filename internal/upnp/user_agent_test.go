@@ -0,0 +1,67 @@
+package upnp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestApplyUserAgent_SetsHeaderOnSOAPRequests(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		custom    bool
+	}{
+		{"配置了User-Agent", "auto-upnp/1.0 (home)", true},
+		{"未配置时使用默认值", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mutex sync.Mutex
+			var received []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost {
+					http.NotFound(w, r)
+					return
+				}
+				mutex.Lock()
+				received = append(received, r.Header.Get("User-Agent"))
+				mutex.Unlock()
+				w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+				fmt.Fprint(w, soapExternalIPResponse)
+			}))
+			defer server.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			um := &UPnPManager{
+				logger: logrus.New(),
+				ctx:    ctx,
+				config: &Config{ControlURL: server.URL + "/ctl/IPConn", DiscoveryTimeout: 5 * time.Second, UserAgent: tt.userAgent},
+			}
+			if err := um.discoverByControlURL(); err != nil {
+				t.Fatalf("通过控制URL发现失败: %v", err)
+			}
+			if _, err := um.clients[0].Client.GetExternalIPAddress(); err != nil {
+				t.Fatalf("客户端调用失败: %v", err)
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if len(received) == 0 {
+				t.Fatal("路由器没有收到SOAP请求")
+			}
+			for _, userAgent := range received {
+				if (userAgent == tt.userAgent) != tt.custom {
+					t.Errorf("SOAP请求的User-Agent为 %q, 配置为 %q", userAgent, tt.userAgent)
+				}
+			}
+		})
+	}
+}
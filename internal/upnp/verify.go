@@ -0,0 +1,59 @@
+package upnp
+
+import (
+	"fmt"
+)
+
+// VerifyRouterMapping 确认路由器上的映射存在且指向本机的内部端口
+func (um *UPnPManager) VerifyRouterMapping(internalPort, externalPort int, protocol string) error {
+	um.mutex.RLock()
+	// 限制来源地址的映射在路由器上以来源地址区分，需要按添加时的来源地址查询
+	var remoteHost string
+	if mapping, exists := um.mappings[um.getMappingKey(internalPort, externalPort, protocol)]; exists {
+		remoteHost = mapping.RemoteHost
+	}
+	clients := make([]*UPnPClientInfo, 0, len(um.clients))
+	for _, clientInfo := range um.clients {
+		if clientInfo.IsHealthy {
+			clients = append(clients, clientInfo)
+		}
+	}
+	um.mutex.RUnlock()
+
+	if len(clients) == 0 {
+		return fmt.Errorf("没有可用的UPnP客户端")
+	}
+
+	localIP, err := um.getLocalIP()
+	if err != nil {
+		return fmt.Errorf("获取本地IP地址失败: %w", err)
+	}
+
+	var lastErr error
+	for _, clientInfo := range clients {
+		entryPort, entryClient, enabled, _, _, err := clientInfo.Client.GetSpecificPortMappingEntry(
+			remoteHost,           // NewRemoteHost
+			uint16(externalPort), // NewExternalPort
+			protocol,             // NewProtocol
+		)
+		if err != nil {
+			if UPnPErrorCode(err) == ErrCodeNoSuchEntry {
+				lastErr = fmt.Errorf("路由器上不存在外部端口 %d/%s 的映射", externalPort, protocol)
+			} else {
+				lastErr = fmt.Errorf("查询路由器端口映射失败: %w", err)
+			}
+			continue
+		}
+
+		switch {
+		case entryClient != localIP || int(entryPort) != internalPort:
+			lastErr = fmt.Errorf("路由器上的映射指向 %s:%d，而不是本机 %s:%d", entryClient, entryPort, localIP, internalPort)
+		case !enabled:
+			lastErr = fmt.Errorf("路由器上的映射已被禁用")
+		default:
+			return nil
+		}
+	}
+
+	return lastErr
+}
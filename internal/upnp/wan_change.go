@@ -0,0 +1,82 @@
+package upnp
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WANChangeHandler 路由器外部IP变化时的回调，previous或current为空表示WAN口暂时没有地址
+type WANChangeHandler func(previous, current string)
+
+// wanState 健康检查观察到的WAN地址
+type wanState struct {
+	address   string
+	observed  bool // 是否已经观察到过地址，首次观察不算变化
+	changedAt time.Time
+	handler   WANChangeHandler
+}
+
+// WANStatus WAN地址及最近一次变化的时间，尚未变化过时ChangedAt为零值
+type WANStatus struct {
+	Address   string    `json:"address"`
+	Observed  bool      `json:"observed"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// SetWANChangeHandler 设置WAN地址变化时调用的回调，回调在单独的goroutine中执行
+func (um *UPnPManager) SetWANChangeHandler(handler WANChangeHandler) {
+	um.mutex.Lock()
+	defer um.mutex.Unlock()
+	um.wan.handler = handler
+}
+
+// GetWANStatus 获取健康检查观察到的WAN地址
+func (um *UPnPManager) GetWANStatus() WANStatus {
+	um.mutex.RLock()
+	defer um.mutex.RUnlock()
+	return WANStatus{
+		Address:   um.wan.address,
+		Observed:  um.wan.observed,
+		ChangedAt: um.wan.changedAt,
+	}
+}
+
+// normalizeWANAddress 规范化路由器返回的外部IP，WAN口断开时部分路由器返回0.0.0.0
+func normalizeWANAddress(address string) string {
+	address = strings.TrimSpace(address)
+	if ip := net.ParseIP(address); ip == nil || ip.IsUnspecified() {
+		return ""
+	}
+	return address
+}
+
+// observeWANAddress 记录健康检查得到的外部IP，地址变化或变为空时调用回调，返回是否发生了变化
+// 调用者需要持有mutex
+func (um *UPnPManager) observeWANAddress(address string) bool {
+	address = normalizeWANAddress(address)
+	if !um.wan.observed {
+		um.wan.observed = true
+		um.wan.address = address
+		return false
+	}
+	if address == um.wan.address {
+		return false
+	}
+
+	previous := um.wan.address
+	um.wan.address = address
+	um.wan.changedAt = time.Now()
+
+	um.logger.WithFields(logrus.Fields{
+		"previous": previous,
+		"current":  address,
+	}).Warn("路由器WAN地址发生变化")
+
+	if handler := um.wan.handler; handler != nil {
+		go handler(previous, address)
+	}
+	return true
+}
@@ -0,0 +1,55 @@
+package upnp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestObserveWANAddress(t *testing.T) {
+	um := &UPnPManager{logger: logrus.New(), config: &Config{}}
+
+	type change struct{ previous, current string }
+	changes := make(chan change, 4)
+	um.SetWANChangeHandler(func(previous, current string) {
+		changes <- change{previous, current}
+	})
+
+	steps := []struct {
+		address string
+		changed bool
+		want    change
+	}{
+		{address: "203.0.113.7", changed: false}, // 首次观察不算变化
+		{address: "203.0.113.7", changed: false},
+		{address: "0.0.0.0", changed: true, want: change{"203.0.113.7", ""}}, // 重新拨号期间暂时没有地址
+		{address: "", changed: false},
+		{address: "198.51.100.2", changed: true, want: change{"", "198.51.100.2"}},
+	}
+
+	for i, step := range steps {
+		um.mutex.Lock()
+		changed := um.observeWANAddress(step.address)
+		um.mutex.Unlock()
+		if changed != step.changed {
+			t.Fatalf("第%d步 %q: changed = %v，期望 %v", i, step.address, changed, step.changed)
+		}
+		if !changed {
+			continue
+		}
+		select {
+		case got := <-changes:
+			if got != step.want {
+				t.Errorf("第%d步回调参数 = %+v，期望 %+v", i, got, step.want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("第%d步地址变化后没有调用回调", i)
+		}
+	}
+
+	status := um.GetWANStatus()
+	if status.Address != "198.51.100.2" || !status.Observed || status.ChangedAt.IsZero() {
+		t.Errorf("WAN状态 = %+v", status)
+	}
+}
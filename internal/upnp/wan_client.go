@@ -0,0 +1,162 @@
+package upnp
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/huin/goupnp"
+	"github.com/huin/goupnp/dcps/internetgateway1"
+	"github.com/huin/goupnp/dcps/internetgateway2"
+	"github.com/huin/goupnp/soap"
+	"github.com/sirupsen/logrus"
+)
+
+// 发现网关时搜索的设备类型，只提供IGDv2的路由器（如较新的Fritz!Box）不响应IGDv1的搜索
+const (
+	urnInternetGatewayDevice1 = "urn:schemas-upnp-org:device:InternetGatewayDevice:1"
+	urnInternetGatewayDevice2 = "urn:schemas-upnp-org:device:InternetGatewayDevice:2"
+)
+
+// wanProbeTimeout 设备同时提供多个WAN连接服务时，确认哪个服务已连接的超时
+const wanProbeTimeout = 3 * time.Second
+
+// WANConnectionClient WANIPConnection:1、WANPPPConnection:1和IGDv2的WANIPConnection:2客户端共同的操作
+type WANConnectionClient interface {
+	GetExternalIPAddress() (string, error)
+	GetExternalIPAddressCtx(ctx context.Context) (string, error)
+	AddPortMapping(remoteHost string, externalPort uint16, protocol string, internalPort uint16, internalClient string, enabled bool, description string, leaseDuration uint32) error
+	DeletePortMapping(remoteHost string, externalPort uint16, protocol string) error
+	GetSpecificPortMappingEntry(remoteHost string, externalPort uint16, protocol string) (internalPort uint16, internalClient string, enabled bool, description string, leaseDuration uint32, err error)
+	GetGenericPortMappingEntry(index uint16) (remoteHost string, externalPort uint16, protocol string, internalPort uint16, internalClient string, enabled bool, description string, leaseDuration uint32, err error)
+	GetServiceClient() *goupnp.ServiceClient
+}
+
+// wanService 支持的WAN连接服务
+type wanService struct {
+	serviceType string
+	version     int // IGD版本
+	fromRoot    func(root *goupnp.RootDevice, loc *url.URL) ([]WANConnectionClient, error)
+	fromClient  func(serviceClient goupnp.ServiceClient) WANConnectionClient
+}
+
+// wanServices 依次尝试的WAN连接服务，DSL/PPPoE路由器通常只提供WANPPPConnection:1
+var wanServices = []wanService{
+	{
+		serviceType: internetgateway1.URN_WANIPConnection_1,
+		version:     1,
+		fromRoot: func(root *goupnp.RootDevice, loc *url.URL) ([]WANConnectionClient, error) {
+			clients, err := internetgateway1.NewWANIPConnection1ClientsFromRootDevice(root, loc)
+			return asWANClients(clients), err
+		},
+		fromClient: func(sc goupnp.ServiceClient) WANConnectionClient {
+			return &internetgateway1.WANIPConnection1{ServiceClient: sc}
+		},
+	},
+	{
+		serviceType: internetgateway1.URN_WANPPPConnection_1,
+		version:     1,
+		fromRoot: func(root *goupnp.RootDevice, loc *url.URL) ([]WANConnectionClient, error) {
+			clients, err := internetgateway1.NewWANPPPConnection1ClientsFromRootDevice(root, loc)
+			return asWANClients(clients), err
+		},
+		fromClient: func(sc goupnp.ServiceClient) WANConnectionClient {
+			return &internetgateway1.WANPPPConnection1{ServiceClient: sc}
+		},
+	},
+	{
+		serviceType: internetgateway2.URN_WANIPConnection_2,
+		version:     2,
+		fromRoot: func(root *goupnp.RootDevice, loc *url.URL) ([]WANConnectionClient, error) {
+			clients, err := internetgateway2.NewWANIPConnection2ClientsFromRootDevice(root, loc)
+			return asWANClients(clients), err
+		},
+		fromClient: func(sc goupnp.ServiceClient) WANConnectionClient {
+			return &internetgateway2.WANIPConnection2{ServiceClient: sc}
+		},
+	},
+}
+
+// asWANClients 将具体类型的客户端列表转换为接口列表
+func asWANClients[T WANConnectionClient](clients []T) []WANConnectionClient {
+	result := make([]WANConnectionClient, 0, len(clients))
+	for _, client := range clients {
+		result = append(result, client)
+	}
+	return result
+}
+
+// wanServiceName 客户端使用的服务名，如 WANPPPConnection:1
+func wanServiceName(client WANConnectionClient) string {
+	if client == nil {
+		return ""
+	}
+	serviceClient := client.GetServiceClient()
+	if serviceClient == nil || serviceClient.Service == nil {
+		return ""
+	}
+	return strings.TrimPrefix(serviceClient.Service.ServiceType, "urn:schemas-upnp-org:service:")
+}
+
+// wanClientFromRootDevice 从设备描述中创建WAN连接客户端，返回客户端和IGD版本
+// 设备同时提供多个WAN连接服务时（常见于同时列出未连接的WANIPConnection的PPPoE路由器），
+// 选择第一个能返回外部IP的服务，都不能返回时使用第一个
+func (um *UPnPManager) wanClientFromRootDevice(root *goupnp.RootDevice, loc *url.URL) (WANConnectionClient, int, error) {
+	type candidate struct {
+		client  WANConnectionClient
+		version int
+	}
+
+	var candidates []candidate
+	for _, service := range wanServices {
+		clients, err := service.fromRoot(root, loc)
+		if err != nil || len(clients) == 0 {
+			continue
+		}
+		um.applyUserAgent(clients[0].GetServiceClient())
+		candidates = append(candidates, candidate{client: clients[0], version: service.version})
+	}
+
+	switch len(candidates) {
+	case 0:
+		return nil, 0, fmt.Errorf("设备没有WANIPConnection或WANPPPConnection服务")
+	case 1:
+		return candidates[0].client, candidates[0].version, nil
+	}
+
+	for _, c := range candidates {
+		ctx, cancel := context.WithTimeout(um.ctx, wanProbeTimeout)
+		address, err := c.client.GetExternalIPAddressCtx(ctx)
+		cancel()
+		if err == nil && normalizeWANAddress(address) != "" {
+			return c.client, c.version, nil
+		}
+		um.logger.WithFields(logrus.Fields{
+			"device":  root.Device.FriendlyName,
+			"service": wanServiceName(c.client),
+			"address": address,
+			"error":   err,
+		}).Debug("WAN连接服务未连接，尝试下一个服务")
+	}
+	return candidates[0].client, candidates[0].version, nil
+}
+
+// wanClientByURL 读取设备描述并创建WAN连接客户端，返回客户端和IGD版本
+func (um *UPnPManager) wanClientByURL(ctx context.Context, loc *url.URL) (WANConnectionClient, int, error) {
+	root, err := goupnp.DeviceByURLCtx(ctx, loc)
+	if err != nil {
+		return nil, 0, err
+	}
+	return um.wanClientFromRootDevice(root, loc)
+}
+
+// newWANClientForControlURL 以控制地址创建指定服务类型的WAN连接客户端
+func newWANClientForControlURL(loc *url.URL, service wanService) WANConnectionClient {
+	return service.fromClient(goupnp.ServiceClient{
+		SOAPClient: soap.NewSOAPClient(*loc),
+		Location:   loc,
+		Service:    &goupnp.Service{ServiceType: service.serviceType},
+	})
+}